@@ -0,0 +1,215 @@
+// Command journal - read, replay and compact journal of room changes (see config.JournalConfig)
+//
+// Storages are taken from the service config (CONFIG_PATH file and env), journal.enabled isn't required:
+//
+//	journal entries [-after seq] <room_id>  - print entries of room, one JSON per line
+//	journal replay <room_id>                - print room rebuilt from its last snapshot and entries after it
+//	journal restore [-replace] <room_id>    - write rebuilt room into rooms storage (-replace: delete existing room first)
+//	journal compact <room_id>               - save rebuilt room as snapshot, erase entries and snapshots before it
+//
+// "file" journal must not be compacted while the service appends into it
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/config"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/journal"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/mongodb"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/redis"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const usage = `usage: journal <command> [flags] <room_id>
+
+commands:
+  entries [-after seq]  print entries of room, one JSON per line
+  replay                print room rebuilt from journal
+  restore [-replace]    write room rebuilt from journal into rooms storage
+  compact               save rebuilt room as snapshot and erase journal before it
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	afterSeq := flags.Uint64("after", 0, "print entries with greater seq only")
+	replace := flags.Bool("replace", false, "delete room from rooms storage before it's restored")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+	parsedID, err := types.NewUUID(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("room id '%s' - invalid uuid", flags.Arg(0))
+	}
+	roomID := models.RoomID(parsedID)
+
+	cfg, err := config.TryRead()
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	roomsJournal, closeJournal, err := openJournal(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeJournal()
+
+	output := json.NewEncoder(os.Stdout)
+	switch command {
+	case "entries":
+		entries, err := roomsJournal.Entries(ctx, roomID, *afterSeq)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err = output.Encode(&entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "replay":
+		snapshot, err := journal.Rebuild(ctx, roomsJournal, roomID)
+		if err != nil {
+			return err
+		}
+		return output.Encode(snapshot)
+	case "restore":
+		rooms, closeRooms, err := openRooms(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer closeRooms()
+		snapshot, err := journal.Restore(ctx, roomsJournal, rooms, roomID, *replace)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "room is restored up to entry %d\n", snapshot.Seq)
+		return nil
+	case "compact":
+		snapshot, err := journal.CompactRoom(ctx, roomsJournal, roomID)
+		if err != nil {
+			return err
+		}
+		if snapshot == nil {
+			fmt.Fprintln(os.Stderr, "room is deleted, its journal is erased")
+		} else {
+			fmt.Fprintf(os.Stderr, "journal is compacted up to entry %d\n", snapshot.Seq)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command '%s'\n%s", command, usage)
+	}
+}
+
+// openJournal - journal of config.JournalConfig.Storage, close must be called when it's not needed
+func openJournal(ctx context.Context, cfg *config.Config) (ports.JournalPort, func(), error) {
+	switch cfg.Journal.Storage {
+	case config.JournalStorageFile, "":
+		fileJournal, err := journal.NewFileJournal(cfg.Journal.Dir)
+		return fileJournal, func() {}, err
+	case config.JournalStorageMongoDB:
+		writeConcern, err := cfg.MongoDBRoomsRepo.ParseWriteConcern()
+		if err != nil {
+			return nil, nil, err
+		}
+		client, err := mongodb.New(ctx, cfg.MongoDB)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating mongodb client: %w", err)
+		}
+		mongoJournal, err := journal.NewMongoDBJournal(ctx, client, journal.MongoJournalParams{
+			Database:            cfg.MongoDBRoomsRepo.Database,
+			EntriesCollection:   cfg.Journal.MongoEntriesCollection,
+			SnapshotsCollection: cfg.Journal.MongoSnapshotsCollection,
+			SeqsCollection:      cfg.Journal.MongoSeqsCollection,
+			WriteConcern:        writeConcern,
+		})
+		closeClient := func() { mongodb.DeferDisconnect(ctx, client) }
+		if err != nil {
+			closeClient()
+			return nil, nil, err
+		}
+		return mongoJournal, closeClient, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown journal storage: '%s' (Use one of these: 'file', 'mongodb')", cfg.Journal.Storage)
+	}
+}
+
+// openRooms - rooms storage of config.RoomsConfig.Storage, close must be called when it's not needed
+func openRooms(ctx context.Context, cfg *config.Config) (ports.RoomsPort, func(), error) {
+	switch cfg.Rooms.Storage {
+	case config.RoomsStorageMongoDB, "":
+		readConcern, err := cfg.MongoDBRoomsRepo.ParseReadConcern()
+		if err != nil {
+			return nil, nil, err
+		}
+		writeConcern, err := cfg.MongoDBRoomsRepo.ParseWriteConcern()
+		if err != nil {
+			return nil, nil, err
+		}
+		client, err := mongodb.New(ctx, cfg.MongoDB)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating mongodb client: %w", err)
+		}
+		rooms, err := room.NewMongoDBRepository(ctx, client, room.MongoRepoParams{
+			Database:       cfg.MongoDBRoomsRepo.Database,
+			RoomCollection: cfg.MongoDBRoomsRepo.RoomsCollection,
+			WriteConcern:   writeConcern,
+			ReadConcern:    readConcern,
+		})
+		if err != nil {
+			mongodb.DeferDisconnect(ctx, client)
+			return nil, nil, fmt.Errorf("error creating mongodb rooms storage: %w", err)
+		}
+		return rooms, func() { mongodb.DeferDisconnect(ctx, client) }, nil
+	case config.RoomsStorageRedis:
+		client, err := redis.New(ctx, cfg.Redis)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating redis client: %w", err)
+		}
+		return room.NewRedisRepository(client, room.RedisRepoParams{
+			KeyPrefix: cfg.Rooms.RedisKeyPrefix,
+			TTL:       time.Duration(cfg.Rooms.RedisTTLSeconds) * time.Second,
+		}), func() { redis.DeferDisconnect(ctx, client) }, nil
+	case config.RoomsStorageBolt:
+		// the service must be stopped, otherwise the file is locked
+		db, err := bolt.Open(cfg.Bolt.Path, 0o600, &bolt.Options{
+			Timeout: time.Duration(cfg.Bolt.OpenTimeoutSeconds) * time.Second,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening bolt file: %w", err)
+		}
+		rooms, err := room.NewBoltRepository(db)
+		if err != nil {
+			_ = db.Close()
+			return nil, nil, err
+		}
+		return rooms, func() { _ = db.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("rooms can't be restored into '%s' storage (Use one of these: 'mongodb', 'redis', 'bolt')", cfg.Rooms.Storage)
+	}
+}
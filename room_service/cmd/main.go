@@ -2,28 +2,64 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/chempik1234/room-service/internal/config"
+	roomhealth "github.com/chempik1234/room-service/internal/health"
+	"github.com/chempik1234/room-service/internal/metrics"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/circuitbreaker"
 	"github.com/chempik1234/room-service/internal/repositories/commandcache"
+	"github.com/chempik1234/room-service/internal/repositories/eventbus"
+	"github.com/chempik1234/room-service/internal/repositories/instrumented"
+	"github.com/chempik1234/room-service/internal/repositories/journal"
+	"github.com/chempik1234/room-service/internal/repositories/membership"
+	"github.com/chempik1234/room-service/internal/repositories/ratelimit"
 	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/chempik1234/room-service/internal/repositories/snapshotcache"
+	"github.com/chempik1234/room-service/internal/repositories/writebehind"
 	"github.com/chempik1234/room-service/internal/service/roomservice"
+	"github.com/chempik1234/room-service/internal/sharding"
+	"github.com/chempik1234/room-service/internal/tracing"
 	"github.com/chempik1234/room-service/pkg/api/room_service"
+	pkgconfig "github.com/chempik1234/room-service/pkg/config"
+	"github.com/chempik1234/room-service/pkg/logging"
 	"github.com/chempik1234/room-service/pkg/transport/grpc/interceptors"
-	"github.com/chempik1234/super-danis-library-golang/v2/pkg/logger"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/mongodb"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/redis"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/server"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/server/grpcserver"
-	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
-	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/server/httpserver"
+	goredis "github.com/go-redis/redis/v8"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"log"
+	"math"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// defaultShutdownTimeout - used when config.RoomServiceConfig.ShutdownTimeoutSeconds isn't set
+const defaultShutdownTimeout = 15 * time.Second
+
+// defaultTracingServiceName - used when config.TracingConfig.ServiceName isn't set
+const defaultTracingServiceName = "room-service"
+
+// tracingFlushTimeout - how long finished spans are exported on shutdown
+const tracingFlushTimeout = 5 * time.Second
+
 func main() {
-	//region load config from env
+	//region load config (YAML file from CONFIG_PATH, overridden by env)
 	var cfg, err = config.TryRead()
 	if err != nil {
 		log.Fatal(fmt.Errorf("error loading config: %w", err))
@@ -32,82 +68,675 @@ func main() {
 	//endregion
 
 	//region logger
-	ctx, _ := logger.New(context.Background())
+	baseLogger, err := logging.New(logging.Params{
+		Level:              cfg.Log.LogLevel,
+		Format:             logging.Format(cfg.Log.Format),
+		SamplingInitial:    cfg.Log.SamplingInitial,
+		SamplingThereafter: cfg.Log.SamplingThereafter,
+	})
+	if err != nil {
+		log.Fatal(fmt.Errorf("error creating logger: %w", err))
+	}
+	defer func() { _ = baseLogger.Sync() }()
+
+	ctx := logging.WithLogger(context.Background(), baseLogger)
 
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "logger init")
+	logging.FromContext(ctx).Info(ctx, "logger init", zap.String("log_level", baseLogger.Level()))
 	//endregion
 
-	//region mongodb
-	mongoClient, err := mongodb.New(ctx, cfg.MongoDB)
+	//region metrics
+	appMetrics := metrics.New()
+	//endregion
+
+	//region tracing
+	tracingServiceName := cfg.Tracing.ServiceName
+	if len(tracingServiceName) == 0 {
+		tracingServiceName = defaultTracingServiceName
+	}
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Params{
+		Exporter:     tracing.Exporter(cfg.Tracing.Exporter),
+		ServiceName:  tracingServiceName,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+	})
 	if err != nil {
-		logger.GetLoggerFromCtx(ctx).Error(ctx, "error creating mongodb client", zap.Error(err))
+		logging.FromContext(ctx).Error(ctx, "error setting up tracing", zap.Error(err))
 		return
 	}
-	defer mongodb.DeferDisconnect(ctx, mongoClient)
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "mongodb client created")
+	defer func() {
+		// spans of drained commands are flushed after everything else is stopped
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancelFlush()
+		if errFlush := shutdownTracing(flushCtx); errFlush != nil {
+			logging.FromContext(ctx).Error(ctx, "failed to flush spans", zap.Error(errFlush))
+		}
+	}()
+	logging.FromContext(ctx).Info(ctx, "tracing set up", zap.String("exporter", cfg.Tracing.Exporter))
+	//endregion
+
+	//region mongodb
+	// one client is shared by rooms and journal, it's created only if one of them uses mongodb
+	var mongoClient *mongo.Client
+	if cfg.Rooms.Storage == config.RoomsStorageMongoDB || cfg.Rooms.Storage == "" ||
+		(cfg.Journal.Enabled && cfg.Journal.Storage == config.JournalStorageMongoDB) {
+		mongoClient, err = mongodb.New(ctx, cfg.MongoDB)
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "error creating mongodb client", zap.Error(err))
+			return
+		}
+		defer func() {
+			if errDisconnect := mongoClient.Disconnect(ctx); errDisconnect != nil {
+				logging.FromContext(ctx).Error(ctx, "error disconnecting mongodb client", zap.Error(errDisconnect))
+			}
+		}()
+		logging.FromContext(ctx).Info(ctx, "mongodb client created")
+	}
 	//endregion
 
 	//region redis
-	redisClient, err := redis.New(ctx, cfg.Redis)
-	if err != nil {
-		logger.GetLoggerFromCtx(ctx).Error(ctx, "error creating redis client", zap.Error(err))
-		return
+	// one client is shared by rooms, command cache, rate limiter and event bus, it's created only if one of them uses redis
+	var redisClient *goredis.Client
+	if cfg.Rooms.Storage == config.RoomsStorageRedis ||
+		cfg.CommandCache.Storage == config.CommandCacheStorageRedis || cfg.CommandCache.Storage == "" ||
+		cfg.RateLimit.Storage == config.RateLimitStorageRedis ||
+		(!cfg.EventBus.Disabled && cfg.EventBus.Storage == config.EventBusStorageRedis) {
+		redisClient, err = redis.New(ctx, cfg.Redis)
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "error creating redis client", zap.Error(err))
+			return
+		}
+		defer func() {
+			if errClose := redisClient.Close(); errClose != nil {
+				logging.FromContext(ctx).Error(ctx, "error closing redis client", zap.Error(errClose))
+			}
+		}()
+		logging.FromContext(ctx).Info(ctx, "redis client created")
 	}
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "redis client created")
 	//endregion
 
-	gRPCRetryStrategy := cfg.Service.RetryStrategy.ToStrategy()
+	//region bolt
+	// one file is shared by rooms and command cache, it's opened only if one of them uses bolt
+	var boltDB *bolt.DB
+	if cfg.Rooms.Storage == config.RoomsStorageBolt || cfg.CommandCache.Storage == config.CommandCacheStorageBolt {
+		boltDB, err = bolt.Open(cfg.Bolt.Path, 0o600, &bolt.Options{
+			Timeout: time.Duration(cfg.Bolt.OpenTimeoutSeconds) * time.Second,
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "error opening bolt file", zap.String("path", cfg.Bolt.Path), zap.Error(err))
+			return
+		}
+		defer func() {
+			if errClose := boltDB.Close(); errClose != nil {
+				logging.FromContext(ctx).Error(ctx, "error closing bolt file", zap.Error(errClose))
+			}
+		}()
+		logging.FromContext(ctx).Info(ctx, "bolt file opened", zap.String("path", cfg.Bolt.Path))
+	}
+	//endregion
 
-	//region service
-	var readConcern *readconcern.ReadConcern
-	switch cfg.MongoDBRoomsRepo.ReadConcern {
-	case "available":
-		readConcern = readconcern.Available()
-	case "local":
-		readConcern = readconcern.Local()
-	case "majority":
-		readConcern = readconcern.Majority()
-	case "linearizable":
-		readConcern = readconcern.Linearizable()
-	case "snapshot":
-		readConcern = readconcern.Snapshot()
+	//region command cache
+	var commandCache ports.CommandIDShortCache
+	var inMemoryCommandCache *commandcache.InMemoryCommandCache
+	switch cfg.CommandCache.Storage {
+	case config.CommandCacheStorageRedis, "":
+		commandCache = commandcache.NewRedisCommandCache(redisClient, cfg.Redis.TTLSeconds*1000)
+	case config.CommandCacheStorageInMemory:
+		inMemoryCommandCache = commandcache.NewInMemoryCommandCache(
+			cfg.CommandCache.Capacity,
+			cfg.CommandCache.Shards,
+			cfg.CommandCache.TTLSeconds*1000,
+		)
+		commandCache = inMemoryCommandCache
+		logging.FromContext(ctx).Info(ctx, "in-memory command cache created")
+	case config.CommandCacheStorageBolt:
+		commandCache, err = commandcache.NewBoltCommandCache(boltDB, cfg.CommandCache.TTLSeconds*1000)
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "error creating bolt command cache", zap.Error(err))
+			return
+		}
 	default:
-		panic(fmt.Errorf("unknown read concern: '%s' (Use one of these: 'available', 'local', 'majority', 'linearizable', 'snapshot')", cfg.MongoDBRoomsRepo.ReadConcern))
+		panic(fmt.Errorf("unknown command cache storage: '%s' (Use one of these: 'redis', 'in_memory', 'bolt')", cfg.CommandCache.Storage))
 	}
-	roomServiceServer := roomservice.NewRoomService(
-		room.NewMongoDBRepository(mongoClient, room.MongoRepoParams{
+	if inMemoryCommandCache != nil {
+		appMetrics.RegisterCommandCacheStats(func() metrics.CommandCacheStats {
+			stats := inMemoryCommandCache.Stats()
+			return metrics.CommandCacheStats{
+				Hits:        stats.Hits,
+				Misses:      stats.Misses,
+				Evictions:   stats.Evictions,
+				Expirations: stats.Expirations,
+				Size:        stats.Size,
+			}
+		})
+	}
+	//endregion
+
+	//region rate limiter
+	var rateLimiter ports.RateLimiter
+	switch cfg.RateLimit.Storage {
+	case config.RateLimitStorageInMemory, "":
+		rateLimiter = ratelimit.NewInMemoryRateLimiter()
+	case config.RateLimitStorageRedis:
+		rateLimiter = ratelimit.NewRedisRateLimiter(redisClient)
+	default:
+		panic(fmt.Errorf("unknown rate limit storage: '%s' (Use one of these: 'in_memory', 'redis')", cfg.RateLimit.Storage))
+	}
+	logging.FromContext(ctx).Info(ctx, "rate limiter created",
+		zap.String("storage", string(cfg.RateLimit.Storage)), zap.Int("limited_commands", len(cfg.RateLimit.Commands)))
+	//endregion
+
+	// one budget for every operation, it's kept on config reload
+	retryBudget := cfg.Service.RetryStrategy.ToBudget()
+
+	//region rooms storage
+	var roomsRepo ports.RoomsPort
+	switch cfg.Rooms.Storage {
+	case config.RoomsStorageMongoDB, "":
+		// config is already validated, see config.TryRead
+		readConcern, err := cfg.MongoDBRoomsRepo.ParseReadConcern()
+		if err != nil {
+			panic(err)
+		}
+		writeConcern, err := cfg.MongoDBRoomsRepo.ParseWriteConcern()
+		if err != nil {
+			panic(err)
+		}
+		roomsRepo, err = room.NewMongoDBRepository(ctx, mongoClient, room.MongoRepoParams{
 			Database:       cfg.MongoDBRoomsRepo.Database,
 			RoomCollection: cfg.MongoDBRoomsRepo.RoomsCollection,
-			WriteConcern:   writeconcern.Custom(cfg.MongoDBRoomsRepo.WriteConcern),
+			WriteConcern:   writeConcern,
 			ReadConcern:    readConcern,
-		}),
-		commandcache.NewRedisCommandCache(redisClient, cfg.Redis.TTLSeconds*1000),
-		gRPCRetryStrategy,
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "error creating mongodb rooms storage", zap.Error(err))
+			return
+		}
+	case config.RoomsStorageRedis:
+		roomsRepo = room.NewRedisRepository(redisClient, room.RedisRepoParams{
+			KeyPrefix: cfg.Rooms.RedisKeyPrefix,
+			TTL:       time.Duration(cfg.Rooms.RedisTTLSeconds) * time.Second,
+		})
+	case config.RoomsStorageBolt:
+		roomsRepo, err = room.NewBoltRepository(boltDB)
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "error creating bolt rooms storage", zap.Error(err))
+			return
+		}
+	case config.RoomsStorageInMemory:
+		roomsRepo = room.NewInMemoryRepository()
+	default:
+		panic(fmt.Errorf("unknown rooms storage: '%s' (Use one of these: 'mongodb', 'redis', 'bolt', 'in_memory')", cfg.Rooms.Storage))
+	}
+	logging.FromContext(ctx).Info(ctx, "rooms storage created", zap.String("storage", string(cfg.Rooms.Storage)))
+	//endregion
+
+	//region journal
+	var roomsJournal ports.JournalPort
+	if cfg.Journal.Enabled {
+		switch cfg.Journal.Storage {
+		case config.JournalStorageFile, "":
+			roomsJournal, err = journal.NewFileJournal(cfg.Journal.Dir)
+		case config.JournalStorageMongoDB:
+			// config is already validated, see config.TryRead
+			writeConcern, errConcern := cfg.MongoDBRoomsRepo.ParseWriteConcern()
+			if errConcern != nil {
+				panic(errConcern)
+			}
+			roomsJournal, err = journal.NewMongoDBJournal(ctx, mongoClient, journal.MongoJournalParams{
+				Database:            cfg.MongoDBRoomsRepo.Database,
+				EntriesCollection:   cfg.Journal.MongoEntriesCollection,
+				SnapshotsCollection: cfg.Journal.MongoSnapshotsCollection,
+				SeqsCollection:      cfg.Journal.MongoSeqsCollection,
+				WriteConcern:        writeConcern,
+			})
+		default:
+			panic(fmt.Errorf("unknown journal storage: '%s' (Use one of these: 'file', 'mongodb')", cfg.Journal.Storage))
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "error creating journal", zap.Error(err))
+			return
+		}
+		logging.FromContext(ctx).Info(ctx, "journal created", zap.String("storage", string(cfg.Journal.Storage)))
+	}
+	//endregion
+
+	//region event bus
+	var eventBus ports.EventBusPort
+	var redisEventBus *eventbus.RedisEventBus
+	if !cfg.EventBus.Disabled {
+		var eventBusStats func() eventbus.Stats
+		switch cfg.EventBus.Storage {
+		case config.EventBusStorageInProcess, "":
+			inProcessEventBus := eventbus.NewInProcessEventBus(cfg.EventBus.SubscriberBufferSize)
+			eventBus, eventBusStats = inProcessEventBus, inProcessEventBus.Stats
+		case config.EventBusStorageRedis:
+			redisEventBus = eventbus.NewRedisEventBus(redisClient, eventbus.RedisEventBusParams{
+				ChannelPrefix:        cfg.EventBus.RedisChannelPrefix,
+				SubscriberBufferSize: cfg.EventBus.SubscriberBufferSize,
+			})
+			eventBus, eventBusStats = redisEventBus, redisEventBus.Stats
+		default:
+			panic(fmt.Errorf("unknown event bus storage: '%s' (Use one of these: 'in_process', 'redis')", cfg.EventBus.Storage))
+		}
+		appMetrics.RegisterEventBusStats(func() metrics.EventBusStats {
+			stats := eventBusStats()
+			return metrics.EventBusStats{
+				Subscriptions: stats.Subscriptions,
+				Published:     stats.Published,
+				Delivered:     stats.Delivered,
+				Dropped:       stats.Dropped,
+				Resyncs:       stats.Resyncs,
+			}
+		})
+		logging.FromContext(ctx).Info(ctx, "event bus created", zap.String("storage", string(cfg.EventBus.Storage)))
+	}
+	//endregion
+
+	//region service
+
+	// breakers are outside of instrumented decorators, so rejected calls aren't recorded as port calls
+	var servedRoomsRepo ports.RoomsPort = instrumented.NewRoomsRepository(roomsRepo, appMetrics)
+	if !cfg.CircuitBreaker.Rooms.Disabled {
+		servedRoomsRepo = circuitbreaker.NewRoomsRepository(servedRoomsRepo,
+			circuitbreaker.NewBreaker(metrics.PortRooms, breakerParams(cfg.CircuitBreaker.Rooms), appMetrics))
+	}
+	var servedCommandCache ports.CommandIDShortCache = instrumented.NewCommandCache(commandCache, appMetrics)
+	if !cfg.CircuitBreaker.CommandCache.Disabled {
+		servedCommandCache = circuitbreaker.NewCommandCache(servedCommandCache,
+			circuitbreaker.NewBreaker(metrics.PortCommandCache, breakerParams(cfg.CircuitBreaker.CommandCache), appMetrics),
+			cfg.CircuitBreaker.CommandCache.FailOpen)
+	}
+	// write-behind is outside of breaker, so only loads and flushes take its calls; rooms are in memory, snapshot cache isn't needed
+	var writeBehind *writebehind.RoomsRepository
+	if cfg.WriteBehind.Enabled {
+		writeBehind = writebehind.NewRoomsRepository(servedRoomsRepo, writebehind.Params{
+			FlushInterval: time.Duration(cfg.WriteBehind.FlushIntervalMilliseconds) * time.Millisecond,
+			MaxStaleness:  time.Duration(cfg.WriteBehind.MaxStalenessMilliseconds) * time.Millisecond,
+			IdleTimeout:   time.Duration(cfg.WriteBehind.IdleSeconds) * time.Second,
+		})
+		appMetrics.RegisterWriteBehindStats(func() metrics.WriteBehindStats {
+			stats := writeBehind.Stats()
+			return metrics.WriteBehindStats{
+				Rooms:       stats.Rooms,
+				DirtyRooms:  stats.DirtyRooms,
+				FlushLag:    stats.FlushLag,
+				Loads:       stats.Loads,
+				Flushes:     stats.Flushes,
+				FlushErrors: stats.FlushErrors,
+				Evictions:   stats.Evictions,
+			}
+		})
+		servedRoomsRepo = writeBehind
+		logging.FromContext(ctx).Info(ctx, "rooms write-behind enabled",
+			zap.Int("flush_interval_ms", cfg.WriteBehind.FlushIntervalMilliseconds),
+			zap.Int("max_staleness_ms", cfg.WriteBehind.MaxStalenessMilliseconds))
+	}
+	// journal is outside of write-behind, so changes that aren't flushed yet are journaled and can be restored
+	if roomsJournal != nil {
+		servedRoomsRepo = journal.NewRoomsRepository(servedRoomsRepo, roomsJournal, journal.Params{
+			SnapshotEvery: uint64(cfg.Journal.SnapshotEvery),
+			Compact:       cfg.Journal.Compact,
+		})
+	}
+	// snapshot cache is the outermost, so hits don't take breaker's calls and aren't recorded as port calls
+	var snapshotCache *snapshotcache.RoomsRepository
+	if !cfg.SnapshotCache.Disabled && writeBehind == nil {
+		snapshotCache = snapshotcache.NewRoomsRepository(servedRoomsRepo, roomservice.SnapshotCodec{}, snapshotcache.Params{
+			MaxBytes:    cfg.SnapshotCache.MaxBytes,
+			TTL:         time.Duration(cfg.SnapshotCache.TTLMilliseconds) * time.Millisecond,
+			LoadTimeout: time.Duration(cfg.SnapshotCache.LoadTimeoutMilliseconds) * time.Millisecond,
+		})
+		appMetrics.RegisterSnapshotCacheStats(func() metrics.SnapshotCacheStats {
+			stats := snapshotCache.Stats()
+			return metrics.SnapshotCacheStats{
+				Hits:      stats.Hits,
+				Misses:    stats.Misses,
+				Shared:    stats.Shared,
+				Evictions: stats.Evictions,
+				Rooms:     stats.Rooms,
+				Bytes:     stats.Bytes,
+			}
+		})
+		servedRoomsRepo = snapshotCache
+	}
+
+	//region sharding
+	// router is nil (not a nil *sharding.Router) if sharding is disabled, so the service doesn't use it
+	var shardRouter roomservice.ShardRouter
+	var staticMembership *membership.StaticMembership
+	var router *sharding.Router
+	if cfg.Sharding.Enabled {
+		var shardMembership ports.MembershipPort
+		switch cfg.Sharding.Membership {
+		case config.ShardingMembershipStatic, "":
+			staticMembership = membership.NewStaticMembership(cfg.Sharding.Members)
+			shardMembership = staticMembership
+		default:
+			panic(fmt.Errorf("unknown sharding membership: '%s' (Use one of these: 'static')", cfg.Sharding.Membership))
+		}
+		router = sharding.NewRouter(cfg.Sharding.AdvertiseAddr, shardMembership, sharding.Params{
+			VirtualNodes:    cfg.Sharding.VirtualNodes,
+			RefreshInterval: time.Duration(cfg.Sharding.RefreshIntervalSeconds) * time.Second,
+		})
+		defer func() { _ = router.Close() }()
+		// rooms that move to another instance are written and unloaded, so it reads them from storage
+		if writeBehind != nil {
+			router.OnRebalance(func(ctx context.Context, owned func(roomID string) bool) error {
+				return writeBehind.Release(ctx, ownedRoomIDs(owned))
+			})
+		}
+		if snapshotCache != nil {
+			router.OnRebalance(func(ctx context.Context, owned func(roomID string) bool) error {
+				return snapshotCache.Release(ctx, ownedRoomIDs(owned))
+			})
+		}
+		if err = router.Refresh(ctx); err != nil {
+			logging.FromContext(ctx).Error(ctx, "error reading sharding members", zap.Error(err))
+			return
+		}
+		appMetrics.RegisterShardingStats(func() metrics.ShardingStats {
+			stats := router.Stats()
+			return metrics.ShardingStats{
+				Members:       stats.Members,
+				Forwarded:     stats.Forwarded,
+				ForwardErrors: stats.ForwardErrors,
+				Rebalances:    stats.Rebalances,
+			}
+		})
+		shardRouter = router
+		logging.FromContext(ctx).Info(ctx, "rooms are sharded",
+			zap.String("advertise_addr", cfg.Sharding.AdvertiseAddr), zap.Strings("members", cfg.Sharding.Members))
+	}
+	//endregion
+
+	roomServiceServer := roomservice.NewRoomService(
+		servedRoomsRepo,
+		servedCommandCache,
+		rateLimiter,
+		eventBus,
+		shardRouter,
+		cfg.Service.RetryStrategy.ToPolicy(nil, retryBudget),
+		roomservice.StreamParams{
+			Ordering: roomservice.CommandOrderingParams{
+				Mode:        roomservice.CommandOrdering(cfg.Service.Ordering.Mode),
+				QueueSize:   cfg.Service.Ordering.QueueSize,
+				RoomWorkers: cfg.Service.Ordering.RoomWorkers,
+			},
+			Outbound: roomservice.StreamWriterParams{
+				BufferSize:         cfg.Service.Outbound.BufferSize,
+				SlowConsumerPolicy: roomservice.SlowConsumerPolicy(cfg.Service.Outbound.SlowConsumerPolicy),
+			},
+			CommandTimeout: time.Duration(cfg.Service.CommandTimeoutMilliseconds) * time.Millisecond,
+		},
+		appMetrics,
 	)
+	roomServiceServer.UpdateRuntimeParams(runtimeParams(cfg, retryBudget))
 	//endregion
 
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(interceptors.AddLogMiddleware))
+	// stats handler extracts trace context from incoming metadata, so command spans continue client's trace
+	grpcServer := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.UnaryInterceptor(interceptors.NewLogMiddleware(baseLogger)),
+		grpc.StreamInterceptor(interceptors.NewStreamLogMiddleware(baseLogger)),
+	)
 	room_service.RegisterRoomServiceServer(grpcServer, roomServiceServer)
+	if router != nil {
+		room_service.RegisterRoomShardServiceServer(grpcServer, roomServiceServer)
+	}
+
+	//region health checks
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	probes := []roomhealth.Probe{
+		{Name: "rooms", Check: roomsRepo.Ping},
+		{Name: "command_cache", Check: commandCache.Ping},
+	}
+	if roomsJournal != nil {
+		probes = append(probes, roomhealth.Probe{Name: "journal", Check: roomsJournal.Ping})
+	}
+	if redisEventBus != nil {
+		probes = append(probes, roomhealth.Probe{Name: "event_bus", Check: redisEventBus.Ping})
+	}
+	healthChecker := roomhealth.NewChecker(healthServer,
+		roomhealth.Params{
+			Interval: time.Duration(cfg.Service.Health.ProbeIntervalSeconds) * time.Second,
+			Timeout:  time.Duration(cfg.Service.Health.ProbeTimeoutSeconds) * time.Second,
+			Services: []string{room_service.RoomService_ServiceDesc.ServiceName},
+		},
+		probes...,
+	)
+	//endregion
 	appServer := server.NewGracefulServer[*net.Listener](
 		grpcserver.NewGracefulServerImplementationGRPC(grpcServer))
 
 	//region run
-	ctx, stopCtx := context.WithCancel(context.Background())
+	ctx, stopCtx := context.WithCancel(ctx)
 	defer stopCtx()
 
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "server starting :grpc_port", zap.Int("grpc_port", cfg.Service.GRPCPort))
-	err = appServer.GracefulRun(ctx, cfg.Service.GRPCPort)
+	// serverCtx is canceled only after streams are drained, otherwise GracefulStop waits for them forever
+	serverCtx, stopServer := context.WithCancel(ctx)
+	defer stopServer()
+	signalCtx, stopSignal := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignal()
+
+	drainTimeout := time.Duration(cfg.Service.ShutdownTimeoutSeconds) * time.Second
+	if drainTimeout <= 0 {
+		drainTimeout = defaultShutdownTimeout
+	}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-signalCtx.Done()
+
+		// not ready anymore, so no new streams are routed here while existing ones are drained
+		healthChecker.Shutdown()
+		logging.FromContext(ctx).Info(ctx, "shutting down, draining streams", zap.Duration("timeout", drainTimeout))
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancelDrain()
+		if errDrain := roomServiceServer.Shutdown(drainCtx); errDrain != nil {
+			logging.FromContext(ctx).Error(ctx, "failed to drain streams", zap.Error(errDrain))
+		} else {
+			logging.FromContext(ctx).Info(ctx, "streams drained")
+		}
+		stopServer()
+	}()
+
+	//region config reload
+	// SIGHUP or CONFIG_PATH file change - retry strategy, command timeout, rate limits, quotas, log level
+	// and static sharding members are applied, streams stay open
+	configWatcher := config.NewWatcher(cfg, 0, func(ctx context.Context, next *config.Config, changes []config.Change) {
+		roomServiceServer.UpdateRuntimeParams(runtimeParams(next, retryBudget))
+		if staticMembership != nil {
+			staticMembership.Set(next.Sharding.Members)
+			if errRefresh := router.Refresh(ctx); errRefresh != nil {
+				logging.FromContext(ctx).Error(ctx, "failed to rebalance rooms", zap.Error(errRefresh))
+			}
+		}
+		// level isn't reset on unrelated changes, so SIGUSR1 change is kept
+		for _, change := range changes {
+			if change.Field == "log.level" {
+				if errLevel := baseLogger.SetLevel(next.Log.LogLevel); errLevel != nil {
+					logging.FromContext(ctx).Error(ctx, "failed to change log level", zap.Error(errLevel))
+				}
+			}
+		}
+	})
+	go configWatcher.Run(ctx)
+	//endregion
+
+	//region log level signals
+	// SIGUSR1 - switch to debug level, SIGUSR2 - back to the configured one
+	logLevelSignals := make(chan os.Signal, 1)
+	signal.Notify(logLevelSignals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(logLevelSignals)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case received := <-logLevelSignals:
+				level := configWatcher.Current().Log.LogLevel
+				if received == syscall.SIGUSR1 {
+					level = "debug"
+				}
+				if errLevel := baseLogger.SetLevel(level); errLevel != nil {
+					logging.FromContext(ctx).Error(ctx, "failed to change log level", zap.Error(errLevel))
+					continue
+				}
+				logging.FromContext(ctx).Warn(ctx, "log level changed", zap.String("log_level", level), zap.String("signal", received.String()))
+			}
+		}
+	}()
+	//endregion
+
+	//region metrics server
+	metricsStopped := closedChan()
+	if cfg.Service.MetricsPort > 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", appMetrics.Handler())
+		metricsStopped = runHTTPServer(ctx, "metrics", cfg.Service.MetricsPort, metricsMux)
+	}
+	//endregion
+
+	if writeBehind != nil {
+		go writeBehind.Run(ctx)
+	}
+	if redisEventBus != nil {
+		go redisEventBus.Run(ctx)
+	}
+	if router != nil {
+		go router.Run(ctx)
+	}
+
+	//region health
+	go healthChecker.Run(ctx)
+	healthStopped := closedChan()
+	if cfg.Service.Health.HTTPPort > 0 {
+		healthMux := http.NewServeMux()
+		healthMux.Handle("/healthz", healthChecker.LivenessHandler())
+		healthMux.Handle("/readyz", healthChecker.ReadinessHandler())
+		healthStopped = runHTTPServer(ctx, "health", cfg.Service.Health.HTTPPort, healthMux)
+	}
+	//endregion
+
+	logging.FromContext(ctx).Info(ctx, "server starting :grpc_port", zap.Int("grpc_port", cfg.Service.GRPCPort))
+	err = appServer.GracefulRun(serverCtx, cfg.Service.GRPCPort)
+
+	// server might stop by itself, drain anyway
+	stopSignal()
+	<-drained
 	//endregion
 
 	//region shutdown
 	if err != nil {
-		logger.GetLoggerFromCtx(ctx).Error(ctx, fmt.Errorf("http server error: %w", err).Error())
+		logging.FromContext(ctx).Error(ctx, fmt.Errorf("http server error: %w", err).Error())
+	}
+
+	logging.FromContext(ctx).Info(ctx, "server gracefully shutdown")
+
+	// streams are drained, so every change of rooms is already made
+	if writeBehind != nil {
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), drainTimeout)
+		if errFlush := writeBehind.Flush(flushCtx); errFlush != nil {
+			logging.FromContext(ctx).Error(ctx, "failed to flush rooms, their last changes are lost", zap.Error(errFlush))
+		} else {
+			logging.FromContext(ctx).Info(ctx, "rooms flushed")
+		}
+		cancelFlush()
 	}
 
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "server gracefully shutdown")
+	if inMemoryCommandCache != nil {
+		stats := inMemoryCommandCache.Stats()
+		logging.FromContext(ctx).Info(ctx, "in-memory command cache stats",
+			zap.Uint64("hits", stats.Hits),
+			zap.Uint64("misses", stats.Misses),
+			zap.Uint64("evictions", stats.Evictions),
+			zap.Uint64("expirations", stats.Expirations),
+			zap.Int("size", stats.Size))
+	}
 
 	stopCtx()
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "background operations gracefully shutdown")
+	<-metricsStopped
+	<-healthStopped
+	logging.FromContext(ctx).Info(ctx, "background operations gracefully shutdown, closing repositories")
 	fmt.Println("finish")
 	//endregion
 }
+
+// runtimeParams - part of cfg that can be changed without restart, see roomservice.RoomService.UpdateRuntimeParams
+//
+// retryBudget is shared by every policy, its size is only read on start
+func runtimeParams(cfg *config.Config, retryBudget *pkgconfig.RetryBudget) roomservice.RuntimeParams {
+	rateLimits := make(map[string]roomservice.CommandRateLimits, len(cfg.RateLimit.Commands))
+	for payloadType, limits := range cfg.RateLimit.Commands {
+		rateLimits[payloadType] = roomservice.CommandRateLimits{
+			Room:   rateLimit(limits.Room),
+			User:   rateLimit(limits.User),
+			Stream: rateLimit(limits.Stream),
+		}
+	}
+	return roomservice.RuntimeParams{
+		RetryPolicy:    cfg.Service.RetryStrategy.ToPolicy(nil, retryBudget),
+		CommandTimeout: time.Duration(cfg.Service.CommandTimeoutMilliseconds) * time.Millisecond,
+		RateLimits:     rateLimits,
+		Quotas: roomservice.Quotas{
+			MaxRoomsPerOwner: cfg.Service.Quotas.MaxRoomsPerOwner,
+			MaxMembers:       cfg.Service.Quotas.MaxMembers,
+			MaxMetadataBytes: cfg.Service.Quotas.MaxMetadataBytes,
+			MaxKeys:          cfg.Service.Quotas.MaxKeys,
+			MaxValueBytes:    cfg.Service.Quotas.MaxValueBytes,
+			MaxRoomBytes:     cfg.Service.Quotas.MaxRoomBytes,
+			MaxListLength:    cfg.Service.Quotas.MaxListLength,
+			MaxDepth:         cfg.Service.Quotas.MaxDepth,
+		},
+	}
+}
+
+// ownedRoomIDs - owned of sharding.RebalanceFunc for repositories that store rooms by models.RoomID
+func ownedRoomIDs(owned func(roomID string) bool) func(roomID models.RoomID) bool {
+	return func(roomID models.RoomID) bool {
+		return owned(roomID.String())
+	}
+}
+
+// rateLimit - bucket as ports.RateLimit, burst defaults to per second rounded up
+func rateLimit(bucket config.BucketConfig) ports.RateLimit {
+	burst := bucket.Burst
+	if burst == 0 {
+		burst = max(1, int(math.Ceil(bucket.PerSecond)))
+	}
+	return ports.RateLimit{PerSecond: bucket.PerSecond, Burst: burst}
+}
+
+// breakerParams - cfg as circuitbreaker.Params
+func breakerParams(cfg config.BreakerConfig) circuitbreaker.Params {
+	return circuitbreaker.Params{
+		Window:         time.Duration(cfg.WindowSeconds) * time.Second,
+		MinCalls:       cfg.MinCalls,
+		FailureRatio:   cfg.FailureRatio,
+		OpenTimeout:    time.Duration(cfg.OpenTimeoutMilliseconds) * time.Millisecond,
+		HalfOpenProbes: cfg.HalfOpenProbes,
+	}
+}
+
+// runHTTPServer - serve handler on port until ctx is canceled (or os.Interrupt, see server.GracefulRun), returned chan is closed when it's stopped
+func runHTTPServer(ctx context.Context, name string, port int, handler http.Handler) <-chan struct{} {
+	stopped := make(chan struct{})
+	httpServer := server.NewGracefulServer[*http.Server](httpserver.NewGracefulServerImplementationHTTP(handler))
+	go func() {
+		defer close(stopped)
+		logging.FromContext(ctx).Info(ctx, name+" server starting :port", zap.Int("port", port))
+		if err := httpServer.GracefulRun(ctx, port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.FromContext(ctx).Error(ctx, name+" server error", zap.Error(err))
+		}
+	}()
+	return stopped
+}
+
+// closedChan - chan of a server that isn't started
+func closedChan() <-chan struct{} {
+	stopped := make(chan struct{})
+	close(stopped)
+	return stopped
+}
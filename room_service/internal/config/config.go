@@ -5,23 +5,49 @@ import (
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/mongodb"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/redis"
 	"github.com/ilyakaznacheev/cleanenv"
+	"os"
 )
 
+// EnvConfigPath - env variable with path to YAML config file, env variables override values from it
+const EnvConfigPath = "CONFIG_PATH"
+
 // Config is the main, assembled config type
 type Config struct {
 	Service          RoomServiceConfig      `yaml:"room_service" env-prefix:"ROOM_SERVICE_"`
 	Log              LogConfig              `yaml:"log" env-prefix:"ROOM_SERVICE_LOG_"`
+	Rooms            RoomsConfig            `yaml:"rooms" env-prefix:"ROOM_SERVICE_ROOMS_"`
 	MongoDBRoomsRepo MongoDBRoomsRepoConfig `yaml:"mongodb_rooms" env-prefix:"ROOM_SERVICE_ROOMS_MONGODB_"`
 	MongoDB          mongodb.Config         `yaml:"mongodb" env-prefix:"ROOM_SERVICE_MONGODB_"`
 	Redis            redis.Config           `yaml:"redis" env-prefix:"ROOM_SERVICE_REDIS_"`
+	Bolt             BoltConfig             `yaml:"bolt" env-prefix:"ROOM_SERVICE_BOLT_"`
+	CommandCache     CommandCacheConfig     `yaml:"command_cache" env-prefix:"ROOM_SERVICE_COMMAND_CACHE_"`
+	Tracing          TracingConfig          `yaml:"tracing" env-prefix:"ROOM_SERVICE_TRACING_"`
+	RateLimit        RateLimitConfig        `yaml:"rate_limit" env-prefix:"ROOM_SERVICE_RATE_LIMIT_"`
+	CircuitBreaker   CircuitBreakersConfig  `yaml:"circuit_breaker" env-prefix:"ROOM_SERVICE_CIRCUIT_BREAKER_"`
+	SnapshotCache    SnapshotCacheConfig    `yaml:"snapshot_cache" env-prefix:"ROOM_SERVICE_SNAPSHOT_CACHE_"`
+	WriteBehind      WriteBehindConfig      `yaml:"write_behind" env-prefix:"ROOM_SERVICE_WRITE_BEHIND_"`
+	Journal          JournalConfig          `yaml:"journal" env-prefix:"ROOM_SERVICE_JOURNAL_"`
+	EventBus         EventBusConfig         `yaml:"event_bus" env-prefix:"ROOM_SERVICE_EVENT_BUS_"`
+	Sharding         ShardingConfig         `yaml:"sharding" env-prefix:"ROOM_SERVICE_SHARDING_"`
 }
 
-// TryRead tries to read config from ENV and returns it on success
+// TryRead tries to read config and returns it on success
+//
+// if CONFIG_PATH is set, YAML file is read first and env variables override it, otherwise only env is read.
+// Config is validated, see Config.Validate
 func TryRead() (*Config, error) {
 	var cfg Config
-	if err := cleanenv.ReadEnv(&cfg); err != nil {
+	if path, ok := os.LookupEnv(EnvConfigPath); ok && len(path) > 0 {
+		if err := cleanenv.ReadConfig(path, &cfg); err != nil {
+			return nil,
+				fmt.Errorf("failed to read config file '%s' and env variables: %w", path, err)
+		}
+	} else if err := cleanenv.ReadEnv(&cfg); err != nil {
 		return nil,
 			fmt.Errorf("failed to read env variables after accessing .env: %w", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
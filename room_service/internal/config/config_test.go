@@ -0,0 +1,294 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTryReadMergesYAMLWithEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+room_service:
+  grpc_port: 50051
+  retry:
+    attempts: 5
+mongodb:
+  hosts: ["mongo-1:27017"]
+redis:
+  addr: redis:6379
+mongodb_rooms:
+  write_concern: "w: 2, j: false"
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvConfigPath, path)
+	t.Setenv("ROOM_SERVICE_RETRY_ATTEMPTS", "7")
+
+	cfg, err := TryRead()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Service.GRPCPort != 50051 {
+		t.Errorf("grpc_port from file = %d, want 50051", cfg.Service.GRPCPort)
+	}
+	if cfg.Service.RetryStrategy.Attempts != 7 {
+		t.Errorf("retry attempts = %d, want 7 from env", cfg.Service.RetryStrategy.Attempts)
+	}
+	if cfg.Service.Ordering.Mode != "stream" {
+		t.Errorf("ordering mode = %q, want default 'stream'", cfg.Service.Ordering.Mode)
+	}
+
+	writeConcern, err := cfg.MongoDBRoomsRepo.ParseWriteConcern()
+	if err != nil {
+		t.Fatalf("unexpected write concern error: %v", err)
+	}
+	if writeConcern.W != 2 || writeConcern.Journal == nil || *writeConcern.Journal {
+		t.Errorf("write concern = %+v, want w: 2, j: false", writeConcern)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Config{}
+	cfg.Service.GRPCPort = -1
+	cfg.Service.Ordering.Mode = "random"
+	cfg.MongoDBRoomsRepo.ReadConcern = "local"
+	cfg.MongoDBRoomsRepo.WriteConcern = "w: majority, fsync: true"
+	cfg.MongoDB.Hosts = []string{"mongodb:27017"}
+	cfg.Redis.Addr = "redis:6379"
+	cfg.Tracing.SampleRatio = 2
+	cfg.WriteBehind = WriteBehindConfig{Enabled: true, FlushIntervalMilliseconds: 1000, MaxStalenessMilliseconds: 500}
+	cfg.Journal = JournalConfig{Enabled: true, Storage: "s3", SnapshotEvery: -1}
+	cfg.EventBus = EventBusConfig{Storage: "kafka", SubscriberBufferSize: -1}
+	cfg.RateLimit.Commands = map[string]CommandRateLimitConfig{
+		"affect_data": {Room: BucketConfig{PerSecond: -1}},
+		"send_spam":   {},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, field := range []string{
+		"room_service.grpc_port",
+		"room_service.retry: attempts",
+		"room_service.retry: backoff",
+		"room_service.ordering.mode",
+		"mongodb_rooms.write_concern",
+		"tracing.sample_ratio",
+		"write_behind.max_staleness_milliseconds",
+		"journal.storage",
+		"journal.snapshot_every",
+		"event_bus.storage",
+		"event_bus.subscriber_buffer_size",
+		"rate_limit.commands.affect_data.room.per_second",
+		"unknown value 'send_spam'",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error doesn't mention %s:\n%v", field, err)
+		}
+	}
+	if strings.Contains(err.Error(), "mongodb_rooms.read_concern") {
+		t.Errorf("valid read concern is reported:\n%v", err)
+	}
+}
+
+func TestValidateRedisRoomsStorage(t *testing.T) {
+	cfg := Config{}
+	cfg.Service.GRPCPort = 50051
+	cfg.Rooms.Storage = RoomsStorageRedis
+	cfg.CommandCache.Storage = CommandCacheStorageInMemory
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "redis.addr") {
+		t.Fatalf("expected redis.addr to be required, got %v", err)
+	}
+	if strings.Contains(err.Error(), "mongodb") {
+		t.Errorf("mongodb is validated though rooms are stored in redis:\n%v", err)
+	}
+	// in-memory command cache without capacity and TTL would grow without limit
+	if !strings.Contains(err.Error(), "command_cache.capacity") {
+		t.Errorf("unbounded in-memory command cache isn't reported:\n%v", err)
+	}
+}
+
+func TestValidateBoltStorages(t *testing.T) {
+	cfg := Config{}
+	cfg.Service.GRPCPort = 50051
+	cfg.Service.RetryStrategy.Attempts = 1
+	cfg.Service.RetryStrategy.Backoff = 1
+	cfg.Rooms.Storage = RoomsStorageBolt
+	cfg.CommandCache.Storage = CommandCacheStorageBolt
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "bolt.path") {
+		t.Fatalf("expected bolt.path to be required, got %v", err)
+	}
+	if strings.Contains(err.Error(), "mongodb") || strings.Contains(err.Error(), "redis") {
+		t.Errorf("mongodb or redis is validated though only bolt is used:\n%v", err)
+	}
+
+	cfg.Bolt.Path = "room_service.db"
+	if err = cfg.Validate(); err != nil {
+		t.Fatalf("valid bolt config: %v", err)
+	}
+}
+
+func TestValidateSharding(t *testing.T) {
+	cfg := Config{}
+	cfg.Service.GRPCPort = 50051
+	cfg.Service.RetryStrategy.Attempts = 1
+	cfg.Service.RetryStrategy.Backoff = 1
+	cfg.Rooms.Storage = RoomsStorageInMemory
+	cfg.CommandCache.Storage = CommandCacheStorageInMemory
+	cfg.CommandCache.Capacity = 1000
+	cfg.Sharding = ShardingConfig{Enabled: true, AdvertiseAddr: "room-c:50051", Members: []string{"room-a:50051", "room-b:50051"}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, field := range []string{"sharding.advertise_addr", "rooms.storage", "event_bus.storage"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error doesn't mention %s:\n%v", field, err)
+		}
+	}
+
+	cfg.Sharding.AdvertiseAddr = "room-b:50051"
+	cfg.Rooms.Storage = RoomsStorageRedis
+	cfg.Redis.Addr = "redis:6379"
+	cfg.EventBus.Storage = EventBusStorageRedis
+	if err = cfg.Validate(); err != nil {
+		t.Fatalf("valid sharding config: %v", err)
+	}
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	cfg := Config{}
+	cfg.Service.GRPCPort = 50051
+	cfg.Service.RetryStrategy.Attempts = 1
+	cfg.Service.RetryStrategy.Backoff = 1
+	cfg.Rooms.Storage = RoomsStorageInMemory
+	cfg.CommandCache.Storage = CommandCacheStorageInMemory
+	cfg.CommandCache.Capacity = 1000
+	// zero bucket = no limit, zero burst = per_second rounded up
+	cfg.RateLimit.Commands = map[string]CommandRateLimitConfig{
+		"join_room":   {},
+		"affect_data": {Room: BucketConfig{PerSecond: 0.5}, User: BucketConfig{PerSecond: 10, Burst: 20}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid rate limits: %v", err)
+	}
+
+	cfg.RateLimit.Storage = "memcached"
+	cfg.RateLimit.Commands = map[string]CommandRateLimitConfig{
+		"affect_data":  {User: BucketConfig{PerSecond: 1, Burst: -1}},
+		"refresh_room": {Stream: BucketConfig{PerSecond: -0.5}},
+		"":             {},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, field := range []string{
+		"rate_limit.storage",
+		"rate_limit.commands.affect_data.user.burst",
+		"rate_limit.commands.refresh_room.stream.per_second",
+		"rate_limit.commands: unknown value ''",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error doesn't mention %s:\n%v", field, err)
+		}
+	}
+	if strings.Contains(err.Error(), "affect_data.user.per_second") {
+		t.Errorf("valid per_second is reported:\n%v", err)
+	}
+
+	// redis buckets need redis.addr
+	cfg.RateLimit = RateLimitConfig{Storage: RateLimitStorageRedis}
+	if err = cfg.Validate(); err == nil || !strings.Contains(err.Error(), "redis.addr") {
+		t.Fatalf("expected redis.addr to be required, got %v", err)
+	}
+}
+
+func TestValidateQuotas(t *testing.T) {
+	cfg := Config{}
+	cfg.Service.GRPCPort = 50051
+	cfg.Service.RetryStrategy.Attempts = 1
+	cfg.Service.RetryStrategy.Backoff = 1
+	cfg.Rooms.Storage = RoomsStorageInMemory
+	cfg.CommandCache.Storage = CommandCacheStorageInMemory
+	cfg.CommandCache.Capacity = 1000
+	// 0 = no limit, value size isn't bound by unlimited room size
+	cfg.Service.Quotas = QuotasConfig{MaxMembers: 10, MaxValueBytes: 1024}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid quotas: %v", err)
+	}
+
+	cfg.Service.Quotas = QuotasConfig{
+		MaxRoomsPerOwner: -1,
+		MaxMembers:       -1,
+		MaxMetadataBytes: -1,
+		MaxKeys:          -1,
+		MaxValueBytes:    2048,
+		MaxRoomBytes:     1024,
+		MaxListLength:    -1,
+		MaxDepth:         -1,
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, field := range []string{
+		"room_service.quotas.max_rooms_per_owner",
+		"room_service.quotas.max_members",
+		"room_service.quotas.max_metadata_bytes",
+		"room_service.quotas.max_keys",
+		"room_service.quotas.max_value_bytes: must not be bigger than max_room_bytes",
+		"room_service.quotas.max_list_length",
+		"room_service.quotas.max_depth",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error doesn't mention %s:\n%v", field, err)
+		}
+	}
+	if strings.Contains(err.Error(), "max_room_bytes:") {
+		t.Errorf("valid max_room_bytes is reported:\n%v", err)
+	}
+}
+
+func TestParseWriteConcern(t *testing.T) {
+	tests := []struct {
+		concern string
+		wantW   any
+		wantErr bool
+	}{
+		{concern: "w: majority, j: true", wantW: "majority"},
+		{concern: "w: 1", wantW: 1},
+		{concern: "w: dc-east", wantW: "dc-east"},
+		{concern: "", wantW: nil},
+		{concern: "majority", wantErr: true},
+		{concern: "w: -1", wantErr: true},
+		{concern: "j: maybe", wantErr: true},
+		{concern: "w: 0, j: true", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.concern, func(t *testing.T) {
+			concern, err := MongoDBRoomsRepoConfig{WriteConcern: tt.concern}.ParseWriteConcern()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", concern)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if concern.W != tt.wantW {
+				t.Errorf("w = %v, want %v", concern.W, tt.wantW)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Change - one field that differs between two configs, see Diff
+type Change struct {
+	// Field - path of yaml keys, e.g. "room_service.retry.attempts"
+	Field    string
+	Previous string
+	Next     string
+}
+
+// String - "field: previous -> next"
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Previous, c.Next)
+}
+
+// Diff - fields that differ between previous and next, secrets (fields named like "password") are masked
+func Diff(previous *Config, next *Config) []Change {
+	var changes []Change
+	diffValues("", reflect.ValueOf(*previous), reflect.ValueOf(*next), &changes)
+	return changes
+}
+
+func diffValues(path string, previous reflect.Value, next reflect.Value, changes *[]Change) {
+	if previous.Kind() == reflect.Struct {
+		for i := 0; i < previous.NumField(); i++ {
+			field := previous.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			diffValues(joinPath(path, yamlName(field)), previous.Field(i), next.Field(i), changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(previous.Interface(), next.Interface()) {
+		return
+	}
+	change := Change{
+		Field:    path,
+		Previous: fmt.Sprintf("%v", previous.Interface()),
+		Next:     fmt.Sprintf("%v", next.Interface()),
+	}
+	if strings.Contains(strings.ToLower(path), "password") {
+		change.Previous, change.Next = "***", "***"
+	}
+	*changes = append(*changes, change)
+}
+
+// yamlName - name of field in YAML, lowercase field name if there's no tag
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if len(name) == 0 {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func joinPath(path string, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
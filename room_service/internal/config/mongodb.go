@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+	"strconv"
+	"strings"
+)
+
+// ParseReadConcern - MongoDBRoomsRepoConfig.ReadConcern as readconcern.ReadConcern
+//
+// available values: "available", "local", "majority", "linearizable", "snapshot"
+func (c MongoDBRoomsRepoConfig) ParseReadConcern() (*readconcern.ReadConcern, error) {
+	switch c.ReadConcern {
+	case "available":
+		return readconcern.Available(), nil
+	case "local":
+		return readconcern.Local(), nil
+	case "majority":
+		return readconcern.Majority(), nil
+	case "linearizable":
+		return readconcern.Linearizable(), nil
+	case "snapshot":
+		return readconcern.Snapshot(), nil
+	default:
+		return nil, fmt.Errorf("unknown read concern: '%s' (Use one of these: 'available', 'local', 'majority', 'linearizable', 'snapshot')", c.ReadConcern)
+	}
+}
+
+// ParseWriteConcern - MongoDBRoomsRepoConfig.WriteConcern as writeconcern.WriteConcern
+//
+// format: comma-separated "key: value" options, e.g. "w: majority, j: true"
+//
+// "w" - "majority", amount of nodes (0 = unacknowledged) or tag set name; "j" - true/false (journaled)
+func (c MongoDBRoomsRepoConfig) ParseWriteConcern() (*writeconcern.WriteConcern, error) {
+	concern := &writeconcern.WriteConcern{}
+	for _, option := range strings.Split(c.WriteConcern, ",") {
+		option = strings.TrimSpace(option)
+		if len(option) == 0 {
+			continue
+		}
+		key, value, ok := strings.Cut(option, ":")
+		if !ok {
+			return nil, fmt.Errorf("write concern option '%s' isn't 'key: value'", option)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "w":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("write concern option 'w' is empty")
+			}
+			if nodes, err := strconv.Atoi(value); err == nil {
+				if nodes < 0 {
+					return nil, fmt.Errorf("write concern option 'w' is negative: %d", nodes)
+				}
+				concern.W = nodes
+			} else {
+				// "majority" or tag set name
+				concern.W = value
+			}
+		case "j":
+			journaled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("write concern option 'j' isn't bool: '%s'", value)
+			}
+			concern.Journal = &journaled
+		default:
+			return nil, fmt.Errorf("unknown write concern option '%s' (Use these: 'w', 'j')", key)
+		}
+	}
+	if !concern.IsValid() {
+		return nil, fmt.Errorf("write concern '%s' is invalid (unacknowledged writes can't be journaled)", c.WriteConcern)
+	}
+	return concern, nil
+}
@@ -6,22 +6,311 @@ import "github.com/chempik1234/room-service/pkg/config"
 type RoomServiceConfig struct {
 	// GRPCPort - port that Client gateways should connect to (requests and room streaming)
 	GRPCPort int `yaml:"grpc_port" env:"GRPC_PORT"`
+	// MetricsPort - port of HTTP "/metrics" endpoint (prometheus), 0 = disabled
+	MetricsPort int `yaml:"metrics_port" env:"METRICS_PORT" env-default:"9090"`
 	// RetryStrategy - retries for gRPC operations
 	RetryStrategy config.RetryStrategyConfig `yaml:"retry" env-prefix:"RETRY_"`
+	// Ordering - how commands received in one stream are ordered
+	Ordering CommandOrderingConfig `yaml:"ordering" env-prefix:"ORDERING_"`
+	// Outbound - how events are sent into one stream
+	Outbound OutboundConfig `yaml:"outbound" env-prefix:"OUTBOUND_"`
+	// CommandTimeoutMilliseconds - max time of processing one command, 0 = no limit
+	CommandTimeoutMilliseconds int `yaml:"command_timeout_milliseconds" env:"COMMAND_TIMEOUT_MILLISECONDS" env-default:"10000"`
+	// ShutdownTimeoutSeconds - how long streams are drained on shutdown before in-flight commands are canceled
+	ShutdownTimeoutSeconds int `yaml:"shutdown_timeout_seconds" env:"SHUTDOWN_TIMEOUT_SECONDS" env-default:"15"`
+	// Health - dependency probes, reported by grpc.health.v1 on GRPCPort and HTTP "/healthz", "/readyz"
+	Health HealthConfig `yaml:"health" env-prefix:"HEALTH_"`
+	// Quotas - limits of stored data, checked before storage is called
+	Quotas QuotasConfig `yaml:"quotas" env-prefix:"QUOTAS_"`
+}
+
+// QuotasConfig - config for limits of stored data, 0 = no limit
+//
+// sizes are in bytes: values are counted by their type (1 byte) and payload (8 for numbers, 1 for bool, length of strings and bytes),
+// metadata (user's metadata, room options) by lengths of keys and values
+type QuotasConfig struct {
+	MaxRoomsPerOwner int `yaml:"max_rooms_per_owner" env:"MAX_ROOMS_PER_OWNER"`
+	MaxMembers       int `yaml:"max_members" env:"MAX_MEMBERS"`
+	MaxMetadataBytes int `yaml:"max_metadata_bytes" env:"MAX_METADATA_BYTES"`
+	// MaxKeys - data keys in one room
+	MaxKeys       int `yaml:"max_keys" env:"MAX_KEYS"`
+	MaxValueBytes int `yaml:"max_value_bytes" env:"MAX_VALUE_BYTES"`
+	// MaxRoomBytes - all data keys and values of one room
+	MaxRoomBytes  int `yaml:"max_room_bytes" env:"MAX_ROOM_BYTES"`
+	MaxListLength int `yaml:"max_list_length" env:"MAX_LIST_LENGTH"`
+	// MaxDepth - nesting of lists and maps in one value, 1 = list or map of scalars
+	MaxDepth int `yaml:"max_depth" env:"MAX_DEPTH"`
+}
+
+// HealthConfig - config for health checks
+type HealthConfig struct {
+	// HTTPPort - port of HTTP "/healthz" (liveness) and "/readyz" (readiness) endpoints, 0 = disabled
+	HTTPPort int `yaml:"http_port" env:"HTTP_PORT" env-default:"8081"`
+	// ProbeIntervalSeconds - time between dependency probes, 0 = 5 seconds
+	ProbeIntervalSeconds int `yaml:"probe_interval_seconds" env:"PROBE_INTERVAL_SECONDS" env-default:"5"`
+	// ProbeTimeoutSeconds - max time of one dependency probe, 0 = 2 seconds
+	ProbeTimeoutSeconds int `yaml:"probe_timeout_seconds" env:"PROBE_TIMEOUT_SECONDS" env-default:"2"`
+}
+
+// OutboundConfig - config for events sent into one stream
+//
+// available slow consumer policies: "block" (wait for space), "drop" (drop event), "disconnect" (close stream)
+type OutboundConfig struct {
+	// BufferSize - max events waiting to be sent into one stream
+	BufferSize         int    `yaml:"buffer_size" env:"BUFFER_SIZE" env-default:"256"`
+	SlowConsumerPolicy string `yaml:"slow_consumer_policy" env:"SLOW_CONSUMER_POLICY" env-default:"block"`
+}
+
+// CommandOrderingConfig - config for ordering of commands in one stream
+//
+// available modes: "stream" (sequential per stream), "room" (sequential per room), "parallel"
+type CommandOrderingConfig struct {
+	Mode string `yaml:"mode" env:"MODE" env-default:"stream"`
+	// QueueSize - max queued commands per worker ("parallel": max commands executed at once)
+	QueueSize int `yaml:"queue_size" env:"QUEUE_SIZE" env-default:"64"`
+	// RoomWorkers - workers per stream in "room" mode
+	RoomWorkers int `yaml:"room_workers" env:"ROOM_WORKERS" env-default:"8"`
 }
 
 // LogConfig - config struct for logging
 //
-// available log levels: "trace", "debug", "info", "warn", "error", "fatal", "panic"
-// TOO: remove that or add leveling to logs
+// available log levels: "debug", "info", "warn", "error", "dpanic", "panic", "fatal"
+//
+// available formats: "json", "console"
 type LogConfig struct {
-	LogLevel string `yaml:"level" env:"LEVEL" envDefault:"info"`
+	LogLevel string `yaml:"level" env:"LEVEL" env-default:"info"`
+	Format   string `yaml:"format" env:"FORMAT" env-default:"json"`
+	// SamplingInitial - same entries logged every second before sampling starts, 0 = no sampling
+	SamplingInitial int `yaml:"sampling_initial" env:"SAMPLING_INITIAL"`
+	// SamplingThereafter - every Nth same entry is logged after SamplingInitial
+	SamplingThereafter int `yaml:"sampling_thereafter" env:"SAMPLING_THEREAFTER" env-default:"100"`
+}
+
+// TracingConfig - config for OpenTelemetry tracing
+//
+// available exporters: "none" (only trace context propagation), "stdout", "otlp" (gRPC)
+type TracingConfig struct {
+	Exporter    string `yaml:"exporter" env:"EXPORTER" env-default:"none"`
+	ServiceName string `yaml:"service_name" env:"SERVICE_NAME" env-default:"room-service"`
+	// OTLPEndpoint - host:port of OTLP collector, empty = OTEL_EXPORTER_OTLP_* env or localhost:4317
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTLP_ENDPOINT"`
+	OTLPInsecure bool   `yaml:"otlp_insecure" env:"OTLP_INSECURE"`
+	// SampleRatio - share of new traces that are sampled, 0 = never, 1 = every trace
+	SampleRatio float64 `yaml:"sample_ratio" env:"SAMPLE_RATIO" env-default:"1"`
 }
 
 // MongoDBRoomsRepoConfig - config for rooms repo params
 type MongoDBRoomsRepoConfig struct {
-	Database        string `yaml:"database" env:"DATABASE" envDefault:"rooms_db"`
-	RoomsCollection string `yaml:"rooms_collection" env:"ROOMS_COLLECTION" envDefault:"rooms"`
-	ReadConcern     string `yaml:"read_concern" env:"READ_CONCERN" envDefault:"available"`
-	WriteConcern    string `yaml:"write_concern" env:"WRITE_CONCERN" envDefault:"w: majority, j: true"`
+	Database        string `yaml:"database" env:"DATABASE" env-default:"rooms_db"`
+	RoomsCollection string `yaml:"rooms_collection" env:"ROOMS_COLLECTION" env-default:"rooms"`
+	ReadConcern     string `yaml:"read_concern" env:"READ_CONCERN" env-default:"available"`
+	WriteConcern    string `yaml:"write_concern" env:"WRITE_CONCERN" env-default:"w: majority, j: true"`
+}
+
+// RoomsStorage - where rooms are stored
+type RoomsStorage string
+
+const (
+	// RoomsStorageMongoDB - store rooms in MongoDB (ROOM_SERVICE_MONGODB_ and ROOM_SERVICE_ROOMS_MONGODB_ configs are used)
+	RoomsStorageMongoDB RoomsStorage = "mongodb"
+	// RoomsStorageRedis - store short-lived rooms in Redis (ROOM_SERVICE_REDIS_ config is used), they expire without writes
+	RoomsStorageRedis RoomsStorage = "redis"
+	// RoomsStorageBolt - store rooms in embedded BoltDB file (ROOM_SERVICE_BOLT_ config is used), single instance only
+	RoomsStorageBolt RoomsStorage = "bolt"
+	// RoomsStorageInMemory - store rooms in process memory, they're lost on restart; for development, single instance only
+	RoomsStorageInMemory RoomsStorage = "in_memory"
+)
+
+// RoomsConfig - config for rooms storage
+//
+// RedisKeyPrefix and RedisTTLSeconds are only used with RoomsStorageRedis, 0 TTL = rooms don't expire
+type RoomsConfig struct {
+	Storage         RoomsStorage `yaml:"storage" env:"STORAGE" env-default:"mongodb"`
+	RedisKeyPrefix  string       `yaml:"redis_key_prefix" env:"REDIS_KEY_PREFIX" env-default:"room"`
+	RedisTTLSeconds int          `yaml:"redis_ttl_seconds" env:"REDIS_TTL_SECONDS" env-default:"86400"`
+}
+
+// CommandCacheStorage - where command IDs (no-repeat) are stored
+type CommandCacheStorage string
+
+const (
+	// CommandCacheStorageRedis - store command IDs in Redis (ROOM_SERVICE_REDIS_ config is used)
+	CommandCacheStorageRedis CommandCacheStorage = "redis"
+	// CommandCacheStorageInMemory - store command IDs in process memory, no Redis required
+	CommandCacheStorageInMemory CommandCacheStorage = "in_memory"
+	// CommandCacheStorageBolt - store command IDs in embedded BoltDB file (ROOM_SERVICE_BOLT_ config is used),
+	// they survive restarts, single instance only
+	CommandCacheStorageBolt CommandCacheStorage = "bolt"
+)
+
+// CommandCacheConfig - config for command IDs short cache
+//
+// Capacity and Shards are only used with CommandCacheStorageInMemory,
+// TTLSeconds - with CommandCacheStorageInMemory and CommandCacheStorageBolt, Redis storage uses redis TTL
+type CommandCacheConfig struct {
+	Storage    CommandCacheStorage `yaml:"storage" env:"STORAGE" env-default:"redis"`
+	Capacity   int                 `yaml:"capacity" env:"CAPACITY" env-default:"100000"`
+	Shards     int                 `yaml:"shards" env:"SHARDS" env-default:"16"`
+	TTLSeconds int                 `yaml:"ttl_seconds" env:"TTL_SECONDS" env-default:"300"`
+}
+
+// RateLimitStorage - where rate limit buckets are stored
+type RateLimitStorage string
+
+const (
+	// RateLimitStorageInMemory - buckets are stored in process memory, limits are per instance
+	RateLimitStorageInMemory RateLimitStorage = "in_memory"
+	// RateLimitStorageRedis - buckets are shared by instances in Redis (ROOM_SERVICE_REDIS_ config is used)
+	RateLimitStorageRedis RateLimitStorage = "redis"
+)
+
+// RateLimitConfig - config for token bucket rate limiting of commands
+//
+// Commands are keyed by payload type: "create_room", "delete_room", "join_room", "leave_room", "affect_data", "refresh_room".
+// Command types that aren't listed aren't limited. Commands can only be set in YAML
+type RateLimitConfig struct {
+	Storage  RateLimitStorage                  `yaml:"storage" env:"STORAGE" env-default:"in_memory"`
+	Commands map[string]CommandRateLimitConfig `yaml:"commands"`
+}
+
+// CommandRateLimitConfig - buckets of one command type, every room, user and stream has its own bucket
+type CommandRateLimitConfig struct {
+	Room   BucketConfig `yaml:"room"`
+	User   BucketConfig `yaml:"user"`
+	Stream BucketConfig `yaml:"stream"`
+}
+
+// BucketConfig - token bucket, 0 per second = no limit
+type BucketConfig struct {
+	// PerSecond - tokens added per second
+	PerSecond float64 `yaml:"per_second"`
+	// Burst - max tokens, 0 = PerSecond rounded up (at least 1)
+	Burst int `yaml:"burst"`
+}
+
+// CircuitBreakersConfig - circuit breakers of storages, they stop calling storage while it fails
+type CircuitBreakersConfig struct {
+	Rooms        BreakerConfig `yaml:"rooms" env-prefix:"ROOMS_"`
+	CommandCache BreakerConfig `yaml:"command_cache" env-prefix:"COMMAND_CACHE_"`
+}
+
+// BreakerConfig - config for one circuit breaker
+//
+// opened when FailureRatio of at least MinCalls during WindowSeconds fail (network errors, timeouts),
+// after OpenTimeoutMilliseconds HalfOpenProbes calls are made, breaker is closed if all of them succeed
+type BreakerConfig struct {
+	Disabled                bool    `yaml:"disabled" env:"DISABLED"`
+	WindowSeconds           int     `yaml:"window_seconds" env:"WINDOW_SECONDS" env-default:"10"`
+	MinCalls                int     `yaml:"min_calls" env:"MIN_CALLS" env-default:"20"`
+	FailureRatio            float64 `yaml:"failure_ratio" env:"FAILURE_RATIO" env-default:"0.5"`
+	OpenTimeoutMilliseconds int     `yaml:"open_timeout_milliseconds" env:"OPEN_TIMEOUT_MILLISECONDS" env-default:"5000"`
+	HalfOpenProbes          int     `yaml:"half_open_probes" env:"HALF_OPEN_PROBES" env-default:"1"`
+	// FailOpen - only for command cache: while it's open, commands are executed without deduplication instead of rejected
+	FailOpen bool `yaml:"fail_open" env:"FAIL_OPEN"`
+}
+
+// SnapshotCacheConfig - config for in-memory cache of room snapshots (RefreshRoom)
+//
+// writes of this instance update cached snapshots, writes of other instances are seen after TTLMilliseconds
+// (0 = default 2s, snapshots always expire). Concurrent reads of a room share one storage read
+// limited by LoadTimeoutMilliseconds (0 = default 5s)
+type SnapshotCacheConfig struct {
+	Disabled                bool `yaml:"disabled" env:"DISABLED"`
+	MaxBytes                int  `yaml:"max_bytes" env:"MAX_BYTES" env-default:"67108864"`
+	TTLMilliseconds         int  `yaml:"ttl_milliseconds" env:"TTL_MILLISECONDS" env-default:"2000"`
+	LoadTimeoutMilliseconds int  `yaml:"load_timeout_milliseconds" env:"LOAD_TIMEOUT_MILLISECONDS" env-default:"5000"`
+}
+
+// WriteBehindConfig - config for write-behind of rooms: rooms are served from memory and their changes
+// are written into rooms storage every FlushIntervalMilliseconds and on shutdown
+//
+// Write into room whose changes aren't written for MaxStalenessMilliseconds fails until storage accepts them
+// (0 = no limit). Rooms without changes are unloaded after IdleSeconds (0 = never).
+// Changes of other instances aren't seen, so every room must be served by one instance.
+// Snapshot cache isn't used with it, rooms are in memory already
+type WriteBehindConfig struct {
+	Enabled                   bool `yaml:"enabled" env:"ENABLED"`
+	FlushIntervalMilliseconds int  `yaml:"flush_interval_milliseconds" env:"FLUSH_INTERVAL_MILLISECONDS" env-default:"1000"`
+	MaxStalenessMilliseconds  int  `yaml:"max_staleness_milliseconds" env:"MAX_STALENESS_MILLISECONDS" env-default:"10000"`
+	IdleSeconds               int  `yaml:"idle_seconds" env:"IDLE_SECONDS" env-default:"600"`
+}
+
+// JournalStorage - where journal of room changes is stored
+type JournalStorage string
+
+const (
+	// JournalStorageFile - store journal in files of JournalConfig.Dir, single instance only
+	JournalStorageFile JournalStorage = "file"
+	// JournalStorageMongoDB - store journal in MongoDB collections (ROOM_SERVICE_MONGODB_ config and database,
+	// write concern of ROOM_SERVICE_ROOMS_MONGODB_ config are used)
+	JournalStorageMongoDB JournalStorage = "mongodb"
+)
+
+// JournalConfig - config for append-only journal of room changes, for audit and crash recovery (see cmd/journal)
+//
+// Every SnapshotEvery entries of a room its full snapshot is saved (0 = never), so room is rebuilt by replaying
+// entries after the last snapshot. With Compact entries and snapshots before the previous snapshot are erased,
+// otherwise the whole history is kept (MongoDB must run as a replica set to compact: it's one transaction)
+type JournalConfig struct {
+	Enabled                  bool           `yaml:"enabled" env:"ENABLED"`
+	Storage                  JournalStorage `yaml:"storage" env:"STORAGE" env-default:"file"`
+	Dir                      string         `yaml:"dir" env:"DIR" env-default:"journal"`
+	MongoEntriesCollection   string         `yaml:"mongodb_entries_collection" env:"MONGODB_ENTRIES_COLLECTION" env-default:"journal"`
+	MongoSnapshotsCollection string         `yaml:"mongodb_snapshots_collection" env:"MONGODB_SNAPSHOTS_COLLECTION" env-default:"journal_snapshots"`
+	MongoSeqsCollection      string         `yaml:"mongodb_seqs_collection" env:"MONGODB_SEQS_COLLECTION" env-default:"journal_seqs"`
+	SnapshotEvery            int            `yaml:"snapshot_every" env:"SNAPSHOT_EVERY" env-default:"1000"`
+	Compact                  bool           `yaml:"compact" env:"COMPACT"`
+}
+
+// EventBusStorage - how events of rooms are delivered to streams of other users
+type EventBusStorage string
+
+const (
+	// EventBusStorageInProcess - only streams of this instance get events, for a single instance
+	EventBusStorageInProcess EventBusStorage = "in_process"
+	// EventBusStorageRedis - events are shared by instances with Redis pub/sub (ROOM_SERVICE_REDIS_ config is used),
+	// streams get full rooms after connection to Redis is restored
+	EventBusStorageRedis EventBusStorage = "redis"
+)
+
+// EventBusConfig - config for broadcasting changes of rooms to every stream that joined a user there
+//
+// Every subscribed stream queues up to SubscriberBufferSize events, events of a stream that can't keep up are dropped
+// until its queue is empty, then it gets the full room
+type EventBusConfig struct {
+	Disabled             bool            `yaml:"disabled" env:"DISABLED"`
+	Storage              EventBusStorage `yaml:"storage" env:"STORAGE" env-default:"in_process"`
+	RedisChannelPrefix   string          `yaml:"redis_channel_prefix" env:"REDIS_CHANNEL_PREFIX" env-default:"room_events"`
+	SubscriberBufferSize int             `yaml:"subscriber_buffer_size" env:"SUBSCRIBER_BUFFER_SIZE" env-default:"1024"`
+}
+
+// ShardingMembership - where members of sharding are read from
+type ShardingMembership string
+
+const (
+	// ShardingMembershipStatic - members are ShardingConfig.Members, they're re-read on config reload
+	ShardingMembershipStatic ShardingMembership = "static"
+)
+
+// ShardingConfig - config for sharding rooms between instances
+//
+// Every room is owned by one instance: consistent hashing of room IDs over Members (gRPC addresses of every instance,
+// AdvertiseAddr is this one). Commands of rooms owned by other instances are forwarded to them, when members change
+// rooms are rebalanced. Rooms storage must be shared (mongodb, redis), so must be event bus (redis) and journal (mongodb)
+type ShardingConfig struct {
+	Enabled       bool               `yaml:"enabled" env:"ENABLED"`
+	Membership    ShardingMembership `yaml:"membership" env:"MEMBERSHIP" env-default:"static"`
+	AdvertiseAddr string             `yaml:"advertise_addr" env:"ADVERTISE_ADDR"`
+	Members       []string           `yaml:"members" env:"MEMBERS"`
+	// VirtualNodes - points of every member on hash ring, more points - rooms are spread more evenly
+	VirtualNodes int `yaml:"virtual_nodes" env:"VIRTUAL_NODES" env-default:"128"`
+	// RefreshIntervalSeconds - how often members are re-read
+	RefreshIntervalSeconds int `yaml:"refresh_interval_seconds" env:"REFRESH_INTERVAL_SECONDS" env-default:"5"`
+}
+
+// BoltConfig - config for embedded BoltDB file, used by "bolt" storages of rooms and command cache (one file for both)
+type BoltConfig struct {
+	Path string `yaml:"path" env:"PATH" env-default:"room_service.db"`
+	// OpenTimeoutSeconds - how long to wait for file lock (e.g. another instance uses the file), 0 = forever
+	OpenTimeoutSeconds int `yaml:"open_timeout_seconds" env:"OPEN_TIMEOUT_SECONDS" env-default:"5"`
 }
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"slices"
+	"strings"
+)
+
+const maxPort = 65535
+
+// commandPayloadTypes - keys of RateLimitConfig.Commands
+var commandPayloadTypes = []string{"create_room", "delete_room", "join_room", "leave_room", "affect_data", "refresh_room"}
+
+// Validate - check the whole Config, every problem is reported (joined errors, one per line)
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	//region room_service
+	v.check(c.Service.GRPCPort > 0 && c.Service.GRPCPort <= maxPort,
+		"room_service.grpc_port", "must be within [1, %d], got %d", maxPort, c.Service.GRPCPort)
+	v.check(c.Service.MetricsPort >= 0 && c.Service.MetricsPort <= maxPort,
+		"room_service.metrics_port", "must be within [0, %d] (0 = disabled), got %d", maxPort, c.Service.MetricsPort)
+	v.check(c.Service.Health.HTTPPort >= 0 && c.Service.Health.HTTPPort <= maxPort,
+		"room_service.health.http_port", "must be within [0, %d] (0 = disabled), got %d", maxPort, c.Service.Health.HTTPPort)
+	v.uniquePorts(map[string]int{
+		"room_service.grpc_port":        c.Service.GRPCPort,
+		"room_service.metrics_port":     c.Service.MetricsPort,
+		"room_service.health.http_port": c.Service.Health.HTTPPort,
+	})
+
+	if err := c.Service.RetryStrategy.Validate(); err != nil {
+		v.add("room_service.retry", err)
+	}
+
+	v.oneOf("room_service.ordering.mode", c.Service.Ordering.Mode, "stream", "room", "parallel")
+	v.nonNegative("room_service.ordering.queue_size", c.Service.Ordering.QueueSize)
+	v.nonNegative("room_service.ordering.room_workers", c.Service.Ordering.RoomWorkers)
+
+	v.oneOf("room_service.outbound.slow_consumer_policy", c.Service.Outbound.SlowConsumerPolicy, "block", "drop", "disconnect")
+	v.nonNegative("room_service.outbound.buffer_size", c.Service.Outbound.BufferSize)
+
+	v.nonNegative("room_service.command_timeout_milliseconds", c.Service.CommandTimeoutMilliseconds)
+	v.nonNegative("room_service.shutdown_timeout_seconds", c.Service.ShutdownTimeoutSeconds)
+	v.nonNegative("room_service.health.probe_interval_seconds", c.Service.Health.ProbeIntervalSeconds)
+	v.nonNegative("room_service.health.probe_timeout_seconds", c.Service.Health.ProbeTimeoutSeconds)
+
+	quotas := c.Service.Quotas
+	v.nonNegative("room_service.quotas.max_rooms_per_owner", quotas.MaxRoomsPerOwner)
+	v.nonNegative("room_service.quotas.max_members", quotas.MaxMembers)
+	v.nonNegative("room_service.quotas.max_metadata_bytes", quotas.MaxMetadataBytes)
+	v.nonNegative("room_service.quotas.max_keys", quotas.MaxKeys)
+	v.nonNegative("room_service.quotas.max_value_bytes", quotas.MaxValueBytes)
+	v.nonNegative("room_service.quotas.max_room_bytes", quotas.MaxRoomBytes)
+	v.nonNegative("room_service.quotas.max_list_length", quotas.MaxListLength)
+	v.nonNegative("room_service.quotas.max_depth", quotas.MaxDepth)
+	v.check(quotas.MaxRoomBytes == 0 || quotas.MaxValueBytes <= quotas.MaxRoomBytes,
+		"room_service.quotas.max_value_bytes", "must not be bigger than max_room_bytes, got %d > %d", quotas.MaxValueBytes, quotas.MaxRoomBytes)
+	//endregion
+
+	//region log
+	if len(c.Log.LogLevel) > 0 {
+		if _, err := zapcore.ParseLevel(c.Log.LogLevel); err != nil {
+			v.add("log.level", err)
+		}
+	}
+	v.oneOf("log.format", c.Log.Format, "json", "console")
+	v.nonNegative("log.sampling_initial", c.Log.SamplingInitial)
+	v.nonNegative("log.sampling_thereafter", c.Log.SamplingThereafter)
+	//endregion
+
+	//region storages
+	redisRequiredBy, boltRequiredBy := "", ""
+	switch c.Rooms.Storage {
+	case RoomsStorageMongoDB, "":
+		if _, err := c.MongoDBRoomsRepo.ParseReadConcern(); err != nil {
+			v.add("mongodb_rooms.read_concern", err)
+		}
+		if _, err := c.MongoDBRoomsRepo.ParseWriteConcern(); err != nil {
+			v.add("mongodb_rooms.write_concern", err)
+		}
+		v.check(len(c.MongoDB.Hosts) > 0, "mongodb.hosts", "at least one host is required")
+	case RoomsStorageRedis:
+		redisRequiredBy = "rooms"
+		v.nonNegative("rooms.redis_ttl_seconds", c.Rooms.RedisTTLSeconds)
+	case RoomsStorageBolt:
+		boltRequiredBy = "rooms"
+	case RoomsStorageInMemory:
+	default:
+		v.oneOf("rooms.storage", string(c.Rooms.Storage),
+			string(RoomsStorageMongoDB), string(RoomsStorageRedis), string(RoomsStorageBolt), string(RoomsStorageInMemory))
+	}
+
+	switch c.CommandCache.Storage {
+	case CommandCacheStorageRedis, "":
+		redisRequiredBy = "command cache"
+	case CommandCacheStorageInMemory:
+		v.nonNegative("command_cache.capacity", c.CommandCache.Capacity)
+		v.nonNegative("command_cache.shards", c.CommandCache.Shards)
+		v.nonNegative("command_cache.ttl_seconds", c.CommandCache.TTLSeconds)
+		v.check(c.CommandCache.Capacity > 0 || c.CommandCache.TTLSeconds > 0,
+			"command_cache.capacity", "capacity or ttl_seconds must be set, otherwise in-memory cache grows without limit")
+	case CommandCacheStorageBolt:
+		boltRequiredBy = "command cache"
+		v.nonNegative("command_cache.ttl_seconds", c.CommandCache.TTLSeconds)
+	default:
+		v.oneOf("command_cache.storage", string(c.CommandCache.Storage),
+			string(CommandCacheStorageRedis), string(CommandCacheStorageInMemory), string(CommandCacheStorageBolt))
+	}
+
+	switch c.RateLimit.Storage {
+	case RateLimitStorageInMemory, "":
+	case RateLimitStorageRedis:
+		redisRequiredBy = "rate limit"
+	default:
+		v.oneOf("rate_limit.storage", string(c.RateLimit.Storage), string(RateLimitStorageInMemory), string(RateLimitStorageRedis))
+	}
+	if !c.EventBus.Disabled {
+		switch c.EventBus.Storage {
+		case EventBusStorageInProcess, "":
+		case EventBusStorageRedis:
+			redisRequiredBy = "event bus"
+		default:
+			v.oneOf("event_bus.storage", string(c.EventBus.Storage), string(EventBusStorageInProcess), string(EventBusStorageRedis))
+		}
+		v.nonNegative("event_bus.subscriber_buffer_size", c.EventBus.SubscriberBufferSize)
+	}
+	if len(redisRequiredBy) > 0 {
+		v.check(len(c.Redis.Addr) > 0, "redis.addr", "is required with 'redis' %s storage", redisRequiredBy)
+	}
+	if len(boltRequiredBy) > 0 {
+		v.check(len(c.Bolt.Path) > 0, "bolt.path", "is required with 'bolt' %s storage", boltRequiredBy)
+		v.nonNegative("bolt.open_timeout_seconds", c.Bolt.OpenTimeoutSeconds)
+	}
+	//endregion
+
+	//region rate limit
+	rateLimitedTypes := make([]string, 0, len(c.RateLimit.Commands))
+	for payloadType := range c.RateLimit.Commands {
+		rateLimitedTypes = append(rateLimitedTypes, payloadType)
+	}
+	slices.Sort(rateLimitedTypes)
+	for _, payloadType := range rateLimitedTypes {
+		limits := c.RateLimit.Commands[payloadType]
+		field := "rate_limit.commands." + payloadType
+		if !slices.Contains(commandPayloadTypes, payloadType) {
+			// not oneOf: empty type isn't a default, it's a mistake
+			v.check(false, "rate_limit.commands", "unknown value '%s' (Use one of these: '%s')",
+				payloadType, strings.Join(commandPayloadTypes, "', '"))
+			continue
+		}
+		v.bucket(field+".room", limits.Room)
+		v.bucket(field+".user", limits.User)
+		v.bucket(field+".stream", limits.Stream)
+	}
+	//endregion
+
+	//region circuit breaker
+	v.breaker("circuit_breaker.rooms", c.CircuitBreaker.Rooms)
+	v.breaker("circuit_breaker.command_cache", c.CircuitBreaker.CommandCache)
+	//endregion
+
+	//region snapshot cache
+	v.nonNegative("snapshot_cache.max_bytes", c.SnapshotCache.MaxBytes)
+	v.nonNegative("snapshot_cache.ttl_milliseconds", c.SnapshotCache.TTLMilliseconds)
+	v.nonNegative("snapshot_cache.load_timeout_milliseconds", c.SnapshotCache.LoadTimeoutMilliseconds)
+	//endregion
+
+	//region write behind
+	if c.WriteBehind.Enabled {
+		v.check(c.WriteBehind.FlushIntervalMilliseconds > 0, "write_behind.flush_interval_milliseconds",
+			"must be positive, got %d", c.WriteBehind.FlushIntervalMilliseconds)
+		v.nonNegative("write_behind.idle_seconds", c.WriteBehind.IdleSeconds)
+		// otherwise writes are flushed by themselves before the interval ends
+		v.check(c.WriteBehind.MaxStalenessMilliseconds == 0 || c.WriteBehind.MaxStalenessMilliseconds >= c.WriteBehind.FlushIntervalMilliseconds,
+			"write_behind.max_staleness_milliseconds", "must be 0 or not less than flush_interval_milliseconds (%d), got %d",
+			c.WriteBehind.FlushIntervalMilliseconds, c.WriteBehind.MaxStalenessMilliseconds)
+	}
+	//endregion
+
+	//region journal
+	if c.Journal.Enabled {
+		switch c.Journal.Storage {
+		case JournalStorageFile, "":
+			v.check(len(c.Journal.Dir) > 0, "journal.dir", "is required with 'file' journal storage")
+		case JournalStorageMongoDB:
+			// otherwise they're checked for rooms storage already
+			if c.Rooms.Storage != RoomsStorageMongoDB && c.Rooms.Storage != "" {
+				if _, err := c.MongoDBRoomsRepo.ParseWriteConcern(); err != nil {
+					v.add("mongodb_rooms.write_concern", err)
+				}
+				v.check(len(c.MongoDB.Hosts) > 0, "mongodb.hosts", "at least one host is required with 'mongodb' journal storage")
+			}
+		default:
+			v.oneOf("journal.storage", string(c.Journal.Storage), string(JournalStorageFile), string(JournalStorageMongoDB))
+		}
+		v.nonNegative("journal.snapshot_every", c.Journal.SnapshotEvery)
+	}
+	//endregion
+
+	//region sharding
+	if c.Sharding.Enabled {
+		v.oneOf("sharding.membership", string(c.Sharding.Membership), string(ShardingMembershipStatic))
+		v.check(len(c.Sharding.Members) > 0, "sharding.members", "at least one member is required")
+		v.check(slices.Contains(c.Sharding.Members, c.Sharding.AdvertiseAddr),
+			"sharding.advertise_addr", "must be one of sharding.members, got '%s'", c.Sharding.AdvertiseAddr)
+		v.nonNegative("sharding.virtual_nodes", c.Sharding.VirtualNodes)
+		v.nonNegative("sharding.refresh_interval_seconds", c.Sharding.RefreshIntervalSeconds)
+		// rooms move between instances, so they must see the same storages
+		v.check(c.Rooms.Storage != RoomsStorageInMemory && c.Rooms.Storage != RoomsStorageBolt,
+			"rooms.storage", "must be shared ('mongodb', 'redis') with sharding")
+		v.check(c.EventBus.Disabled || c.EventBus.Storage == EventBusStorageRedis,
+			"event_bus.storage", "must be 'redis' with sharding")
+		v.check(!c.Journal.Enabled || c.Journal.Storage == JournalStorageMongoDB,
+			"journal.storage", "must be 'mongodb' with sharding")
+	}
+	//endregion
+
+	//region tracing
+	v.oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "otlp")
+	v.check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+		"tracing.sample_ratio", "must be within [0, 1], got %v", c.Tracing.SampleRatio)
+	//endregion
+
+	return v.err()
+}
+
+// validator - collects problems of Config.Validate
+type validator struct {
+	errs []error
+}
+
+// add - err of field, joined errors are reported one by one
+func (v *validator) add(field string, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			v.add(field, err)
+		}
+		return
+	}
+	v.errs = append(v.errs, fmt.Errorf("%s: %w", field, err))
+}
+
+func (v *validator) check(ok bool, field string, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: "+format, append([]any{field}, args...)...))
+	}
+}
+
+func (v *validator) nonNegative(field string, value int) {
+	v.check(value >= 0, field, "must not be negative, got %d", value)
+}
+
+func (v *validator) bucket(field string, bucket BucketConfig) {
+	v.check(bucket.PerSecond >= 0, field+".per_second", "must not be negative, got %v", bucket.PerSecond)
+	v.nonNegative(field+".burst", bucket.Burst)
+}
+
+func (v *validator) breaker(field string, breaker BreakerConfig) {
+	v.nonNegative(field+".window_seconds", breaker.WindowSeconds)
+	v.nonNegative(field+".min_calls", breaker.MinCalls)
+	v.check(breaker.FailureRatio >= 0 && breaker.FailureRatio <= 1,
+		field+".failure_ratio", "must be within [0, 1], got %v", breaker.FailureRatio)
+	v.nonNegative(field+".open_timeout_milliseconds", breaker.OpenTimeoutMilliseconds)
+	v.nonNegative(field+".half_open_probes", breaker.HalfOpenProbes)
+}
+
+// oneOf - value must be one of allowed or empty (default is used)
+func (v *validator) oneOf(field string, value string, allowed ...string) {
+	v.check(len(value) == 0 || slices.Contains(allowed, value),
+		field, "unknown value '%s' (Use one of these: '%s')", value, strings.Join(allowed, "', '"))
+}
+
+// uniquePorts - enabled (non-zero) ports must differ
+func (v *validator) uniquePorts(ports map[string]int) {
+	fields := make([]string, 0, len(ports))
+	for field := range ports {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	for i, field := range fields {
+		for _, other := range fields[i+1:] {
+			v.check(ports[field] == 0 || ports[field] != ports[other],
+				field, "same port as %s: %d", other, ports[field])
+		}
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n%w", errors.Join(v.errs...))
+}
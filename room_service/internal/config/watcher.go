@@ -0,0 +1,152 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultWatchInterval - how often CONFIG_PATH file is checked for changes by default
+const defaultWatchInterval = 2 * time.Second
+
+// reloadableFields - fields (or their prefixes) applied without restart, see Change.Reloadable
+var reloadableFields = []string{
+	"room_service.retry.attempts",
+	"room_service.retry.delay_milliseconds",
+	"room_service.retry.backoff",
+	"room_service.retry.max_delay_milliseconds",
+	"room_service.retry.jitter",
+	"room_service.command_timeout_milliseconds",
+	"room_service.quotas.",
+	"log.level",
+	"rate_limit.commands",
+	"sharding.members",
+}
+
+// Reloadable - field is applied by Watcher's callback without restart
+func (c Change) Reloadable() bool {
+	for _, field := range reloadableFields {
+		if c.Field == field || (strings.HasSuffix(field, ".") && strings.HasPrefix(c.Field, field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReloadFunc - apply reloadable parts of next config, changes are already logged
+type ReloadFunc func(ctx context.Context, next *Config, changes []Change)
+
+// Watcher - re-reads config (see TryRead) on SIGHUP or when CONFIG_PATH file is changed
+//
+// invalid config is logged and ignored, otherwise changes are logged and passed to ReloadFunc
+type Watcher struct {
+	interval time.Duration
+	onReload ReloadFunc
+
+	mu      sync.Mutex
+	current *Config
+}
+
+// NewWatcher - watch config, current is the one the service is started with
+//
+// interval - how often CONFIG_PATH file is checked, 0 = 2 seconds
+func NewWatcher(current *Config, interval time.Duration, onReload ReloadFunc) *Watcher {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &Watcher{interval: interval, onReload: onReload, current: current}
+}
+
+// Current - last successfully read config, fields that aren't Reloadable may be not applied
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Run - reload on SIGHUP and file changes until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	path := os.Getenv(EnvConfigPath)
+	lastModified := fileVersion(path)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			logging.FromContext(ctx).Info(ctx, "SIGHUP received, reloading config")
+		case <-ticker.C:
+			if len(path) == 0 {
+				continue
+			}
+			modified := fileVersion(path)
+			if modified == lastModified {
+				continue
+			}
+			lastModified = modified
+			logging.FromContext(ctx).Info(ctx, "config file changed, reloading config", zap.String("path", path))
+		}
+
+		if err := w.Reload(ctx); err != nil {
+			logging.FromContext(ctx).Error(ctx, "config isn't reloaded, previous one is kept", zap.Error(err))
+		}
+	}
+}
+
+// Reload - read and validate config, log the diff and call ReloadFunc if anything is changed
+func (w *Watcher) Reload(ctx context.Context) error {
+	next, err := TryRead()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	previous := w.current
+	changes := Diff(previous, next)
+	w.current = next
+	w.mu.Unlock()
+
+	if len(changes) == 0 {
+		logging.FromContext(ctx).Info(ctx, "config reloaded, nothing is changed")
+		return nil
+	}
+	for _, change := range changes {
+		fields := []zap.Field{
+			zap.String("field", change.Field),
+			zap.String("previous", change.Previous),
+			zap.String("next", change.Next),
+		}
+		if change.Reloadable() {
+			logging.FromContext(ctx).Info(ctx, "config field changed", fields...)
+		} else {
+			logging.FromContext(ctx).Warn(ctx, "config field changed, restart is required to apply it", fields...)
+		}
+	}
+	w.onReload(ctx, next, changes)
+	return nil
+}
+
+// fileVersion - modification time and size of file, empty if it can't be read
+func fileVersion(path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+}
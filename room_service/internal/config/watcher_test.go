@@ -0,0 +1,165 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const watcherTestConfig = `
+room_service:
+  grpc_port: 50051
+  retry:
+    attempts: %s
+mongodb:
+  hosts: ["mongodb:27017"]
+  password: %s
+redis:
+  addr: redis:6379
+`
+
+func writeWatcherTestConfig(t *testing.T, path string, attempts string, password string) {
+	t.Helper()
+	content := []byte(fmt.Sprintf(watcherTestConfig, attempts, password))
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv(EnvConfigPath, path)
+	writeWatcherTestConfig(t, path, "3", "secret")
+	initial, err := TryRead()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var reloaded *Config
+	var reloadChanges []Change
+	watcher := NewWatcher(initial, 0, func(_ context.Context, next *Config, changes []Change) {
+		reloaded, reloadChanges = next, changes
+	})
+
+	// invalid config is ignored
+	writeWatcherTestConfig(t, path, "-1", "secret")
+	if err := watcher.Reload(context.Background()); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if reloaded != nil || watcher.Current() != initial {
+		t.Fatal("invalid config is applied")
+	}
+
+	writeWatcherTestConfig(t, path, "5", "other-secret")
+	if err := watcher.Reload(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reloaded == nil || reloaded.Service.RetryStrategy.Attempts != 5 || watcher.Current() != reloaded {
+		t.Fatalf("config isn't reloaded: %+v", reloaded)
+	}
+
+	changes := make(map[string]Change, len(reloadChanges))
+	for _, change := range reloadChanges {
+		changes[change.Field] = change
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %v", reloadChanges)
+	}
+	attempts := changes["room_service.retry.attempts"]
+	if attempts.Previous != "3" || attempts.Next != "5" || !attempts.Reloadable() {
+		t.Errorf("unexpected attempts change: %v", attempts)
+	}
+	password := changes["mongodb.password"]
+	if password.Previous != "***" || password.Next != "***" || password.Reloadable() {
+		t.Errorf("password change must be masked and not reloadable: %v", password)
+	}
+}
+
+const watcherRateLimitTestConfig = `
+room_service:
+  grpc_port: 50051
+mongodb:
+  hosts: ["mongodb:27017"]
+redis:
+  addr: redis:6379
+rate_limit:
+  commands:
+    affect_data:
+      room:
+        per_second: %s
+`
+
+func TestWatcherReloadsRateLimitsWhileConfigIsRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv(EnvConfigPath, path)
+	write := func(perSecond string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(fmt.Sprintf(watcherRateLimitTestConfig, perSecond)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("10")
+	initial, err := TryRead()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var applied atomic.Int32
+	watcher := NewWatcher(initial, 0, func(_ context.Context, next *Config, changes []Change) {
+		for _, change := range changes {
+			if !change.Reloadable() {
+				t.Errorf("rate limit change isn't reloadable: %v", change)
+			}
+		}
+		applied.Add(1)
+	})
+
+	// config is read by commands while it's reloaded
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for range 4 {
+		readers.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				perSecond := watcher.Current().RateLimit.Commands["affect_data"].Room.PerSecond
+				if perSecond < 0 {
+					t.Errorf("invalid rate limit %v is applied", perSecond)
+					return
+				}
+			}
+		})
+	}
+
+	for i := range 20 {
+		if i%2 == 1 {
+			// invalid config is ignored, the previous one stays
+			write("-1")
+			if err = watcher.Reload(context.Background()); err == nil {
+				t.Error("expected validation error")
+			}
+			continue
+		}
+		// 0 = no limit
+		write(fmt.Sprint(i % 4 * 5))
+		if err = watcher.Reload(context.Background()); err != nil {
+			t.Errorf("reload %d: %v", i, err)
+		}
+	}
+	close(stop)
+	readers.Wait()
+
+	if applied.Load() == 0 {
+		t.Fatal("valid rate limits aren't applied")
+	}
+	if perSecond := watcher.Current().RateLimit.Commands["affect_data"].Room.PerSecond; perSecond != 10 {
+		t.Fatalf("per_second = %v, want 10 of the last valid config", perSecond)
+	}
+}
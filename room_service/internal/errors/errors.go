@@ -1,6 +1,10 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 // ErrRoomDoesntExist - when no room found with given filter
 var ErrRoomDoesntExist = errors.New("room does not exist")
@@ -11,5 +15,36 @@ var ErrRoomIDAlreadyExists = errors.New("room already exists")
 // ErrUserNotInRoom - when user you're trying to remove isn't in room
 var ErrUserNotInRoom = errors.New("user not in room")
 
+// ErrNotRoomOwner - when user tries to do what only room owner can, e.g. kick another user
+var ErrNotRoomOwner = errors.New("user is not room owner")
+
 // ErrDataPieceDoesntExist - when data item by key you're trying to read/update/delete doesn't exist
 var ErrDataPieceDoesntExist = errors.New("data piece does not exist")
+
+// ErrQuotaExceeded - when command would store more than quotas allow (keys, bytes, members...)
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrCircuitOpen - when storage call isn't made because it's failing (circuit breaker is open), see RetryAfterError
+var ErrCircuitOpen = errors.New("storage is unavailable, circuit breaker is open")
+
+// ErrRateLimited - when command is rejected by rate limiter, see RetryAfterError
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// ErrOwnerUnavailable - when command isn't executed because instance that owns the room can't be reached
+var ErrOwnerUnavailable = errors.New("instance that owns the room is unavailable")
+
+// RetryAfterError - error that may not happen if the same thing is tried again after RetryAfter
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+// Error - implements error
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err.Error(), e.RetryAfter)
+}
+
+// Unwrap - so errors.Is works with RetryAfterError.Err
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,214 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of Params
+const (
+	defaultInterval = 5 * time.Second
+	defaultTimeout  = 2 * time.Second
+)
+
+// errNotProbedYet - status of a probe before it's first run
+var errNotProbedYet = errors.New("not probed yet")
+
+// errShuttingDown - status of every probe after Checker.Shutdown
+var errShuttingDown = errors.New("shutting down")
+
+// Probe - one dependency check, e.g. ports.RoomsPort.Ping
+type Probe struct {
+	// Name - gRPC health service name of the dependency, e.g. "mongodb"
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Params - settings of Checker
+type Params struct {
+	// Interval - time between probes, default 5s
+	Interval time.Duration
+	// Timeout - max time of one probe, default 2s
+	Timeout time.Duration
+	// Services - gRPC services that are SERVING only while every probe passes ("" - whole server - is always included)
+	Services []string
+}
+
+func (p Params) withDefaults() Params {
+	if p.Interval <= 0 {
+		p.Interval = defaultInterval
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = defaultTimeout
+	}
+	return p
+}
+
+// Checker - runs probes periodically and reports results into grpc.health.v1 server and HTTP handlers
+//
+// every probe is reported as it's own gRPC health service, Params.Services are SERVING only if all probes pass
+type Checker struct {
+	server *grpchealth.Server
+	params Params
+	probes []Probe
+
+	mu           sync.Mutex
+	results      map[string]error
+	shuttingDown bool
+}
+
+// NewChecker - create Checker that reports into server, everything is NOT_SERVING until probes are run
+func NewChecker(server *grpchealth.Server, params Params, probes ...Probe) *Checker {
+	c := &Checker{
+		server:  server,
+		params:  params.withDefaults(),
+		probes:  probes,
+		results: make(map[string]error, len(probes)),
+	}
+	for _, probe := range probes {
+		c.results[probe.Name] = errNotProbedYet
+	}
+	c.publish()
+	return c
+}
+
+// Run - probe right away and then every Params.Interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.params.Interval)
+	defer ticker.Stop()
+	for {
+		c.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown - report NOT_SERVING everywhere from now on, so no new traffic is routed here
+func (c *Checker) Shutdown() {
+	c.mu.Lock()
+	c.shuttingDown = true
+	c.mu.Unlock()
+	c.server.Shutdown()
+}
+
+func (c *Checker) probeAll(ctx context.Context) {
+	results := make(map[string]error, len(c.probes))
+	var wg sync.WaitGroup
+	var resultsMu sync.Mutex
+	for _, probe := range c.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, c.params.Timeout)
+			defer cancel()
+			err := probe.Check(probeCtx)
+			resultsMu.Lock()
+			results[probe.Name] = err
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	for name, err := range results {
+		previous := c.results[name]
+		c.results[name] = err
+		switch {
+		case err != nil && (previous == nil || errors.Is(previous, errNotProbedYet)):
+			logging.FromContext(ctx).Warn(ctx, "dependency is unhealthy", zap.String("dependency", name), zap.Error(err))
+		case err == nil && previous != nil:
+			logging.FromContext(ctx).Info(ctx, "dependency is healthy", zap.String("dependency", name))
+		}
+	}
+	c.mu.Unlock()
+	c.publish()
+}
+
+// publish - report current results into gRPC health server
+func (c *Checker) publish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shuttingDown {
+		return
+	}
+
+	ready := true
+	for name, err := range c.results {
+		c.server.SetServingStatus(name, servingStatus(err == nil))
+		ready = ready && err == nil
+	}
+	c.server.SetServingStatus("", servingStatus(ready))
+	for _, service := range c.params.Services {
+		c.server.SetServingStatus(service, servingStatus(ready))
+	}
+}
+
+// ready - every probe passes and Shutdown isn't called, results are returned as dependency -> error text ("" = ok)
+func (c *Checker) ready() (bool, map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ready := !c.shuttingDown
+	results := make(map[string]string, len(c.results))
+	for name, err := range c.results {
+		if c.shuttingDown {
+			err = errShuttingDown
+		}
+		results[name] = ""
+		if err != nil {
+			results[name] = err.Error()
+			ready = false
+		}
+	}
+	return ready, results
+}
+
+// LivenessHandler - "/healthz", 200 while the process is able to serve HTTP
+func (c *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeStatus(w, http.StatusOK, statusResponse{Status: "ok"})
+	})
+}
+
+// ReadinessHandler - "/readyz", 200 if every probe passes, 503 otherwise (including shutdown)
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		ready, dependencies := c.ready()
+		if !ready {
+			writeStatus(w, http.StatusServiceUnavailable, statusResponse{Status: "unavailable", Dependencies: dependencies})
+			return
+		}
+		writeStatus(w, http.StatusOK, statusResponse{Status: "ok", Dependencies: dependencies})
+	})
+}
+
+// statusResponse - body of LivenessHandler and ReadinessHandler
+type statusResponse struct {
+	Status string `json:"status"`
+	// Dependencies - dependency -> error ("" = ok)
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+func writeStatus(w http.ResponseWriter, code int, body statusResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func servingStatus(ok bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ok {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
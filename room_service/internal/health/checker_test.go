@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"errors"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckerFlipsServingStatus(t *testing.T) {
+	ctx := context.Background()
+
+	var redisDown atomic.Bool
+	server := grpchealth.NewServer()
+	checker := NewChecker(server, Params{Services: []string{"room_service.RoomService"}},
+		Probe{Name: "mongodb", Check: func(context.Context) error { return nil }},
+		Probe{Name: "redis", Check: func(context.Context) error {
+			if redisDown.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}},
+	)
+
+	assertStatus := func(service string, want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		response, err := server.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("check of %q failed: %v", service, err)
+		}
+		if response.GetStatus() != want {
+			t.Errorf("status of %q = %v, want %v", service, response.GetStatus(), want)
+		}
+	}
+	assertReadyz := func(want int) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		checker.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if recorder.Code != want {
+			t.Errorf("/readyz = %d, want %d (%s)", recorder.Code, want, recorder.Body.String())
+		}
+	}
+
+	// nothing is probed yet
+	assertStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	assertReadyz(http.StatusServiceUnavailable)
+
+	checker.probeAll(ctx)
+	assertStatus("", healthpb.HealthCheckResponse_SERVING)
+	assertStatus("room_service.RoomService", healthpb.HealthCheckResponse_SERVING)
+	assertStatus("redis", healthpb.HealthCheckResponse_SERVING)
+	assertReadyz(http.StatusOK)
+
+	redisDown.Store(true)
+	checker.probeAll(ctx)
+	assertStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	assertStatus("room_service.RoomService", healthpb.HealthCheckResponse_NOT_SERVING)
+	assertStatus("redis", healthpb.HealthCheckResponse_NOT_SERVING)
+	assertStatus("mongodb", healthpb.HealthCheckResponse_SERVING)
+	assertReadyz(http.StatusServiceUnavailable)
+
+	redisDown.Store(false)
+	checker.probeAll(ctx)
+	assertStatus("", healthpb.HealthCheckResponse_SERVING)
+	assertReadyz(http.StatusOK)
+
+	checker.Shutdown()
+	checker.probeAll(ctx)
+	assertStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	assertReadyz(http.StatusServiceUnavailable)
+
+	recorder := httptest.NewRecorder()
+	checker.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", recorder.Code)
+	}
+}
@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// roomMembersBuckets - buckets of room_members histogram
+var roomMembersBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// RoomPresenceFunc - returns members amount of every active room
+type RoomPresenceFunc func() []int
+
+// RegisterRoomPresence - export active_rooms gauge and room_members histogram, computed on every scrape
+func (m *Metrics) RegisterRoomPresence(presence RoomPresenceFunc) {
+	m.MustRegister(&roomPresenceCollector{
+		presence: presence,
+		activeRooms: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "active_rooms"),
+			"Rooms with at least one member connected to this instance",
+			nil, nil),
+		roomMembers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "room_members"),
+			"Members connected to this instance per active room",
+			nil, nil),
+	})
+}
+
+type roomPresenceCollector struct {
+	presence    RoomPresenceFunc
+	activeRooms *prometheus.Desc
+	roomMembers *prometheus.Desc
+}
+
+// Describe - implements prometheus.Collector
+func (c *roomPresenceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeRooms
+	ch <- c.roomMembers
+}
+
+// Collect - implements prometheus.Collector
+func (c *roomPresenceCollector) Collect(ch chan<- prometheus.Metric) {
+	members := c.presence()
+
+	buckets := make(map[float64]uint64, len(roomMembersBuckets))
+	var sum float64
+	for _, amount := range members {
+		sum += float64(amount)
+		for _, upperBound := range roomMembersBuckets {
+			if float64(amount) <= upperBound {
+				buckets[upperBound]++
+			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.activeRooms, prometheus.GaugeValue, float64(len(members)))
+	ch <- prometheus.MustNewConstHistogram(c.roomMembers, uint64(len(members)), sum, buckets)
+}
+
+// CommandCacheStats - counters of a command cache, see RegisterCommandCacheStats
+type CommandCacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+	Size        int
+}
+
+// RegisterCommandCacheStats - export hit/miss/evict counters of in-memory command cache, read on every scrape
+func (m *Metrics) RegisterCommandCacheStats(stats func() CommandCacheStats) {
+	counter := func(name string, help string, value func(CommandCacheStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "command_cache",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+
+	m.MustRegister(
+		counter("hits_total", "Command IDs found in cache", func(s CommandCacheStats) uint64 { return s.Hits }),
+		counter("misses_total", "Command IDs not found in cache", func(s CommandCacheStats) uint64 { return s.Misses }),
+		counter("evictions_total", "Command IDs evicted because cache is full", func(s CommandCacheStats) uint64 { return s.Evictions }),
+		counter("expirations_total", "Command IDs erased because of TTL", func(s CommandCacheStats) uint64 { return s.Expirations }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "command_cache",
+			Name:      "size",
+			Help:      "Command IDs stored in cache",
+		}, func() float64 { return float64(stats().Size) }),
+	)
+}
+
+// SnapshotCacheStats - counters of room snapshot cache, see RegisterSnapshotCacheStats
+type SnapshotCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Shared    uint64
+	Evictions uint64
+	Rooms     int
+	Bytes     int
+}
+
+// RegisterSnapshotCacheStats - export hit/miss/evict counters and memory usage of snapshot cache, read on every scrape
+func (m *Metrics) RegisterSnapshotCacheStats(stats func() SnapshotCacheStats) {
+	counter := func(name string, help string, value func(SnapshotCacheStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "snapshot_cache",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+	gauge := func(name string, help string, value func(SnapshotCacheStats) int) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "snapshot_cache",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+
+	m.MustRegister(
+		counter("hits_total", "Room snapshots returned from cache", func(s SnapshotCacheStats) uint64 { return s.Hits }),
+		counter("misses_total", "Room snapshots read from storage", func(s SnapshotCacheStats) uint64 { return s.Misses }),
+		counter("shared_total", "Room snapshot requests that waited for the same room's read from storage", func(s SnapshotCacheStats) uint64 { return s.Shared }),
+		counter("evictions_total", "Room snapshots evicted because cache is out of memory limit", func(s SnapshotCacheStats) uint64 { return s.Evictions }),
+		gauge("rooms", "Room snapshots stored in cache", func(s SnapshotCacheStats) int { return s.Rooms }),
+		gauge("bytes", "Size of room snapshots stored in cache", func(s SnapshotCacheStats) int { return s.Bytes }),
+	)
+}
+
+// WriteBehindStats - state of rooms write-behind, see RegisterWriteBehindStats
+type WriteBehindStats struct {
+	Rooms       int
+	DirtyRooms  int
+	FlushLag    time.Duration
+	Loads       uint64
+	Flushes     uint64
+	FlushErrors uint64
+	Evictions   uint64
+}
+
+// RegisterWriteBehindStats - export flush lag, dirty rooms and flush counters of rooms write-behind, read on every scrape
+func (m *Metrics) RegisterWriteBehindStats(stats func() WriteBehindStats) {
+	counter := func(name string, help string, value func(WriteBehindStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "write_behind",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+	gauge := func(name string, help string, value func(WriteBehindStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "write_behind",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return value(stats()) })
+	}
+
+	m.MustRegister(
+		gauge("flush_lag_seconds", "Age of the oldest room change that isn't written into rooms storage", func(s WriteBehindStats) float64 { return s.FlushLag.Seconds() }),
+		gauge("dirty_rooms", "Rooms with changes that aren't written into rooms storage", func(s WriteBehindStats) float64 { return float64(s.DirtyRooms) }),
+		gauge("rooms", "Rooms loaded into memory", func(s WriteBehindStats) float64 { return float64(s.Rooms) }),
+		counter("loads_total", "Rooms read from rooms storage", func(s WriteBehindStats) uint64 { return s.Loads }),
+		counter("flushes_total", "Dirty rooms written into rooms storage", func(s WriteBehindStats) uint64 { return s.Flushes }),
+		counter("flush_errors_total", "Failed writes of dirty rooms, they are retried", func(s WriteBehindStats) uint64 { return s.FlushErrors }),
+		counter("evictions_total", "Idle rooms unloaded from memory", func(s WriteBehindStats) uint64 { return s.Evictions }),
+	)
+}
+
+// EventBusStats - counters of event bus, see RegisterEventBusStats
+type EventBusStats struct {
+	Subscriptions int
+	Published     uint64
+	Delivered     uint64
+	Dropped       uint64
+	Resyncs       uint64
+}
+
+// RegisterEventBusStats - export subscriptions and published/delivered/dropped/resync counters of event bus, read on every scrape
+func (m *Metrics) RegisterEventBusStats(stats func() EventBusStats) {
+	counter := func(name string, help string, value func(EventBusStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "event_bus",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+
+	m.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "event_bus",
+			Name:      "subscriptions",
+			Help:      "Rooms subscribed by streams of this instance",
+		}, func() float64 { return float64(stats().Subscriptions) }),
+		counter("published_total", "Room events published by this instance", func(s EventBusStats) uint64 { return s.Published }),
+		counter("delivered_total", "Room events queued for streams of this instance", func(s EventBusStats) uint64 { return s.Delivered }),
+		counter("dropped_total", "Room events dropped because queue of a stream was full", func(s EventBusStats) uint64 { return s.Dropped }),
+		counter("resyncs_total", "Full room snapshots requested from streams that might have lost room events", func(s EventBusStats) uint64 { return s.Resyncs }),
+	)
+}
+
+// ShardingStats - counters of sharding router, see RegisterShardingStats
+type ShardingStats struct {
+	Members       int
+	Forwarded     uint64
+	ForwardErrors uint64
+	Rebalances    uint64
+}
+
+// RegisterShardingStats - export members, forwarded commands and rebalances of sharding router, read on every scrape
+func (m *Metrics) RegisterShardingStats(stats func() ShardingStats) {
+	counter := func(name string, help string, value func(ShardingStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sharding",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(stats())) })
+	}
+
+	m.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sharding",
+			Name:      "members",
+			Help:      "Instances that rooms are sharded between",
+		}, func() float64 { return float64(stats().Members) }),
+		counter("forwarded_total", "Commands forwarded to instances that own their rooms", func(s ShardingStats) uint64 { return s.Forwarded }),
+		counter("forward_errors_total", "Commands that couldn't be forwarded to instances that own their rooms", func(s ShardingStats) uint64 { return s.ForwardErrors }),
+		counter("rebalances_total", "Changes of members, rooms are rebalanced on every change", func(s ShardingStats) uint64 { return s.Rebalances }),
+	)
+}
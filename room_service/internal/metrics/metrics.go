@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "room_service"
+
+// Outcome label values
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// Port label values for ObservePortCall
+const (
+	PortRooms        = "rooms"
+	PortCommandCache = "command_cache"
+)
+
+// Metrics - prometheus collectors of the service, exposed with Handler
+//
+// All methods are safe to call on nil *Metrics (nothing is recorded), so metrics are optional everywhere
+type Metrics struct {
+	registry *prometheus.Registry
+
+	commands           *prometheus.CounterVec
+	commandDuration    *prometheus.HistogramVec
+	portCallDuration   *prometheus.HistogramVec
+	retryAttempts      *prometheus.CounterVec
+	retryBudgetEmpty   *prometheus.CounterVec
+	rateLimited        *prometheus.CounterVec
+	quotaExceeded      *prometheus.CounterVec
+	breakerState       *prometheus.GaugeVec
+	breakerRejected    *prometheus.CounterVec
+	activeStreams      prometheus.Gauge
+	outboundQueueDepth prometheus.Gauge
+}
+
+// New - create Metrics with own registry (Go runtime and process collectors included)
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "commands_total",
+			Help:      "Processed commands by payload type and outcome",
+		}, []string{"payload_type", "outcome"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "command_duration_seconds",
+			Help:      "Command processing latency by payload type and outcome",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"payload_type", "outcome"}),
+		portCallDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "port_call_duration_seconds",
+			Help:      "Latency of RoomsPort and CommandIDShortCache calls",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"port", "method", "outcome"}),
+		retryAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retry_attempts_total",
+			Help:      "Repeated attempts (not counting the first one) of retried operations",
+		}, []string{"operation"}),
+		retryBudgetEmpty: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retry_budget_exhausted_total",
+			Help:      "Operations that weren't retried because retry budget was exhausted",
+		}, []string{"operation"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Commands rejected by rate limiter by payload type and scope (stream, user, room)",
+		}, []string{"payload_type", "scope"}),
+		quotaExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "quota_exceeded_total",
+			Help:      "Commands rejected because they would exceed quota, by quota (max_keys, max_room_bytes, ...)",
+		}, []string{"quota"}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "circuit_breaker_state",
+			Help:      "State of circuit breaker by port: 0 = closed, 1 = half-open, 2 = open",
+		}, []string{"port"}),
+		breakerRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "circuit_breaker_rejected_total",
+			Help:      "Port calls that weren't made because circuit breaker is open, by port and method",
+		}, []string{"port", "method"}),
+		activeStreams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_streams",
+			Help:      "Open Stream calls",
+		}),
+		outboundQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "outbound_queue_depth",
+			Help:      "Events waiting to be sent, summed over all streams",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.commands,
+		m.commandDuration,
+		m.portCallDuration,
+		m.retryAttempts,
+		m.retryBudgetEmpty,
+		m.rateLimited,
+		m.quotaExceeded,
+		m.breakerState,
+		m.breakerRejected,
+		m.activeStreams,
+		m.outboundQueueDepth,
+	)
+	return m
+}
+
+// Handler - http handler for "/metrics"
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// MustRegister - register additional collectors, panics on conflicts
+func (m *Metrics) MustRegister(cs ...prometheus.Collector) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(cs...)
+}
+
+// ObserveCommand - count processed command and it's latency
+func (m *Metrics) ObserveCommand(payloadType string, err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	outcome := outcomeOf(err)
+	m.commands.WithLabelValues(payloadType, outcome).Inc()
+	m.commandDuration.WithLabelValues(payloadType, outcome).Observe(duration.Seconds())
+}
+
+// ObservePortCall - record latency of a port method call, see PortRooms, PortCommandCache
+func (m *Metrics) ObservePortCall(port string, method string, err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.portCallDuration.WithLabelValues(port, method, outcomeOf(err)).Observe(duration.Seconds())
+}
+
+// IncRetryAttempts - count one repeated attempt of operation
+func (m *Metrics) IncRetryAttempts(operation string) {
+	if m == nil {
+		return
+	}
+	m.retryAttempts.WithLabelValues(operation).Inc()
+}
+
+// IncRetryBudgetExhausted - count one operation that wasn't retried because of retry budget
+func (m *Metrics) IncRetryBudgetExhausted(operation string) {
+	if m == nil {
+		return
+	}
+	m.retryBudgetEmpty.WithLabelValues(operation).Inc()
+}
+
+// IncRateLimited - count one command rejected by rate limiter in scope
+func (m *Metrics) IncRateLimited(payloadType string, scope string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.WithLabelValues(payloadType, scope).Inc()
+}
+
+// IncQuotaExceeded - count one command rejected by quota
+func (m *Metrics) IncQuotaExceeded(quota string) {
+	if m == nil {
+		return
+	}
+	m.quotaExceeded.WithLabelValues(quota).Inc()
+}
+
+// SetCircuitBreakerState - current state of port's circuit breaker: 0 = closed, 1 = half-open, 2 = open
+func (m *Metrics) SetCircuitBreakerState(port string, state int) {
+	if m == nil {
+		return
+	}
+	m.breakerState.WithLabelValues(port).Set(float64(state))
+}
+
+// IncCircuitBreakerRejected - count one port call that wasn't made because circuit breaker is open
+func (m *Metrics) IncCircuitBreakerRejected(port string, method string) {
+	if m == nil {
+		return
+	}
+	m.breakerRejected.WithLabelValues(port, method).Inc()
+}
+
+// StreamOpened - one more active stream
+func (m *Metrics) StreamOpened() {
+	if m == nil {
+		return
+	}
+	m.activeStreams.Inc()
+}
+
+// StreamClosed - one less active stream
+func (m *Metrics) StreamClosed() {
+	if m == nil {
+		return
+	}
+	m.activeStreams.Dec()
+}
+
+// AddOutboundQueueDepth - events are queued (delta > 0) or sent/discarded (delta < 0)
+func (m *Metrics) AddOutboundQueueDepth(delta int) {
+	if m == nil {
+		return
+	}
+	m.outboundQueueDepth.Add(float64(delta))
+}
+
+func outcomeOf(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeOK
+}
@@ -147,8 +147,16 @@ func (v *Value) Equal(v2 *Value) bool {
 	}
 
 	switch v.valueType {
-	case typeInt, typeStr, typeBool, typeFloat:
-		return v.intValue == v2.intValue
+	case typeNotSet:
+		return true
+	case typeInt:
+		return *v.intValue == *v2.intValue
+	case typeStr:
+		return *v.strValue == *v2.strValue
+	case typeBool:
+		return *v.boolValue == *v2.boolValue
+	case typeFloat:
+		return *v.floatValue == *v2.floatValue
 	case typeBytes:
 		if len(*v2.bytesValue) != len(*v.bytesValue) {
 			return false
@@ -197,3 +205,146 @@ func (v *Value) resetValues() {
 	v.listValue = nil
 	v.mapValue = nil
 }
+
+// Size - serialized size of value in bytes: 1 byte of type and the payload
+//
+// int and float take 8 bytes, bool 1, string and bytes their length,
+// list - sum of items, map - sum of keys' lengths and items
+func (v *Value) Size() int {
+	const typeSize = 1
+	switch v.valueType {
+	case typeInt, typeFloat:
+		return typeSize + 8
+	case typeBool:
+		return typeSize + 1
+	case typeStr:
+		return typeSize + len(*v.strValue)
+	case typeBytes:
+		return typeSize + len(*v.bytesValue)
+	case typeList:
+		size := typeSize
+		for i := range *v.listValue {
+			size += (*v.listValue)[i].Size()
+		}
+		return size
+	case typeMap:
+		size := typeSize
+		for key, item := range *v.mapValue {
+			size += len(key) + item.Size()
+		}
+		return size
+	default:
+		return typeSize
+	}
+}
+
+// Depth - nesting depth: 0 for scalars, 1 for list or map of scalars, 2 for list of lists etc.
+func (v *Value) Depth() int {
+	depth := 0
+	switch v.valueType {
+	case typeList:
+		for i := range *v.listValue {
+			depth = max(depth, (*v.listValue)[i].Depth())
+		}
+		return depth + 1
+	case typeMap:
+		for _, item := range *v.mapValue {
+			depth = max(depth, item.Depth())
+		}
+		return depth + 1
+	default:
+		return 0
+	}
+}
+
+// Len - amount of items in list or map, 0 for other types
+func (v *Value) Len() int {
+	switch v.valueType {
+	case typeList:
+		return len(*v.listValue)
+	case typeMap:
+		return len(*v.mapValue)
+	default:
+		return 0
+	}
+}
+
+// Plain - value as regular object: int64, string, bool, float64, []byte, []any of items or map[string]any of items,
+// nil if value isn't set
+func (v *Value) Plain() any {
+	switch v.valueType {
+	case typeInt:
+		return *v.intValue
+	case typeStr:
+		return *v.strValue
+	case typeBool:
+		return *v.boolValue
+	case typeFloat:
+		return *v.floatValue
+	case typeBytes:
+		return *v.bytesValue
+	case typeList:
+		list := make([]any, len(*v.listValue))
+		for i := range *v.listValue {
+			list[i] = (*v.listValue)[i].Plain()
+		}
+		return list
+	case typeMap:
+		dict := make(map[string]any, len(*v.mapValue))
+		for key, item := range *v.mapValue {
+			dict[key] = item.Plain()
+		}
+		return dict
+	default:
+		return nil
+	}
+}
+
+// IsList - value stores a list
+func (v *Value) IsList() bool {
+	return v.valueType == typeList
+}
+
+// MaxListLength - length of the longest list in value, including nested ones
+func (v *Value) MaxListLength() int {
+	longest := 0
+	switch v.valueType {
+	case typeList:
+		longest = len(*v.listValue)
+		for i := range *v.listValue {
+			longest = max(longest, (*v.listValue)[i].MaxListLength())
+		}
+	case typeMap:
+		for _, item := range *v.mapValue {
+			longest = max(longest, item.MaxListLength())
+		}
+	}
+	return longest
+}
+
+// Appended - copy of list value with item added to the end, value that isn't a list is replaced by list of item
+func (v *Value) Appended(item Value) *Value {
+	if v == nil || v.valueType != typeList {
+		return ListValue([]Value{item})
+	}
+	list := make([]Value, 0, len(*v.listValue)+1)
+	list = append(list, *v.listValue...)
+	return ListValue(append(list, item))
+}
+
+// Removed - copy of list value without items equal to item, false if value isn't a list or there's no such item
+func (v *Value) Removed(item *Value) (*Value, bool) {
+	if v == nil || v.valueType != typeList {
+		return nil, false
+	}
+	list := make([]Value, 0, len(*v.listValue))
+	for i := range *v.listValue {
+		if !(*v.listValue)[i].Equal(item) {
+			list = append(list, (*v.listValue)[i])
+		}
+	}
+	if len(list) == len(*v.listValue) {
+		return nil, false
+	}
+	return ListValue(list), true
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// valueJSON - JSON form of Value, exactly one field is set ({} = not set)
+//
+// bytes are base64 like any []byte, map keys are sorted by encoding/json, so equal values are encoded equally
+type valueJSON struct {
+	Int   *int64            `json:"int,omitempty"`
+	Str   *string           `json:"str,omitempty"`
+	Bool  *bool             `json:"bool,omitempty"`
+	Float *float64          `json:"float,omitempty"`
+	Bytes *[]byte           `json:"bytes,omitempty"`
+	List  *[]Value          `json:"list,omitempty"`
+	Map   *map[string]Value `json:"map,omitempty"`
+}
+
+// MarshalJSON - encode value with its type, e.g. {"int":1}, {"list":[{"str":"a"}]}
+func (v Value) MarshalJSON() ([]byte, error) {
+	var encoded valueJSON
+	switch v.valueType {
+	case typeInt:
+		encoded.Int = v.intValue
+	case typeStr:
+		encoded.Str = v.strValue
+	case typeBool:
+		encoded.Bool = v.boolValue
+	case typeFloat:
+		encoded.Float = v.floatValue
+	case typeBytes:
+		encoded.Bytes = v.bytesValue
+	case typeList:
+		list := *v.listValue
+		if list == nil {
+			list = []Value{}
+		}
+		encoded.List = &list
+	case typeMap:
+		dict := *v.mapValue
+		if dict == nil {
+			dict = map[string]Value{}
+		}
+		encoded.Map = &dict
+	}
+	return json.Marshal(&encoded)
+}
+
+// UnmarshalJSON - decode value encoded with MarshalJSON
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var encoded valueJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	set := 0
+	*v = Value{valueType: typeNotSet}
+	if encoded.Int != nil {
+		set++
+		v.SetInt(*encoded.Int)
+	}
+	if encoded.Str != nil {
+		set++
+		v.SetStr(*encoded.Str)
+	}
+	if encoded.Bool != nil {
+		set++
+		v.SetBool(*encoded.Bool)
+	}
+	if encoded.Float != nil {
+		set++
+		v.SetFloat(*encoded.Float)
+	}
+	if encoded.Bytes != nil {
+		set++
+		v.SetBytes(*encoded.Bytes)
+	}
+	if encoded.List != nil {
+		set++
+		v.SetList(*encoded.List)
+	}
+	if encoded.Map != nil {
+		set++
+		v.SetMap(*encoded.Map)
+	}
+	if set > 1 {
+		return fmt.Errorf("value has %d types set, expected one: %s", set, data)
+	}
+	return nil
+}
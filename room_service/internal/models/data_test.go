@@ -0,0 +1,64 @@
+package models
+
+import "testing"
+
+func TestValueSizeDepthAndListLength(t *testing.T) {
+	nested := MapValue(map[string]Value{
+		"name": *StrValue("abc"),
+		"tags": *ListValue([]Value{*IntValue(1), *BoolValue(true), *ListValue([]Value{*FloatValue(1), *FloatValue(2), *FloatValue(3)})}),
+	})
+
+	tests := []struct {
+		name          string
+		value         *Value
+		size          int
+		depth         int
+		maxListLength int
+	}{
+		{name: "int", value: IntValue(7), size: 9},
+		{name: "bool", value: BoolValue(false), size: 2},
+		{name: "string", value: StrValue("hello"), size: 6},
+		{name: "bytes", value: BytesValue([]byte{1, 2}), size: 3},
+		{name: "empty list", value: ListValue(nil), size: 1, depth: 1},
+		// map 1 + "name" 4 + str 4 + "tags" 4 + list (1 + int 9 + bool 2 + list (1 + 3 * 9))
+		{name: "nested", value: nested, size: 1 + 4 + 4 + 4 + 1 + 9 + 2 + 1 + 27, depth: 3, maxListLength: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.value.Size(); got != tt.size {
+				t.Errorf("Size() = %d, want %d", got, tt.size)
+			}
+			if got := tt.value.Depth(); got != tt.depth {
+				t.Errorf("Depth() = %d, want %d", got, tt.depth)
+			}
+			if got := tt.value.MaxListLength(); got != tt.maxListLength {
+				t.Errorf("MaxListLength() = %d, want %d", got, tt.maxListLength)
+			}
+		})
+	}
+}
+
+func TestValueEqual(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  *Value
+		equal bool
+	}{
+		{name: "not set", a: EmptyValue(), b: EmptyValue(), equal: true},
+		{name: "same int", a: IntValue(1), b: IntValue(1), equal: true},
+		{name: "different int", a: IntValue(1), b: IntValue(2)},
+		{name: "different string", a: StrValue("a"), b: StrValue("b")},
+		{name: "different bool", a: BoolValue(true), b: BoolValue(false)},
+		{name: "different float", a: FloatValue(1), b: FloatValue(1.5)},
+		{name: "different types", a: IntValue(1), b: FloatValue(1)},
+		{name: "same map", a: MapValue(map[string]Value{"k": *StrValue("v")}), b: MapValue(map[string]Value{"k": *StrValue("v")}), equal: true},
+		{name: "different list item", a: ListValue([]Value{*StrValue("a")}), b: ListValue([]Value{*StrValue("b")})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Equal(tt.b); got != tt.equal {
+				t.Errorf("Equal() = %v, want %v", got, tt.equal)
+			}
+		})
+	}
+}
@@ -7,11 +7,41 @@ import "context"
 // might be implemented with different storages (e.g. in-memory, redis)
 // and mechanisms (e.g. N last saved)
 //
-// just storing that commandID exists
+// stores command's state and its resulting event, so a repeated command gets the original result
 type CommandIDShortCache interface {
-	// Exists - check if commandID exists in DB (if it does, then skip command)
-	Exists(ctx context.Context, commandID string) (bool, error)
+	// Reserve - atomically check if commandID is already seen and, if it's not, save it as CommandStateInProgress
+	//
+	// alreadySeen = false means that caller now owns commandID and must either Save it's result or Release it
+	Reserve(ctx context.Context, commandID string) (alreadySeen bool, err error)
 
-	// Save - saves commandID, so CommandIDShortCache.Exists returns true
-	Save(ctx context.Context, commandID string) error
+	// Get - returns stored record of commandID, nil if commandID is unknown
+	Get(ctx context.Context, commandID string) (*CommandRecord, error)
+
+	// Save - saves (overwrites) record of commandID, so CommandIDShortCache.Get returns it
+	Save(ctx context.Context, commandID string, record *CommandRecord) error
+
+	// Release - forget commandID (e.g. command failed), so it can be reserved again
+	Release(ctx context.Context, commandID string) error
+
+	// Ping - check that storage is reachable, used by health probes
+	Ping(ctx context.Context) error
+}
+
+// CommandState is type for command execution states ENUM
+//
+// IN_PROGRESS, DONE
+type CommandState uint8
+
+const (
+	// CommandStateInProgress - command is being executed right now, repeats must wait for it
+	CommandStateInProgress CommandState = iota
+	// CommandStateDone - command is executed, repeats get CommandRecord.Event
+	CommandStateDone CommandState = iota
+)
+
+// CommandRecord - what CommandIDShortCache stores for every commandID
+type CommandRecord struct {
+	State CommandState `json:"state"`
+	// Event - serialized resulting event, only set on CommandStateDone
+	Event []byte `json:"event,omitempty"`
 }
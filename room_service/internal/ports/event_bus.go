@@ -0,0 +1,33 @@
+package ports
+
+import "context"
+
+// EventBusPort - delivers events of rooms to their subscribers on every instance of the service
+//
+// might be implemented with different storages (e.g. in-process for one instance, Redis pub/sub)
+type EventBusPort interface {
+	// Publish - deliver event to every subscriber of event.RoomID, subscribers of this instance included
+	//
+	// every subscriber receives events of one room in the same order
+	Publish(ctx context.Context, event *RoomEvent) error
+	// Subscribe - call handle for events of room until unsubscribe is called
+	//
+	// handle is called by one goroutine per subscription, so events come in order.
+	// If events might be lost (subscriber can't keep up, connection to storage is restored), handle gets
+	// an event with Resync set once it can take events again: subscriber must read the whole room
+	Subscribe(ctx context.Context, roomID string, handle func(event *RoomEvent)) (unsubscribe func(), err error)
+	// Ping - check that storage is reachable, used by health probes
+	Ping(ctx context.Context) error
+}
+
+// RoomEvent - event published into EventBusPort
+type RoomEvent struct {
+	RoomID string
+	// Origin - who published the event (e.g. ID of the stream whose command made it), so it can skip its own events
+	Origin string
+	// Payload - serialized event, empty if Resync is set
+	Payload []byte
+	// Resync - events of room before this one might be lost, subscriber must read the room's full state;
+	// events after it might repeat changes that are already in the state read
+	Resync bool
+}
@@ -0,0 +1,93 @@
+package ports
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/models"
+	"time"
+)
+
+// JournalPort - append-only journal of changes applied to rooms, with full room snapshots
+//
+// room is rebuilt by applying its entries after the last snapshot to that snapshot.
+// might be implemented with different storages (e.g. files for one instance, MongoDB collection)
+type JournalPort interface {
+	// Append - add entry to the end of room's journal, entry.Seq is ignored, assigned Seq is returned
+	//
+	// Seq of room's entries grows by 1, starting with 1
+	Append(ctx context.Context, entry *JournalEntry) (seq uint64, err error)
+	// Entries - entries of room with Seq > afterSeq, ordered by Seq
+	Entries(ctx context.Context, roomID models.RoomID, afterSeq uint64) ([]JournalEntry, error)
+	// SaveSnapshot - store room state made by entries up to snapshot.Seq
+	SaveSnapshot(ctx context.Context, snapshot *JournalSnapshot) error
+	// LastSnapshot - snapshot of room with the greatest Seq, nil if there's none
+	LastSnapshot(ctx context.Context, roomID models.RoomID) (*JournalSnapshot, error)
+	// Compact - erase entries of room with Seq <= seq and snapshots with Seq < seq
+	Compact(ctx context.Context, roomID models.RoomID, seq uint64) error
+	// Ping - check that storage is reachable, used by health probes
+	Ping(ctx context.Context) error
+}
+
+// JournalOp is type for journaled changes ENUM, names of RoomsPort methods
+type JournalOp string
+
+const (
+	JournalOpCreateRoom  JournalOp = "create_room"
+	JournalOpDeleteRoom  JournalOp = "delete_room"
+	JournalOpJoinRoom    JournalOp = "join_room"
+	JournalOpLeaveRoom   JournalOp = "leave_room"
+	JournalOpAffectData  JournalOp = "affect_data"
+	JournalOpReplaceRoom JournalOp = "replace_room"
+	// JournalOpAbort - change of entry AbortedSeq isn't applied, replay skips it
+	JournalOpAbort JournalOp = "abort"
+	// JournalOpResync - room is made equal to the state in entry, journal is repaired with it when it isn't known
+	// whether a change was applied
+	JournalOpResync JournalOp = "resync"
+)
+
+// JournalEntry - one change applied to room, params of RoomsPort method that made it
+//
+// CommandID and UserID are taken from command that made the change, they're empty for changes made by tools
+type JournalEntry struct {
+	RoomID    models.RoomID `json:"-"`
+	Seq       uint64        `json:"seq"`
+	Time      time.Time     `json:"time"`
+	CommandID string        `json:"command_id,omitempty"`
+	UserID    string        `json:"user_id,omitempty"`
+	Op        JournalOp     `json:"op"`
+
+	// Owner and Options - JournalOpCreateRoom and JournalOpResync (empty Owner - room doesn't exist)
+	Owner   string            `json:"owner,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+	// User - JournalOpJoinRoom
+	User *JournalUser `json:"user,omitempty"`
+	// CallerUserID - JournalOpDeleteRoom and JournalOpLeaveRoom, KickedUserID - JournalOpLeaveRoom
+	CallerUserID string `json:"caller_user_id,omitempty"`
+	KickedUserID string `json:"kicked_user_id,omitempty"`
+	// DataID, Action and Value - JournalOpAffectData
+	DataID string        `json:"data_id,omitempty"`
+	Action Action        `json:"action,omitempty"`
+	Value  *models.Value `json:"value,omitempty"`
+	// Users and Values - JournalOpReplaceRoom and JournalOpResync
+	Users  []JournalUser           `json:"users,omitempty"`
+	Values map[string]models.Value `json:"values,omitempty"`
+	// AbortedSeq - JournalOpAbort
+	AbortedSeq uint64 `json:"aborted_seq,omitempty"`
+}
+
+// JournalUser - models.User in JournalEntry and JournalSnapshot
+type JournalUser struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// JournalSnapshot - full room state after entries up to Seq are applied
+type JournalSnapshot struct {
+	RoomID  models.RoomID           `json:"-"`
+	Seq     uint64                  `json:"seq"`
+	Time    time.Time               `json:"time"`
+	Owner   string                  `json:"owner"`
+	Options map[string]string       `json:"options,omitempty"`
+	Users   []JournalUser           `json:"users"`
+	Values  map[string]models.Value `json:"values"`
+}
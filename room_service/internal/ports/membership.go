@@ -0,0 +1,11 @@
+package ports
+
+import "context"
+
+// MembershipPort - instances of the service that share rooms between them, see sharding.Router
+//
+// might be implemented with different sources (e.g. static config, service discovery)
+type MembershipPort interface {
+	// Members - gRPC addresses of current instances, every instance must see the same addresses
+	Members(ctx context.Context) ([]string, error)
+}
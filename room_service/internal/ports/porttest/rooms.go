@@ -0,0 +1,479 @@
+// Package porttest - conformance suites of ports, every adapter must pass them
+package porttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RoomsPortFactory - returns empty ports.RoomsPort, called once per test case; use t.Cleanup to release it
+type RoomsPortFactory func(t *testing.T) ports.RoomsPort
+
+// stressWorkers - goroutines of concurrency cases
+const stressWorkers = 16
+
+// RunRoomsPortSuite - check that ports.RoomsPort adapter follows the contract:
+//
+//   - CreateRoom with taken ID -> errors.ErrRoomIDAlreadyExists
+//   - every method but CreateRoom, CountOwnedRooms and Ping on missing room -> errors.ErrRoomDoesntExist
+//   - JoinRoom is idempotent, repeated join updates user's name and metadata
+//   - LeaveRoom of user that isn't in room -> errors.ErrUserNotInRoom,
+//     kick of another user not by room owner -> errors.ErrNotRoomOwner
+//   - AffectData: SET overwrites, DELETE of missing key -> errors.ErrDataPieceDoesntExist,
+//     APPEND adds item to list (value that isn't a list is replaced by list of one item),
+//     REMOVE erases list items equal to value, no such item -> errors.ErrDataPieceDoesntExist
+//   - every models.Value type is stored as is
+//   - ReplaceRoom replaces users and data, but not owner and options
+//   - concurrent writes aren't lost, concurrent creates of one ID succeed once
+//
+// order of RoomSnapshot.Users isn't part of the contract
+func RunRoomsPortSuite(t *testing.T, newPort RoomsPortFactory) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, port ports.RoomsPort)
+	}{
+		{name: "create room", run: testCreateRoom},
+		{name: "missing room", run: testMissingRoom},
+		{name: "delete room", run: testDeleteRoom},
+		{name: "join room is idempotent", run: testJoinRoom},
+		{name: "leave room", run: testLeaveRoom},
+		{name: "count owned rooms", run: testCountOwnedRooms},
+		{name: "affect data", run: testAffectData},
+		{name: "value types", run: testValueTypes},
+		{name: "replace room", run: testReplaceRoom},
+		{name: "concurrent appends", run: testConcurrentAppends},
+		{name: "concurrent joins and leaves", run: testConcurrentJoins},
+		{name: "concurrent creates", run: testConcurrentCreates},
+		{name: "ping", run: testPing},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, newPort(t))
+		})
+	}
+}
+
+func newRoom(t *testing.T, port ports.RoomsPort, owner types.NotEmptyText) *models.Room {
+	t.Helper()
+	room, err := port.CreateRoom(context.Background(), models.NewRoom(owner, map[string]string{"max_users": "10"}))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	return room
+}
+
+func snapshotOf(t *testing.T, port ports.RoomsPort, roomID models.RoomID) *models.RoomSnapshot {
+	t.Helper()
+	snapshot, err := port.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: roomID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	return snapshot
+}
+
+// userIDs - sorted IDs of snapshot's users
+func userIDs(snapshot *models.RoomSnapshot) []string {
+	ids := make([]string, 0, len(snapshot.Users))
+	for _, user := range snapshot.Users {
+		ids = append(ids, user.ID.String())
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func join(t *testing.T, port ports.RoomsPort, roomID models.RoomID, user models.User) {
+	t.Helper()
+	if err := port.JoinRoom(context.Background(), ports.JoinRoomParams{RoomID: roomID, UserFull: user}); err != nil {
+		t.Fatalf("JoinRoom(%s): %v", user.ID, err)
+	}
+}
+
+func affect(port ports.RoomsPort, roomID models.RoomID, dataID string, action ports.Action, value *models.Value) error {
+	return port.AffectData(context.Background(), ports.AffectDataParams{
+		RoomID: roomID, DataID: types.AnyText(dataID), Action: action, Value: value,
+	})
+}
+
+func expectErr(t *testing.T, operation string, err error, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s: got %v, want %v", operation, err, want)
+	}
+}
+
+func testCreateRoom(t *testing.T, port ports.RoomsPort) {
+	ctx := context.Background()
+	room := models.NewRoom("owner", map[string]string{"max_users": "10"})
+	created, err := port.CreateRoom(ctx, room)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if created.ID != room.ID || created.OwnerUserID != room.OwnerUserID {
+		t.Errorf("created room = %+v, want %+v", created, room)
+	}
+
+	_, err = port.CreateRoom(ctx, models.NewRoom("another", nil))
+	if err != nil {
+		t.Fatalf("CreateRoom of another room: %v", err)
+	}
+	_, err = port.CreateRoom(ctx, &models.Room{ID: room.ID, OwnerUserID: "another"})
+	expectErr(t, "CreateRoom with taken ID", err, roomerrors.ErrRoomIDAlreadyExists)
+
+	snapshot := snapshotOf(t, port, room.ID)
+	if snapshot.Room == nil || snapshot.Room.ID != room.ID || snapshot.Room.OwnerUserID != "owner" {
+		t.Fatalf("snapshot room = %+v, want room of owner", snapshot.Room)
+	}
+	if snapshot.Room.Options["max_users"] != "10" {
+		t.Errorf("snapshot options = %v, want max_users = 10", snapshot.Room.Options)
+	}
+	if len(snapshot.Users) != 0 || len(snapshot.Values) != 0 {
+		t.Errorf("new room has users %v and values %v, want none", snapshot.Users, snapshot.Values)
+	}
+}
+
+func testMissingRoom(t *testing.T, port ports.RoomsPort) {
+	ctx := context.Background()
+	roomID := models.RoomID(types.GenerateUUID())
+
+	expectErr(t, "DeleteRoom", port.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: roomID, UserID: "owner"}), roomerrors.ErrRoomDoesntExist)
+	expectErr(t, "JoinRoom", port.JoinRoom(ctx, ports.JoinRoomParams{RoomID: roomID, UserFull: models.User{ID: "user", Name: "User"}}), roomerrors.ErrRoomDoesntExist)
+	expectErr(t, "LeaveRoom", port.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: roomID, CommandCallerUserID: "user", KickedUserID: "user"}), roomerrors.ErrRoomDoesntExist)
+	_, err := port.IsRoomOwner(ctx, ports.IsRoomOwnerParams{RoomID: roomID, UserID: "owner"})
+	expectErr(t, "IsRoomOwner", err, roomerrors.ErrRoomDoesntExist)
+	_, err = port.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	expectErr(t, "RoomSnapshot", err, roomerrors.ErrRoomDoesntExist)
+	expectErr(t, "ReplaceRoom", port.ReplaceRoom(ctx, ports.ReplaceRoomParams{RoomID: roomID}), roomerrors.ErrRoomDoesntExist)
+	for _, action := range []ports.Action{ports.ActionSet, ports.ActionDelete, ports.ActionAppend, ports.ActionRemove} {
+		expectErr(t, fmt.Sprintf("AffectData(%d)", action), affect(port, roomID, "key", action, models.IntValue(1)), roomerrors.ErrRoomDoesntExist)
+	}
+}
+
+func testDeleteRoom(t *testing.T, port ports.RoomsPort) {
+	ctx := context.Background()
+	room := newRoom(t, port, "owner")
+	kept := newRoom(t, port, "owner")
+	join(t, port, room.ID, models.User{ID: "user", Name: "User"})
+	if err := affect(port, room.ID, "key", ports.ActionSet, models.IntValue(1)); err != nil {
+		t.Fatalf("AffectData: %v", err)
+	}
+
+	expectErr(t, "DeleteRoom by not owner", port.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: room.ID, UserID: "user"}), roomerrors.ErrNotRoomOwner)
+	snapshotOf(t, port, room.ID)
+
+	if err := port.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: room.ID, UserID: "owner"}); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+	_, err := port.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID})
+	expectErr(t, "RoomSnapshot of deleted room", err, roomerrors.ErrRoomDoesntExist)
+	expectErr(t, "DeleteRoom of deleted room", port.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: room.ID, UserID: "owner"}), roomerrors.ErrRoomDoesntExist)
+	snapshotOf(t, port, kept.ID)
+}
+
+func testJoinRoom(t *testing.T, port ports.RoomsPort) {
+	room := newRoom(t, port, "owner")
+	join(t, port, room.ID, models.User{ID: "guest", Name: "Guest", Metadata: map[string]string{"avatar": "1"}})
+	join(t, port, room.ID, models.User{ID: "owner", Name: "Owner"})
+	join(t, port, room.ID, models.User{ID: "guest", Name: "Guest 2", Metadata: map[string]string{"avatar": "2"}})
+
+	snapshot := snapshotOf(t, port, room.ID)
+	if ids := userIDs(snapshot); !slices.Equal(ids, []string{"guest", "owner"}) {
+		t.Fatalf("users = %v, want guest and owner once", ids)
+	}
+	for _, user := range snapshot.Users {
+		if user.ID == "guest" && (user.Name != "Guest 2" || user.Metadata["avatar"] != "2") {
+			t.Errorf("guest = %+v, want name and metadata of the last join", user)
+		}
+	}
+}
+
+func testLeaveRoom(t *testing.T, port ports.RoomsPort) {
+	ctx := context.Background()
+	room := newRoom(t, port, "owner")
+	for _, id := range []types.NotEmptyText{"owner", "first", "second", "third"} {
+		join(t, port, room.ID, models.User{ID: id, Name: id})
+	}
+	leave := func(caller, kicked types.NotEmptyText) error {
+		return port.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: room.ID, CommandCallerUserID: caller, KickedUserID: kicked})
+	}
+
+	expectErr(t, "kick by another user", leave("first", "second"), roomerrors.ErrNotRoomOwner)
+	expectErr(t, "kick of stranger", leave("owner", "stranger"), roomerrors.ErrUserNotInRoom)
+	if err := leave("first", "first"); err != nil {
+		t.Errorf("leave by user: %v", err)
+	}
+	expectErr(t, "repeated leave", leave("first", "first"), roomerrors.ErrUserNotInRoom)
+	if err := leave("owner", "second"); err != nil {
+		t.Errorf("kick by owner: %v", err)
+	}
+
+	if ids := userIDs(snapshotOf(t, port, room.ID)); !slices.Equal(ids, []string{"owner", "third"}) {
+		t.Errorf("users = %v, want owner and third", ids)
+	}
+	isOwner, err := port.IsRoomOwner(ctx, ports.IsRoomOwnerParams{RoomID: room.ID, UserID: "owner"})
+	if err != nil || !isOwner {
+		t.Errorf("IsRoomOwner(owner) = %v, %v, want true", isOwner, err)
+	}
+	isOwner, err = port.IsRoomOwner(ctx, ports.IsRoomOwnerParams{RoomID: room.ID, UserID: "third"})
+	if err != nil || isOwner {
+		t.Errorf("IsRoomOwner(third) = %v, %v, want false", isOwner, err)
+	}
+}
+
+func testCountOwnedRooms(t *testing.T, port ports.RoomsPort) {
+	ctx := context.Background()
+	count := func(owner types.NotEmptyText) int {
+		t.Helper()
+		owned, err := port.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: owner})
+		if err != nil {
+			t.Fatalf("CountOwnedRooms: %v", err)
+		}
+		return owned
+	}
+
+	if owned := count("owner"); owned != 0 {
+		t.Fatalf("CountOwnedRooms of new owner = %d, want 0", owned)
+	}
+	first := newRoom(t, port, "owner")
+	newRoom(t, port, "owner")
+	newRoom(t, port, "another")
+	if owned := count("owner"); owned != 2 {
+		t.Fatalf("CountOwnedRooms = %d, want 2", owned)
+	}
+	if err := port.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: first.ID, UserID: "owner"}); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+	if owned := count("owner"); owned != 1 {
+		t.Fatalf("CountOwnedRooms after delete = %d, want 1", owned)
+	}
+}
+
+func testAffectData(t *testing.T, port ports.RoomsPort) {
+	room := newRoom(t, port, "owner")
+	steps := []struct {
+		name    string
+		dataID  string
+		action  ports.Action
+		value   *models.Value
+		wantErr error
+	}{
+		{name: "set", dataID: "scalar", action: ports.ActionSet, value: models.IntValue(1)},
+		{name: "overwrite", dataID: "scalar", action: ports.ActionSet, value: models.StrValue("two")},
+		{name: "append to missing key", dataID: "list", action: ports.ActionAppend, value: models.IntValue(1)},
+		{name: "append", dataID: "list", action: ports.ActionAppend, value: models.IntValue(2)},
+		{name: "append duplicate", dataID: "list", action: ports.ActionAppend, value: models.IntValue(1)},
+		{name: "remove every equal item", dataID: "list", action: ports.ActionRemove, value: models.IntValue(1)},
+		{name: "remove missing item", dataID: "list", action: ports.ActionRemove, value: models.IntValue(1), wantErr: roomerrors.ErrDataPieceDoesntExist},
+		{name: "remove from missing key", dataID: "missing", action: ports.ActionRemove, value: models.IntValue(1), wantErr: roomerrors.ErrDataPieceDoesntExist},
+		{name: "remove from scalar", dataID: "scalar", action: ports.ActionRemove, value: models.StrValue("two"), wantErr: roomerrors.ErrDataPieceDoesntExist},
+		{name: "set to be replaced", dataID: "replaced", action: ports.ActionSet, value: models.BoolValue(true)},
+		{name: "append replaces scalar", dataID: "replaced", action: ports.ActionAppend, value: models.StrValue("item")},
+		{name: "set to be deleted", dataID: "deleted", action: ports.ActionSet, value: models.IntValue(1)},
+		{name: "delete", dataID: "deleted", action: ports.ActionDelete},
+		{name: "delete missing key", dataID: "deleted", action: ports.ActionDelete, wantErr: roomerrors.ErrDataPieceDoesntExist},
+	}
+	for _, step := range steps {
+		err := affect(port, room.ID, step.dataID, step.action, step.value)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.wantErr)
+		}
+	}
+
+	want := map[string]*models.Value{
+		"scalar":   models.StrValue("two"),
+		"list":     models.ListValue([]models.Value{*models.IntValue(2)}),
+		"replaced": models.ListValue([]models.Value{*models.StrValue("item")}),
+	}
+	checkValues(t, snapshotOf(t, port, room.ID), want)
+}
+
+func testValueTypes(t *testing.T, port ports.RoomsPort) {
+	room := newRoom(t, port, "owner")
+	want := map[string]*models.Value{
+		"empty":  models.EmptyValue(),
+		"int":    models.IntValue(-1 << 62),
+		"str":    models.StrValue("строка \x00 with zero byte"),
+		"bool":   models.BoolValue(false),
+		"float":  models.FloatValue(0.1),
+		"bytes":  models.BytesValue([]byte{0, 1, 255}),
+		"list":   models.ListValue([]models.Value{*models.IntValue(1), *models.ListValue(nil)}),
+		"map":    models.MapValue(map[string]models.Value{"nested": *models.MapValue(map[string]models.Value{"k": *models.BoolValue(true)})}),
+		"a:b{c}": models.StrValue("key with separators"),
+	}
+	for key, value := range want {
+		if err := affect(port, room.ID, key, ports.ActionSet, value); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+	checkValues(t, snapshotOf(t, port, room.ID), want)
+}
+
+func testReplaceRoom(t *testing.T, port ports.RoomsPort) {
+	ctx := context.Background()
+	room := newRoom(t, port, "owner")
+	join(t, port, room.ID, models.User{ID: "left", Name: "Left"})
+	join(t, port, room.ID, models.User{ID: "guest", Name: "Guest"})
+	for _, key := range []string{"deleted", "changed"} {
+		if err := affect(port, room.ID, key, ports.ActionSet, models.IntValue(1)); err != nil {
+			t.Fatalf("set %s: %v", key, err)
+		}
+	}
+
+	err := port.ReplaceRoom(ctx, ports.ReplaceRoomParams{
+		RoomID: room.ID,
+		Users: []*models.User{
+			{ID: "guest", Name: "Guest 2", Metadata: map[string]string{"avatar": "2"}},
+			{ID: "owner", Name: "Owner"},
+		},
+		Values: map[string]models.Value{
+			"changed": *models.StrValue("two"),
+			"list":    *models.ListValue([]models.Value{*models.IntValue(1)}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceRoom: %v", err)
+	}
+
+	snapshot := snapshotOf(t, port, room.ID)
+	if snapshot.Room.OwnerUserID != "owner" || snapshot.Room.Options["max_users"] != "10" {
+		t.Errorf("room = %+v, want owner and options kept", snapshot.Room)
+	}
+	if ids := userIDs(snapshot); !slices.Equal(ids, []string{"guest", "owner"}) {
+		t.Fatalf("users = %v, want guest and owner", ids)
+	}
+	for _, user := range snapshot.Users {
+		if user.ID == "guest" && (user.Name != "Guest 2" || user.Metadata["avatar"] != "2") {
+			t.Errorf("guest = %+v, want replaced name and metadata", user)
+		}
+	}
+	checkValues(t, snapshot, map[string]*models.Value{
+		"changed": models.StrValue("two"),
+		"list":    models.ListValue([]models.Value{*models.IntValue(1)}),
+	})
+}
+
+func checkValues(t *testing.T, snapshot *models.RoomSnapshot, want map[string]*models.Value) {
+	t.Helper()
+	if len(snapshot.Values) != len(want) {
+		keys := make([]string, 0, len(snapshot.Values))
+		for key := range snapshot.Values {
+			keys = append(keys, key)
+		}
+		t.Errorf("values have keys %s, want %d keys", strings.Join(keys, ", "), len(want))
+	}
+	for key, wantValue := range want {
+		value, ok := snapshot.Values[key]
+		if !ok {
+			t.Errorf("value %s is missing", key)
+			continue
+		}
+		if !value.Equal(wantValue) {
+			t.Errorf("value %s = %+v, want %+v", key, value, wantValue)
+		}
+	}
+}
+
+func testConcurrentAppends(t *testing.T, port ports.RoomsPort) {
+	room := newRoom(t, port, "owner")
+	const appendsPerWorker = 5
+
+	var wg sync.WaitGroup
+	for worker := range stressWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range appendsPerWorker {
+				if err := affect(port, room.ID, "list", ports.ActionAppend, models.IntValue(int64(worker*appendsPerWorker+i))); err != nil {
+					t.Errorf("append: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value := snapshotOf(t, port, room.ID).Values["list"]
+	if value.Len() != stressWorkers*appendsPerWorker {
+		t.Fatalf("list has %d items, want %d: appends were lost", value.Len(), stressWorkers*appendsPerWorker)
+	}
+}
+
+func testConcurrentJoins(t *testing.T, port ports.RoomsPort) {
+	ctx := context.Background()
+	room := newRoom(t, port, "owner")
+
+	// every worker joins its user twice, odd workers leave after that
+	var wg sync.WaitGroup
+	for worker := range stressWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := models.User{ID: types.NotEmptyText(fmt.Sprintf("user-%02d", worker)), Name: "User"}
+			for range 2 {
+				if err := port.JoinRoom(ctx, ports.JoinRoomParams{RoomID: room.ID, UserFull: user}); err != nil {
+					t.Errorf("JoinRoom: %v", err)
+					return
+				}
+			}
+			if worker%2 == 1 {
+				if err := port.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: room.ID, CommandCallerUserID: user.ID, KickedUserID: user.ID}); err != nil {
+					t.Errorf("LeaveRoom: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := make([]string, 0, stressWorkers/2)
+	for worker := 0; worker < stressWorkers; worker += 2 {
+		want = append(want, fmt.Sprintf("user-%02d", worker))
+	}
+	if ids := userIDs(snapshotOf(t, port, room.ID)); !slices.Equal(ids, want) {
+		t.Fatalf("users = %v, want %v", ids, want)
+	}
+}
+
+func testConcurrentCreates(t *testing.T, port ports.RoomsPort) {
+	roomID := models.RoomID(types.GenerateUUID())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created, taken := 0, 0
+	for worker := range stressWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			owner := types.NotEmptyText(fmt.Sprintf("owner-%02d", worker))
+			_, err := port.CreateRoom(context.Background(), &models.Room{ID: roomID, OwnerUserID: owner})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, roomerrors.ErrRoomIDAlreadyExists):
+				taken++
+			default:
+				t.Errorf("CreateRoom: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 || taken != stressWorkers-1 {
+		t.Fatalf("created = %d, taken = %d, want 1 and %d", created, taken, stressWorkers-1)
+	}
+}
+
+func testPing(t *testing.T, port ports.RoomsPort) {
+	if err := port.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
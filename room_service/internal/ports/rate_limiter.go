@@ -0,0 +1,36 @@
+package ports
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimiter - token buckets identified by key, e.g. one bucket per room
+//
+// might be implemented with different storages (e.g. in-memory for one instance, redis for many)
+type RateLimiter interface {
+	// Allow - take one token from every bucket at once: from all of them or, if any is empty, from none
+	//
+	// buckets are refilled according to their limits, unlimited ones are skipped.
+	// allowed = false means there's no token in buckets[denied], retryAfter is the time until every empty bucket has one
+	Allow(ctx context.Context, buckets []RateLimitBucket) (allowed bool, denied int, retryAfter time.Duration, err error)
+}
+
+// RateLimitBucket - bucket of RateLimiter.Allow call
+type RateLimitBucket struct {
+	Key   string
+	Limit RateLimit
+}
+
+// RateLimit - token bucket params
+type RateLimit struct {
+	// PerSecond - tokens added every second, 0 = no limit
+	PerSecond float64
+	// Burst - bucket capacity, max tokens taken at once after idle period
+	Burst int
+}
+
+// Unlimited - there's no limit, RateLimiter skips the bucket
+func (l RateLimit) Unlimited() bool {
+	return l.PerSecond <= 0
+}
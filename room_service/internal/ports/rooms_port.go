@@ -10,10 +10,12 @@ import (
 type RoomsPort interface {
 	// CreateRoom - "Create" method for "Room", generates and returns ID
 	CreateRoom(ctx context.Context, params *models.Room) (room *models.Room, err error)
-	// DeleteRoom - "Delete" method for "Room", error on not found
+	// DeleteRoom - "Delete" method for "Room", error on not found or if user isn't room owner
 	DeleteRoom(ctx context.Context, params DeleteRoomParams) (err error)
 	// JoinRoom - adds user to visitors of existing room (if room exists, error on not found), idempotent
 	JoinRoom(ctx context.Context, params JoinRoomParams) (err error)
+	// CountOwnedRooms - amount of existing rooms created by user, used for quotas
+	CountOwnedRooms(ctx context.Context, params CountOwnedRoomsParams) (int, error)
 	// IsRoomOwner - returns true if user is owner of given room, used for security checks
 	IsRoomOwner(ctx context.Context, params IsRoomOwnerParams) (bool, error)
 	// LeaveRoom - kick user from one's room, either by himself or by admin
@@ -26,6 +28,12 @@ type RoomsPort interface {
 	//
 	// The whole data storage is a KV storage that can store different values, including lists and dicts
 	AffectData(ctx context.Context, params AffectDataParams) error
+	// ReplaceRoom - make users and data of existing room equal to given ones in one atomic operation, error on not found
+	//
+	// Owner and options aren't changed, quotas aren't checked: it writes state made by checked changes (see writebehind)
+	ReplaceRoom(ctx context.Context, params ReplaceRoomParams) error
+	// Ping - check that storage is reachable, used by health probes
+	Ping(ctx context.Context) error
 }
 
 // DeleteRoomParams - param set for RoomsPort.DeleteRoom method
@@ -52,12 +60,24 @@ type RoomSnapshotParams struct {
 	RoomID models.RoomID
 }
 
+// CountOwnedRoomsParams - param set for RoomsPort.CountOwnedRooms method
+type CountOwnedRoomsParams struct {
+	OwnerUserID types.NotEmptyText
+}
+
 // IsRoomOwnerParams - param set for RoomsPort.JoinRoom method
 type IsRoomOwnerParams struct {
 	RoomID models.RoomID
 	UserID types.NotEmptyText
 }
 
+// ReplaceRoomParams - param set for RoomsPort.ReplaceRoom method
+type ReplaceRoomParams struct {
+	RoomID models.RoomID
+	Users  []*models.User
+	Values map[string]models.Value
+}
+
 // Action is type for data affection modes ENUM
 //
 // SET, DELETE, APPEND, REMOVE
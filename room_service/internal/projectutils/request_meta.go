@@ -0,0 +1,88 @@
+package projectutils
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+)
+
+// gRPC metadata keys that are carried from the incoming request into commands
+const (
+	MetadataKeyRequestID   = "x-request-id"
+	MetadataKeyTraceID     = "x-trace-id"
+	MetadataKeyTraceParent = "traceparent"
+	// MetadataKeyStreamID - ID of stream that received forwarded command
+	MetadataKeyStreamID = "x-stream-id"
+)
+
+type requestMetaKey string
+
+const (
+	keyForUserID    requestMetaKey = "user_id"
+	keyForTraceID   requestMetaKey = "trace_id"
+	keyForCommandID requestMetaKey = "command_id"
+)
+
+// RequestIDFromIncomingContext returns request ID from incoming gRPC metadata or generates a new one
+func RequestIDFromIncomingContext(ctx context.Context) string {
+	if requestID := firstIncomingMetadataValue(ctx, MetadataKeyRequestID); len(requestID) > 0 {
+		return requestID
+	}
+	return GenerateRequestID()
+}
+
+// TraceIDFromIncomingContext returns trace ID from incoming gRPC metadata ("x-trace-id" or "traceparent"), empty if none
+func TraceIDFromIncomingContext(ctx context.Context) string {
+	if traceID := firstIncomingMetadataValue(ctx, MetadataKeyTraceID); len(traceID) > 0 {
+		return traceID
+	}
+	return firstIncomingMetadataValue(ctx, MetadataKeyTraceParent)
+}
+
+// StreamIDFromIncomingContext returns ID of stream that received forwarded command from incoming gRPC metadata, empty if none
+func StreamIDFromIncomingContext(ctx context.Context) string {
+	return firstIncomingMetadataValue(ctx, MetadataKeyStreamID)
+}
+
+// WithUserID stores ID of user who sent the command in ctx
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, keyForUserID, userID)
+}
+
+// UserIDFromContext returns ID stored with WithUserID, empty if none
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(keyForUserID).(string)
+	return userID
+}
+
+// WithTraceID stores trace ID in ctx
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, keyForTraceID, traceID)
+}
+
+// TraceIDFromContext returns trace ID stored with WithTraceID, empty if none
+func TraceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(keyForTraceID).(string)
+	return traceID
+}
+
+// WithCommandID stores ID of the command being processed in ctx
+func WithCommandID(ctx context.Context, commandID string) context.Context {
+	return context.WithValue(ctx, keyForCommandID, commandID)
+}
+
+// CommandIDFromContext returns ID stored with WithCommandID, empty if none
+func CommandIDFromContext(ctx context.Context) string {
+	commandID, _ := ctx.Value(keyForCommandID).(string)
+	return commandID
+}
+
+func firstIncomingMetadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
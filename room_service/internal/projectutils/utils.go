@@ -10,7 +10,7 @@ func NowTimestamp() int64 {
 	return time.Now().Unix()
 }
 
-// GenerateRequestID generates requestID for logger.KeyForRequestID to store
+// GenerateRequestID generates requestID for logging.WithRequestID to store
 func GenerateRequestID() string {
 	return types.GenerateUUID().String()
 }
@@ -0,0 +1,210 @@
+package circuitbreaker
+
+import (
+	"context"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/metrics"
+	"github.com/chempik1234/room-service/pkg/config"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// State - state of Breaker
+type State int
+
+const (
+	// StateClosed - calls are made, failures are counted
+	StateClosed State = iota
+	// StateHalfOpen - only a few probe calls are made, their results decide if Breaker is closed or opened again
+	StateHalfOpen
+	// StateOpen - calls aren't made until Params.OpenTimeout passes
+	StateOpen
+)
+
+// String - "closed", "half_open", "open"
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half_open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// Params - when Breaker is opened and how it's closed again, zero fields are set to defaults
+type Params struct {
+	// Window - failure ratio is counted over calls of the last Window, default 10 seconds
+	Window time.Duration
+	// MinCalls - Breaker isn't opened before there are MinCalls in Window, default 20
+	MinCalls int
+	// FailureRatio - share of failed calls in Window that opens Breaker, default 0.5
+	FailureRatio float64
+	// OpenTimeout - how long Breaker is open before probe calls are made, default 5 seconds
+	OpenTimeout time.Duration
+	// HalfOpenProbes - probe calls made at once in StateHalfOpen, all of them must succeed to close Breaker, default 1
+	HalfOpenProbes int
+	// IsFailure - which errors are failures, nil = config.IsTransient (e.g. "room doesn't exist" isn't storage failure)
+	IsFailure func(err error) bool
+}
+
+func (p Params) withDefaults() Params {
+	if p.Window <= 0 {
+		p.Window = 10 * time.Second
+	}
+	if p.MinCalls <= 0 {
+		p.MinCalls = 20
+	}
+	if p.FailureRatio <= 0 {
+		p.FailureRatio = 0.5
+	}
+	if p.OpenTimeout <= 0 {
+		p.OpenTimeout = 5 * time.Second
+	}
+	if p.HalfOpenProbes <= 0 {
+		p.HalfOpenProbes = 1
+	}
+	if p.IsFailure == nil {
+		p.IsFailure = config.IsTransient
+	}
+	return p
+}
+
+// Breaker - circuit breaker of one port, stops calling it while it fails
+//
+// closed -> open when failure ratio is reached, open -> half-open after OpenTimeout,
+// half-open -> closed when probes succeed or -> open when one of them fails.
+// State changes are logged and exported in metrics
+type Breaker struct {
+	port    string
+	params  Params
+	metrics *metrics.Metrics
+	// now - time source, replaced in tests
+	now func() time.Time
+
+	mu    sync.Mutex
+	state State
+	// windowStart, calls, failures - counters of StateClosed
+	windowStart time.Time
+	calls       int
+	failures    int
+	// openedAt - when StateOpen started
+	openedAt time.Time
+	// probes - probe calls in progress, succeededProbes - finished successfully in StateHalfOpen
+	probes          int
+	succeededProbes int
+}
+
+// NewBreaker - closed Breaker of port (see metrics.PortRooms, metrics.PortCommandCache), m is optional (nil)
+func NewBreaker(port string, params Params, m *metrics.Metrics) *Breaker {
+	b := &Breaker{
+		port:    port,
+		params:  params.withDefaults(),
+		metrics: m,
+		now:     time.Now,
+	}
+	b.windowStart = b.now()
+	m.SetCircuitBreakerState(port, int(StateClosed))
+	return b
+}
+
+// State - current state, StateOpen becomes StateHalfOpen only when the next call is allowed
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow - whether call of method can be made, done must be called with its result
+//
+// not allowed -> errors.RetryAfterError with errors.ErrCircuitOpen, RetryAfter is the time until probes
+func (b *Breaker) Allow(ctx context.Context, method string) (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case StateOpen:
+		if waited := now.Sub(b.openedAt); waited < b.params.OpenTimeout {
+			return nil, b.reject(method, b.params.OpenTimeout-waited)
+		}
+		b.setState(ctx, StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if b.probes+b.succeededProbes >= b.params.HalfOpenProbes {
+			return nil, b.reject(method, 0)
+		}
+		b.probes++
+		return func(err error) { b.probeDone(ctx, err) }, nil
+	default:
+		if now.Sub(b.windowStart) >= b.params.Window {
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+		return func(err error) { b.callDone(ctx, err) }, nil
+	}
+}
+
+func (b *Breaker) reject(method string, retryAfter time.Duration) error {
+	b.metrics.IncCircuitBreakerRejected(b.port, method)
+	return &roomerrors.RetryAfterError{Err: roomerrors.ErrCircuitOpen, RetryAfter: retryAfter}
+}
+
+// callDone - count call made in StateClosed, open if failure ratio is reached
+func (b *Breaker) callDone(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Breaker might be opened by another call while this one was made
+	if b.state != StateClosed {
+		return
+	}
+
+	b.calls++
+	if err != nil && b.params.IsFailure(err) {
+		b.failures++
+	}
+	if b.calls >= b.params.MinCalls && float64(b.failures) >= float64(b.calls)*b.params.FailureRatio {
+		logging.FromContext(ctx).Warn(ctx, "circuit breaker failure ratio is reached",
+			zap.String("port", b.port), zap.Int("calls", b.calls), zap.Int("failures", b.failures), zap.Error(err))
+		b.open(ctx)
+	}
+}
+
+// probeDone - close if every probe succeeded, open again on failure
+func (b *Breaker) probeDone(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateHalfOpen {
+		return
+	}
+
+	b.probes--
+	if err != nil && b.params.IsFailure(err) {
+		logging.FromContext(ctx).Warn(ctx, "circuit breaker probe failed", zap.String("port", b.port), zap.Error(err))
+		b.open(ctx)
+		return
+	}
+	b.succeededProbes++
+	if b.succeededProbes >= b.params.HalfOpenProbes {
+		b.windowStart, b.calls, b.failures = b.now(), 0, 0
+		b.setState(ctx, StateClosed)
+	}
+}
+
+func (b *Breaker) open(ctx context.Context) {
+	b.openedAt = b.now()
+	b.probes, b.succeededProbes = 0, 0
+	b.setState(ctx, StateOpen)
+}
+
+func (b *Breaker) setState(ctx context.Context, state State) {
+	previous := b.state
+	b.state = state
+	b.metrics.SetCircuitBreakerState(b.port, int(state))
+	logging.FromContext(ctx).Warn(ctx, "circuit breaker state changed",
+		zap.String("port", b.port), zap.String("previous", previous.String()), zap.String("state", state.String()))
+}
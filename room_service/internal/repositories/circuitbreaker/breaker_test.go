@@ -0,0 +1,118 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/ports"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var errNetwork = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+// fakeClock - time source of Breaker that is moved by tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(clock *fakeClock) *Breaker {
+	breaker := NewBreaker("rooms", Params{MinCalls: 4, FailureRatio: 0.5, OpenTimeout: time.Second, HalfOpenProbes: 1}, nil)
+	breaker.now = clock.Now
+	breaker.windowStart = clock.now
+	return breaker
+}
+
+// makeCall - call through breaker that returns callErr, error of Allow is returned as is
+func makeCall(breaker *Breaker, callErr error) error {
+	done, err := breaker.Allow(context.Background(), "room_snapshot")
+	if err != nil {
+		return err
+	}
+	done(callErr)
+	return callErr
+}
+
+func TestBreakerOpensHalfOpensAndCloses(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	breaker := newTestBreaker(clock)
+
+	// domain errors aren't storage failures
+	for range 4 {
+		_ = makeCall(breaker, roomerrors.ErrRoomDoesntExist)
+	}
+	if breaker.State() != StateClosed {
+		t.Fatalf("state = %s after domain errors, want closed", breaker.State())
+	}
+
+	// new window: 2 of 4 calls fail -> open
+	clock.now = clock.now.Add(time.Minute)
+	for _, err := range []error{nil, errNetwork, nil, errNetwork} {
+		_ = makeCall(breaker, err)
+	}
+	if breaker.State() != StateOpen {
+		t.Fatalf("state = %s after failures, want open", breaker.State())
+	}
+
+	clock.now = clock.now.Add(300 * time.Millisecond)
+	err := makeCall(breaker, nil)
+	var retryAfterErr *roomerrors.RetryAfterError
+	if !errors.Is(err, roomerrors.ErrCircuitOpen) || !errors.As(err, &retryAfterErr) || retryAfterErr.RetryAfter != 700*time.Millisecond {
+		t.Fatalf("call while open: got %v, want ErrCircuitOpen with retry after 700ms", err)
+	}
+
+	// half-open: one probe at once, its failure opens again
+	clock.now = clock.now.Add(time.Second)
+	done, err := breaker.Allow(context.Background(), "room_snapshot")
+	if err != nil || breaker.State() != StateHalfOpen {
+		t.Fatalf("probe: err = %v, state = %s, want allowed half-open probe", err, breaker.State())
+	}
+	if err = makeCall(breaker, nil); !errors.Is(err, roomerrors.ErrCircuitOpen) {
+		t.Fatalf("second call while probing: got %v, want ErrCircuitOpen", err)
+	}
+	done(errNetwork)
+	if breaker.State() != StateOpen {
+		t.Fatalf("state = %s after failed probe, want open", breaker.State())
+	}
+
+	// successful probe closes
+	clock.now = clock.now.Add(time.Second)
+	if err = makeCall(breaker, nil); err != nil || breaker.State() != StateClosed {
+		t.Fatalf("successful probe: err = %v, state = %s, want closed", err, breaker.State())
+	}
+}
+
+// failingCommandCache - ports.CommandIDShortCache that always fails with network error
+type failingCommandCache struct {
+	ports.CommandIDShortCache
+}
+
+func (failingCommandCache) Reserve(context.Context, string) (bool, error) {
+	return false, errNetwork
+}
+
+func TestCommandCacheFailOpen(t *testing.T) {
+	for _, failOpen := range []bool{false, true} {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		cache := NewCommandCache(failingCommandCache{}, newTestBreaker(clock), failOpen)
+		for range 4 {
+			if _, err := cache.Reserve(context.Background(), "command"); !errors.Is(err, syscall.ECONNRESET) {
+				t.Fatalf("failOpen = %v: got %v before breaker is open, want network error", failOpen, err)
+			}
+		}
+
+		alreadySeen, err := cache.Reserve(context.Background(), "command")
+		if failOpen && (err != nil || alreadySeen) {
+			t.Errorf("fail open: got (%v, %v), want command to be executed without deduplication", alreadySeen, err)
+		}
+		if !failOpen && !errors.Is(err, roomerrors.ErrCircuitOpen) {
+			t.Errorf("fail closed: got %v, want ErrCircuitOpen", err)
+		}
+	}
+}
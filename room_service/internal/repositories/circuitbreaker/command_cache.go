@@ -0,0 +1,85 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// CommandCache - ports.CommandIDShortCache decorator that fails fast with errors.ErrCircuitOpen while next is failing
+//
+// with failOpen commands are executed without deduplication instead:
+// Reserve says commandID isn't seen, Get finds nothing, Save and Release do nothing
+type CommandCache struct {
+	next     ports.CommandIDShortCache
+	breaker  *Breaker
+	failOpen bool
+}
+
+// NewCommandCache - wrap next, calls go through breaker
+func NewCommandCache(next ports.CommandIDShortCache, breaker *Breaker, failOpen bool) *CommandCache {
+	return &CommandCache{next: next, breaker: breaker, failOpen: failOpen}
+}
+
+// Reserve - ports.CommandIDShortCache.Reserve through circuit breaker
+func (s *CommandCache) Reserve(ctx context.Context, commandID string) (alreadySeen bool, err error) {
+	err = call(ctx, s.breaker, "reserve", func() error {
+		alreadySeen, err = s.next.Reserve(ctx, commandID)
+		return err
+	})
+	if s.skipped(ctx, err) {
+		return false, nil
+	}
+	return alreadySeen, err
+}
+
+// Get - ports.CommandIDShortCache.Get through circuit breaker
+func (s *CommandCache) Get(ctx context.Context, commandID string) (record *ports.CommandRecord, err error) {
+	err = call(ctx, s.breaker, "get", func() error {
+		record, err = s.next.Get(ctx, commandID)
+		return err
+	})
+	if s.skipped(ctx, err) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// Save - ports.CommandIDShortCache.Save through circuit breaker
+func (s *CommandCache) Save(ctx context.Context, commandID string, record *ports.CommandRecord) error {
+	err := call(ctx, s.breaker, "save", func() error {
+		return s.next.Save(ctx, commandID, record)
+	})
+	if s.skipped(ctx, err) {
+		return nil
+	}
+	return err
+}
+
+// Release - ports.CommandIDShortCache.Release through circuit breaker
+func (s *CommandCache) Release(ctx context.Context, commandID string) error {
+	err := call(ctx, s.breaker, "release", func() error {
+		return s.next.Release(ctx, commandID)
+	})
+	if s.skipped(ctx, err) {
+		return nil
+	}
+	return err
+}
+
+// Ping - ports.CommandIDShortCache.Ping, not limited by breaker, so health probes see the real state of storage
+func (s *CommandCache) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// skipped - call wasn't made because breaker is open and cache fails open
+func (s *CommandCache) skipped(ctx context.Context, err error) bool {
+	if !s.failOpen || !errors.Is(err, roomerrors.ErrCircuitOpen) {
+		return false
+	}
+	logging.FromContext(ctx).Warn(ctx, "command cache is unavailable, command is executed without deduplication", zap.Error(err))
+	return true
+}
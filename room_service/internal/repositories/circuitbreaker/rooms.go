@@ -0,0 +1,105 @@
+package circuitbreaker
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+)
+
+// RoomsRepository - ports.RoomsPort decorator that fails fast with errors.ErrCircuitOpen while next is failing
+type RoomsRepository struct {
+	next    ports.RoomsPort
+	breaker *Breaker
+}
+
+// NewRoomsRepository - wrap next, calls go through breaker
+func NewRoomsRepository(next ports.RoomsPort, breaker *Breaker) *RoomsRepository {
+	return &RoomsRepository{next: next, breaker: breaker}
+}
+
+// CreateRoom - ports.RoomsPort.CreateRoom through circuit breaker
+func (s *RoomsRepository) CreateRoom(ctx context.Context, params *models.Room) (room *models.Room, err error) {
+	err = call(ctx, s.breaker, "create_room", func() error {
+		room, err = s.next.CreateRoom(ctx, params)
+		return err
+	})
+	return room, err
+}
+
+// DeleteRoom - ports.RoomsPort.DeleteRoom through circuit breaker
+func (s *RoomsRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) error {
+	return call(ctx, s.breaker, "delete_room", func() error {
+		return s.next.DeleteRoom(ctx, params)
+	})
+}
+
+// JoinRoom - ports.RoomsPort.JoinRoom through circuit breaker
+func (s *RoomsRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	return call(ctx, s.breaker, "join_room", func() error {
+		return s.next.JoinRoom(ctx, params)
+	})
+}
+
+// CountOwnedRooms - ports.RoomsPort.CountOwnedRooms through circuit breaker
+func (s *RoomsRepository) CountOwnedRooms(ctx context.Context, params ports.CountOwnedRoomsParams) (count int, err error) {
+	err = call(ctx, s.breaker, "count_owned_rooms", func() error {
+		count, err = s.next.CountOwnedRooms(ctx, params)
+		return err
+	})
+	return count, err
+}
+
+// IsRoomOwner - ports.RoomsPort.IsRoomOwner through circuit breaker
+func (s *RoomsRepository) IsRoomOwner(ctx context.Context, params ports.IsRoomOwnerParams) (isOwner bool, err error) {
+	err = call(ctx, s.breaker, "is_room_owner", func() error {
+		isOwner, err = s.next.IsRoomOwner(ctx, params)
+		return err
+	})
+	return isOwner, err
+}
+
+// LeaveRoom - ports.RoomsPort.LeaveRoom through circuit breaker
+func (s *RoomsRepository) LeaveRoom(ctx context.Context, params ports.LeaveRoomParams) error {
+	return call(ctx, s.breaker, "leave_room", func() error {
+		return s.next.LeaveRoom(ctx, params)
+	})
+}
+
+// RoomSnapshot - ports.RoomsPort.RoomSnapshot through circuit breaker
+func (s *RoomsRepository) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (snapshot *models.RoomSnapshot, err error) {
+	err = call(ctx, s.breaker, "room_snapshot", func() error {
+		snapshot, err = s.next.RoomSnapshot(ctx, params)
+		return err
+	})
+	return snapshot, err
+}
+
+// AffectData - ports.RoomsPort.AffectData through circuit breaker
+func (s *RoomsRepository) AffectData(ctx context.Context, params ports.AffectDataParams) error {
+	return call(ctx, s.breaker, "affect_data", func() error {
+		return s.next.AffectData(ctx, params)
+	})
+}
+
+// ReplaceRoom - ports.RoomsPort.ReplaceRoom through circuit breaker
+func (s *RoomsRepository) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	return call(ctx, s.breaker, "replace_room", func() error {
+		return s.next.ReplaceRoom(ctx, params)
+	})
+}
+
+// Ping - ports.RoomsPort.Ping, not limited by breaker, so health probes see the real state of storage
+func (s *RoomsRepository) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// call - make fn if breaker allows it and report its result
+func call(ctx context.Context, breaker *Breaker, method string, fn func() error) error {
+	done, err := breaker.Allow(ctx, method)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err)
+	return err
+}
@@ -0,0 +1,217 @@
+package commandcache
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/ports"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// bucket names of BoltCommandCache
+var (
+	boltCommandsBucket = []byte("commands")
+	boltExpiryBucket   = []byte("command_expiry")
+)
+
+// boltSweepBatch - max expired records erased by one write
+const boltSweepBatch = 64
+
+// BoltCommandCache - impl of ports.CommandIdShortCache with embedded BoltDB file, records survive restarts
+//
+// Records expire after TTL. "command_expiry" bucket is an index ordered by expiration time,
+// every write erases a few expired records from it, so the file doesn't grow with old commands
+type BoltCommandCache struct {
+	db  *bolt.DB
+	ttl time.Duration
+	// now - time source, replaced in tests
+	now func() time.Time
+}
+
+// boltCommandEntry - JSON of record in "commands" bucket
+type boltCommandEntry struct {
+	Record ports.CommandRecord `json:"record"`
+	// ExpiresAt - unix nanoseconds
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// NewBoltCommandCache - create new BoltCommandCache, buckets are created if db is new
+//
+// db can be shared with other stores (e.g. room.BoltRepository), they use their own buckets
+func NewBoltCommandCache(db *bolt.DB, ttlMs int) (*BoltCommandCache, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltCommandsBucket, boltExpiryBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating command cache buckets in bolt: %w", err)
+	}
+	return &BoltCommandCache{
+		db:  db,
+		ttl: time.Duration(ttlMs) * time.Millisecond,
+		now: time.Now,
+	}, nil
+}
+
+// Reserve - save commandID as in-progress in one transaction, if it's not saved yet (or expired)
+func (s *BoltCommandCache) Reserve(_ context.Context, commandID string) (bool, error) {
+	alreadySeen := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		now := s.now()
+		if err := s.sweep(tx, now); err != nil {
+			return err
+		}
+		entry, err := s.entry(tx, commandID, now)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			alreadySeen = true
+			return nil
+		}
+		return s.put(tx, commandID, &ports.CommandRecord{State: ports.CommandStateInProgress}, now)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error reserving command in bolt: %w", err)
+	}
+	return alreadySeen, nil
+}
+
+// Get - get record of commandID saved in BoltDB
+//
+// nil record if it's not saved or expired
+func (s *BoltCommandCache) Get(_ context.Context, commandID string) (*ports.CommandRecord, error) {
+	var record *ports.CommandRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		entry, err := s.entry(tx, commandID, s.now())
+		if entry != nil {
+			record = &entry.Record
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying bolt: %w", err)
+	}
+	return record, nil
+}
+
+// Save - store record of commandID in BoltDB, its TTL starts again
+func (s *BoltCommandCache) Save(_ context.Context, commandID string, record *ports.CommandRecord) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		now := s.now()
+		if err := s.sweep(tx, now); err != nil {
+			return err
+		}
+		return s.put(tx, commandID, record, now)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving command in bolt: %w", err)
+	}
+	return nil
+}
+
+// Release - delete commandID from BoltDB
+func (s *BoltCommandCache) Release(_ context.Context, commandID string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return s.delete(tx, commandID)
+	})
+	if err != nil {
+		return fmt.Errorf("error releasing command in bolt: %w", err)
+	}
+	return nil
+}
+
+// Ping - check that BoltDB file is open
+func (s *BoltCommandCache) Ping(_ context.Context) error {
+	if err := s.db.View(func(*bolt.Tx) error { return nil }); err != nil {
+		return fmt.Errorf("error accessing bolt: %w", err)
+	}
+	return nil
+}
+
+// entry - not expired entry of commandID, nil if there's none
+func (s *BoltCommandCache) entry(tx *bolt.Tx, commandID string, now time.Time) (*boltCommandEntry, error) {
+	raw := tx.Bucket(boltCommandsBucket).Get([]byte(commandID))
+	if raw == nil {
+		return nil, nil
+	}
+	entry := &boltCommandEntry{}
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return nil, fmt.Errorf("error decoding command record: %w", err)
+	}
+	if entry.ExpiresAt <= now.UnixNano() {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// put - store record with expiration after TTL, previous expiry index key is replaced
+func (s *BoltCommandCache) put(tx *bolt.Tx, commandID string, record *ports.CommandRecord, now time.Time) error {
+	if err := s.delete(tx, commandID); err != nil {
+		return err
+	}
+	entry := boltCommandEntry{Record: *record, ExpiresAt: now.Add(s.ttl).UnixNano()}
+	raw, err := json.Marshal(&entry)
+	if err != nil {
+		return fmt.Errorf("error encoding command record: %w", err)
+	}
+	if err = tx.Bucket(boltCommandsBucket).Put([]byte(commandID), raw); err != nil {
+		return err
+	}
+	return tx.Bucket(boltExpiryBucket).Put(boltExpiryKey(entry.ExpiresAt, commandID), nil)
+}
+
+// delete - erase record and its expiry index key
+func (s *BoltCommandCache) delete(tx *bolt.Tx, commandID string) error {
+	commands := tx.Bucket(boltCommandsBucket)
+	raw := commands.Get([]byte(commandID))
+	if raw == nil {
+		return nil
+	}
+	var entry boltCommandEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return fmt.Errorf("error decoding command record: %w", err)
+	}
+	if err := tx.Bucket(boltExpiryBucket).Delete(boltExpiryKey(entry.ExpiresAt, commandID)); err != nil {
+		return err
+	}
+	return commands.Delete([]byte(commandID))
+}
+
+// sweep - erase up to boltSweepBatch expired records, the oldest first
+func (s *BoltCommandCache) sweep(tx *bolt.Tx, now time.Time) error {
+	expiry := tx.Bucket(boltExpiryBucket)
+	commands := tx.Bucket(boltCommandsBucket)
+
+	// keys are collected first, deleting while iterating moves the cursor
+	expired := make([][]byte, 0)
+	cursor := expiry.Cursor()
+	for key, _ := cursor.First(); key != nil && len(expired) < boltSweepBatch; key, _ = cursor.Next() {
+		if int64(binary.BigEndian.Uint64(key[:8])) > now.UnixNano() {
+			break
+		}
+		expired = append(expired, append([]byte(nil), key...))
+	}
+	for _, key := range expired {
+		if err := commands.Delete(key[8:]); err != nil {
+			return err
+		}
+		if err := expiry.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltExpiryKey - expiration time (big endian, so keys are ordered by it) and commandID
+func boltExpiryKey(expiresAt int64, commandID string) []byte {
+	key := make([]byte, 8, 8+len(commandID))
+	binary.BigEndian.PutUint64(key, uint64(expiresAt))
+	return append(key, commandID...)
+}
@@ -0,0 +1,82 @@
+package commandcache
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/ports"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltCommandCache(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "commands.db")
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("open bolt: %v", err)
+	}
+	cache, err := NewBoltCommandCache(db, 1000)
+	if err != nil {
+		t.Fatalf("NewBoltCommandCache: %v", err)
+	}
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	if seen, err := cache.Reserve(ctx, "a"); err != nil || seen {
+		t.Fatalf("first Reserve = %v, %v, want not seen", seen, err)
+	}
+	if seen, err := cache.Reserve(ctx, "a"); err != nil || !seen {
+		t.Fatalf("second Reserve = %v, %v, want seen", seen, err)
+	}
+	if err = cache.Save(ctx, "a", &ports.CommandRecord{State: ports.CommandStateDone, Event: []byte("event")}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err = cache.Reserve(ctx, "b"); err != nil {
+		t.Fatalf("Reserve b: %v", err)
+	}
+	if err = cache.Release(ctx, "b"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if record, err := cache.Get(ctx, "b"); err != nil || record != nil {
+		t.Fatalf("Get of released = %+v, %v, want nil", record, err)
+	}
+
+	// records survive restart
+	if err = db.Close(); err != nil {
+		t.Fatalf("close bolt: %v", err)
+	}
+	if db, err = bolt.Open(path, 0o600, nil); err != nil {
+		t.Fatalf("reopen bolt: %v", err)
+	}
+	defer db.Close()
+	if cache, err = NewBoltCommandCache(db, 1000); err != nil {
+		t.Fatalf("NewBoltCommandCache: %v", err)
+	}
+	cache.now = func() time.Time { return now }
+	record, err := cache.Get(ctx, "a")
+	if err != nil || record == nil || record.State != ports.CommandStateDone || string(record.Event) != "event" {
+		t.Fatalf("Get after restart = %+v, %v, want done record with event", record, err)
+	}
+
+	// expired records aren't returned and are swept by the next write
+	now = now.Add(time.Second)
+	if record, err = cache.Get(ctx, "a"); err != nil || record != nil {
+		t.Fatalf("Get of expired = %+v, %v, want nil", record, err)
+	}
+	if seen, err := cache.Reserve(ctx, "c"); err != nil || seen {
+		t.Fatalf("Reserve c = %v, %v, want not seen", seen, err)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if keys := tx.Bucket(boltCommandsBucket).Stats().KeyN; keys != 1 {
+			t.Errorf("commands stored = %d, want 1: expired ones aren't swept", keys)
+		}
+		if keys := tx.Bucket(boltExpiryBucket).Stats().KeyN; keys != 1 {
+			t.Errorf("expiry index keys = %d, want 1", keys)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package commandcache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/ports"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InMemoryCommandCache - impl of ports.CommandIdShortCache with in-memory LRU map
+//
+// Records are spread over shards by commandID hash, every shard has it's own lock,
+// so commands with different IDs rarely wait for each other.
+//
+// Every shard stores up to capacity/shards records, the least recently used one is evicted on overflow.
+// In-progress reservations are kept apart and never evicted: their command is still executed, so a repeat
+// must wait for it instead of executing it once more. A shard has up to capacity/shards of them too,
+// Reserve fails with ErrTooManyReserved when there's no room for one more.
+//
+// Records expire after TTL, expired records are erased on access and on every write into their shard,
+// so the cache never holds more than the records of the last TTL, even without capacity
+type InMemoryCommandCache struct {
+	shards []*inMemoryCommandCacheShard
+	ttl    time.Duration
+	stats  inMemoryCommandCacheCounters
+	// now - time source, replaced in tests
+	now func() time.Time
+}
+
+// InMemoryCommandCacheStats - counters of InMemoryCommandCache, see InMemoryCommandCache.Stats
+type InMemoryCommandCacheStats struct {
+	// Hits - Reserve/Get calls that found a stored commandID
+	Hits uint64
+	// Misses - Reserve/Get calls that didn't find a stored commandID
+	Misses uint64
+	// Evictions - records erased because shard was out of capacity
+	Evictions uint64
+	// Expirations - records erased because of TTL
+	Expirations uint64
+	// Size - records stored right now (including expired ones that aren't erased yet)
+	Size int
+	// Reserved - in-progress records among Size, they aren't evicted
+	Reserved int
+}
+
+type inMemoryCommandCacheCounters struct {
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+// ErrTooManyReserved - shard of InMemoryCommandCache has no room for one more in-progress command,
+// it's a kind of errors.ErrRateLimited: the command may be sent again when others are finished
+var ErrTooManyReserved = fmt.Errorf("too many commands in progress: %w", roomerrors.ErrRateLimited)
+
+type inMemoryCommandCacheShard struct {
+	records map[string]*list.Element
+	// usage - LRU order of finished records, front is the most recently used, values are *inMemoryCommandEntry
+	usage *list.List
+	// reserved - in-progress records, they aren't in usage, so they are never evicted
+	reserved map[string]*inMemoryCommandEntry
+	// expiry - reserved and finished records in the order they expire, front expires first, only used with TTL
+	expiry   *list.List
+	mu       sync.Mutex
+	capacity int
+}
+
+type inMemoryCommandEntry struct {
+	commandID string
+	record    ports.CommandRecord
+	expiresAt time.Time
+	// expiryElement - element of entry in shard's expiry list, nil without TTL
+	expiryElement *list.Element
+}
+
+// NewInMemoryCommandCache - create new InMemoryCommandCache
+//
+// capacity <= 0 means no limit, shards <= 0 means 1 shard, ttlMs <= 0 means records never expire,
+// at least one of capacity and ttlMs must be set, otherwise the cache grows without limit
+func NewInMemoryCommandCache(capacity int, shards int, ttlMs int) *InMemoryCommandCache {
+	if shards <= 0 {
+		shards = 1
+	}
+
+	shardCapacity := 0
+	if capacity > 0 {
+		shardCapacity = max(capacity/shards, 1)
+	}
+
+	s := &InMemoryCommandCache{
+		shards: make([]*inMemoryCommandCacheShard, shards),
+		ttl:    time.Duration(ttlMs) * time.Millisecond,
+		now:    time.Now,
+	}
+	for i := range s.shards {
+		s.shards[i] = &inMemoryCommandCacheShard{
+			records:  make(map[string]*list.Element),
+			usage:    list.New(),
+			reserved: make(map[string]*inMemoryCommandEntry),
+			expiry:   list.New(),
+			capacity: shardCapacity,
+		}
+	}
+	return s
+}
+
+// Reserve - save commandID as in-progress under shard's lock, if it's not saved yet
+//
+// ErrTooManyReserved if shard already has capacity/shards in-progress commands
+func (s *InMemoryCommandCache) Reserve(_ context.Context, commandID string) (bool, error) {
+	shard := s.shardFor(commandID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := s.now()
+	if s.lookup(shard, commandID, now) != nil {
+		return true, nil
+	}
+	s.expire(shard, now)
+	if shard.capacity > 0 && len(shard.reserved) >= shard.capacity {
+		return false, ErrTooManyReserved
+	}
+	s.store(shard, commandID, ports.CommandRecord{State: ports.CommandStateInProgress})
+	return false, nil
+}
+
+// Get - get record of commandID, nil if it's not saved (or expired)
+func (s *InMemoryCommandCache) Get(_ context.Context, commandID string) (*ports.CommandRecord, error) {
+	shard := s.shardFor(commandID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry := s.lookup(shard, commandID, s.now())
+	if entry == nil {
+		return nil, nil
+	}
+
+	record := entry.record
+	return &record, nil
+}
+
+// Save - store record of commandID, TTL starts over
+func (s *InMemoryCommandCache) Save(_ context.Context, commandID string, record *ports.CommandRecord) error {
+	shard := s.shardFor(commandID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	s.store(shard, commandID, *record)
+	return nil
+}
+
+// Release - forget commandID
+func (s *InMemoryCommandCache) Release(_ context.Context, commandID string) error {
+	shard := s.shardFor(commandID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.remove(commandID)
+	return nil
+}
+
+// Ping - process memory is always reachable
+func (s *InMemoryCommandCache) Ping(_ context.Context) error {
+	return nil
+}
+
+// Stats - return hit/miss/evict counters and current size
+func (s *InMemoryCommandCache) Stats() InMemoryCommandCacheStats {
+	stats := InMemoryCommandCacheStats{
+		Hits:        s.stats.hits.Load(),
+		Misses:      s.stats.misses.Load(),
+		Evictions:   s.stats.evictions.Load(),
+		Expirations: s.stats.expirations.Load(),
+	}
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		stats.Size += shard.usage.Len() + len(shard.reserved)
+		stats.Reserved += len(shard.reserved)
+		shard.mu.Unlock()
+	}
+	return stats
+}
+
+func (s *InMemoryCommandCache) shardFor(commandID string) *inMemoryCommandCacheShard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(commandID))
+	return s.shards[hash.Sum32()%uint32(len(s.shards))]
+}
+
+// lookup - find not expired entry and mark it as recently used, must be called under shard's lock
+func (s *InMemoryCommandCache) lookup(shard *inMemoryCommandCacheShard, commandID string, now time.Time) *inMemoryCommandEntry {
+	entry, element := shard.reserved[commandID], (*list.Element)(nil)
+	if entry == nil {
+		var ok bool
+		if element, ok = shard.records[commandID]; !ok {
+			s.stats.misses.Add(1)
+			return nil
+		}
+		entry = element.Value.(*inMemoryCommandEntry)
+	}
+
+	if !entry.expiresAt.IsZero() && now.After(entry.expiresAt) {
+		shard.remove(commandID)
+		s.stats.expirations.Add(1)
+		s.stats.misses.Add(1)
+		return nil
+	}
+
+	if element != nil {
+		shard.usage.MoveToFront(element)
+	}
+	s.stats.hits.Add(1)
+	return entry
+}
+
+// store - insert or overwrite entry, expired entries of shard are erased, must be called under shard's lock
+//
+// in-progress entry is reserved, finished one is put into LRU, the least recently used is evicted on overflow
+func (s *InMemoryCommandCache) store(shard *inMemoryCommandCacheShard, commandID string, record ports.CommandRecord) {
+	now := s.now()
+	s.expire(shard, now)
+
+	shard.remove(commandID)
+	entry := &inMemoryCommandEntry{commandID: commandID, record: record}
+	if s.ttl > 0 {
+		// TTL is the same for every entry, so the new one expires last
+		entry.expiresAt = now.Add(s.ttl)
+		entry.expiryElement = shard.expiry.PushBack(entry)
+	}
+
+	if record.State == ports.CommandStateInProgress {
+		shard.reserved[commandID] = entry
+		return
+	}
+	shard.records[commandID] = shard.usage.PushFront(entry)
+
+	if shard.capacity > 0 && shard.usage.Len() > shard.capacity {
+		shard.remove(shard.usage.Back().Value.(*inMemoryCommandEntry).commandID)
+		s.stats.evictions.Add(1)
+	}
+}
+
+// expire - erase entries of shard whose TTL is over, must be called under shard's lock
+func (s *InMemoryCommandCache) expire(shard *inMemoryCommandCacheShard, now time.Time) {
+	for element := shard.expiry.Front(); element != nil; element = shard.expiry.Front() {
+		entry := element.Value.(*inMemoryCommandEntry)
+		if !now.After(entry.expiresAt) {
+			return
+		}
+		shard.remove(entry.commandID)
+		s.stats.expirations.Add(1)
+	}
+}
+
+// remove - erase entry of commandID, reserved or stored in LRU, must be called under lock
+func (s *inMemoryCommandCacheShard) remove(commandID string) {
+	entry, ok := s.reserved[commandID]
+	if ok {
+		delete(s.reserved, commandID)
+	}
+	if element, found := s.records[commandID]; found {
+		entry = element.Value.(*inMemoryCommandEntry)
+		s.usage.Remove(element)
+		delete(s.records, commandID)
+	}
+	if entry != nil && entry.expiryElement != nil {
+		s.expiry.Remove(entry.expiryElement)
+	}
+}
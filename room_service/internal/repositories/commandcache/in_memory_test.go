@@ -0,0 +1,226 @@
+package commandcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/ports"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInMemoryCommandCacheReserveIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCommandCache(1000, 4, 60000)
+
+	const workers = 32
+	var reserved atomic.Int32
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seen, err := cache.Reserve(ctx, "command")
+			if err != nil {
+				t.Errorf("Reserve: %v", err)
+			}
+			if !seen {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved.Load() != 1 {
+		t.Fatalf("command is reserved %d times, want 1", reserved.Load())
+	}
+
+	record, err := cache.Get(ctx, "command")
+	if err != nil || record == nil || record.State != ports.CommandStateInProgress {
+		t.Fatalf("Get = %+v, %v, want in-progress record", record, err)
+	}
+	if err = cache.Release(ctx, "command"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if seen, err := cache.Reserve(ctx, "command"); err != nil || seen {
+		t.Fatalf("Reserve after Release = %v, %v, want not seen", seen, err)
+	}
+}
+
+func TestInMemoryCommandCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCommandCache(2, 1, 0)
+	done := &ports.CommandRecord{State: ports.CommandStateDone, Event: []byte("event")}
+
+	for _, commandID := range []string{"a", "b"} {
+		if err := cache.Save(ctx, commandID, done); err != nil {
+			t.Fatalf("Save %s: %v", commandID, err)
+		}
+	}
+	// "a" is used, so "b" is the least recently used one
+	if record, _ := cache.Get(ctx, "a"); record == nil {
+		t.Fatal("a isn't stored")
+	}
+	if err := cache.Save(ctx, "c", done); err != nil {
+		t.Fatalf("Save c: %v", err)
+	}
+	for commandID, stored := range map[string]bool{"a": true, "b": false, "c": true} {
+		if record, _ := cache.Get(ctx, commandID); (record != nil) != stored {
+			t.Errorf("Get(%s) = %+v, stored = %v", commandID, record, stored)
+		}
+	}
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Size != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestInMemoryCommandCacheDoesNotEvictReserved(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCommandCache(2, 1, 0)
+
+	if seen, err := cache.Reserve(ctx, "in-progress"); err != nil || seen {
+		t.Fatalf("Reserve = %v, %v, want not seen", seen, err)
+	}
+	// finished commands overflow the shard many times over
+	for i := range 10 {
+		err := cache.Save(ctx, fmt.Sprintf("done-%d", i), &ports.CommandRecord{State: ports.CommandStateDone})
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	if seen, err := cache.Reserve(ctx, "in-progress"); err != nil || !seen {
+		t.Fatalf("Reserve of in-progress command = %v, %v, want seen: reservation is evicted", seen, err)
+	}
+	if stats := cache.Stats(); stats.Size != 3 || stats.Reserved != 1 || stats.Evictions != 8 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// finished reservation takes its place in LRU
+	if err := cache.Save(ctx, "in-progress", &ports.CommandRecord{State: ports.CommandStateDone}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if stats := cache.Stats(); stats.Size != 2 || stats.Reserved != 0 || stats.Evictions != 9 {
+		t.Fatalf("unexpected stats after Save %+v", stats)
+	}
+}
+
+func TestInMemoryCommandCacheExpiresRecords(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCommandCache(10, 1, 1000)
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	if _, err := cache.Reserve(ctx, "reserved"); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if err := cache.Save(ctx, "done", &ports.CommandRecord{State: ports.CommandStateDone}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	now = now.Add(999 * time.Millisecond)
+	if record, _ := cache.Get(ctx, "done"); record == nil {
+		t.Fatal("record expired before TTL")
+	}
+
+	// abandoned reservations expire too, otherwise the command can't be retried
+	now = now.Add(2 * time.Millisecond)
+	for _, commandID := range []string{"reserved", "done"} {
+		if record, err := cache.Get(ctx, commandID); err != nil || record != nil {
+			t.Fatalf("Get(%s) of expired = %+v, %v, want nil", commandID, record, err)
+		}
+	}
+	if seen, err := cache.Reserve(ctx, "reserved"); err != nil || seen {
+		t.Fatalf("Reserve of expired = %v, %v, want not seen", seen, err)
+	}
+	if stats := cache.Stats(); stats.Expirations != 2 || stats.Size != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestInMemoryCommandCacheShardsHaveOwnCapacity(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCommandCache(8, 4, 0)
+
+	for i := range 100 {
+		err := cache.Save(ctx, fmt.Sprintf("command-%d", i), &ports.CommandRecord{State: ports.CommandStateDone})
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	for _, shard := range cache.shards {
+		if shard.usage.Len() != 2 || len(shard.records) != 2 {
+			t.Errorf("shard stores %d records (%d indexed), want 2", shard.usage.Len(), len(shard.records))
+		}
+	}
+
+	stats := cache.Stats()
+	if stats.Size != 8 || stats.Evictions != 92 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("Save must not count hits or misses, got %+v", stats)
+	}
+	_, _ = cache.Get(ctx, "command-99")
+	_, _ = cache.Get(ctx, "command-0")
+	if stats = cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected hits/misses %+v", stats)
+	}
+}
+
+func TestInMemoryCommandCacheErasesExpiredRecordsOnWrite(t *testing.T) {
+	ctx := context.Background()
+	// no capacity, memory is bounded by TTL only
+	cache := NewInMemoryCommandCache(0, 1, 1000)
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	for i := range 100 {
+		if _, err := cache.Reserve(ctx, fmt.Sprintf("reserved-%d", i)); err != nil {
+			t.Fatalf("Reserve: %v", err)
+		}
+		err := cache.Save(ctx, fmt.Sprintf("done-%d", i), &ports.CommandRecord{State: ports.CommandStateDone})
+		if err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	// expired records are never read again, the next write erases them
+	now = now.Add(1001 * time.Millisecond)
+	if err := cache.Save(ctx, "fresh", &ports.CommandRecord{State: ports.CommandStateDone}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	stats := cache.Stats()
+	if stats.Size != 1 || stats.Reserved != 0 || stats.Expirations != 200 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if shard := cache.shards[0]; shard.expiry.Len() != 1 {
+		t.Fatalf("expiry list has %d entries, want 1", shard.expiry.Len())
+	}
+}
+
+func TestInMemoryCommandCacheLimitsReserved(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCommandCache(2, 1, 0)
+
+	for _, commandID := range []string{"a", "b"} {
+		if seen, err := cache.Reserve(ctx, commandID); err != nil || seen {
+			t.Fatalf("Reserve(%s) = %v, %v, want not seen", commandID, seen, err)
+		}
+	}
+	if _, err := cache.Reserve(ctx, "c"); !errors.Is(err, ErrTooManyReserved) || !errors.Is(err, roomerrors.ErrRateLimited) {
+		t.Fatalf("Reserve over limit: %v, want ErrTooManyReserved", err)
+	}
+	// repeats of reserved commands are still seen
+	if seen, err := cache.Reserve(ctx, "a"); err != nil || !seen {
+		t.Fatalf("Reserve of reserved = %v, %v, want seen", seen, err)
+	}
+
+	if err := cache.Save(ctx, "a", &ports.CommandRecord{State: ports.CommandStateDone}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if seen, err := cache.Reserve(ctx, "c"); err != nil || seen {
+		t.Fatalf("Reserve after finished command = %v, %v, want not seen", seen, err)
+	}
+}
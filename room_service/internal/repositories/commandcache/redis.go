@@ -2,15 +2,17 @@ package commandcache
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/chempik1234/room-service/internal/ports"
 	"github.com/go-redis/redis/v8"
 	"time"
 )
 
 // RedisCommandCache - impl of ports.CommandIdShortCache with Redis
 //
-// Before executing command, check id with Exists - if it's stored, skip the command -
+// Before executing command, Reserve it's id - if it's already stored, don't execute the command -
 // it's already in execution (or finished)
 type RedisCommandCache struct {
 	client *redis.Client
@@ -25,31 +27,69 @@ func NewRedisCommandCache(client *redis.Client, ttlMs int) *RedisCommandCache {
 	}
 }
 
-// Exists - check if commandID is already saved in Redis
+// Reserve - save commandID as in-progress with SET NX PX, if it's not saved yet
+func (s *RedisCommandCache) Reserve(ctx context.Context, commandID string) (bool, error) {
+	raw, err := json.Marshal(&ports.CommandRecord{State: ports.CommandStateInProgress})
+	if err != nil {
+		return false, fmt.Errorf("error encoding command record: %w", err)
+	}
+	reserved, err := s.client.SetNX(ctx, s.generateKey(commandID), raw, s.ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("error reserving command in redis: %w", err)
+	}
+	return !reserved, nil
+}
+
+// Get - get record of commandID saved in Redis
 //
-// if it is, skip the command - it's already in execution (or finished)
-func (s *RedisCommandCache) Exists(ctx context.Context, commandID string) (bool, error) {
+// nil record if it's not saved
+func (s *RedisCommandCache) Get(ctx context.Context, commandID string) (*ports.CommandRecord, error) {
 	key := s.generateKey(commandID)
-	_, err := s.client.Get(ctx, key).Result()
+	raw, err := s.client.Get(ctx, key).Bytes()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return false, nil // Not found
+			return nil, nil // Not found
 		}
-		return false, fmt.Errorf("error querying redis: %w", err) // Other errors
+		return nil, fmt.Errorf("error querying redis: %w", err) // Other errors
 	}
 
-	return true, nil
+	record := &ports.CommandRecord{}
+	if err = json.Unmarshal(raw, record); err != nil {
+		return nil, fmt.Errorf("error decoding command record from redis: %w", err)
+	}
+	return record, nil
 }
 
-// Save - store commandID in Redis
-func (s *RedisCommandCache) Save(ctx context.Context, commandID string) error {
-	_, err := s.client.Set(ctx, s.generateKey(commandID), "", s.ttl).Result()
+// Save - store record of commandID in Redis
+func (s *RedisCommandCache) Save(ctx context.Context, commandID string, record *ports.CommandRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding command record: %w", err)
+	}
+	_, err = s.client.Set(ctx, s.generateKey(commandID), raw, s.ttl).Result()
 	if err != nil {
 		return fmt.Errorf("error saving command in redis: %w", err)
 	}
 	return nil
 }
 
+// Release - delete commandID from Redis
+func (s *RedisCommandCache) Release(ctx context.Context, commandID string) error {
+	_, err := s.client.Del(ctx, s.generateKey(commandID)).Result()
+	if err != nil {
+		return fmt.Errorf("error releasing command in redis: %w", err)
+	}
+	return nil
+}
+
+// Ping - PING Redis
+func (s *RedisCommandCache) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error pinging redis: %w", err)
+	}
+	return nil
+}
+
 func (s *RedisCommandCache) generateKey(id string) string {
 	return fmt.Sprintf("command_%s", id)
 }
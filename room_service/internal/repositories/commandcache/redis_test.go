@@ -0,0 +1,81 @@
+package commandcache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRedisCommandCache(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	cache := NewRedisCommandCache(client, 1000)
+
+	if err := cache.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	// only one of concurrent Reserve calls gets the command
+	var reserved atomic.Int32
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seen, err := cache.Reserve(ctx, "a")
+			if err != nil {
+				t.Errorf("Reserve: %v", err)
+			}
+			if !seen {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved.Load() != 1 {
+		t.Fatalf("command is reserved %d times, want 1", reserved.Load())
+	}
+	record, err := cache.Get(ctx, "a")
+	if err != nil || record == nil || record.State != ports.CommandStateInProgress {
+		t.Fatalf("Get = %+v, %v, want in-progress record", record, err)
+	}
+
+	if err = cache.Save(ctx, "a", &ports.CommandRecord{State: ports.CommandStateDone, Event: []byte("event")}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	record, err = cache.Get(ctx, "a")
+	if err != nil || record == nil || record.State != ports.CommandStateDone || string(record.Event) != "event" {
+		t.Fatalf("Get = %+v, %v, want done record with event", record, err)
+	}
+
+	if _, err = cache.Reserve(ctx, "b"); err != nil {
+		t.Fatalf("Reserve b: %v", err)
+	}
+	if err = cache.Release(ctx, "b"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if record, err = cache.Get(ctx, "b"); err != nil || record != nil {
+		t.Fatalf("Get of released = %+v, %v, want nil", record, err)
+	}
+
+	// records expire after TTL
+	server.FastForward(1001 * time.Millisecond)
+	if record, err = cache.Get(ctx, "a"); err != nil || record != nil {
+		t.Fatalf("Get of expired = %+v, %v, want nil", record, err)
+	}
+	if seen, err := cache.Reserve(ctx, "a"); err != nil || seen {
+		t.Fatalf("Reserve of expired = %v, %v, want not seen", seen, err)
+	}
+
+	server.Close()
+	if err = cache.Ping(ctx); err == nil {
+		t.Fatal("expected error pinging stopped redis")
+	}
+}
@@ -0,0 +1,206 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/go-redis/redis/v8"
+	"testing"
+	"time"
+)
+
+// receive - wait for n events from subscription's channel
+func receive(t *testing.T, events <-chan *ports.RoomEvent, n int) []*ports.RoomEvent {
+	t.Helper()
+	received := make([]*ports.RoomEvent, 0, n)
+	timeout := time.After(2 * time.Second)
+	for len(received) < n {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		case <-timeout:
+			t.Fatalf("expected %d events, got %d", n, len(received))
+		}
+	}
+	return received
+}
+
+func expectNoEvents(t *testing.T, events <-chan *ports.RoomEvent) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func subscribe(t *testing.T, bus ports.EventBusPort, roomID string) (<-chan *ports.RoomEvent, func()) {
+	t.Helper()
+	events := make(chan *ports.RoomEvent, 100)
+	unsubscribe, err := bus.Subscribe(context.Background(), roomID, func(event *ports.RoomEvent) { events <- event })
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return events, unsubscribe
+}
+
+// publish - publish events with payloads from, from+1, ..., from+n-1
+func publish(t *testing.T, bus ports.EventBusPort, roomID string, from int, n int) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		event := &ports.RoomEvent{RoomID: roomID, Origin: "test", Payload: []byte(fmt.Sprint(i))}
+		if err := bus.Publish(context.Background(), event); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+func expectInOrder(t *testing.T, events []*ports.RoomEvent) {
+	t.Helper()
+	for i, event := range events {
+		if string(event.Payload) != fmt.Sprint(i) {
+			t.Fatalf("event %d has payload %q, events are out of order", i, event.Payload)
+		}
+	}
+}
+
+func TestInProcessEventBus(t *testing.T) {
+	bus := NewInProcessEventBus(0)
+	first, unsubscribeFirst := subscribe(t, bus, "room")
+	second, unsubscribeSecond := subscribe(t, bus, "room")
+	defer unsubscribeSecond()
+	other, unsubscribeOther := subscribe(t, bus, "other room")
+	defer unsubscribeOther()
+
+	publish(t, bus, "room", 0, 50)
+	expectInOrder(t, receive(t, first, 50))
+	expectInOrder(t, receive(t, second, 50))
+	expectNoEvents(t, other)
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	publish(t, bus, "room", 50, 1)
+	receive(t, second, 1)
+	expectNoEvents(t, first)
+
+	if stats := bus.Stats(); stats.Subscriptions != 2 || stats.Published != 51 || stats.Delivered != 101 || stats.Dropped != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestEventBusDropsEventsOfSlowSubscriber(t *testing.T) {
+	bus := NewInProcessEventBus(2)
+	release := make(chan struct{})
+	handled := make(chan *ports.RoomEvent, 10)
+	unsubscribe, _ := bus.Subscribe(context.Background(), "room", func(event *ports.RoomEvent) {
+		<-release
+		handled <- event
+	})
+	defer unsubscribe()
+
+	// the first event is taken by handler, 2 are queued, the rest is dropped
+	publish(t, bus, "room", 0, 1)
+	time.Sleep(20 * time.Millisecond)
+	publish(t, bus, "room", 1, 4)
+	close(release)
+
+	// queued events are handled, then subscriber must resync
+	received := receive(t, handled, 4)
+	expectInOrder(t, received[:3])
+	if !received[3].Resync || received[3].RoomID != "room" {
+		t.Fatalf("expected resync after dropped events, got %+v", received[3])
+	}
+	publish(t, bus, "room", 5, 1)
+	if event := receive(t, handled, 1)[0]; string(event.Payload) != "5" {
+		t.Fatalf("expected event after resync, got %+v", event)
+	}
+	if stats := bus.Stats(); stats.Delivered != 4 || stats.Dropped != 2 || stats.Resyncs != 1 {
+		t.Fatalf("expected 2 dropped events and 1 resync, got %+v", stats)
+	}
+}
+
+func TestRedisEventBusDeliversToEveryInstance(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newBus := func() *RedisEventBus {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		bus := NewRedisEventBus(client, RedisEventBusParams{})
+		go bus.Run(ctx)
+		return bus
+	}
+	publisher, subscriber := newBus(), newBus()
+
+	first, unsubscribeFirst := subscribe(t, subscriber, "room")
+	second, unsubscribeSecond := subscribe(t, subscriber, "room")
+	own, unsubscribeOwn := subscribe(t, publisher, "room")
+	defer unsubscribeOwn()
+
+	// Subscribe returns once subscription is confirmed
+	if subscribers := server.PubSubNumSub("room_events:room")["room_events:room"]; subscribers != 2 {
+		t.Fatalf("expected 2 subscribed instances, got %d", subscribers)
+	}
+
+	publish(t, publisher, "room", 0, 50)
+	for _, events := range []<-chan *ports.RoomEvent{first, second, own} {
+		received := receive(t, events, 50)
+		expectInOrder(t, received)
+		if received[0].RoomID != "room" || received[0].Origin != "test" {
+			t.Fatalf("unexpected event %+v", received[0])
+		}
+	}
+
+	// channel is kept until the last subscriber of instance leaves
+	unsubscribeFirst()
+	if subscribers := server.PubSubNumSub("room_events:room")["room_events:room"]; subscribers != 2 {
+		t.Fatalf("expected 2 subscribed instances, got %d", subscribers)
+	}
+	unsubscribeSecond()
+	deadline := time.Now().Add(2 * time.Second)
+	for server.PubSubNumSub("room_events:room")["room_events:room"] != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("channel of room isn't unsubscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if stats := subscriber.Stats(); stats.Subscriptions != 0 || stats.Delivered != 100 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if err := subscriber.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestRedisEventBusResyncsAfterReconnect(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer func() { _ = client.Close() }()
+	bus := NewRedisEventBus(client, RedisEventBusParams{})
+	go bus.Run(ctx)
+
+	events, unsubscribe := subscribe(t, bus, "room")
+	defer unsubscribe()
+	other, unsubscribeOther := subscribe(t, bus, "other room")
+	defer unsubscribeOther()
+
+	// events published while connection is broken are lost
+	server.Close()
+	if err := server.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	for _, events := range []<-chan *ports.RoomEvent{events, other} {
+		if event := receive(t, events, 1)[0]; !event.Resync {
+			t.Fatalf("expected resync after reconnect, got %+v", event)
+		}
+	}
+
+	publish(t, bus, "room", 0, 3)
+	expectInOrder(t, receive(t, events, 3))
+	expectNoEvents(t, other)
+}
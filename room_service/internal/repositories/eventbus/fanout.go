@@ -0,0 +1,175 @@
+package eventbus
+
+import (
+	"github.com/chempik1234/room-service/internal/ports"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSubscriberBufferSize - used when buffer size of subscriber isn't set
+const defaultSubscriberBufferSize = 1024
+
+// Stats - counters of event bus, see InProcessEventBus.Stats and RedisEventBus.Stats
+type Stats struct {
+	// Subscriptions - subscriptions of this instance right now
+	Subscriptions int
+	// Published - events published by this instance
+	Published uint64
+	// Delivered - events queued for subscribers of this instance
+	Delivered uint64
+	// Dropped - events not delivered because subscriber's queue was full
+	Dropped uint64
+	// Resyncs - events with Resync set queued for subscribers that might have lost events
+	Resyncs uint64
+}
+
+// fanout - subscribers of this instance, events are queued for subscribers of their room
+//
+// every subscriber has a queue and a goroutine that calls its handler,
+// so a slow subscriber doesn't stop others: when its queue is full, events are dropped until the queue is empty,
+// then it gets an event with Resync set
+type fanout struct {
+	bufferSize int
+
+	// mu - held while event is queued, so subscribers of one room get events in the same order
+	mu    sync.Mutex
+	rooms map[string]map[*subscriber]struct{}
+	count int
+
+	published atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	resyncs   atomic.Uint64
+}
+
+type subscriber struct {
+	roomID string
+	queue  chan *ports.RoomEvent
+	// lost - an event wasn't queued, the following are dropped until Resync is queued; mu of fanout must be held
+	lost bool
+}
+
+func newFanout(bufferSize int) *fanout {
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriberBufferSize
+	}
+	return &fanout{bufferSize: bufferSize, rooms: make(map[string]map[*subscriber]struct{})}
+}
+
+// add - start subscriber of room
+func (f *fanout) add(roomID string, handle func(event *ports.RoomEvent)) *subscriber {
+	sub := &subscriber{roomID: roomID, queue: make(chan *ports.RoomEvent, f.bufferSize)}
+	go func() {
+		for event := range sub.queue {
+			handle(event)
+			f.recover(sub)
+		}
+	}()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscribers, ok := f.rooms[roomID]
+	if !ok {
+		subscribers = make(map[*subscriber]struct{})
+		f.rooms[roomID] = subscribers
+	}
+	subscribers[sub] = struct{}{}
+	f.count++
+	return sub
+}
+
+// remove - stop subscriber, queued events are still handled; last = room has no subscribers now
+//
+// false if subscriber is already removed
+func (f *fanout) remove(sub *subscriber) (removed bool, last bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscribers := f.rooms[sub.roomID]
+	if _, ok := subscribers[sub]; !ok {
+		return false, false
+	}
+	delete(subscribers, sub)
+	f.count--
+	close(sub.queue)
+	if len(subscribers) == 0 {
+		delete(f.rooms, sub.roomID)
+		return true, true
+	}
+	return true, false
+}
+
+// deliver - queue event for every subscriber of its room, subscribers that lost events get nothing until resync
+func (f *fanout) deliver(event *ports.RoomEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.rooms[event.RoomID] {
+		if !sub.lost && f.push(sub, event) {
+			f.delivered.Add(1)
+		} else {
+			f.dropped.Add(1)
+		}
+	}
+}
+
+// resync - queue Resync for every subscriber of room, e.g. events of room might be lost while connection was restored
+func (f *fanout) resync(roomID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.rooms[roomID] {
+		// subscriber that lost events gets Resync when its queue is empty anyway
+		if !sub.lost && f.push(sub, &ports.RoomEvent{RoomID: roomID, Resync: true}) {
+			f.resyncs.Add(1)
+		}
+	}
+}
+
+// recover - queue Resync for subscriber that lost events, once it handled every queued event
+//
+// called by goroutine of subscriber, so its queue doesn't grow meanwhile
+func (f *fanout) recover(sub *subscriber) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !sub.lost || len(sub.queue) > 0 {
+		return
+	}
+	if _, ok := f.rooms[sub.roomID][sub]; !ok {
+		// removed, queue is closed
+		return
+	}
+	sub.lost = false
+	sub.queue <- &ports.RoomEvent{RoomID: sub.roomID, Resync: true}
+	f.resyncs.Add(1)
+}
+
+// push - queue event for subscriber, queue is full -> subscriber lost the event; mu must be held
+func (f *fanout) push(sub *subscriber, event *ports.RoomEvent) bool {
+	select {
+	case sub.queue <- event:
+		return true
+	default:
+		sub.lost = true
+		return false
+	}
+}
+
+// unsubscribeFunc - remove sub once, onLast is called if room has no subscribers after that
+func (f *fanout) unsubscribeFunc(sub *subscriber, onLast func()) func() {
+	return func() {
+		if removed, last := f.remove(sub); removed && last && onLast != nil {
+			onLast()
+		}
+	}
+}
+
+func (f *fanout) stats() Stats {
+	f.mu.Lock()
+	count := f.count
+	f.mu.Unlock()
+	return Stats{
+		Subscriptions: count,
+		Published:     f.published.Load(),
+		Delivered:     f.delivered.Load(),
+		Dropped:       f.dropped.Load(),
+		Resyncs:       f.resyncs.Load(),
+	}
+}
@@ -0,0 +1,41 @@
+package eventbus
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/ports"
+)
+
+// InProcessEventBus - ports.EventBusPort impl that delivers events to subscribers of this instance only
+//
+// for a single instance and tests
+type InProcessEventBus struct {
+	fanout *fanout
+}
+
+// NewInProcessEventBus - return new InProcessEventBus, every subscriber queues up to bufferSize events (default 1024)
+func NewInProcessEventBus(bufferSize int) *InProcessEventBus {
+	return &InProcessEventBus{fanout: newFanout(bufferSize)}
+}
+
+// Publish - queue event for subscribers of its room
+func (b *InProcessEventBus) Publish(_ context.Context, event *ports.RoomEvent) error {
+	b.fanout.published.Add(1)
+	b.fanout.deliver(event)
+	return nil
+}
+
+// Subscribe - call handle for every event of room published after Subscribe returns
+func (b *InProcessEventBus) Subscribe(_ context.Context, roomID string, handle func(event *ports.RoomEvent)) (func(), error) {
+	sub := b.fanout.add(roomID, handle)
+	return b.fanout.unsubscribeFunc(sub, nil), nil
+}
+
+// Ping - always OK
+func (b *InProcessEventBus) Ping(_ context.Context) error {
+	return nil
+}
+
+// Stats - return current counters
+func (b *InProcessEventBus) Stats() Stats {
+	return b.fanout.stats()
+}
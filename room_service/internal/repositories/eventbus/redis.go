@@ -0,0 +1,222 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisEventBus - ports.EventBusPort impl with Redis pub/sub, one channel per room ("<prefix>:<room_id>")
+//
+// Instance subscribes to channel of room while it has subscribers of that room, all channels share one connection,
+// messages are read by Run. Redis sends messages of one channel in the order they're published.
+//
+// Pub/sub delivers at most once: events published while connection is being restored are missed,
+// so every subscriber of the restored channels gets an event with Resync set
+type RedisEventBus struct {
+	client *redis.Client
+	prefix string
+	fanout *fanout
+
+	pubsub *redis.PubSub
+	// channelsMu - held while channels of pubsub are changed, so subscribe and unsubscribe of one room don't reorder
+	channelsMu sync.Mutex
+	// channels - subscribed rooms, chan is closed when Redis confirms subscription
+	channels map[string]chan struct{}
+}
+
+// RedisEventBusParams - params of RedisEventBus
+type RedisEventBusParams struct {
+	// ChannelPrefix - default "room_events"
+	ChannelPrefix string
+	// SubscriberBufferSize - events queued for one subscriber, default 1024
+	SubscriberBufferSize int
+}
+
+// redisEventMessage - RoomEvent in channel of its room
+type redisEventMessage struct {
+	Origin  string `json:"o,omitempty"`
+	Payload []byte `json:"p"`
+}
+
+// NewRedisEventBus - return new RedisEventBus, call Run to receive events
+func NewRedisEventBus(client *redis.Client, params RedisEventBusParams) *RedisEventBus {
+	if len(params.ChannelPrefix) == 0 {
+		params.ChannelPrefix = "room_events"
+	}
+	return &RedisEventBus{
+		client:   client,
+		prefix:   params.ChannelPrefix + ":",
+		fanout:   newFanout(params.SubscriberBufferSize),
+		pubsub:   client.Subscribe(context.Background()),
+		channels: make(map[string]chan struct{}),
+	}
+}
+
+const (
+	// redisPingInterval - connection is pinged if nothing is received for this long, so a broken one is restored
+	redisPingInterval = 5 * time.Second
+	// redisReceiveRetryDelay - pause after receive failed, connection is restored by the next one
+	redisReceiveRetryDelay = 100 * time.Millisecond
+)
+
+// Run - deliver events of subscribed rooms to subscribers until ctx is done, connection is closed after that
+//
+// must be running while Subscribe is called: it waits for confirmation received here
+func (b *RedisEventBus) Run(ctx context.Context) {
+	defer func() { _ = b.pubsub.Close() }()
+	// closed pubsub unblocks receive
+	stop := context.AfterFunc(ctx, func() { _ = b.pubsub.Close() })
+	defer stop()
+
+	for {
+		received, err := b.pubsub.ReceiveTimeout(ctx, redisPingInterval)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, redis.ErrClosed) {
+				return
+			}
+			if netErr := net.Error(nil); errors.As(err, &netErr) && netErr.Timeout() {
+				// broken connection is restored by ping, pong is received as any message
+				_ = b.pubsub.Ping(ctx)
+				continue
+			}
+			logging.FromContext(ctx).Warn(ctx, "failed to receive room events, reconnecting", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(redisReceiveRetryDelay):
+			}
+			continue
+		}
+
+		switch received := received.(type) {
+		case *redis.Subscription:
+			if received.Kind == "subscribe" {
+				b.subscribed(strings.TrimPrefix(received.Channel, b.prefix))
+			}
+		case *redis.Message:
+			var decoded redisEventMessage
+			if err = json.Unmarshal([]byte(received.Payload), &decoded); err != nil {
+				logging.FromContext(ctx).Warn(ctx, "failed to decode room event", zap.String("channel", received.Channel), zap.Error(err))
+				continue
+			}
+			b.fanout.deliver(&ports.RoomEvent{
+				RoomID:  strings.TrimPrefix(received.Channel, b.prefix),
+				Origin:  decoded.Origin,
+				Payload: decoded.Payload,
+			})
+		}
+	}
+}
+
+// Publish - publish event into channel of its room
+func (b *RedisEventBus) Publish(ctx context.Context, event *ports.RoomEvent) error {
+	message, err := json.Marshal(redisEventMessage{Origin: event.Origin, Payload: event.Payload})
+	if err != nil {
+		return fmt.Errorf("error encoding room event: %w", err)
+	}
+	if err = b.client.Publish(ctx, b.prefix+event.RoomID, message).Err(); err != nil {
+		return fmt.Errorf("error publishing room event: %w", err)
+	}
+	b.fanout.published.Add(1)
+	return nil
+}
+
+// Subscribe - call handle for every event of room received by Run, channel of room is subscribed if it isn't yet
+//
+// returns once Redis confirms subscription, so every event published after that is received
+func (b *RedisEventBus) Subscribe(ctx context.Context, roomID string, handle func(event *ports.RoomEvent)) (func(), error) {
+	sub := b.fanout.add(roomID, handle)
+	unsubscribe := b.fanout.unsubscribeFunc(sub, func() {
+		ctx := context.WithoutCancel(ctx)
+		if _, err := b.syncChannel(ctx, roomID); err != nil {
+			logging.FromContext(ctx).Warn(ctx, "failed to unsubscribe from room events", zap.String("room_id", roomID), zap.Error(err))
+		}
+	})
+	confirmed, err := b.syncChannel(ctx, roomID)
+	if err != nil {
+		unsubscribe()
+		return nil, err
+	}
+	select {
+	case <-confirmed:
+		return unsubscribe, nil
+	case <-ctx.Done():
+		unsubscribe()
+		return nil, fmt.Errorf("error waiting for subscription to room events: %w", ctx.Err())
+	}
+}
+
+// Ping - ping redis
+func (b *RedisEventBus) Ping(ctx context.Context) error {
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error pinging redis: %w", err)
+	}
+	return nil
+}
+
+// Stats - return current counters
+func (b *RedisEventBus) Stats() Stats {
+	return b.fanout.stats()
+}
+
+// syncChannel - subscribe to channel of room if it has subscribers, unsubscribe otherwise
+//
+// returns chan that is closed when subscription is confirmed, nil if room isn't subscribed
+func (b *RedisEventBus) syncChannel(ctx context.Context, roomID string) (<-chan struct{}, error) {
+	b.channelsMu.Lock()
+	defer b.channelsMu.Unlock()
+
+	b.fanout.mu.Lock()
+	wanted := len(b.fanout.rooms[roomID]) > 0
+	b.fanout.mu.Unlock()
+	confirmed, subscribed := b.channels[roomID]
+
+	switch {
+	case wanted && !subscribed:
+		if err := b.pubsub.Subscribe(ctx, b.prefix+roomID); err != nil {
+			return nil, fmt.Errorf("error subscribing to room events: %w", err)
+		}
+		confirmed = make(chan struct{})
+		b.channels[roomID] = confirmed
+	case !wanted && subscribed:
+		if err := b.pubsub.Unsubscribe(ctx, b.prefix+roomID); err != nil {
+			return nil, fmt.Errorf("error unsubscribing from room events: %w", err)
+		}
+		delete(b.channels, roomID)
+		return nil, nil
+	}
+	return confirmed, nil
+}
+
+// subscribed - Redis confirmed subscription to channel of room
+//
+// the first confirmation unblocks Subscribe, the next ones come after connection is restored:
+// events published meanwhile are lost, so subscribers of room must resync
+func (b *RedisEventBus) subscribed(roomID string) {
+	b.channelsMu.Lock()
+	confirmed, ok := b.channels[roomID]
+	restored := false
+	if ok {
+		select {
+		case <-confirmed:
+			restored = true
+		default:
+			close(confirmed)
+		}
+	}
+	b.channelsMu.Unlock()
+
+	if restored {
+		b.fanout.resync(roomID)
+	}
+}
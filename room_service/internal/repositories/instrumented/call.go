@@ -0,0 +1,25 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/metrics"
+	"github.com/chempik1234/room-service/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// startCall - start span of one port method call, returned done records its latency into m and ends the span
+func startCall(ctx context.Context, m *metrics.Metrics, port string, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, port+"."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("port.name", port),
+			attribute.String("port.method", method),
+		))
+	return ctx, func(err error) {
+		m.ObservePortCall(port, method, err, time.Since(start))
+		tracing.End(span, err)
+	}
+}
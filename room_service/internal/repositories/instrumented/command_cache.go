@@ -0,0 +1,55 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/metrics"
+	"github.com/chempik1234/room-service/internal/ports"
+)
+
+// CommandCache - ports.CommandIDShortCache decorator that records latency of every call and traces it
+type CommandCache struct {
+	next    ports.CommandIDShortCache
+	metrics *metrics.Metrics
+}
+
+// NewCommandCache - wrap next, calls are recorded into m and traced with tracing.Tracer
+func NewCommandCache(next ports.CommandIDShortCache, m *metrics.Metrics) *CommandCache {
+	return &CommandCache{next: next, metrics: m}
+}
+
+// Reserve - ports.CommandIDShortCache.Reserve with metrics and tracing
+func (s *CommandCache) Reserve(ctx context.Context, commandID string) (bool, error) {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortCommandCache, "reserve")
+	alreadySeen, err := s.next.Reserve(ctx, commandID)
+	done(err)
+	return alreadySeen, err
+}
+
+// Get - ports.CommandIDShortCache.Get with metrics and tracing
+func (s *CommandCache) Get(ctx context.Context, commandID string) (*ports.CommandRecord, error) {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortCommandCache, "get")
+	record, err := s.next.Get(ctx, commandID)
+	done(err)
+	return record, err
+}
+
+// Save - ports.CommandIDShortCache.Save with metrics and tracing
+func (s *CommandCache) Save(ctx context.Context, commandID string, record *ports.CommandRecord) error {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortCommandCache, "save")
+	err := s.next.Save(ctx, commandID, record)
+	done(err)
+	return err
+}
+
+// Release - ports.CommandIDShortCache.Release with metrics and tracing
+func (s *CommandCache) Release(ctx context.Context, commandID string) error {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortCommandCache, "release")
+	err := s.next.Release(ctx, commandID)
+	done(err)
+	return err
+}
+
+// Ping - ports.CommandIDShortCache.Ping, not recorded, so periodic health probes don't flood metrics and traces
+func (s *CommandCache) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
@@ -0,0 +1,96 @@
+package instrumented
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/metrics"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+)
+
+// RoomsRepository - ports.RoomsPort decorator that records latency of every call and traces it
+type RoomsRepository struct {
+	next    ports.RoomsPort
+	metrics *metrics.Metrics
+}
+
+// NewRoomsRepository - wrap next, calls are recorded into m and traced with tracing.Tracer
+func NewRoomsRepository(next ports.RoomsPort, m *metrics.Metrics) *RoomsRepository {
+	return &RoomsRepository{next: next, metrics: m}
+}
+
+// CreateRoom - ports.RoomsPort.CreateRoom with metrics and tracing
+func (s *RoomsRepository) CreateRoom(ctx context.Context, params *models.Room) (*models.Room, error) {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "create_room")
+	room, err := s.next.CreateRoom(ctx, params)
+	done(err)
+	return room, err
+}
+
+// DeleteRoom - ports.RoomsPort.DeleteRoom with metrics and tracing
+func (s *RoomsRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) error {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "delete_room")
+	err := s.next.DeleteRoom(ctx, params)
+	done(err)
+	return err
+}
+
+// JoinRoom - ports.RoomsPort.JoinRoom with metrics and tracing
+func (s *RoomsRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "join_room")
+	err := s.next.JoinRoom(ctx, params)
+	done(err)
+	return err
+}
+
+// CountOwnedRooms - ports.RoomsPort.CountOwnedRooms with metrics and tracing
+func (s *RoomsRepository) CountOwnedRooms(ctx context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "count_owned_rooms")
+	count, err := s.next.CountOwnedRooms(ctx, params)
+	done(err)
+	return count, err
+}
+
+// IsRoomOwner - ports.RoomsPort.IsRoomOwner with metrics and tracing
+func (s *RoomsRepository) IsRoomOwner(ctx context.Context, params ports.IsRoomOwnerParams) (bool, error) {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "is_room_owner")
+	isOwner, err := s.next.IsRoomOwner(ctx, params)
+	done(err)
+	return isOwner, err
+}
+
+// LeaveRoom - ports.RoomsPort.LeaveRoom with metrics and tracing
+func (s *RoomsRepository) LeaveRoom(ctx context.Context, params ports.LeaveRoomParams) error {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "leave_room")
+	err := s.next.LeaveRoom(ctx, params)
+	done(err)
+	return err
+}
+
+// RoomSnapshot - ports.RoomsPort.RoomSnapshot with metrics and tracing
+func (s *RoomsRepository) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "room_snapshot")
+	snapshot, err := s.next.RoomSnapshot(ctx, params)
+	done(err)
+	return snapshot, err
+}
+
+// Ping - ports.RoomsPort.Ping, not recorded, so periodic health probes don't flood metrics and traces
+func (s *RoomsRepository) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// AffectData - ports.RoomsPort.AffectData with metrics and tracing
+func (s *RoomsRepository) AffectData(ctx context.Context, params ports.AffectDataParams) error {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "affect_data")
+	err := s.next.AffectData(ctx, params)
+	done(err)
+	return err
+}
+
+// ReplaceRoom - ports.RoomsPort.ReplaceRoom with metrics and tracing
+func (s *RoomsRepository) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	ctx, done := startCall(ctx, s.metrics, metrics.PortRooms, "replace_room")
+	err := s.next.ReplaceRoom(ctx, params)
+	done(err)
+	return err
+}
@@ -0,0 +1,325 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// file names in room's directory of FileJournal
+const (
+	fileEntriesName    = "journal.jsonl"
+	fileSnapshotPrefix = "snapshot-"
+	fileSnapshotSuffix = ".json"
+)
+
+// FileJournal - ports.JournalPort impl with files, for single-node deployments and local development
+//
+// Every room has a directory: "journal.jsonl" (one JSON entry per line) and "snapshot-<seq>.json" files.
+// Appends are synced to disk before Append returns. Line that is cut by a crash is erased on the next Append
+// and ignored by Entries. Snapshots and compacted journal are written into temp file and renamed, so they're atomic
+type FileJournal struct {
+	dir string
+
+	mu    sync.Mutex
+	rooms map[models.RoomID]*fileRoom
+}
+
+// fileRoom - lastSeq is read from files on first Append, mu is held by every method of room
+type fileRoom struct {
+	mu      sync.Mutex
+	loaded  bool
+	lastSeq uint64
+}
+
+// NewFileJournal - return new FileJournal that stores rooms in dir, dir is created if it doesn't exist
+func NewFileJournal(dir string) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating journal dir: %w", err)
+	}
+	return &FileJournal{dir: dir, rooms: make(map[models.RoomID]*fileRoom)}, nil
+}
+
+// Append - write entry into room's journal file and sync it
+func (j *FileJournal) Append(_ context.Context, entry *ports.JournalEntry) (uint64, error) {
+	room := j.lockRoom(entry.RoomID)
+	defer room.mu.Unlock()
+
+	dir := j.roomDir(entry.RoomID)
+	if !room.loaded {
+		lastSeq, err := j.recover(dir)
+		if err != nil {
+			return 0, err
+		}
+		room.lastSeq, room.loaded = lastSeq, true
+	}
+
+	appended := *entry
+	appended.Seq = room.lastSeq + 1
+	line, err := json.Marshal(&appended)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding journal entry: %w", err)
+	}
+	if err = os.MkdirAll(dir, 0o750); err != nil {
+		return 0, fmt.Errorf("error creating room journal dir: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, fileEntriesName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("error opening journal file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		// the line might be written partly, it's erased on the next load
+		room.loaded = false
+		return 0, fmt.Errorf("error writing journal entry: %w", err)
+	}
+	if err = file.Sync(); err != nil {
+		room.loaded = false
+		return 0, fmt.Errorf("error syncing journal file: %w", err)
+	}
+	room.lastSeq = appended.Seq
+	return appended.Seq, nil
+}
+
+// Entries - read entries of room with Seq > afterSeq from its journal file
+func (j *FileJournal) Entries(_ context.Context, roomID models.RoomID, afterSeq uint64) ([]ports.JournalEntry, error) {
+	room := j.lockRoom(roomID)
+	defer room.mu.Unlock()
+
+	entries := make([]ports.JournalEntry, 0)
+	_, err := readEntries(filepath.Join(j.roomDir(roomID), fileEntriesName), func(entry ports.JournalEntry, _ []byte) {
+		if entry.Seq > afterSeq {
+			entry.RoomID = roomID
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// SaveSnapshot - write snapshot into "snapshot-<seq>.json" of room
+func (j *FileJournal) SaveSnapshot(_ context.Context, snapshot *ports.JournalSnapshot) error {
+	room := j.lockRoom(snapshot.RoomID)
+	defer room.mu.Unlock()
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error encoding journal snapshot: %w", err)
+	}
+	dir := j.roomDir(snapshot.RoomID)
+	if err = os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("error creating room journal dir: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(dir, snapshotFileName(snapshot.Seq)), content)
+}
+
+// LastSnapshot - read snapshot file of room with the greatest seq, nil if there's none
+func (j *FileJournal) LastSnapshot(_ context.Context, roomID models.RoomID) (*ports.JournalSnapshot, error) {
+	room := j.lockRoom(roomID)
+	defer room.mu.Unlock()
+
+	dir := j.roomDir(roomID)
+	seqs, err := snapshotSeqs(dir)
+	if err != nil || len(seqs) == 0 {
+		return nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, snapshotFileName(seqs[len(seqs)-1])))
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal snapshot: %w", err)
+	}
+	snapshot := &ports.JournalSnapshot{}
+	if err = json.Unmarshal(content, snapshot); err != nil {
+		return nil, fmt.Errorf("error decoding journal snapshot: %w", err)
+	}
+	snapshot.RoomID = roomID
+	return snapshot, nil
+}
+
+// Compact - rewrite journal file of room without entries with Seq <= seq (lines are kept as is),
+// erase snapshot files with Seq < seq
+func (j *FileJournal) Compact(_ context.Context, roomID models.RoomID, seq uint64) error {
+	room := j.lockRoom(roomID)
+	defer room.mu.Unlock()
+
+	dir := j.roomDir(roomID)
+	var kept bytes.Buffer
+	_, err := readEntries(filepath.Join(dir, fileEntriesName), func(entry ports.JournalEntry, line []byte) {
+		if entry.Seq > seq {
+			kept.Write(line)
+		}
+	})
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err = writeFileAtomic(filepath.Join(dir, fileEntriesName), kept.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	seqs, err := snapshotSeqs(dir)
+	if err != nil {
+		return err
+	}
+	for _, snapshotSeq := range seqs {
+		if snapshotSeq >= seq {
+			break
+		}
+		if err = os.Remove(filepath.Join(dir, snapshotFileName(snapshotSeq))); err != nil {
+			return fmt.Errorf("error removing journal snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// Ping - check that journal dir is accessible
+func (j *FileJournal) Ping(_ context.Context) error {
+	if _, err := os.Stat(j.dir); err != nil {
+		return fmt.Errorf("error accessing journal dir: %w", err)
+	}
+	return nil
+}
+
+// lockRoom - return locked fileRoom of room
+func (j *FileJournal) lockRoom(roomID models.RoomID) *fileRoom {
+	j.mu.Lock()
+	room, ok := j.rooms[roomID]
+	if !ok {
+		room = &fileRoom{}
+		j.rooms[roomID] = room
+	}
+	j.mu.Unlock()
+	room.mu.Lock()
+	return room
+}
+
+func (j *FileJournal) roomDir(roomID models.RoomID) string {
+	return filepath.Join(j.dir, roomID.String())
+}
+
+// recover - the greatest seq of room's entries and snapshots, line cut by a crash is erased from journal file
+func (j *FileJournal) recover(dir string) (uint64, error) {
+	var lastSeq uint64
+	path := filepath.Join(dir, fileEntriesName)
+	validSize, err := readEntries(path, func(entry ports.JournalEntry, _ []byte) {
+		lastSeq = entry.Seq
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	if err == nil {
+		if info, errStat := os.Stat(path); errStat == nil && info.Size() > validSize {
+			if err = os.Truncate(path, validSize); err != nil {
+				return 0, fmt.Errorf("error erasing cut journal entry: %w", err)
+			}
+		}
+	}
+
+	// journal might be compacted up to the last snapshot
+	seqs, err := snapshotSeqs(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(seqs) > 0 {
+		lastSeq = max(lastSeq, seqs[len(seqs)-1])
+	}
+	return lastSeq, nil
+}
+
+// readEntries - call read for every entry of journal file with its line, size of complete lines is returned
+//
+// the last line without '\n' is cut by a crash, it's skipped
+func readEntries(path string, read func(entry ports.JournalEntry, line []byte)) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error opening journal file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading journal file: %w", err)
+		}
+		var entry ports.JournalEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return 0, fmt.Errorf("error decoding journal entry at byte %d: %w", size, err)
+		}
+		read(entry, line)
+		size += int64(len(line))
+	}
+}
+
+// snapshotSeqs - sorted seqs of snapshot files in dir
+func snapshotSeqs(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing room journal dir: %w", err)
+	}
+	seqs := make([]uint64, 0)
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, fileSnapshotPrefix) || !strings.HasSuffix(name, fileSnapshotSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, fileSnapshotPrefix), fileSnapshotSuffix), 10, 64)
+		if err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	slices.Sort(seqs)
+	return seqs, nil
+}
+
+func snapshotFileName(seq uint64) string {
+	return fmt.Sprintf("%s%020d%s", fileSnapshotPrefix, seq, fileSnapshotSuffix)
+}
+
+// writeFileAtomic - write content into temp file, sync it and rename it to path
+func writeFileAtomic(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp journal file: %w", err)
+	}
+	defer func() { _ = os.Remove(temp.Name()) }()
+
+	if _, err = temp.Write(content); err == nil {
+		err = temp.Sync()
+	}
+	if errClose := temp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("error writing temp journal file: %w", err)
+	}
+	if err = os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("error renaming temp journal file: %w", err)
+	}
+	return nil
+}
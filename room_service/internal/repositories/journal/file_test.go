@@ -0,0 +1,157 @@
+package journal
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func appendEntries(t *testing.T, journal ports.JournalPort, roomID models.RoomID, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		_, err := journal.Append(context.Background(), &ports.JournalEntry{
+			RoomID: roomID, Op: ports.JournalOpAffectData, DataID: "score", Action: ports.ActionSet, Value: models.IntValue(int64(i)),
+		})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+func valueOf(values map[string]models.Value, key string) *models.Value {
+	value, ok := values[key]
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func entrySeqs(t *testing.T, journal ports.JournalPort, roomID models.RoomID, afterSeq uint64) []uint64 {
+	t.Helper()
+	entries, err := journal.Entries(context.Background(), roomID, afterSeq)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	seqs := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		if entry.RoomID != roomID {
+			t.Errorf("entry %d has room %v, want %v", entry.Seq, entry.RoomID, roomID)
+		}
+		seqs = append(seqs, entry.Seq)
+	}
+	return seqs
+}
+
+func TestFileJournalAppend(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	roomID, otherRoomID := models.NewRoom("owner", nil).ID, models.NewRoom("owner", nil).ID
+
+	journal, err := NewFileJournal(dir)
+	if err != nil {
+		t.Fatalf("NewFileJournal: %v", err)
+	}
+	appendEntries(t, journal, roomID, 3)
+	appendEntries(t, journal, otherRoomID, 1)
+
+	// seq continues after restart
+	journal, err = NewFileJournal(dir)
+	if err != nil {
+		t.Fatalf("NewFileJournal: %v", err)
+	}
+	seq, err := journal.Append(ctx, &ports.JournalEntry{RoomID: roomID, Op: ports.JournalOpJoinRoom, User: &ports.JournalUser{ID: "guest", Name: "Guest"}})
+	if err != nil || seq != 4 {
+		t.Fatalf("Append = %d, %v, want 4", seq, err)
+	}
+
+	if seqs := entrySeqs(t, journal, roomID, 1); len(seqs) != 3 || seqs[0] != 2 || seqs[2] != 4 {
+		t.Errorf("entries after 1 = %v, want [2 3 4]", seqs)
+	}
+	if seqs := entrySeqs(t, journal, otherRoomID, 0); len(seqs) != 1 {
+		t.Errorf("entries of other room = %v, want [1]", seqs)
+	}
+	entries, _ := journal.Entries(ctx, roomID, 2)
+	if !entries[0].Value.Equal(models.IntValue(2)) || entries[1].User == nil || entries[1].User.ID != "guest" {
+		t.Errorf("entries = %+v, want value 2 and user guest", entries)
+	}
+}
+
+func TestFileJournalErasesCutEntry(t *testing.T) {
+	dir := t.TempDir()
+	roomID := models.NewRoom("owner", nil).ID
+	journal, err := NewFileJournal(dir)
+	if err != nil {
+		t.Fatalf("NewFileJournal: %v", err)
+	}
+	appendEntries(t, journal, roomID, 2)
+
+	// crash in the middle of write
+	file, err := os.OpenFile(filepath.Join(dir, roomID.String(), fileEntriesName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString(`{"seq":3,"op":"affe`)
+	_ = file.Close()
+
+	journal, err = NewFileJournal(dir)
+	if err != nil {
+		t.Fatalf("NewFileJournal: %v", err)
+	}
+	if seqs := entrySeqs(t, journal, roomID, 0); len(seqs) != 2 {
+		t.Errorf("entries = %v, want [1 2]", seqs)
+	}
+	appendEntries(t, journal, roomID, 1)
+	if seqs := entrySeqs(t, journal, roomID, 0); len(seqs) != 3 || seqs[2] != 3 {
+		t.Errorf("entries = %v, want [1 2 3]", seqs)
+	}
+}
+
+func TestFileJournalCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	roomID := models.NewRoom("owner", nil).ID
+	journal, err := NewFileJournal(dir)
+	if err != nil {
+		t.Fatalf("NewFileJournal: %v", err)
+	}
+
+	if snapshot, err := journal.LastSnapshot(ctx, roomID); snapshot != nil || err != nil {
+		t.Fatalf("LastSnapshot = %+v, %v, want none", snapshot, err)
+	}
+	appendEntries(t, journal, roomID, 5)
+	for _, seq := range []uint64{2, 4} {
+		err = journal.SaveSnapshot(ctx, &ports.JournalSnapshot{RoomID: roomID, Seq: seq, Owner: "owner", Values: map[string]models.Value{"score": *models.IntValue(int64(seq))}})
+		if err != nil {
+			t.Fatalf("SaveSnapshot: %v", err)
+		}
+	}
+
+	if err = journal.Compact(ctx, roomID, 4); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if seqs := entrySeqs(t, journal, roomID, 0); len(seqs) != 1 || seqs[0] != 5 {
+		t.Errorf("entries = %v, want [5]", seqs)
+	}
+	if seqs, _ := snapshotSeqs(filepath.Join(dir, roomID.String())); len(seqs) != 1 || seqs[0] != 4 {
+		t.Errorf("snapshots = %v, want [4]", seqs)
+	}
+	snapshot, err := journal.LastSnapshot(ctx, roomID)
+	if err != nil || snapshot.Seq != 4 || snapshot.RoomID != roomID || !valueOf(snapshot.Values, "score").Equal(models.IntValue(4)) {
+		t.Fatalf("LastSnapshot = %+v, %v, want seq 4", snapshot, err)
+	}
+
+	// seq isn't reused when every entry is erased
+	if err = journal.SaveSnapshot(ctx, &ports.JournalSnapshot{RoomID: roomID, Seq: 5, Owner: "owner"}); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	if err = journal.Compact(ctx, roomID, 5); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	journal, _ = NewFileJournal(dir)
+	if seq, err := journal.Append(ctx, &ports.JournalEntry{RoomID: roomID, Op: ports.JournalOpDeleteRoom}); err != nil || seq != 6 {
+		t.Errorf("Append = %d, %v, want 6", seq, err)
+	}
+}
@@ -0,0 +1,234 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+	"time"
+)
+
+// MongoDBJournal - ports.JournalPort impl with MongoDB collections of entries and snapshots
+//
+// Documents have room_id, seq, time and (entries only) op, command_id, user_id fields, so they can be queried
+// for audit; the rest is JSON in "payload" (models.Value has no BSON form).
+// Seq of entry is taken from room's counter in seqs collection by one atomic increment, so Append is safe
+// for many instances and seq isn't reused after compaction; unique index of room_id and seq guards it.
+// Compact is one transaction, so it needs MongoDB replica set
+type MongoDBJournal struct {
+	client    *mongo.Client
+	entries   *mongo.Collection
+	snapshots *mongo.Collection
+	seqs      *mongo.Collection
+}
+
+// MongoJournalParams - params for initializing MongoDBJournal
+type MongoJournalParams struct {
+	Database            string
+	EntriesCollection   string
+	SnapshotsCollection string
+	SeqsCollection      string
+	WriteConcern        *writeconcern.WriteConcern
+}
+
+// mongoSeqDocument - document of seqs collection, the last seq taken by room's entries
+type mongoSeqDocument struct {
+	RoomID string `bson:"_id"`
+	Seq    int64  `bson:"seq"`
+}
+
+// mongoJournalDocument - document of entries and snapshots collections
+type mongoJournalDocument struct {
+	RoomID    string    `bson:"room_id"`
+	Seq       int64     `bson:"seq"`
+	Time      time.Time `bson:"time"`
+	Op        string    `bson:"op,omitempty"`
+	CommandID string    `bson:"command_id,omitempty"`
+	UserID    string    `bson:"user_id,omitempty"`
+	Payload   string    `bson:"payload"`
+}
+
+// NewMongoDBJournal - return new MongoDBJournal, indexes are created if they don't exist
+//
+// collection names default = "journal", "journal_snapshots", "journal_seqs"
+func NewMongoDBJournal(ctx context.Context, client *mongo.Client, params MongoJournalParams) (*MongoDBJournal, error) {
+	db := client.Database(params.Database, options.Database().SetWriteConcern(params.WriteConcern))
+	if len(params.EntriesCollection) == 0 {
+		params.EntriesCollection = "journal"
+	}
+	if len(params.SnapshotsCollection) == 0 {
+		params.SnapshotsCollection = "journal_snapshots"
+	}
+	if len(params.SeqsCollection) == 0 {
+		params.SeqsCollection = "journal_seqs"
+	}
+	j := &MongoDBJournal{
+		client:    client,
+		entries:   db.Collection(params.EntriesCollection),
+		snapshots: db.Collection(params.SnapshotsCollection),
+		seqs:      db.Collection(params.SeqsCollection),
+	}
+
+	for _, collection := range []*mongo.Collection{j.entries, j.snapshots} {
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "room_id", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating index of %s collection: %w", collection.Name(), err)
+		}
+	}
+	return j, nil
+}
+
+// Append - take the next seq of room from its counter and insert entry with it
+//
+// seq taken by entry that isn't inserted is skipped
+func (j *MongoDBJournal) Append(ctx context.Context, entry *ports.JournalEntry) (uint64, error) {
+	counter, err := j.nextSeq(ctx, entry.RoomID)
+	if mongo.IsDuplicateKeyError(err) {
+		// counter is created by a concurrent append, now it's incremented
+		counter, err = j.nextSeq(ctx, entry.RoomID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error taking journal seq: %w", err)
+	}
+
+	appended := *entry
+	appended.Seq = uint64(counter.Seq)
+	payload, err := json.Marshal(&appended)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding journal entry: %w", err)
+	}
+	_, err = j.entries.InsertOne(ctx, mongoJournalDocument{
+		RoomID:    entry.RoomID.String(),
+		Seq:       counter.Seq,
+		Time:      appended.Time,
+		Op:        string(appended.Op),
+		CommandID: appended.CommandID,
+		UserID:    appended.UserID,
+		Payload:   string(payload),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting journal entry: %w", err)
+	}
+	return appended.Seq, nil
+}
+
+// Entries - find entries of room with Seq > afterSeq, ordered by seq
+func (j *MongoDBJournal) Entries(ctx context.Context, roomID models.RoomID, afterSeq uint64) ([]ports.JournalEntry, error) {
+	cursor, err := j.entries.Find(ctx,
+		bson.D{{Key: "room_id", Value: roomID.String()}, {Key: "seq", Value: bson.D{{Key: "$gt", Value: int64(afterSeq)}}}},
+		options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("error querying journal entries: %w", err)
+	}
+	var documents []mongoJournalDocument
+	if err = cursor.All(ctx, &documents); err != nil {
+		return nil, fmt.Errorf("error reading journal entries: %w", err)
+	}
+
+	entries := make([]ports.JournalEntry, 0, len(documents))
+	for _, document := range documents {
+		var entry ports.JournalEntry
+		if err = json.Unmarshal([]byte(document.Payload), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding journal entry %d: %w", document.Seq, err)
+		}
+		entry.RoomID = roomID
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SaveSnapshot - insert snapshot, snapshot of the same seq is replaced
+func (j *MongoDBJournal) SaveSnapshot(ctx context.Context, snapshot *ports.JournalSnapshot) error {
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error encoding journal snapshot: %w", err)
+	}
+	document := mongoJournalDocument{
+		RoomID:  snapshot.RoomID.String(),
+		Seq:     int64(snapshot.Seq),
+		Time:    snapshot.Time,
+		Payload: string(payload),
+	}
+	_, err = j.snapshots.ReplaceOne(ctx,
+		bson.D{{Key: "room_id", Value: document.RoomID}, {Key: "seq", Value: document.Seq}},
+		document, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("error saving journal snapshot: %w", err)
+	}
+	return nil
+}
+
+// LastSnapshot - find snapshot of room with the greatest seq, nil if there's none
+func (j *MongoDBJournal) LastSnapshot(ctx context.Context, roomID models.RoomID) (*ports.JournalSnapshot, error) {
+	var document mongoJournalDocument
+	err := j.snapshots.FindOne(ctx,
+		bson.D{{Key: "room_id", Value: roomID.String()}},
+		options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying journal snapshot: %w", err)
+	}
+	snapshot := &ports.JournalSnapshot{}
+	if err = json.Unmarshal([]byte(document.Payload), snapshot); err != nil {
+		return nil, fmt.Errorf("error decoding journal snapshot: %w", err)
+	}
+	snapshot.RoomID = roomID
+	return snapshot, nil
+}
+
+// Compact - delete entries of room with Seq <= seq and snapshots with Seq < seq in one transaction
+func (j *MongoDBJournal) Compact(ctx context.Context, roomID models.RoomID, seq uint64) error {
+	session, err := j.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting mongodb session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		_, err := j.entries.DeleteMany(ctx,
+			bson.D{{Key: "room_id", Value: roomID.String()}, {Key: "seq", Value: bson.D{{Key: "$lte", Value: int64(seq)}}}})
+		if err != nil {
+			return nil, fmt.Errorf("error deleting journal entries: %w", err)
+		}
+		_, err = j.snapshots.DeleteMany(ctx,
+			bson.D{{Key: "room_id", Value: roomID.String()}, {Key: "seq", Value: bson.D{{Key: "$lt", Value: int64(seq)}}}})
+		if err != nil {
+			return nil, fmt.Errorf("error deleting journal snapshots: %w", err)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error compacting journal: %w", err)
+	}
+	return nil
+}
+
+// Ping - ping primary node of MongoDB
+func (j *MongoDBJournal) Ping(ctx context.Context) error {
+	if err := j.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("error pinging mongodb: %w", err)
+	}
+	return nil
+}
+
+// nextSeq - increment counter of room, it's created with seq 1 if there's none
+func (j *MongoDBJournal) nextSeq(ctx context.Context, roomID models.RoomID) (mongoSeqDocument, error) {
+	var counter mongoSeqDocument
+	err := j.seqs.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: roomID.String()}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+	return counter, err
+}
@@ -0,0 +1,130 @@
+package journal
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/mongotest"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func openTestMongoJournal(t *testing.T, client *mongo.Client, database string) *MongoDBJournal {
+	t.Helper()
+	journal, err := NewMongoDBJournal(context.Background(), client, MongoJournalParams{Database: database})
+	if err != nil {
+		t.Fatalf("NewMongoDBJournal: %v", err)
+	}
+	return journal
+}
+
+func TestMongoDBJournalAppend(t *testing.T) {
+	ctx := context.Background()
+	client := mongotest.NewClient(t)
+	database := mongotest.NewDatabase(t, client)
+	roomID, otherRoomID := models.NewRoom("owner", nil).ID, models.NewRoom("owner", nil).ID
+
+	journal := openTestMongoJournal(t, client, database)
+	appendEntries(t, journal, roomID, 3)
+	appendEntries(t, journal, otherRoomID, 1)
+
+	// journal of another instance continues seq
+	journal = openTestMongoJournal(t, client, database)
+	seq, err := journal.Append(ctx, &ports.JournalEntry{RoomID: roomID, Op: ports.JournalOpJoinRoom, User: &ports.JournalUser{ID: "guest", Name: "Guest"}})
+	if err != nil || seq != 4 {
+		t.Fatalf("Append = %d, %v, want 4", seq, err)
+	}
+
+	if seqs := entrySeqs(t, journal, roomID, 1); !slices.Equal(seqs, []uint64{2, 3, 4}) {
+		t.Errorf("entries after 1 = %v, want [2 3 4]", seqs)
+	}
+	if seqs := entrySeqs(t, journal, otherRoomID, 0); !slices.Equal(seqs, []uint64{1}) {
+		t.Errorf("entries of other room = %v, want [1]", seqs)
+	}
+	entries, _ := journal.Entries(ctx, roomID, 2)
+	if !entries[0].Value.Equal(models.IntValue(2)) || entries[1].User == nil || entries[1].User.ID != "guest" {
+		t.Errorf("entries = %+v, want value 2 and user guest", entries)
+	}
+}
+
+func TestMongoDBJournalConcurrentAppends(t *testing.T) {
+	client := mongotest.NewClient(t)
+	database := mongotest.NewDatabase(t, client)
+	roomID := models.NewRoom("owner", nil).ID
+	// journals of two instances
+	journals := []*MongoDBJournal{openTestMongoJournal(t, client, database), openTestMongoJournal(t, client, database)}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			appendEntries(t, journals[i%2], roomID, 1)
+		}()
+	}
+	wg.Wait()
+
+	if seqs := entrySeqs(t, journals[0], roomID, 0); !slices.Equal(seqs, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+		t.Fatalf("entries = %v, want 1..10: every append takes its own seq", seqs)
+	}
+}
+
+func TestMongoDBJournalCompact(t *testing.T) {
+	ctx := context.Background()
+	client := mongotest.NewClient(t)
+	if !mongotest.SupportsTransactions(t, client) {
+		t.Skipf("compaction is a transaction, set %s to MongoDB replica set", mongotest.URIEnv)
+	}
+	journal := openTestMongoJournal(t, client, mongotest.NewDatabase(t, client))
+	roomID := models.NewRoom("owner", nil).ID
+
+	if snapshot, err := journal.LastSnapshot(ctx, roomID); snapshot != nil || err != nil {
+		t.Fatalf("LastSnapshot = %+v, %v, want none", snapshot, err)
+	}
+	appendEntries(t, journal, roomID, 5)
+	for _, seq := range []uint64{2, 4} {
+		err := journal.SaveSnapshot(ctx, &ports.JournalSnapshot{RoomID: roomID, Seq: seq, Owner: "owner", Values: map[string]models.Value{"score": *models.IntValue(int64(seq))}})
+		if err != nil {
+			t.Fatalf("SaveSnapshot: %v", err)
+		}
+	}
+
+	if err := journal.Compact(ctx, roomID, 4); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if seqs := entrySeqs(t, journal, roomID, 0); !slices.Equal(seqs, []uint64{5}) {
+		t.Errorf("entries = %v, want [5]", seqs)
+	}
+	snapshot, err := journal.LastSnapshot(ctx, roomID)
+	if err != nil || snapshot.Seq != 4 || snapshot.RoomID != roomID || !valueOf(snapshot.Values, "score").Equal(models.IntValue(4)) {
+		t.Fatalf("LastSnapshot = %+v, %v, want seq 4", snapshot, err)
+	}
+	if count, _ := journal.snapshots.CountDocuments(ctx, map[string]string{"room_id": roomID.String()}); count != 1 {
+		t.Errorf("%d snapshots, want 1", count)
+	}
+
+	// seq isn't reused when every entry is erased
+	if err = journal.Compact(ctx, roomID, 5); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if seq, err := journal.Append(ctx, &ports.JournalEntry{RoomID: roomID, Op: ports.JournalOpDeleteRoom}); err != nil || seq != 6 {
+		t.Errorf("Append = %d, %v, want 6", seq, err)
+	}
+}
+
+func TestMongoDBJournalRebuildsRoom(t *testing.T) {
+	ctx := context.Background()
+	client := mongotest.NewClient(t)
+	journal := openTestMongoJournal(t, client, mongotest.NewDatabase(t, client))
+	next := room.NewInMemoryRepository()
+
+	roomID := playRoom(t, ctx, NewRoomsRepository(next, journal, Params{}))
+	snapshot, err := Rebuild(ctx, journal, roomID)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	assertSameRoom(t, next, snapshot)
+}
@@ -0,0 +1,227 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"time"
+)
+
+// NewSnapshot - snapshot of room state made by entries up to seq
+func NewSnapshot(seq uint64, at time.Time, snapshot *models.RoomSnapshot) *ports.JournalSnapshot {
+	journalSnapshot := &ports.JournalSnapshot{
+		RoomID:  snapshot.Room.ID,
+		Seq:     seq,
+		Time:    at,
+		Owner:   snapshot.Room.OwnerUserID.String(),
+		Options: snapshot.Room.Options,
+		Users:   make([]ports.JournalUser, 0, len(snapshot.Users)),
+		Values:  snapshot.Values,
+	}
+	for _, user := range snapshot.Users {
+		journalSnapshot.Users = append(journalSnapshot.Users, ports.JournalUser{
+			ID: user.ID.String(), Name: user.Name.String(), Metadata: user.Metadata,
+		})
+	}
+	return journalSnapshot
+}
+
+// Apply - make change of entry in rooms, the same way it was made when entry was appended
+//
+// JournalOpAbort entry changes nothing, entries it aborts must be skipped by caller
+func Apply(ctx context.Context, rooms ports.RoomsPort, entry *ports.JournalEntry) error {
+	switch entry.Op {
+	case ports.JournalOpCreateRoom:
+		_, err := rooms.CreateRoom(ctx, &models.Room{
+			ID: entry.RoomID, OwnerUserID: types.NotEmptyText(entry.Owner), Options: entry.Options,
+		})
+		return err
+	case ports.JournalOpDeleteRoom:
+		return rooms.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: entry.RoomID, UserID: types.NotEmptyText(entry.CallerUserID)})
+	case ports.JournalOpJoinRoom:
+		if entry.User == nil {
+			return fmt.Errorf("join_room entry %d has no user", entry.Seq)
+		}
+		return rooms.JoinRoom(ctx, ports.JoinRoomParams{RoomID: entry.RoomID, UserFull: models.User{
+			ID: types.NotEmptyText(entry.User.ID), Name: types.NotEmptyText(entry.User.Name), Metadata: entry.User.Metadata,
+		}})
+	case ports.JournalOpLeaveRoom:
+		return rooms.LeaveRoom(ctx, ports.LeaveRoomParams{
+			RoomID:              entry.RoomID,
+			CommandCallerUserID: types.NotEmptyText(entry.CallerUserID),
+			KickedUserID:        types.NotEmptyText(entry.KickedUserID),
+		})
+	case ports.JournalOpAffectData:
+		return rooms.AffectData(ctx, ports.AffectDataParams{
+			RoomID: entry.RoomID, DataID: types.AnyText(entry.DataID), Action: entry.Action, Value: entry.Value,
+		})
+	case ports.JournalOpReplaceRoom:
+		users := make([]*models.User, 0, len(entry.Users))
+		for _, user := range entry.Users {
+			users = append(users, &models.User{
+				ID: types.NotEmptyText(user.ID), Name: types.NotEmptyText(user.Name), Metadata: user.Metadata,
+			})
+		}
+		return rooms.ReplaceRoom(ctx, ports.ReplaceRoomParams{RoomID: entry.RoomID, Users: users, Values: entry.Values})
+	case ports.JournalOpAbort:
+		return nil
+	case ports.JournalOpResync:
+		return resync(ctx, rooms, entry)
+	default:
+		return fmt.Errorf("unknown journal op: '%s'", entry.Op)
+	}
+}
+
+// Rebuild - room state after every entry of journal: its last snapshot with the following entries applied,
+// snapshot's Seq is the seq of the last entry
+//
+// Room is deleted or isn't journaled -> errors.ErrRoomDoesntExist
+func Rebuild(ctx context.Context, journal ports.JournalPort, roomID models.RoomID) (*ports.JournalSnapshot, error) {
+	rooms, lastSeq, err := replay(ctx, journal, roomID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := rooms.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshot(lastSeq, time.Now(), snapshot), nil
+}
+
+// Restore - write room rebuilt from journal into rooms, crash recovery
+//
+// Room is already in rooms -> errors.ErrRoomIDAlreadyExists, unless replace is set: then it's deleted first
+func Restore(ctx context.Context, journal ports.JournalPort, rooms ports.RoomsPort, roomID models.RoomID, replace bool) (*ports.JournalSnapshot, error) {
+	snapshot, err := Rebuild(ctx, journal, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if replace {
+		err = rooms.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: roomID, UserID: types.NotEmptyText(snapshot.Owner)})
+		if err != nil && !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+			return nil, fmt.Errorf("error deleting room: %w", err)
+		}
+	}
+	if err = load(ctx, rooms, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// CompactRoom - save room state as snapshot and erase entries and snapshots before it
+//
+// journal of deleted room is erased, nil snapshot is returned
+func CompactRoom(ctx context.Context, journal ports.JournalPort, roomID models.RoomID) (*ports.JournalSnapshot, error) {
+	rooms, lastSeq, err := replay(ctx, journal, roomID)
+	if err != nil {
+		return nil, err
+	}
+	roomSnapshot, err := rooms.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	if errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		return nil, journal.Compact(ctx, roomID, lastSeq+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := NewSnapshot(lastSeq, time.Now(), roomSnapshot)
+	if err = journal.SaveSnapshot(ctx, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, journal.Compact(ctx, roomID, lastSeq)
+}
+
+// replay - apply the last snapshot of room and entries after it to in-memory rooms, seq of the last one is returned
+//
+// room isn't journaled -> errors.ErrRoomDoesntExist
+func replay(ctx context.Context, journal ports.JournalPort, roomID models.RoomID) (*room.InMemoryRepository, uint64, error) {
+	rooms := room.NewInMemoryRepository()
+	snapshot, err := journal.LastSnapshot(ctx, roomID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var lastSeq uint64
+	if snapshot != nil {
+		if err = load(ctx, rooms, snapshot); err != nil {
+			return nil, 0, err
+		}
+		lastSeq = snapshot.Seq
+	}
+
+	entries, err := journal.Entries(ctx, roomID, lastSeq)
+	if err != nil {
+		return nil, 0, err
+	}
+	if snapshot == nil && len(entries) == 0 {
+		return nil, 0, roomerrors.ErrRoomDoesntExist
+	}
+	aborted := make(map[uint64]bool)
+	for _, entry := range entries {
+		if entry.Op == ports.JournalOpAbort {
+			aborted[entry.AbortedSeq] = true
+		}
+	}
+	for i := range entries {
+		lastSeq = entries[i].Seq
+		if aborted[entries[i].Seq] {
+			continue
+		}
+		if err = Apply(ctx, rooms, &entries[i]); err != nil {
+			return nil, 0, fmt.Errorf("error applying journal entry %d: %w", entries[i].Seq, err)
+		}
+	}
+	return rooms, lastSeq, nil
+}
+
+// resync - make room in rooms equal to the state of JournalOpResync entry: it's deleted and created again
+func resync(ctx context.Context, rooms ports.RoomsPort, entry *ports.JournalEntry) error {
+	current, err := rooms.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: entry.RoomID})
+	switch {
+	case errors.Is(err, roomerrors.ErrRoomDoesntExist):
+	case err != nil:
+		return fmt.Errorf("error reading room: %w", err)
+	default:
+		err = rooms.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: entry.RoomID, UserID: current.Room.OwnerUserID})
+		if err != nil {
+			return fmt.Errorf("error deleting room: %w", err)
+		}
+	}
+	if len(entry.Owner) == 0 {
+		return nil
+	}
+	return load(ctx, rooms, &ports.JournalSnapshot{
+		RoomID: entry.RoomID, Owner: entry.Owner, Options: entry.Options, Users: entry.Users, Values: entry.Values,
+	})
+}
+
+// load - create room of snapshot in rooms with its users and values
+func load(ctx context.Context, rooms ports.RoomsPort, snapshot *ports.JournalSnapshot) error {
+	_, err := rooms.CreateRoom(ctx, &models.Room{
+		ID: snapshot.RoomID, OwnerUserID: types.NotEmptyText(snapshot.Owner), Options: snapshot.Options,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating room: %w", err)
+	}
+	for _, user := range snapshot.Users {
+		err = rooms.JoinRoom(ctx, ports.JoinRoomParams{RoomID: snapshot.RoomID, UserFull: models.User{
+			ID: types.NotEmptyText(user.ID), Name: types.NotEmptyText(user.Name), Metadata: user.Metadata,
+		}})
+		if err != nil {
+			return fmt.Errorf("error joining user %s: %w", user.ID, err)
+		}
+	}
+	for key, value := range snapshot.Values {
+		err = rooms.AffectData(ctx, ports.AffectDataParams{
+			RoomID: snapshot.RoomID, DataID: types.AnyText(key), Action: ports.ActionSet, Value: &value,
+		})
+		if err != nil {
+			return fmt.Errorf("error setting value %s: %w", key, err)
+		}
+	}
+	return nil
+}
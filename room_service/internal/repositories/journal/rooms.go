@@ -0,0 +1,284 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// lockStripes - changes of rooms are serialized by this many locks, rooms with the same lock wait for each other
+const lockStripes = 256
+
+// RoomsRepository - ports.RoomsPort decorator that appends every change applied by next into journal
+//
+// Entry is appended before its change is applied (write-ahead), both under the room's lock,
+// so entries are in the order changes were applied and an applied change is never missed.
+// Entry of change rejected by next (domain error) is followed by JournalOpAbort entry.
+// If it isn't known whether the change or its entry is written (e.g. timeout), the room is marked unsynced and
+// before its next change the journal is repaired with JournalOpResync entry: room state read from next.
+//
+// Every Params.SnapshotEvery entries room's snapshot is saved (and journal is compacted if Params.Compact),
+// failures of that are logged only: the room can still be rebuilt from the previous snapshot
+type RoomsRepository struct {
+	next    ports.RoomsPort
+	journal ports.JournalPort
+	params  Params
+	// now - time source, replaced in tests
+	now func() time.Time
+
+	locks [lockStripes]sync.Mutex
+
+	mu sync.Mutex
+	// unsynced - rooms whose journal might differ from next, it's repaired before their next change
+	unsynced map[models.RoomID]struct{}
+}
+
+// Params - params of RoomsRepository
+type Params struct {
+	// SnapshotEvery - room's snapshot is saved when seq of its entry is a multiple of it, 0 - never
+	SnapshotEvery uint64
+	// Compact - when snapshot is saved, erase entries and snapshots before the previous snapshot
+	//
+	// the previous snapshot and entries after it are kept, so room can be rebuilt if the new snapshot is lost
+	Compact bool
+}
+
+// NewRoomsRepository - journal changes applied by next
+func NewRoomsRepository(next ports.RoomsPort, journal ports.JournalPort, params Params) *RoomsRepository {
+	return &RoomsRepository{
+		next: next, journal: journal, params: params, now: time.Now, unsynced: make(map[models.RoomID]struct{}),
+	}
+}
+
+// CreateRoom - create room in next and journal it
+//
+// Create ID yourself, ID is taken -> errors.ErrRoomIDAlreadyExists
+func (s *RoomsRepository) CreateRoom(ctx context.Context, params *models.Room) (*models.Room, error) {
+	var created *models.Room
+	err := s.record(ctx, params.ID, func() (err error) {
+		created, err = s.next.CreateRoom(ctx, params)
+		return err
+	}, &ports.JournalEntry{
+		Op: ports.JournalOpCreateRoom, Owner: params.OwnerUserID.String(), Options: params.Options,
+	})
+	return created, err
+}
+
+// DeleteRoom - delete room in next and journal it
+//
+// Not found -> errors.ErrRoomDoesntExist
+// User isn't room owner -> errors.ErrNotRoomOwner
+func (s *RoomsRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) error {
+	return s.record(ctx, params.RoomID, func() error {
+		return s.next.DeleteRoom(ctx, params)
+	}, &ports.JournalEntry{Op: ports.JournalOpDeleteRoom, CallerUserID: params.UserID.String()})
+}
+
+// JoinRoom - add user to room in next and journal it
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *RoomsRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	return s.record(ctx, params.RoomID, func() error {
+		return s.next.JoinRoom(ctx, params)
+	}, &ports.JournalEntry{Op: ports.JournalOpJoinRoom, User: &ports.JournalUser{
+		ID: params.UserFull.ID.String(), Name: params.UserFull.Name.String(), Metadata: params.UserFull.Metadata,
+	}})
+}
+
+// CountOwnedRooms - count rooms in next, reads aren't journaled
+func (s *RoomsRepository) CountOwnedRooms(ctx context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	return s.next.CountOwnedRooms(ctx, params)
+}
+
+// IsRoomOwner - check owner in next, reads aren't journaled
+func (s *RoomsRepository) IsRoomOwner(ctx context.Context, params ports.IsRoomOwnerParams) (bool, error) {
+	return s.next.IsRoomOwner(ctx, params)
+}
+
+// LeaveRoom - remove user from room in next and journal it
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// User not found -> errors.ErrUserNotInRoom
+// Another user is kicked not by room owner -> errors.ErrNotRoomOwner
+func (s *RoomsRepository) LeaveRoom(ctx context.Context, param ports.LeaveRoomParams) error {
+	return s.record(ctx, param.RoomID, func() error {
+		return s.next.LeaveRoom(ctx, param)
+	}, &ports.JournalEntry{
+		Op: ports.JournalOpLeaveRoom, CallerUserID: param.CommandCallerUserID.String(), KickedUserID: param.KickedUserID.String(),
+	})
+}
+
+// RoomSnapshot - read room from next, reads aren't journaled
+func (s *RoomsRepository) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	return s.next.RoomSnapshot(ctx, params)
+}
+
+// AffectData - change data in next and journal it
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// Data not found -> errors.ErrDataPieceDoesntExist
+func (s *RoomsRepository) AffectData(ctx context.Context, params ports.AffectDataParams) error {
+	return s.record(ctx, params.RoomID, func() error {
+		return s.next.AffectData(ctx, params)
+	}, &ports.JournalEntry{
+		Op: ports.JournalOpAffectData, DataID: params.DataID.String(), Action: params.Action, Value: params.Value,
+	})
+}
+
+// ReplaceRoom - replace users and data of room in next and journal it
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *RoomsRepository) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	users := make([]ports.JournalUser, 0, len(params.Users))
+	for _, user := range params.Users {
+		users = append(users, ports.JournalUser{ID: user.ID.String(), Name: user.Name.String(), Metadata: user.Metadata})
+	}
+	return s.record(ctx, params.RoomID, func() error {
+		return s.next.ReplaceRoom(ctx, params)
+	}, &ports.JournalEntry{Op: ports.JournalOpReplaceRoom, Users: users, Values: params.Values})
+}
+
+// Ping - ping next
+func (s *RoomsRepository) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// record - append entry and apply change under the room's lock, snapshot is saved if it's time to
+func (s *RoomsRepository) record(ctx context.Context, roomID models.RoomID, apply func() error, entry *ports.JournalEntry) error {
+	lock := s.lock(roomID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// entries of a change that is started must be written even if command is canceled now
+	journalCtx := context.WithoutCancel(ctx)
+	if err := s.repair(journalCtx, roomID); err != nil {
+		return err
+	}
+
+	entry.RoomID = roomID
+	entry.Time = s.now()
+	entry.CommandID = projectutils.CommandIDFromContext(ctx)
+	entry.UserID = projectutils.UserIDFromContext(ctx)
+	seq, err := s.journal.Append(ctx, entry)
+	if err != nil {
+		// entry might be written though
+		s.markUnsynced(roomID)
+		return fmt.Errorf("error journaling change: %w", err)
+	}
+
+	if err = apply(); err != nil {
+		if !rejected(err) {
+			s.markUnsynced(roomID)
+			return err
+		}
+		_, errAbort := s.journal.Append(journalCtx, &ports.JournalEntry{
+			RoomID: roomID, Time: s.now(), CommandID: entry.CommandID, UserID: entry.UserID,
+			Op: ports.JournalOpAbort, AbortedSeq: seq,
+		})
+		if errAbort != nil {
+			logging.FromContext(ctx).Warn(ctx, "failed to journal abort of rejected change",
+				zap.String("room_id", roomID.String()), zap.Uint64("seq", seq), zap.Error(errAbort))
+			s.markUnsynced(roomID)
+		}
+		return err
+	}
+
+	if entry.Op != ports.JournalOpDeleteRoom && s.params.SnapshotEvery > 0 && seq%s.params.SnapshotEvery == 0 {
+		if err = s.snapshot(journalCtx, roomID, seq); err != nil {
+			logging.FromContext(ctx).Warn(ctx, "failed to save journal snapshot",
+				zap.String("room_id", roomID.String()), zap.Uint64("seq", seq), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// repair - append JournalOpResync entry with room state read from next if room is unsynced,
+// must be called under the room's lock
+func (s *RoomsRepository) repair(ctx context.Context, roomID models.RoomID) error {
+	s.mu.Lock()
+	_, unsynced := s.unsynced[roomID]
+	s.mu.Unlock()
+	if !unsynced {
+		return nil
+	}
+
+	entry := &ports.JournalEntry{RoomID: roomID, Time: s.now(), Op: ports.JournalOpResync}
+	roomSnapshot, err := s.next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	switch {
+	case errors.Is(err, roomerrors.ErrRoomDoesntExist):
+	case err != nil:
+		return fmt.Errorf("error reading room to repair its journal: %w", err)
+	default:
+		snapshot := NewSnapshot(0, entry.Time, roomSnapshot)
+		entry.Owner, entry.Options, entry.Users, entry.Values = snapshot.Owner, snapshot.Options, snapshot.Users, snapshot.Values
+	}
+	if _, err = s.journal.Append(ctx, entry); err != nil {
+		return fmt.Errorf("error repairing journal of room: %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.unsynced, roomID)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *RoomsRepository) markUnsynced(roomID models.RoomID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unsynced[roomID] = struct{}{}
+}
+
+// rejected - err of change means next didn't apply it
+func rejected(err error) bool {
+	for _, domainErr := range []error{
+		roomerrors.ErrRoomDoesntExist,
+		roomerrors.ErrRoomIDAlreadyExists,
+		roomerrors.ErrUserNotInRoom,
+		roomerrors.ErrNotRoomOwner,
+		roomerrors.ErrDataPieceDoesntExist,
+		roomerrors.ErrQuotaExceeded,
+		// breaker doesn't call next while it's open
+		roomerrors.ErrCircuitOpen,
+	} {
+		if errors.Is(err, domainErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot - save room state from next as snapshot at seq, compact journal up to the previous snapshot
+//
+// must be called under the room's lock, so next has no changes after seq
+func (s *RoomsRepository) snapshot(ctx context.Context, roomID models.RoomID, seq uint64) error {
+	roomSnapshot, err := s.next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	if err != nil {
+		return fmt.Errorf("error reading room: %w", err)
+	}
+	previous, err := s.journal.LastSnapshot(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if err = s.journal.SaveSnapshot(ctx, NewSnapshot(seq, s.now(), roomSnapshot)); err != nil {
+		return err
+	}
+	if s.params.Compact && previous != nil {
+		return s.journal.Compact(ctx, roomID, previous.Seq)
+	}
+	return nil
+}
+
+func (s *RoomsRepository) lock(roomID models.RoomID) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(roomID.String()))
+	return &s.locks[hash.Sum32()%lockStripes]
+}
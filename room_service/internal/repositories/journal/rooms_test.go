@@ -0,0 +1,289 @@
+package journal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/ports/porttest"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// brokenJournal - journal whose appends fail
+type brokenJournal struct {
+	*FileJournal
+}
+
+var errJournalDown = errors.New("journal is down")
+
+func (j *brokenJournal) Append(context.Context, *ports.JournalEntry) (uint64, error) {
+	return 0, errJournalDown
+}
+
+func newTestJournal(t *testing.T) *FileJournal {
+	t.Helper()
+	journal, err := NewFileJournal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileJournal: %v", err)
+	}
+	return journal
+}
+
+// playRoom - create room and make changes of every kind in it through rooms
+func playRoom(t *testing.T, ctx context.Context, rooms ports.RoomsPort) models.RoomID {
+	t.Helper()
+	created := models.NewRoom("owner", map[string]string{"mode": "duel"})
+	if _, err := rooms.CreateRoom(ctx, created); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomID := created.ID
+	for _, user := range []models.User{{ID: "owner", Name: "Owner"}, {ID: "guest", Name: "Guest"}, {ID: "kicked", Name: "Kicked"}} {
+		if err := rooms.JoinRoom(ctx, ports.JoinRoomParams{RoomID: roomID, UserFull: user}); err != nil {
+			t.Fatalf("JoinRoom: %v", err)
+		}
+	}
+	err := rooms.JoinRoom(ctx, ports.JoinRoomParams{RoomID: roomID, UserFull: models.User{ID: "guest", Name: "Guest", Metadata: map[string]string{"team": "red"}}})
+	if err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	if err = rooms.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: roomID, CommandCallerUserID: "owner", KickedUserID: "kicked"}); err != nil {
+		t.Fatalf("LeaveRoom: %v", err)
+	}
+	for _, params := range []ports.AffectDataParams{
+		{DataID: "score", Action: ports.ActionSet, Value: models.IntValue(1)},
+		{DataID: "score", Action: ports.ActionSet, Value: models.IntValue(2)},
+		{DataID: "moves", Action: ports.ActionAppend, Value: models.StrValue("e4")},
+		{DataID: "moves", Action: ports.ActionAppend, Value: models.StrValue("e5")},
+		{DataID: "moves", Action: ports.ActionRemove, Value: models.StrValue("e4")},
+		{DataID: "draft", Action: ports.ActionSet, Value: models.BoolValue(true)},
+		{DataID: "draft", Action: ports.ActionDelete},
+	} {
+		params.RoomID = roomID
+		if err = rooms.AffectData(ctx, params); err != nil {
+			t.Fatalf("AffectData %v %s: %v", params.Action, params.DataID, err)
+		}
+	}
+	return roomID
+}
+
+// assertSameRoom - snapshot has the same owner, options, users and values as room in rooms
+func assertSameRoom(t *testing.T, rooms ports.RoomsPort, snapshot *ports.JournalSnapshot) {
+	t.Helper()
+	roomSnapshot, err := rooms.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: snapshot.RoomID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	want := NewSnapshot(snapshot.Seq, snapshot.Time, roomSnapshot)
+	if want.Owner != snapshot.Owner || !maps.Equal(want.Options, snapshot.Options) {
+		t.Errorf("room = %s %v, want %s %v", snapshot.Owner, snapshot.Options, want.Owner, want.Options)
+	}
+
+	byID := func(a, b ports.JournalUser) int { return cmp.Compare(a.ID, b.ID) }
+	slices.SortFunc(want.Users, byID)
+	slices.SortFunc(snapshot.Users, byID)
+	if !slices.EqualFunc(want.Users, snapshot.Users, func(a, b ports.JournalUser) bool {
+		return a.ID == b.ID && a.Name == b.Name && maps.Equal(a.Metadata, b.Metadata)
+	}) {
+		t.Errorf("users = %+v, want %+v", snapshot.Users, want.Users)
+	}
+
+	if len(want.Values) != len(snapshot.Values) {
+		t.Errorf("values = %+v, want %+v", snapshot.Values, want.Values)
+	}
+	for key := range want.Values {
+		if !valueOf(want.Values, key).Equal(valueOf(snapshot.Values, key)) {
+			t.Errorf("value %s = %+v, want %+v", key, snapshot.Values[key], want.Values[key])
+		}
+	}
+}
+
+func TestRoomsRepositoryConformance(t *testing.T) {
+	porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+		return NewRoomsRepository(room.NewInMemoryRepository(), newTestJournal(t), Params{SnapshotEvery: 3, Compact: true})
+	})
+}
+
+func TestRoomsRepositoryJournalsChanges(t *testing.T) {
+	ctx := projectutils.WithUserID(projectutils.WithCommandID(context.Background(), "command-1"), "owner")
+	next, journal := room.NewInMemoryRepository(), newTestJournal(t)
+	repo := NewRoomsRepository(next, journal, Params{})
+
+	roomID := playRoom(t, ctx, repo)
+	entries, err := journal.Entries(ctx, roomID, 0)
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	// create, 4 joins, leave, 7 data changes
+	if len(entries) != 13 {
+		t.Fatalf("%d entries, want 13", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Seq != uint64(i+1) || entry.CommandID != "command-1" || entry.UserID != "owner" || entry.Time.IsZero() {
+			t.Errorf("entry %d = %+v, want seq %d of command-1 by owner", i, entry, i+1)
+		}
+	}
+
+	// entry of rejected change is written before the change, so it's aborted
+	err = repo.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: roomID, CommandCallerUserID: "owner", KickedUserID: "nobody"})
+	if !errors.Is(err, roomerrors.ErrUserNotInRoom) {
+		t.Fatalf("LeaveRoom = %v, want ErrUserNotInRoom", err)
+	}
+	entries, err = journal.Entries(ctx, roomID, 13)
+	if err != nil || len(entries) != 2 || entries[1].Op != ports.JournalOpAbort || entries[1].AbortedSeq != 14 {
+		t.Fatalf("entries after 13 = %+v, %v, want rejected change and its abort", entries, err)
+	}
+
+	snapshot, err := Rebuild(ctx, journal, roomID)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if snapshot.Seq != 15 {
+		t.Errorf("rebuilt seq = %d, want 15", snapshot.Seq)
+	}
+	assertSameRoom(t, next, snapshot)
+}
+
+func TestRoomsRepositorySnapshots(t *testing.T) {
+	ctx := context.Background()
+	next, journal := room.NewInMemoryRepository(), newTestJournal(t)
+	repo := NewRoomsRepository(next, journal, Params{SnapshotEvery: 4, Compact: true})
+
+	roomID := playRoom(t, ctx, repo)
+	// snapshots at 4, 8 and 12, compacted up to 8
+	snapshot, err := journal.LastSnapshot(ctx, roomID)
+	if err != nil || snapshot == nil || snapshot.Seq != 12 {
+		t.Fatalf("LastSnapshot = %+v, %v, want seq 12", snapshot, err)
+	}
+	if seqs := entrySeqs(t, journal, roomID, 0); len(seqs) != 5 || seqs[0] != 9 {
+		t.Errorf("entries = %v, want 9..13", seqs)
+	}
+
+	snapshot, err = Rebuild(ctx, journal, roomID)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	assertSameRoom(t, next, snapshot)
+}
+
+func TestRoomsRepositoryNotJournaled(t *testing.T) {
+	ctx := context.Background()
+	next := room.NewInMemoryRepository()
+	repo := NewRoomsRepository(next, &brokenJournal{FileJournal: newTestJournal(t)}, Params{})
+
+	created := models.NewRoom("owner", nil)
+	_, err := repo.CreateRoom(ctx, created)
+	if !errors.Is(err, errJournalDown) {
+		t.Fatalf("CreateRoom = %v, want journal error", err)
+	}
+	// entry is written before the change, so change isn't made without it
+	if _, err = next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Errorf("room is created in next without journal entry: %v", err)
+	}
+}
+
+// timingOutRooms - rooms whose AffectData applies the change, but fails as if it timed out
+type timingOutRooms struct {
+	*room.InMemoryRepository
+}
+
+func (r *timingOutRooms) AffectData(ctx context.Context, params ports.AffectDataParams) error {
+	if err := r.InMemoryRepository.AffectData(ctx, params); err != nil {
+		return err
+	}
+	return context.DeadlineExceeded
+}
+
+func TestRoomsRepositoryRepairsUnsyncedRoom(t *testing.T) {
+	ctx := context.Background()
+	next, journal := room.NewInMemoryRepository(), newTestJournal(t)
+	roomID := playRoom(t, ctx, NewRoomsRepository(next, journal, Params{}))
+
+	// the change is applied, but the error doesn't tell it
+	repo := NewRoomsRepository(&timingOutRooms{InMemoryRepository: next}, journal, Params{})
+	err := repo.AffectData(ctx, ports.AffectDataParams{RoomID: roomID, DataID: "moves", Action: ports.ActionAppend, Value: models.StrValue("d4")})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AffectData = %v, want timeout", err)
+	}
+	if err = repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: roomID, UserFull: models.User{ID: "late", Name: "Late"}}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+
+	entries, err := journal.Entries(ctx, roomID, 14)
+	if err != nil || len(entries) != 2 || entries[0].Op != ports.JournalOpResync || entries[1].Op != ports.JournalOpJoinRoom {
+		t.Fatalf("entries after 14 = %+v, %v, want resync before the next change", entries, err)
+	}
+	snapshot, err := Rebuild(ctx, journal, roomID)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	assertSameRoom(t, next, snapshot)
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	next, journal := room.NewInMemoryRepository(), newTestJournal(t)
+	roomID := playRoom(t, ctx, NewRoomsRepository(next, journal, Params{SnapshotEvery: 5}))
+
+	// storage is lost
+	restored := room.NewInMemoryRepository()
+	if _, err := Restore(ctx, journal, restored, roomID, false); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	snapshot, _ := Rebuild(ctx, journal, roomID)
+	assertSameRoom(t, restored, snapshot)
+
+	// storage is behind
+	if err := restored.AffectData(ctx, ports.AffectDataParams{RoomID: roomID, DataID: "score", Action: ports.ActionSet, Value: models.IntValue(100)}); err != nil {
+		t.Fatalf("AffectData: %v", err)
+	}
+	if _, err := Restore(ctx, journal, restored, roomID, false); !errors.Is(err, roomerrors.ErrRoomIDAlreadyExists) {
+		t.Errorf("Restore without replace = %v, want ErrRoomIDAlreadyExists", err)
+	}
+	if _, err := Restore(ctx, journal, restored, roomID, true); err != nil {
+		t.Fatalf("Restore with replace: %v", err)
+	}
+	assertSameRoom(t, restored, snapshot)
+
+	if _, err := Restore(ctx, journal, restored, models.NewRoom("owner", nil).ID, false); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Errorf("Restore of unknown room = %v, want ErrRoomDoesntExist", err)
+	}
+}
+
+func TestCompactRoom(t *testing.T) {
+	ctx := context.Background()
+	next, journal := room.NewInMemoryRepository(), newTestJournal(t)
+	repo := NewRoomsRepository(next, journal, Params{})
+	roomID := playRoom(t, ctx, repo)
+
+	snapshot, err := CompactRoom(ctx, journal, roomID)
+	if err != nil || snapshot.Seq != 13 {
+		t.Fatalf("CompactRoom = %+v, %v, want snapshot at 13", snapshot, err)
+	}
+	if seqs := entrySeqs(t, journal, roomID, 0); len(seqs) != 0 {
+		t.Errorf("entries = %v, want none", seqs)
+	}
+	if snapshot, err = Rebuild(ctx, journal, roomID); err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	assertSameRoom(t, next, snapshot)
+
+	// journal of deleted room is erased
+	if err = repo.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: roomID, UserID: "owner"}); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+	if _, err = Rebuild(ctx, journal, roomID); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Errorf("Rebuild of deleted room = %v, want ErrRoomDoesntExist", err)
+	}
+	if snapshot, err = CompactRoom(ctx, journal, roomID); snapshot != nil || err != nil {
+		t.Fatalf("CompactRoom of deleted room = %+v, %v, want nil", snapshot, err)
+	}
+	if last, _ := journal.LastSnapshot(ctx, roomID); last != nil || len(entrySeqs(t, journal, roomID, 0)) != 0 {
+		t.Errorf("journal of deleted room isn't erased")
+	}
+}
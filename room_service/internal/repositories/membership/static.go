@@ -0,0 +1,32 @@
+package membership
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// StaticMembership - ports.MembershipPort impl with members from config, they're replaced by Set (e.g. on config reload)
+type StaticMembership struct {
+	mu      sync.RWMutex
+	members []string
+}
+
+// NewStaticMembership - return new StaticMembership with given members
+func NewStaticMembership(members []string) *StaticMembership {
+	return &StaticMembership{members: slices.Clone(members)}
+}
+
+// Members - current members
+func (m *StaticMembership) Members(_ context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.members), nil
+}
+
+// Set - replace members
+func (m *StaticMembership) Set(members []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members = slices.Clone(members)
+}
@@ -0,0 +1,88 @@
+// Package mongotest - MongoDB server for tests of MongoDB adapters
+//
+// MONGODB_TEST_URI env is used if it's set (e.g. replica set in CI), otherwise every test gets embedded FerretDB
+// with SQLite backend in its temp dir.
+//
+// FerretDB doesn't apply a filtered update atomically with its filter, so concurrent writers of different processes
+// can only be checked with a real MongoDB
+package mongotest
+
+import (
+	"context"
+	"fmt"
+	"github.com/FerretDB/FerretDB/ferretdb"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// URIEnv - env with URI of MongoDB server for tests
+const URIEnv = "MONGODB_TEST_URI"
+
+// NewClient - client of MongoDB server for the test, disconnected (and embedded server stopped) on cleanup
+func NewClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv(URIEnv)
+	if len(uri) == 0 {
+		uri = startFerretDB(t)
+	}
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri).SetTimeout(10 * time.Second))
+	if err != nil {
+		t.Fatalf("connect to mongodb: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	return client
+}
+
+// NewDatabase - name of empty database for the test on client's server, it's dropped on cleanup
+func NewDatabase(t *testing.T, client *mongo.Client) string {
+	t.Helper()
+	// names are unique, so tests can share MONGODB_TEST_URI server
+	name := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	t.Cleanup(func() { _ = client.Database(name).Drop(context.Background()) })
+	return name
+}
+
+// SupportsTransactions - server runs as a replica set, so multi-document transactions are available
+func SupportsTransactions(t *testing.T, client *mongo.Client) bool {
+	t.Helper()
+	var hello bson.M
+	err := client.Database("admin").RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+	_, replicaSet := hello["setName"]
+	return replicaSet
+}
+
+func startFerretDB(t *testing.T) string {
+	t.Helper()
+	server, err := ferretdb.New(&ferretdb.Config{
+		Listener:  ferretdb.ListenerConfig{TCP: "127.0.0.1:0"},
+		Logger:    slog.New(slog.DiscardHandler),
+		Handler:   "sqlite",
+		SQLiteURL: "file:" + strings.TrimSuffix(t.TempDir(), "/") + "/",
+	})
+	if err != nil {
+		t.Fatalf("create ferretdb: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = server.Run(ctx)
+	}()
+	// the cleanup of the temp dir is registered earlier, so it runs after the server is stopped
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return server.MongoDBURI()
+}
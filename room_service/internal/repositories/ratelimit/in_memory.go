@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/ports"
+	"math"
+	"sync"
+	"time"
+)
+
+// idleSweepInterval - how often buckets that are full again are forgotten
+const idleSweepInterval = time.Minute
+
+// InMemoryRateLimiter - impl of ports.RateLimiter with buckets in process memory, limits only this instance
+type InMemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// now - time source, replaced in tests
+	now func() time.Time
+}
+
+// bucket - tokens of one key at updatedAt
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	limit     ports.RateLimit
+}
+
+// NewInMemoryRateLimiter - create new InMemoryRateLimiter
+func NewInMemoryRateLimiter() *InMemoryRateLimiter {
+	return &InMemoryRateLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow - refill buckets since the last call and take one token from each if every one has it
+func (s *InMemoryRateLimiter) Allow(_ context.Context, buckets []ports.RateLimitBucket) (bool, int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweepIdle(now)
+
+	allowed, denied, wait := true, 0, time.Duration(0)
+	refilled := make([]*bucket, len(buckets))
+	for i, params := range buckets {
+		if params.Limit.Unlimited() {
+			continue
+		}
+		b := s.refill(params.Key, params.Limit, now)
+		refilled[i] = b
+		if b.tokens < 1 {
+			if allowed {
+				allowed, denied = false, i
+			}
+			wait = max(wait, retryAfter(b.tokens, params.Limit.PerSecond))
+		}
+	}
+	if !allowed {
+		return false, denied, wait, nil
+	}
+	for _, b := range refilled {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	return true, 0, 0, nil
+}
+
+// refill - bucket of key with tokens added since the last call, s.mu must be locked
+func (s *InMemoryRateLimiter) refill(key string, limit ports.RateLimit, now time.Time) *bucket {
+	burst := float64(max(limit.Burst, 1))
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updatedAt: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updatedAt).Seconds()*limit.PerSecond)
+	b.updatedAt = now
+	return b
+}
+
+// sweepIdle - forget buckets that are full by now, a full bucket is the same as a new one
+func (s *InMemoryRateLimiter) sweepIdle(now time.Time) {
+	if now.Sub(s.lastSweep) < idleSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updatedAt).Seconds()*b.limit.PerSecond >= float64(max(b.limit.Burst, 1)) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// retryAfter - time until bucket with tokens (< 1) gets one token
+func retryAfter(tokens float64, perSecond float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / perSecond * float64(time.Second)))
+}
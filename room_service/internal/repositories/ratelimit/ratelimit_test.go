@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/go-redis/redis/v8"
+	"testing"
+	"time"
+)
+
+// allowOne - take a token from one bucket
+func allowOne(ctx context.Context, limiter ports.RateLimiter, key string, limit ports.RateLimit) (bool, time.Duration, error) {
+	allowed, _, retryAfter, err := limiter.Allow(ctx, []ports.RateLimitBucket{{Key: key, Limit: limit}})
+	return allowed, retryAfter, err
+}
+
+func TestRateLimitersTakeBurstThenReject(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	limiters := map[string]ports.RateLimiter{
+		"in_memory": NewInMemoryRateLimiter(),
+		"redis":     NewRedisRateLimiter(client),
+	}
+	limit := ports.RateLimit{PerSecond: 0.5, Burst: 2}
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 0; i < limit.Burst; i++ {
+				allowed, _, err := allowOne(ctx, limiter, "room:1", limit)
+				if err != nil || !allowed {
+					t.Fatalf("call %d: allowed = %v, err = %v, want allowed", i+1, allowed, err)
+				}
+			}
+
+			allowed, retryAfter, err := allowOne(ctx, limiter, "room:1", limit)
+			if err != nil || allowed {
+				t.Fatalf("call after burst: allowed = %v, err = %v, want rejected", allowed, err)
+			}
+			if retryAfter <= 0 || retryAfter > 2*time.Second {
+				t.Errorf("retry after = %s, want (0, 2s]", retryAfter)
+			}
+
+			// other key has it's own bucket
+			if allowed, _, err := allowOne(ctx, limiter, "room:2", limit); err != nil || !allowed {
+				t.Errorf("other key: allowed = %v, err = %v, want allowed", allowed, err)
+			}
+			if allowed, _, err := allowOne(ctx, limiter, "room:1", ports.RateLimit{}); err != nil || !allowed {
+				t.Errorf("unlimited: allowed = %v, err = %v, want allowed", allowed, err)
+			}
+		})
+	}
+}
+
+func TestInMemoryRateLimiterRefillsAndForgetsIdleBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewInMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+	limiter.lastSweep = now
+	limit := ports.RateLimit{PerSecond: 10, Burst: 1}
+
+	if allowed, _, _ := allowOne(context.Background(), limiter, "user:1", limit); !allowed {
+		t.Fatal("first call is rejected")
+	}
+	if allowed, retryAfter, _ := allowOne(context.Background(), limiter, "user:1", limit); allowed || retryAfter != 100*time.Millisecond {
+		t.Fatalf("allowed = %v, retry after = %s, want rejected for 100ms", allowed, retryAfter)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if allowed, _, _ := allowOne(context.Background(), limiter, "user:1", limit); !allowed {
+		t.Fatal("call after refill is rejected")
+	}
+
+	now = now.Add(idleSweepInterval)
+	allowOne(context.Background(), limiter, "user:2", limit)
+	if _, ok := limiter.buckets["user:1"]; ok {
+		t.Error("idle full bucket isn't forgotten")
+	}
+}
+
+func TestRedisRateLimiterRefillsByRedisTime(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	limiter := NewRedisRateLimiter(client)
+	limit := ports.RateLimit{PerSecond: 10, Burst: 1}
+	ctx := context.Background()
+
+	// clock of Redis is far from the clock of this instance
+	now := time.Unix(1000, 0)
+	server.SetTime(now)
+	if allowed, _, err := allowOne(ctx, limiter, "user:1", limit); err != nil || !allowed {
+		t.Fatalf("first call: allowed = %v, err = %v, want allowed", allowed, err)
+	}
+	if allowed, retryAfter, err := allowOne(ctx, limiter, "user:1", limit); err != nil || allowed || retryAfter != 100*time.Millisecond {
+		t.Fatalf("allowed = %v, retry after = %s, err = %v, want rejected for 100ms", allowed, retryAfter, err)
+	}
+
+	server.SetTime(now.Add(100 * time.Millisecond))
+	if allowed, _, err := allowOne(ctx, limiter, "user:1", limit); err != nil || !allowed {
+		t.Fatalf("call after refill: allowed = %v, err = %v, want allowed", allowed, err)
+	}
+}
+
+func TestRateLimitersTakeTokensFromAllBucketsOrNone(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	limiters := map[string]ports.RateLimiter{
+		"in_memory": NewInMemoryRateLimiter(),
+		"redis":     NewRedisRateLimiter(client),
+	}
+	stream := ports.RateLimitBucket{Key: "stream:1", Limit: ports.RateLimit{PerSecond: 0.001, Burst: 2}}
+	unlimited := ports.RateLimitBucket{Key: "user:1"}
+	room := ports.RateLimitBucket{Key: "room:1", Limit: ports.RateLimit{PerSecond: 0.001, Burst: 1}}
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			buckets := []ports.RateLimitBucket{stream, unlimited, room}
+			if allowed, _, _, err := limiter.Allow(ctx, buckets); err != nil || !allowed {
+				t.Fatalf("first call: allowed = %v, err = %v, want allowed", allowed, err)
+			}
+
+			allowed, denied, retryAfter, err := limiter.Allow(ctx, buckets)
+			if err != nil || allowed || denied != 2 || retryAfter <= 0 {
+				t.Fatalf("allowed = %v, denied = %d, retry after = %s, err = %v, want room bucket denied", allowed, denied, retryAfter, err)
+			}
+
+			// denied call took nothing from the stream bucket, it still has the second token
+			if allowed, _, err := allowOne(ctx, limiter, stream.Key, stream.Limit); err != nil || !allowed {
+				t.Fatalf("stream bucket after denied call: allowed = %v, err = %v, want allowed", allowed, err)
+			}
+			if allowed, _, err := allowOne(ctx, limiter, stream.Key, stream.Limit); err != nil || allowed {
+				t.Fatalf("stream bucket after 2 tokens: allowed = %v, err = %v, want rejected", allowed, err)
+			}
+			if allowed, _, _, err := limiter.Allow(ctx, []ports.RateLimitBucket{unlimited}); err != nil || !allowed {
+				t.Fatalf("unlimited buckets only: allowed = %v, err = %v, want allowed", allowed, err)
+			}
+		})
+	}
+}
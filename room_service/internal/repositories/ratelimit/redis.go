@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// tokenBucketScript - refill buckets and take one token from each atomically, all of them or none
+//
+// KEYS - bucket hashes {tokens, updated_at}; ARGV - per second and burst of every key in turn
+//
+// now is Redis TIME (ms), so instances with different clocks refill buckets the same way
+//
+// returns {allowed (0/1), denied bucket (1-based, 0 if allowed), retry after (ms)},
+// bucket expires when it's full again. Buckets are changed only if every one has a token,
+// state of a denied call isn't stored: refill depends only on updated_at
+var tokenBucketScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local tokens = {}
+local denied = 0
+local retryAfter = 0
+for i, key in ipairs(KEYS) do
+	local perSecond = tonumber(ARGV[i * 2 - 1])
+	local burst = tonumber(ARGV[i * 2])
+	local state = redis.call('HMGET', key, 'tokens', 'updated_at')
+	local current = tonumber(state[1])
+	local updatedAt = tonumber(state[2])
+	if current == nil or updatedAt == nil then
+		current = burst
+		updatedAt = now
+	end
+	current = math.min(burst, current + math.max(0, now - updatedAt) * perSecond / 1000)
+	tokens[i] = current
+	if current < 1 then
+		if denied == 0 then
+			denied = i
+		end
+		retryAfter = math.max(retryAfter, math.ceil((1 - current) * 1000 / perSecond))
+	end
+end
+if denied > 0 then
+	return {0, denied, retryAfter}
+end
+
+for i, key in ipairs(KEYS) do
+	local perSecond = tonumber(ARGV[i * 2 - 1])
+	local burst = tonumber(ARGV[i * 2])
+	local left = tokens[i] - 1
+	redis.call('HMSET', key, 'tokens', tostring(left), 'updated_at', tostring(now))
+	redis.call('PEXPIRE', key, math.ceil((burst - left) * 1000 / perSecond) + 1000)
+end
+return {1, 0, 0}
+`)
+
+// RedisRateLimiter - impl of ports.RateLimiter with buckets in Redis, limits are shared by all instances
+//
+// buckets are refilled by time of Redis, clocks of instances don't matter
+type RedisRateLimiter struct {
+	client *redis.Client
+}
+
+// NewRedisRateLimiter - create new RedisRateLimiter
+func NewRedisRateLimiter(client *redis.Client) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client}
+}
+
+// Allow - run token bucket script on limited buckets in one call
+//
+// keys of all buckets must be in one Redis node (this limiter doesn't work with Redis Cluster)
+func (s *RedisRateLimiter) Allow(ctx context.Context, buckets []ports.RateLimitBucket) (bool, int, time.Duration, error) {
+	keys := make([]string, 0, len(buckets))
+	args := make([]any, 0, 2*len(buckets))
+	// indexes - index in buckets of every key
+	indexes := make([]int, 0, len(buckets))
+	for i, bucket := range buckets {
+		if bucket.Limit.Unlimited() {
+			continue
+		}
+		keys = append(keys, s.generateKey(bucket.Key))
+		args = append(args, bucket.Limit.PerSecond, max(bucket.Limit.Burst, 1))
+		indexes = append(indexes, i)
+	}
+	if len(keys) == 0 {
+		return true, 0, 0, nil
+	}
+
+	result, err := tokenBucketScript.Run(ctx, s.client, keys, args...).Int64Slice()
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("error running rate limit script in redis: %w", err)
+	}
+	if len(result) != 3 {
+		return false, 0, 0, fmt.Errorf("unexpected rate limit script result: %v", result)
+	}
+	if result[0] == 1 {
+		return true, 0, 0, nil
+	}
+	if result[1] < 1 || result[1] > int64(len(indexes)) {
+		return false, 0, 0, fmt.Errorf("unexpected denied bucket in rate limit script result: %v", result)
+	}
+	return false, indexes[result[1]-1], time.Duration(result[2]) * time.Millisecond, nil
+}
+
+func (s *RedisRateLimiter) generateKey(key string) string {
+	return fmt.Sprintf("rate_limit_%s", key)
+}
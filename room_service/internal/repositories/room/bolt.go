@@ -0,0 +1,376 @@
+package room
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucket and key names of BoltRepository
+var (
+	boltRoomsBucket  = []byte("rooms")
+	boltOwnersBucket = []byte("room_owners")
+	boltMetaKey      = []byte("meta")
+	boltDataBucket   = []byte("data")
+	boltUsersBucket  = []byte("users")
+)
+
+// BoltRepository - ports.RoomsPort impl with embedded BoltDB file, for single-node deployments
+//
+// Every room is a bucket in "rooms" bucket: "meta" key (owner, options JSON), "data" bucket (key -> models.Value JSON)
+// and "users" bucket (user ID -> name, metadata JSON). "room_owners" bucket has a bucket of room IDs per owner.
+// Every method is one transaction, so writes are atomic and durable (file is synced on commit)
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+// boltRoomMeta - JSON of "meta" key
+type boltRoomMeta struct {
+	Owner   string            `json:"owner"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// NewBoltRepository - return new BoltRepository, buckets are created if db is new
+//
+// db can be shared with other stores (e.g. commandcache.BoltCommandCache), they use their own buckets
+func NewBoltRepository(db *bolt.DB) (*BoltRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltRoomsBucket, boltOwnersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating rooms buckets in bolt: %w", err)
+	}
+	return &BoltRepository{db: db}, nil
+}
+
+// CreateRoom - create room in BoltDB
+//
+// Create ID yourself, ID is taken -> errors.ErrRoomIDAlreadyExists
+func (s *BoltRepository) CreateRoom(_ context.Context, params *models.Room) (*models.Room, error) {
+	meta, err := json.Marshal(&boltRoomMeta{Owner: params.OwnerUserID.String(), Options: params.Options})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding room meta: %w", err)
+	}
+	roomID := []byte(params.ID.String())
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		room, err := tx.Bucket(boltRoomsBucket).CreateBucket(roomID)
+		if errors.Is(err, bolt.ErrBucketExists) {
+			return roomerrors.ErrRoomIDAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		if err = room.Put(boltMetaKey, meta); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{boltDataBucket, boltUsersBucket} {
+			if _, err = room.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+
+		owned, err := tx.Bucket(boltOwnersBucket).CreateBucketIfNotExists([]byte(params.OwnerUserID.String()))
+		if err != nil {
+			return err
+		}
+		return owned.Put(roomID, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating room in bolt: %w", err)
+	}
+	return params, nil
+}
+
+// DeleteRoom - delete room from BoltDB with all data inside
+//
+// Not found -> errors.ErrRoomDoesntExist
+// User isn't room owner -> errors.ErrNotRoomOwner
+func (s *BoltRepository) DeleteRoom(_ context.Context, params ports.DeleteRoomParams) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		_, meta, err := boltRoom(tx, params.RoomID)
+		if err != nil {
+			return err
+		}
+		if meta.Owner != params.UserID.String() {
+			return roomerrors.ErrNotRoomOwner
+		}
+		roomID := []byte(params.RoomID.String())
+		if owned := tx.Bucket(boltOwnersBucket).Bucket([]byte(meta.Owner)); owned != nil {
+			if err = owned.Delete(roomID); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltRoomsBucket).DeleteBucket(roomID)
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting room in bolt: %w", err)
+	}
+	return nil
+}
+
+// JoinRoom - add user to room in BoltDB, user's name and metadata are updated if one is already there
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *BoltRepository) JoinRoom(_ context.Context, params ports.JoinRoomParams) error {
+	user, err := json.Marshal(&storedUser{Name: params.UserFull.Name.String(), Metadata: params.UserFull.Metadata})
+	if err != nil {
+		return fmt.Errorf("error encoding user: %w", err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		room, _, err := boltRoom(tx, params.RoomID)
+		if err != nil {
+			return err
+		}
+		return room.Bucket(boltUsersBucket).Put([]byte(params.UserFull.ID.String()), user)
+	})
+	if err != nil {
+		return fmt.Errorf("error joining room in bolt: %w", err)
+	}
+	return nil
+}
+
+// CountOwnedRooms - count rooms whose owner is given user (BoltDB)
+func (s *BoltRepository) CountOwnedRooms(_ context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	count := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if owned := tx.Bucket(boltOwnersBucket).Bucket([]byte(params.OwnerUserID.String())); owned != nil {
+			count = owned.Stats().KeyN
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error counting owned rooms in bolt: %w", err)
+	}
+	return count, nil
+}
+
+// IsRoomOwner - check if room's owner is given user (BoltDB)
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *BoltRepository) IsRoomOwner(_ context.Context, params ports.IsRoomOwnerParams) (bool, error) {
+	isOwner := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, meta, err := boltRoom(tx, params.RoomID)
+		if err != nil {
+			return err
+		}
+		isOwner = meta.Owner == params.UserID.String()
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error checking room owner in bolt: %w", err)
+	}
+	return isOwner, nil
+}
+
+// LeaveRoom - remove user from room (BoltDB), only the user or room owner can do it
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// User not found -> errors.ErrUserNotInRoom
+// Another user is kicked not by room owner -> errors.ErrNotRoomOwner
+func (s *BoltRepository) LeaveRoom(_ context.Context, param ports.LeaveRoomParams) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		room, meta, err := boltRoom(tx, param.RoomID)
+		if err != nil {
+			return err
+		}
+		if param.CommandCallerUserID != param.KickedUserID && param.CommandCallerUserID.String() != meta.Owner {
+			return roomerrors.ErrNotRoomOwner
+		}
+		users := room.Bucket(boltUsersBucket)
+		kicked := []byte(param.KickedUserID.String())
+		if users.Get(kicked) == nil {
+			return roomerrors.ErrUserNotInRoom
+		}
+		return users.Delete(kicked)
+	})
+	if err != nil {
+		return fmt.Errorf("error leaving room in bolt: %w", err)
+	}
+	return nil
+}
+
+// RoomSnapshot - return a whole sight on room - ownerID, room data KV, roomID... (BoltDB)
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *BoltRepository) RoomSnapshot(_ context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	var snapshot *models.RoomSnapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		room, meta, err := boltRoom(tx, params.RoomID)
+		if err != nil {
+			return err
+		}
+		snapshot = &models.RoomSnapshot{
+			Room:   &models.Room{ID: params.RoomID, OwnerUserID: types.NotEmptyText(meta.Owner), Options: meta.Options},
+			Users:  make([]*models.User, 0),
+			Values: make(map[string]models.Value),
+		}
+
+		err = room.Bucket(boltDataBucket).ForEach(func(key, raw []byte) error {
+			var value models.Value
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("error decoding value '%s': %w", key, err)
+			}
+			snapshot.Values[string(key)] = value
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// keys are sorted, so users are ordered by ID
+		return room.Bucket(boltUsersBucket).ForEach(func(userID, raw []byte) error {
+			var user storedUser
+			if err := json.Unmarshal(raw, &user); err != nil {
+				return fmt.Errorf("error decoding user '%s': %w", userID, err)
+			}
+			snapshot.Users = append(snapshot.Users, &models.User{
+				ID:       types.NotEmptyText(userID),
+				Name:     types.NotEmptyText(user.Name),
+				Metadata: user.Metadata,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading room from bolt: %w", err)
+	}
+	return snapshot, nil
+}
+
+// AffectData - set/delete whole data field or append/remove list item (BoltDB), in one transaction
+//
+// APPEND to value that isn't a list replaces it with list of one item, see models.Value.Appended
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// Data not found (DELETE, REMOVE of missing key or item) -> errors.ErrDataPieceDoesntExist
+func (s *BoltRepository) AffectData(_ context.Context, params ports.AffectDataParams) error {
+	key := []byte(params.DataID.String())
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		room, _, err := boltRoom(tx, params.RoomID)
+		if err != nil {
+			return err
+		}
+		data := room.Bucket(boltDataBucket)
+
+		var current *models.Value
+		if raw := data.Get(key); raw != nil {
+			current = &models.Value{}
+			if err = json.Unmarshal(raw, current); err != nil {
+				return fmt.Errorf("error decoding value '%s': %w", key, err)
+			}
+		}
+
+		var next *models.Value
+		switch params.Action {
+		case ports.ActionSet:
+			next = params.Value
+		case ports.ActionDelete:
+			if current == nil {
+				return roomerrors.ErrDataPieceDoesntExist
+			}
+			return data.Delete(key)
+		case ports.ActionAppend:
+			next = current.Appended(*params.Value)
+		case ports.ActionRemove:
+			var removed bool
+			if next, removed = current.Removed(params.Value); !removed {
+				return roomerrors.ErrDataPieceDoesntExist
+			}
+		default:
+			return fmt.Errorf("unknown data action: %d", params.Action)
+		}
+
+		encoded, err := json.Marshal(next)
+		if err != nil {
+			return fmt.Errorf("error encoding value: %w", err)
+		}
+		return data.Put(key, encoded)
+	})
+	if err != nil {
+		return fmt.Errorf("error affecting data in bolt: %w", err)
+	}
+	return nil
+}
+
+// ReplaceRoom - make users and data of room equal to given ones (BoltDB), in one transaction
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *BoltRepository) ReplaceRoom(_ context.Context, params ports.ReplaceRoomParams) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		room, _, err := boltRoom(tx, params.RoomID)
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{boltDataBucket, boltUsersBucket} {
+			if err = room.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		data, err := room.CreateBucket(boltDataBucket)
+		if err != nil {
+			return err
+		}
+		users, err := room.CreateBucket(boltUsersBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range params.Users {
+			encoded, err := json.Marshal(&storedUser{Name: user.Name.String(), Metadata: user.Metadata})
+			if err != nil {
+				return fmt.Errorf("error encoding user: %w", err)
+			}
+			if err = users.Put([]byte(user.ID.String()), encoded); err != nil {
+				return err
+			}
+		}
+		for key, value := range params.Values {
+			encoded, err := json.Marshal(&value)
+			if err != nil {
+				return fmt.Errorf("error encoding value: %w", err)
+			}
+			if err = data.Put([]byte(key), encoded); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error replacing room in bolt: %w", err)
+	}
+	return nil
+}
+
+// Ping - check that BoltDB file is open
+func (s *BoltRepository) Ping(_ context.Context) error {
+	if err := s.db.View(func(*bolt.Tx) error { return nil }); err != nil {
+		return fmt.Errorf("error accessing bolt: %w", err)
+	}
+	return nil
+}
+
+// boltRoom - bucket and meta of room, not found -> errors.ErrRoomDoesntExist
+func boltRoom(tx *bolt.Tx, roomID models.RoomID) (*bolt.Bucket, *boltRoomMeta, error) {
+	room := tx.Bucket(boltRoomsBucket).Bucket([]byte(roomID.String()))
+	if room == nil {
+		return nil, nil, roomerrors.ErrRoomDoesntExist
+	}
+	meta := &boltRoomMeta{}
+	if err := json.Unmarshal(room.Get(boltMetaKey), meta); err != nil {
+		return nil, nil, fmt.Errorf("error decoding room meta: %w", err)
+	}
+	return room, meta, nil
+}
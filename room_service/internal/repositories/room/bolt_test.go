@@ -0,0 +1,93 @@
+package room
+
+import (
+	"context"
+	"errors"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func openTestBolt(t *testing.T, path string) (*bolt.DB, *BoltRepository) {
+	t.Helper()
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("open bolt: %v", err)
+	}
+	repo, err := NewBoltRepository(db)
+	if err != nil {
+		t.Fatalf("NewBoltRepository: %v", err)
+	}
+	return db, repo
+}
+
+func TestBoltRepositorySurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rooms.db")
+	db, repo := openTestBolt(t, path)
+
+	room := models.NewRoom("owner", map[string]string{"max_users": "10"})
+	if _, err := repo.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if _, err := repo.CreateRoom(ctx, room); !errors.Is(err, roomerrors.ErrRoomIDAlreadyExists) {
+		t.Fatalf("CreateRoom of the same ID: got %v, want ErrRoomIDAlreadyExists", err)
+	}
+	if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: room.ID, UserFull: models.User{ID: "guest", Name: "Guest", Metadata: map[string]string{"avatar": "1"}}}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	for _, params := range []ports.AffectDataParams{
+		{RoomID: room.ID, DataID: "list", Action: ports.ActionAppend, Value: models.IntValue(1)},
+		{RoomID: room.ID, DataID: "list", Action: ports.ActionAppend, Value: models.StrValue("two")},
+		{RoomID: room.ID, DataID: "list", Action: ports.ActionRemove, Value: models.IntValue(1)},
+		{RoomID: room.ID, DataID: "bytes", Action: ports.ActionSet, Value: models.BytesValue([]byte{0, 255})},
+	} {
+		if err := repo.AffectData(ctx, params); err != nil {
+			t.Fatalf("AffectData(%s): %v", params.DataID, err)
+		}
+	}
+	if err := repo.AffectData(ctx, ports.AffectDataParams{RoomID: room.ID, DataID: "list", Action: ports.ActionRemove, Value: models.IntValue(1)}); !errors.Is(err, roomerrors.ErrDataPieceDoesntExist) {
+		t.Fatalf("remove of missing item: got %v, want ErrDataPieceDoesntExist", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close bolt: %v", err)
+	}
+
+	db, repo = openTestBolt(t, path)
+	defer db.Close()
+	snapshot, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot after restart: %v", err)
+	}
+	if snapshot.Room.OwnerUserID != "owner" || snapshot.Room.Options["max_users"] != "10" {
+		t.Fatalf("room = %+v, want owner's room with options", snapshot.Room)
+	}
+	if len(snapshot.Users) != 1 || snapshot.Users[0].Name != "Guest" || snapshot.Users[0].Metadata["avatar"] != "1" {
+		t.Fatalf("users = %+v, want guest with metadata", snapshot.Users)
+	}
+	if value := snapshot.Values["list"]; !value.Equal(models.ListValue([]models.Value{*models.StrValue("two")})) {
+		t.Fatalf("list = %+v, want [two]", value)
+	}
+	if value := snapshot.Values["bytes"]; !value.Equal(models.BytesValue([]byte{0, 255})) {
+		t.Fatalf("bytes = %+v, want [0 255]", value)
+	}
+
+	if owned, err := repo.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: "owner"}); err != nil || owned != 1 {
+		t.Fatalf("CountOwnedRooms = %d, %v, want 1", owned, err)
+	}
+	if err = repo.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: room.ID, CommandCallerUserID: "stranger", KickedUserID: "guest"}); !errors.Is(err, roomerrors.ErrNotRoomOwner) {
+		t.Fatalf("kick by stranger: got %v, want ErrNotRoomOwner", err)
+	}
+	if err = repo.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: room.ID, UserID: "owner"}); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+	if err = repo.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: room.ID, UserID: "owner"}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Fatalf("DeleteRoom of deleted room: got %v, want ErrRoomDoesntExist", err)
+	}
+	if owned, err := repo.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: "owner"}); err != nil || owned != 0 {
+		t.Fatalf("CountOwnedRooms after delete = %d, %v, want 0", owned, err)
+	}
+}
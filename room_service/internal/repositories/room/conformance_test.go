@@ -0,0 +1,35 @@
+package room
+
+import (
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/ports/porttest"
+	"path/filepath"
+	"testing"
+)
+
+func TestInMemoryRepositoryConformance(t *testing.T) {
+	porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+		return NewInMemoryRepository()
+	})
+}
+
+func TestRedisRepositoryConformance(t *testing.T) {
+	porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+		repo, _ := newTestRedisRepository(t, 0)
+		return repo
+	})
+}
+
+func TestMongoDBRepositoryConformance(t *testing.T) {
+	porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+		return newTestMongoRepository(t)
+	})
+}
+
+func TestBoltRepositoryConformance(t *testing.T) {
+	porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+		db, repo := openTestBolt(t, filepath.Join(t.TempDir(), "rooms.db"))
+		t.Cleanup(func() { _ = db.Close() })
+		return repo
+	})
+}
@@ -0,0 +1,166 @@
+package room
+
+import (
+	"context"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"sync"
+)
+
+// InMemoryRepository - ports.RoomsPort impl in process memory, rooms are lost on restart
+//
+// For tests and development. One lock guards every room, so every method is atomic.
+// Snapshots are copies, callers can't change stored rooms through them
+type InMemoryRepository struct {
+	mu    sync.RWMutex
+	rooms map[models.RoomID]*roomState
+}
+
+// NewInMemoryRepository - return new empty InMemoryRepository
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{rooms: make(map[models.RoomID]*roomState)}
+}
+
+// CreateRoom - create room in memory
+//
+// Create ID yourself, ID is taken -> errors.ErrRoomIDAlreadyExists
+func (s *InMemoryRepository) CreateRoom(_ context.Context, params *models.Room) (*models.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[params.ID]; ok {
+		return nil, roomerrors.ErrRoomIDAlreadyExists
+	}
+	s.rooms[params.ID] = newRoomState(params)
+	return params, nil
+}
+
+// DeleteRoom - delete room from memory with all data inside
+//
+// Not found -> errors.ErrRoomDoesntExist
+// User isn't room owner -> errors.ErrNotRoomOwner
+func (s *InMemoryRepository) DeleteRoom(_ context.Context, params ports.DeleteRoomParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[params.RoomID]
+	if !ok {
+		return roomerrors.ErrRoomDoesntExist
+	}
+	if room.room.OwnerUserID != params.UserID {
+		return roomerrors.ErrNotRoomOwner
+	}
+	delete(s.rooms, params.RoomID)
+	return nil
+}
+
+// Evict - delete room from memory whoever its owner is, nothing happens if it doesn't exist
+func (s *InMemoryRepository) Evict(roomID models.RoomID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rooms, roomID)
+}
+
+// JoinRoom - add user to room in memory, user's name and metadata are updated if one is already there
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *InMemoryRepository) JoinRoom(_ context.Context, params ports.JoinRoomParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[params.RoomID]
+	if !ok {
+		return roomerrors.ErrRoomDoesntExist
+	}
+	room.join(params.UserFull)
+	return nil
+}
+
+// CountOwnedRooms - count rooms whose owner is given user (in memory)
+func (s *InMemoryRepository) CountOwnedRooms(_ context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	for _, room := range s.rooms {
+		if room.room.OwnerUserID == params.OwnerUserID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// IsRoomOwner - check if room's owner is given user (in memory)
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *InMemoryRepository) IsRoomOwner(_ context.Context, params ports.IsRoomOwnerParams) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	room, ok := s.rooms[params.RoomID]
+	if !ok {
+		return false, roomerrors.ErrRoomDoesntExist
+	}
+	return room.room.OwnerUserID == params.UserID, nil
+}
+
+// LeaveRoom - remove user from room (in memory), only the user or room owner can do it
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// User not found -> errors.ErrUserNotInRoom
+// Another user is kicked not by room owner -> errors.ErrNotRoomOwner
+func (s *InMemoryRepository) LeaveRoom(_ context.Context, param ports.LeaveRoomParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[param.RoomID]
+	if !ok {
+		return roomerrors.ErrRoomDoesntExist
+	}
+	return room.leave(param)
+}
+
+// RoomSnapshot - return a copy of room - ownerID, room data KV, roomID... (in memory), users are ordered by ID
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *InMemoryRepository) RoomSnapshot(_ context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	room, ok := s.rooms[params.RoomID]
+	if !ok {
+		return nil, roomerrors.ErrRoomDoesntExist
+	}
+
+	return room.snapshot(), nil
+}
+
+// AffectData - set/delete whole data field or append/remove list item (in memory)
+//
+// APPEND to value that isn't a list replaces it with list of one item, see models.Value.Appended
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// Data not found (DELETE, REMOVE of missing key or item) -> errors.ErrDataPieceDoesntExist
+func (s *InMemoryRepository) AffectData(_ context.Context, params ports.AffectDataParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[params.RoomID]
+	if !ok {
+		return roomerrors.ErrRoomDoesntExist
+	}
+
+	return room.affectData(params)
+}
+
+// ReplaceRoom - make users and data of room equal to given ones (in memory)
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *InMemoryRepository) ReplaceRoom(_ context.Context, params ports.ReplaceRoomParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[params.RoomID]
+	if !ok {
+		return roomerrors.ErrRoomDoesntExist
+	}
+	room.replace(params)
+	return nil
+}
+
+// Ping - always succeeds
+func (s *InMemoryRepository) Ping(_ context.Context) error {
+	return nil
+}
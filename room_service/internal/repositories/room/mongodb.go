@@ -2,21 +2,40 @@ package room
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
 	"github.com/chempik1234/room-service/internal/models"
 	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+	"hash/fnv"
+	"sync"
 )
 
 // MongoDBRepository - ports.RoomsPort impl with MongoDB
+//
+// Room is one document (see mongoRoomDocument), so every change of a room is atomic without transactions.
+// Changes are read-modify-write of the whole document: it's replaced only if its version is still the same,
+// otherwise the change is applied again to the newer document (written by another instance).
+// Changes of one room made by this instance are serialized by room's lock, so they don't retry because of each other
 type MongoDBRepository struct {
 	client          *mongo.Client
 	db              *mongo.Database
 	roomsCollection *mongo.Collection
+
+	locks [mongoLockStripes]sync.Mutex
 }
 
+// mongoLockStripes - rooms are spread over this many locks by ID hash
+const mongoLockStripes = 256
+
 // MongoRepoParams - params for initializing MongoDBRepository
 type MongoRepoParams struct {
 	Database       string
@@ -25,10 +44,34 @@ type MongoRepoParams struct {
 	ReadConcern    *readconcern.ReadConcern
 }
 
-// NewMongoDBRepository - return new MongoDBRepository
+// mongoRoomDocument - document of rooms collection
+//
+// options, metadata and data are lists of key-value pairs, because their keys may contain "." and "$".
+// Values are models.Value JSON (models.Value has no BSON form)
+type mongoRoomDocument struct {
+	ID      string      `bson:"_id"`
+	Owner   string      `bson:"owner"`
+	Options []mongoPair `bson:"options"`
+	Users   []mongoUser `bson:"users"`
+	Data    []mongoPair `bson:"data"`
+	Version int64       `bson:"version"`
+}
+
+type mongoPair struct {
+	Key   string `bson:"key"`
+	Value string `bson:"value"`
+}
+
+type mongoUser struct {
+	ID       string      `bson:"id"`
+	Name     string      `bson:"name"`
+	Metadata []mongoPair `bson:"metadata"`
+}
+
+// NewMongoDBRepository - return new MongoDBRepository, indexes are created if they don't exist
 //
 // roomCollectionName default = "rooms"
-func NewMongoDBRepository(client *mongo.Client, params MongoRepoParams) *MongoDBRepository {
+func NewMongoDBRepository(ctx context.Context, client *mongo.Client, params MongoRepoParams) (*MongoDBRepository, error) {
 	s := &MongoDBRepository{client: client}
 	s.db = client.Database(
 		params.Database,
@@ -38,65 +81,276 @@ func NewMongoDBRepository(client *mongo.Client, params MongoRepoParams) *MongoDB
 		params.RoomCollection = "rooms"
 	}
 	s.roomsCollection = s.db.Collection(params.RoomCollection)
-	return s
+
+	_, err := s.roomsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "owner", Value: 1}}})
+	if err != nil {
+		return nil, fmt.Errorf("error creating index of %s collection: %w", params.RoomCollection, err)
+	}
+	return s, nil
 }
 
 // CreateRoom - create room in MongoDB
 //
-// Create ID yourself
-func (s *MongoDBRepository) CreateRoom(ctx context.Context, params *models.Room) (room *models.Room, err error) {
-	//TODO implement me
-	panic("implement me")
+// Create ID yourself, ID is taken -> errors.ErrRoomIDAlreadyExists
+func (s *MongoDBRepository) CreateRoom(ctx context.Context, params *models.Room) (*models.Room, error) {
+	document, err := newMongoRoomDocument(newRoomState(params), 0)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.roomsCollection.InsertOne(ctx, document)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, roomerrors.ErrRoomIDAlreadyExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error inserting room into mongodb: %w", err)
+	}
+	return params, nil
 }
 
 // DeleteRoom - delete room from MongoDB with all data inside
 //
 // Not found -> errors.ErrRoomDoesntExist
-func (s *MongoDBRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) (err error) {
-	//TODO implement me
-	panic("implement me")
+// User isn't room owner -> errors.ErrNotRoomOwner
+func (s *MongoDBRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) error {
+	result, err := s.roomsCollection.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: params.RoomID.String()},
+		{Key: "owner", Value: params.UserID.String()},
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting room from mongodb: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		// room doesn't exist or has another owner
+		if _, err = s.IsRoomOwner(ctx, ports.IsRoomOwnerParams{RoomID: params.RoomID, UserID: params.UserID}); err != nil {
+			return err
+		}
+		return roomerrors.ErrNotRoomOwner
+	}
+	return nil
 }
 
-// JoinRoom - add user to room in MongoDB
+// JoinRoom - add user to room in MongoDB, user's name and metadata are updated if one is already there
 //
 // Not found -> errors.ErrRoomDoesntExist
-func (s *MongoDBRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) (err error) {
-	//TODO implement me
-	panic("implement me")
+func (s *MongoDBRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	return s.update(ctx, params.RoomID, func(state *roomState) error {
+		state.join(params.UserFull)
+		return nil
+	})
+}
+
+// CountOwnedRooms - count rooms whose owner is given user (MongoDB)
+func (s *MongoDBRepository) CountOwnedRooms(ctx context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	count, err := s.roomsCollection.CountDocuments(ctx, bson.D{{Key: "owner", Value: params.OwnerUserID.String()}})
+	if err != nil {
+		return 0, fmt.Errorf("error counting owned rooms in mongodb: %w", err)
+	}
+	return int(count), nil
 }
 
 // IsRoomOwner - check if room's owner is given user (MongoDB)
 //
 // Not found -> errors.ErrRoomDoesntExist
 func (s *MongoDBRepository) IsRoomOwner(ctx context.Context, params ports.IsRoomOwnerParams) (bool, error) {
-	//TODO implement me
-	panic("implement me")
+	var document mongoRoomDocument
+	err := s.roomsCollection.FindOne(ctx, bson.D{{Key: "_id", Value: params.RoomID.String()}},
+		options.FindOne().SetProjection(bson.D{{Key: "owner", Value: 1}})).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, roomerrors.ErrRoomDoesntExist
+	}
+	if err != nil {
+		return false, fmt.Errorf("error finding room in mongodb: %w", err)
+	}
+	return document.Owner == params.UserID.String(), nil
 }
 
-// LeaveRoom - remove user from room (MongoDB)
+// LeaveRoom - remove user from room (MongoDB), only the user or room owner can do it
 //
 // Room not found -> errors.ErrRoomDoesntExist
 // User not found -> errors.ErrUserNotInRoom
+// Another user is kicked not by room owner -> errors.ErrNotRoomOwner
 func (s *MongoDBRepository) LeaveRoom(ctx context.Context, param ports.LeaveRoomParams) error {
-	//TODO implement me
-	panic("implement me")
+	return s.update(ctx, param.RoomID, func(state *roomState) error {
+		return state.leave(param)
+	})
 }
 
-// RoomSnapshot - return a whole sight on room - ownerID, room data KV, roomID... (MongoDB)
+// RoomSnapshot - return a whole sight on room - ownerID, room data KV, roomID... (MongoDB), users are ordered by ID
 //
 // Room not found -> errors.ErrRoomDoesntExist
 func (s *MongoDBRepository) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
-	//TODO implement me
-	panic("implement me")
+	state, _, err := s.load(ctx, params.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	return state.snapshot(), nil
 }
 
-// AffectData - set/delete whole data field or item in dict/list (depends on what models.Value is stored)
+// AffectData - set/delete whole data field or append/remove list item (MongoDB)
 //
-// # The whole data storage is a KV storage that can store different values, including lists and dicts
+// APPEND to value that isn't a list replaces it with list of one item, see models.Value.Appended
 //
 // Room not found -> errors.ErrRoomDoesntExist
-// Data not found -> errors.ErrDataPieceDoesntExist
+// Data not found (DELETE, REMOVE of missing key or item) -> errors.ErrDataPieceDoesntExist
 func (s *MongoDBRepository) AffectData(ctx context.Context, params ports.AffectDataParams) error {
-	//TODO implement me
-	panic("implement me")
+	return s.update(ctx, params.RoomID, func(state *roomState) error {
+		return state.affectData(params)
+	})
+}
+
+// ReplaceRoom - make users and data of room equal to given ones (MongoDB), the document is replaced at once
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *MongoDBRepository) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	return s.update(ctx, params.RoomID, func(state *roomState) error {
+		state.replace(params)
+		return nil
+	})
+}
+
+// Ping - ping MongoDB primary
+func (s *MongoDBRepository) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx, readpref.Primary()); err != nil {
+		return fmt.Errorf("error pinging mongodb: %w", err)
+	}
+	return nil
+}
+
+// load - find room document and decode it
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *MongoDBRepository) load(ctx context.Context, roomID models.RoomID) (*roomState, int64, error) {
+	var document mongoRoomDocument
+	err := s.roomsCollection.FindOne(ctx, bson.D{{Key: "_id", Value: roomID.String()}}).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, 0, roomerrors.ErrRoomDoesntExist
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error finding room in mongodb: %w", err)
+	}
+	state, err := document.state(roomID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return state, document.Version, nil
+}
+
+// mongoUpdateAttempts - update gives up if room is changed concurrently between read and replace this many times
+const mongoUpdateAttempts = 8
+
+// errMongoConflict - update gave up, see mongoUpdateAttempts
+var errMongoConflict = errors.New("room is changed concurrently, too many attempts")
+
+// update - apply change to room and replace its document if nobody changed it since it was read, repeat otherwise
+//
+// change error is returned as is, nothing is written then. Not found -> errors.ErrRoomDoesntExist.
+// Ctx is done or room is changed concurrently mongoUpdateAttempts times -> error, nothing is written then
+func (s *MongoDBRepository) update(ctx context.Context, roomID models.RoomID, change func(state *roomState) error) error {
+	lock := s.lock(roomID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for range mongoUpdateAttempts {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("error updating room in mongodb: %w", err)
+		}
+		state, version, err := s.load(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		if err = change(state); err != nil {
+			return err
+		}
+		document, err := newMongoRoomDocument(state, version+1)
+		if err != nil {
+			return err
+		}
+
+		result, err := s.roomsCollection.ReplaceOne(ctx,
+			bson.D{{Key: "_id", Value: document.ID}, {Key: "version", Value: version}}, document)
+		if err != nil {
+			return fmt.Errorf("error replacing room in mongodb: %w", err)
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+		// changed (or deleted) concurrently -> read it again
+	}
+	return fmt.Errorf("error updating room in mongodb: %w", errMongoConflict)
+}
+
+func (s *MongoDBRepository) lock(roomID models.RoomID) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(roomID.String()))
+	return &s.locks[hash.Sum32()%mongoLockStripes]
+}
+
+func newMongoRoomDocument(state *roomState, version int64) (*mongoRoomDocument, error) {
+	document := &mongoRoomDocument{
+		ID:      state.room.ID.String(),
+		Owner:   state.room.OwnerUserID.String(),
+		Options: mongoPairs(state.room.Options),
+		Users:   make([]mongoUser, 0, len(state.users)),
+		Data:    make([]mongoPair, 0, len(state.values)),
+		Version: version,
+	}
+	for _, user := range state.users {
+		document.Users = append(document.Users, mongoUser{
+			ID:       user.ID.String(),
+			Name:     user.Name.String(),
+			Metadata: mongoPairs(user.Metadata),
+		})
+	}
+	for key, value := range state.values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding value '%s': %w", key, err)
+		}
+		document.Data = append(document.Data, mongoPair{Key: key, Value: string(encoded)})
+	}
+	return document, nil
+}
+
+// state - decode document into roomState
+func (d *mongoRoomDocument) state(roomID models.RoomID) (*roomState, error) {
+	state := newRoomState(&models.Room{
+		ID:          roomID,
+		OwnerUserID: types.NotEmptyText(d.Owner),
+		Options:     mongoMap(d.Options),
+	})
+	for _, user := range d.Users {
+		state.users[types.NotEmptyText(user.ID)] = models.User{
+			ID:       types.NotEmptyText(user.ID),
+			Name:     types.NotEmptyText(user.Name),
+			Metadata: mongoMap(user.Metadata),
+		}
+	}
+	for _, pair := range d.Data {
+		var value models.Value
+		if err := json.Unmarshal([]byte(pair.Value), &value); err != nil {
+			return nil, fmt.Errorf("error decoding value '%s' of room %s: %w", pair.Key, d.ID, err)
+		}
+		state.values[pair.Key] = value
+	}
+	return state, nil
+}
+
+func mongoPairs(m map[string]string) []mongoPair {
+	pairs := make([]mongoPair, 0, len(m))
+	for key, value := range m {
+		pairs = append(pairs, mongoPair{Key: key, Value: value})
+	}
+	return pairs
+}
+
+// mongoMap - map of pairs, nil if there are none
+func mongoMap(pairs []mongoPair) map[string]string {
+	if len(pairs) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		m[pair.Key] = pair.Value
+	}
+	return m
 }
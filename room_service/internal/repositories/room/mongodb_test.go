@@ -0,0 +1,111 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/mongotest"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"slices"
+	"testing"
+)
+
+func newTestMongoRepository(t *testing.T) *MongoDBRepository {
+	t.Helper()
+	client := mongotest.NewClient(t)
+	return openTestMongoRepository(t, client, mongotest.NewDatabase(t, client))
+}
+
+func openTestMongoRepository(t *testing.T, client *mongo.Client, database string) *MongoDBRepository {
+	t.Helper()
+	repo, err := NewMongoDBRepository(context.Background(), client, MongoRepoParams{Database: database})
+	if err != nil {
+		t.Fatalf("NewMongoDBRepository: %v", err)
+	}
+	return repo
+}
+
+func TestMongoDBRepositoryRetriesChangeOfConcurrentlyWrittenRoom(t *testing.T) {
+	ctx := context.Background()
+	client := mongotest.NewClient(t)
+	database := mongotest.NewDatabase(t, client)
+	// repositories of two instances, they don't share locks
+	local, other := openTestMongoRepository(t, client, database), openTestMongoRepository(t, client, database)
+
+	room := models.NewRoom("owner", nil)
+	if _, err := local.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	calls := 0
+	err := local.update(ctx, room.ID, func(state *roomState) error {
+		calls++
+		if calls == 1 {
+			// the other instance changes the room after it's read here
+			if err := other.JoinRoom(ctx, ports.JoinRoomParams{RoomID: room.ID, UserFull: models.User{ID: "bob", Name: "Bob"}}); err != nil {
+				t.Fatalf("JoinRoom of the other instance: %v", err)
+			}
+		}
+		state.join(models.User{ID: "alice", Name: "Alice"})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("change is applied %d times, want 2: stale document isn't detected", calls)
+	}
+
+	snapshot, err := local.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	ids := make([]string, 0, len(snapshot.Users))
+	for _, user := range snapshot.Users {
+		ids = append(ids, user.ID.String())
+	}
+	if !slices.Equal(ids, []string{"alice", "bob"}) {
+		t.Fatalf("users = %v, want alice and bob: change of the other instance is lost", ids)
+	}
+}
+
+func TestMongoDBRepositoryGivesUpOnRoomChangedOnEveryAttempt(t *testing.T) {
+	ctx := context.Background()
+	client := mongotest.NewClient(t)
+	database := mongotest.NewDatabase(t, client)
+	local, other := openTestMongoRepository(t, client, database), openTestMongoRepository(t, client, database)
+
+	room := models.NewRoom("owner", nil)
+	if _, err := local.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	calls := 0
+	err := local.update(ctx, room.ID, func(state *roomState) error {
+		calls++
+		// the other instance changes the room after every read
+		if err := other.JoinRoom(ctx, ports.JoinRoomParams{RoomID: room.ID, UserFull: models.User{ID: "bob", Name: "Bob"}}); err != nil {
+			t.Fatalf("JoinRoom of the other instance: %v", err)
+		}
+		state.join(models.User{ID: "alice", Name: "Alice"})
+		return nil
+	})
+	if !errors.Is(err, errMongoConflict) {
+		t.Fatalf("update = %v, want errMongoConflict", err)
+	}
+	if calls != mongoUpdateAttempts {
+		t.Fatalf("change is applied %d times, want %d", calls, mongoUpdateAttempts)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	calls = 0
+	err = local.update(canceled, room.ID, func(state *roomState) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || calls != 0 {
+		t.Fatalf("update with canceled ctx = %v after %d changes, want context.Canceled before any", err, calls)
+	}
+}
@@ -0,0 +1,557 @@
+package room
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"slices"
+	"time"
+)
+
+// results of Lua scripts, see RedisRepository.runScript
+const (
+	scriptOK           = 1
+	scriptRoomNotFound = 0
+	scriptItemNotFound = -1
+	scriptNotRoomOwner = -2
+	scriptRoomExists   = -4
+)
+
+// redisTouchLua - prepended to every write script, refreshes TTL of room keys (KEYS[1..4]), ttl is the last ARGV
+//
+// owner's rooms set (KEYS[5] of create and delete scripts) doesn't expire
+const redisTouchLua = `
+local function touch()
+	local ttl = tonumber(ARGV[#ARGV])
+	if ttl > 0 then
+		for i = 1, 4 do
+			redis.call('PEXPIRE', KEYS[i], ttl)
+		end
+	end
+end
+`
+
+// redisListLua - functions of list value JSON ({"list":[...]}, see models.Value.MarshalJSON)
+//
+// Items are kept as exact JSON strings: cjson would decode numbers as doubles and change int64 values.
+// Go encodes equal values into equal JSON, so items are compared as strings
+const redisListLua = `
+local listPrefix = '{"list":['
+
+local function isList(value)
+	return value and string.sub(value, 1, #listPrefix) == listPrefix
+end
+
+-- listItems - JSON strings of top-level items of list value
+local function listItems(value)
+	local body = string.sub(value, #listPrefix + 1, -3)
+	local items, depth, start, inString, i = {}, 0, 1, false, 1
+	while i <= #body do
+		local c = string.byte(body, i)
+		if inString then
+			if c == 92 then
+				i = i + 1
+			elseif c == 34 then
+				inString = false
+			end
+		elseif c == 34 then
+			inString = true
+		elseif c == 123 or c == 91 then
+			depth = depth + 1
+		elseif c == 125 or c == 93 then
+			depth = depth - 1
+		elseif c == 44 and depth == 0 then
+			table.insert(items, string.sub(body, start, i - 1))
+			start = i + 1
+		end
+		i = i + 1
+	end
+	if #body > 0 then
+		table.insert(items, string.sub(body, start))
+	end
+	return items
+end
+
+local function listOf(items)
+	return listPrefix .. table.concat(items, ',') .. ']}'
+end
+`
+
+// room keys order of write scripts: meta, data, members, users, (create and delete) owner's rooms
+
+// ARGV: owner, options, room ID
+var redisCreateRoomScript = redis.NewScript(redisTouchLua + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return -4
+end
+redis.call('HSET', KEYS[1], 'owner', ARGV[1], 'options', ARGV[2])
+redis.call('SADD', KEYS[5], ARGV[3])
+touch()
+return 1
+`)
+
+// ARGV: caller, room ID
+var redisDeleteRoomScript = redis.NewScript(`
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if not owner then
+	return 0
+end
+if owner ~= ARGV[1] then
+	return -2
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4])
+redis.call('SREM', KEYS[5], ARGV[2])
+return 1
+`)
+
+var redisJoinRoomScript = redis.NewScript(redisTouchLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('SADD', KEYS[3], ARGV[1])
+redis.call('HSET', KEYS[4], ARGV[1], ARGV[2])
+touch()
+return 1
+`)
+
+// ARGV: caller, kicked user
+var redisLeaveRoomScript = redis.NewScript(redisTouchLua + `
+local owner = redis.call('HGET', KEYS[1], 'owner')
+if not owner then
+	return 0
+end
+if ARGV[1] ~= ARGV[2] and ARGV[1] ~= owner then
+	return -2
+end
+if redis.call('SREM', KEYS[3], ARGV[2]) == 0 then
+	return -1
+end
+redis.call('HDEL', KEYS[4], ARGV[2])
+touch()
+return 1
+`)
+
+var redisSetDataScript = redis.NewScript(redisTouchLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+touch()
+return 1
+`)
+
+var redisDeleteDataScript = redis.NewScript(redisTouchLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if redis.call('HDEL', KEYS[2], ARGV[1]) == 0 then
+	return -1
+end
+touch()
+return 1
+`)
+
+// ARGV: field, item JSON; value that isn't a list is replaced by list of item
+var redisAppendDataScript = redis.NewScript(redisTouchLua + redisListLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local current = redis.call('HGET', KEYS[2], ARGV[1])
+local items = {}
+if isList(current) then
+	items = listItems(current)
+end
+table.insert(items, ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[1], listOf(items))
+touch()
+return 1
+`)
+
+// ARGV: field, item JSON; every item equal to it is removed
+var redisRemoveDataScript = redis.NewScript(redisTouchLua + redisListLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local current = redis.call('HGET', KEYS[2], ARGV[1])
+if not isList(current) then
+	return -1
+end
+local items = listItems(current)
+local kept = {}
+for _, item in ipairs(items) do
+	if item ~= ARGV[2] then
+		table.insert(kept, item)
+	end
+end
+if #kept == #items then
+	return -1
+end
+redis.call('HSET', KEYS[2], ARGV[1], listOf(kept))
+touch()
+return 1
+`)
+
+// ARGV: amount of users, (user, user JSON) of every user, (field, value JSON) of every value
+var redisReplaceRoomScript = redis.NewScript(redisTouchLua + `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('DEL', KEYS[2], KEYS[3], KEYS[4])
+local i = 2
+for _ = 1, tonumber(ARGV[1]) do
+	redis.call('SADD', KEYS[3], ARGV[i])
+	redis.call('HSET', KEYS[4], ARGV[i], ARGV[i + 1])
+	i = i + 2
+end
+while i < #ARGV do
+	redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 1])
+	i = i + 2
+end
+touch()
+return 1
+`)
+
+// RedisRepository - ports.RoomsPort impl with Redis, for short-lived rooms
+//
+// Every room is stored in 4 keys with the same hash tag (room ID): meta hash (owner, options), data hash
+// (key -> models.Value JSON), members set (user IDs) and users hash (user ID -> name, metadata).
+// Every write is one Lua script, so it's atomic. Rooms expire after TTL without writes (native key expiry).
+//
+// Owners' room sets are changed by the create and delete scripts together with the room, so the storage needs
+// a single Redis node (not Cluster). They aren't expired, expired rooms are erased from them by CountOwnedRooms
+type RedisRepository struct {
+	client    *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// RedisRepoParams - params for initializing RedisRepository
+type RedisRepoParams struct {
+	// KeyPrefix - prefix of every key, default = "room"
+	KeyPrefix string
+	// TTL - room is deleted if it isn't written for TTL, 0 = rooms don't expire
+	TTL time.Duration
+}
+
+// storedUser - JSON of models.User in users hash (bucket), ID is the field (key)
+type storedUser struct {
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// NewRedisRepository - return new RedisRepository
+func NewRedisRepository(client *redis.Client, params RedisRepoParams) *RedisRepository {
+	if len(params.KeyPrefix) == 0 {
+		params.KeyPrefix = "room"
+	}
+	return &RedisRepository{
+		client:    client,
+		keyPrefix: params.KeyPrefix,
+		ttl:       params.TTL,
+	}
+}
+
+// CreateRoom - create room in Redis
+//
+// Create ID yourself, ID is taken -> errors.ErrRoomIDAlreadyExists
+func (s *RedisRepository) CreateRoom(ctx context.Context, params *models.Room) (*models.Room, error) {
+	options, err := json.Marshal(params.Options)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding room options: %w", err)
+	}
+	roomID := params.ID
+	err = s.runScriptWithKeys(ctx, redisCreateRoomScript, append(s.roomKeys(roomID), s.ownerKey(params.OwnerUserID)),
+		params.OwnerUserID.String(), options, roomID.String())
+	if err != nil {
+		return nil, fmt.Errorf("error creating room in redis: %w", err)
+	}
+	return params, nil
+}
+
+// DeleteRoom - delete room from Redis with all data inside, room is removed from owner's rooms by the same script
+//
+// Not found -> errors.ErrRoomDoesntExist
+// User isn't room owner -> errors.ErrNotRoomOwner
+func (s *RedisRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) error {
+	roomID := params.RoomID
+	err := s.runScriptWithKeys(ctx, redisDeleteRoomScript, append(s.roomKeys(roomID), s.ownerKey(params.UserID)),
+		params.UserID.String(), roomID.String())
+	if err != nil {
+		return fmt.Errorf("error deleting room in redis: %w", err)
+	}
+	return nil
+}
+
+// JoinRoom - add user to room in Redis, user's name and metadata are updated if one is already there
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *RedisRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	user, err := json.Marshal(&storedUser{Name: params.UserFull.Name.String(), Metadata: params.UserFull.Metadata})
+	if err != nil {
+		return fmt.Errorf("error encoding user: %w", err)
+	}
+	if err = s.runScript(ctx, redisJoinRoomScript, params.RoomID, params.UserFull.ID.String(), user); err != nil {
+		return fmt.Errorf("error joining room in redis: %w", err)
+	}
+	return nil
+}
+
+// CountOwnedRooms - count rooms whose owner is given user (Redis), expired rooms are erased from owner's set
+func (s *RedisRepository) CountOwnedRooms(ctx context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	ownerKey := s.ownerKey(params.OwnerUserID)
+	roomIDs, err := s.client.SMembers(ctx, ownerKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error reading owner's rooms from redis: %w", err)
+	}
+	if len(roomIDs) == 0 {
+		return 0, nil
+	}
+
+	exists := make([]*redis.IntCmd, len(roomIDs))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, roomID := range roomIDs {
+			exists[i] = pipe.Exists(ctx, s.metaKey(roomID))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error checking owner's rooms in redis: %w", err)
+	}
+
+	expired := make([]any, 0)
+	for i, roomID := range roomIDs {
+		if exists[i].Val() == 0 {
+			expired = append(expired, roomID)
+		}
+	}
+	if len(expired) > 0 {
+		if err = s.client.SRem(ctx, ownerKey, expired...).Err(); err != nil {
+			return 0, fmt.Errorf("error removing expired rooms from owner's rooms in redis: %w", err)
+		}
+	}
+	return len(roomIDs) - len(expired), nil
+}
+
+// IsRoomOwner - check if room's owner is given user (Redis)
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *RedisRepository) IsRoomOwner(ctx context.Context, params ports.IsRoomOwnerParams) (bool, error) {
+	owner, err := s.owner(ctx, params.RoomID)
+	if err != nil {
+		return false, err
+	}
+	return owner == params.UserID, nil
+}
+
+// LeaveRoom - remove user from room (Redis), only the user or room owner can do it
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// User not found -> errors.ErrUserNotInRoom
+// Another user is kicked not by room owner -> errors.ErrNotRoomOwner
+func (s *RedisRepository) LeaveRoom(ctx context.Context, param ports.LeaveRoomParams) error {
+	err := s.runScript(ctx, redisLeaveRoomScript, param.RoomID, param.CommandCallerUserID.String(), param.KickedUserID.String())
+	if errors.Is(err, errItemNotFound) {
+		return roomerrors.ErrUserNotInRoom
+	}
+	if err != nil {
+		return fmt.Errorf("error leaving room in redis: %w", err)
+	}
+	return nil
+}
+
+// RoomSnapshot - return a whole sight on room - ownerID, room data KV, roomID... (Redis)
+//
+// Keys are read in one transaction. Room not found -> errors.ErrRoomDoesntExist
+func (s *RedisRepository) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	keys := s.roomKeys(params.RoomID)
+	var meta, data, users *redis.StringStringMapCmd
+	var members *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		meta = pipe.HGetAll(ctx, keys[0])
+		data = pipe.HGetAll(ctx, keys[1])
+		members = pipe.SMembers(ctx, keys[2])
+		users = pipe.HGetAll(ctx, keys[3])
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading room from redis: %w", err)
+	}
+	owner, ok := meta.Val()["owner"]
+	if !ok {
+		return nil, roomerrors.ErrRoomDoesntExist
+	}
+
+	room := &models.Room{ID: params.RoomID, OwnerUserID: types.NotEmptyText(owner)}
+	if err = json.Unmarshal([]byte(meta.Val()["options"]), &room.Options); err != nil {
+		return nil, fmt.Errorf("error decoding room options: %w", err)
+	}
+
+	snapshot := &models.RoomSnapshot{
+		Room:   room,
+		Users:  make([]*models.User, 0, len(members.Val())),
+		Values: make(map[string]models.Value, len(data.Val())),
+	}
+	for key, raw := range data.Val() {
+		var value models.Value
+		if err = json.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, fmt.Errorf("error decoding value '%s': %w", key, err)
+		}
+		snapshot.Values[key] = value
+	}
+
+	memberIDs := members.Val()
+	slices.Sort(memberIDs)
+	for _, userID := range memberIDs {
+		var user storedUser
+		if err = json.Unmarshal([]byte(users.Val()[userID]), &user); err != nil {
+			return nil, fmt.Errorf("error decoding user '%s': %w", userID, err)
+		}
+		snapshot.Users = append(snapshot.Users, &models.User{
+			ID:       types.NotEmptyText(userID),
+			Name:     types.NotEmptyText(user.Name),
+			Metadata: user.Metadata,
+		})
+	}
+	return snapshot, nil
+}
+
+// AffectData - set/delete whole data field or append/remove list item (Redis)
+//
+// Every action is one script, APPEND and REMOVE change the list inside it (see redisListLua).
+// APPEND to value that isn't a list replaces it with list of one item, see models.Value.Appended
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// Data not found (DELETE, REMOVE of missing key or item) -> errors.ErrDataPieceDoesntExist
+func (s *RedisRepository) AffectData(ctx context.Context, params ports.AffectDataParams) error {
+	key := params.DataID.String()
+	var script *redis.Script
+	switch params.Action {
+	case ports.ActionSet:
+		script = redisSetDataScript
+	case ports.ActionDelete:
+		script = redisDeleteDataScript
+	case ports.ActionAppend:
+		script = redisAppendDataScript
+	case ports.ActionRemove:
+		script = redisRemoveDataScript
+	default:
+		return fmt.Errorf("unknown data action: %d", params.Action)
+	}
+
+	var err error
+	if params.Action == ports.ActionDelete {
+		err = s.runScript(ctx, script, params.RoomID, key)
+	} else {
+		var value []byte
+		if value, err = json.Marshal(params.Value); err != nil {
+			return fmt.Errorf("error encoding value: %w", err)
+		}
+		err = s.runScript(ctx, script, params.RoomID, key, value)
+	}
+
+	if errors.Is(err, errItemNotFound) {
+		return roomerrors.ErrDataPieceDoesntExist
+	}
+	if err != nil {
+		return fmt.Errorf("error affecting data in redis: %w", err)
+	}
+	return nil
+}
+
+// ReplaceRoom - make users and data of room equal to given ones (Redis), in one script
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *RedisRepository) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	args := make([]any, 0, 1+2*len(params.Users)+2*len(params.Values))
+	args = append(args, len(params.Users))
+	for _, user := range params.Users {
+		encoded, err := json.Marshal(&storedUser{Name: user.Name.String(), Metadata: user.Metadata})
+		if err != nil {
+			return fmt.Errorf("error encoding user: %w", err)
+		}
+		args = append(args, user.ID.String(), encoded)
+	}
+	for key, value := range params.Values {
+		encoded, err := json.Marshal(&value)
+		if err != nil {
+			return fmt.Errorf("error encoding value: %w", err)
+		}
+		args = append(args, key, encoded)
+	}
+
+	if err := s.runScript(ctx, redisReplaceRoomScript, params.RoomID, args...); err != nil {
+		return fmt.Errorf("error replacing room in redis: %w", err)
+	}
+	return nil
+}
+
+// Ping - PING Redis
+func (s *RedisRepository) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error pinging redis: %w", err)
+	}
+	return nil
+}
+
+// errItemNotFound - script result that is converted by callers
+var errItemNotFound = errors.New("item not found")
+
+// runScript - run write script with room keys, args and TTL, script's result is converted to error
+func (s *RedisRepository) runScript(ctx context.Context, script *redis.Script, roomID models.RoomID, args ...any) error {
+	return s.runScriptWithKeys(ctx, script, s.roomKeys(roomID), args...)
+}
+
+// runScriptWithKeys - runScript with room keys followed by other ones
+func (s *RedisRepository) runScriptWithKeys(ctx context.Context, script *redis.Script, keys []string, args ...any) error {
+	args = append(args, s.ttl.Milliseconds())
+	result, err := script.Run(ctx, s.client, keys, args...).Int()
+	if err != nil {
+		return err
+	}
+	switch result {
+	case scriptOK:
+		return nil
+	case scriptRoomNotFound:
+		return roomerrors.ErrRoomDoesntExist
+	case scriptItemNotFound:
+		return errItemNotFound
+	case scriptNotRoomOwner:
+		return roomerrors.ErrNotRoomOwner
+	case scriptRoomExists:
+		return roomerrors.ErrRoomIDAlreadyExists
+	default:
+		return fmt.Errorf("unexpected script result: %d", result)
+	}
+}
+
+// owner - owner of room, not found -> errors.ErrRoomDoesntExist
+func (s *RedisRepository) owner(ctx context.Context, roomID models.RoomID) (types.NotEmptyText, error) {
+	owner, err := s.client.HGet(ctx, s.roomKeys(roomID)[0], "owner").Result()
+	if errors.Is(err, redis.Nil) {
+		return "", roomerrors.ErrRoomDoesntExist
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading room owner from redis: %w", err)
+	}
+	return types.NotEmptyText(owner), nil
+}
+
+// roomKeys - meta, data, members and users keys of room, hash tag keeps them in one cluster slot
+func (s *RedisRepository) roomKeys(roomID models.RoomID) []string {
+	meta := s.metaKey(roomID.String())
+	return []string{meta, meta + ":data", meta + ":members", meta + ":users"}
+}
+
+func (s *RedisRepository) metaKey(roomID string) string {
+	return fmt.Sprintf("%s:{%s}", s.keyPrefix, roomID)
+}
+
+func (s *RedisRepository) ownerKey(ownerID types.NotEmptyText) string {
+	return fmt.Sprintf("%s_owner:%s", s.keyPrefix, ownerID.String())
+}
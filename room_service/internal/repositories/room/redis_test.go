@@ -0,0 +1,244 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRedisRepository(t *testing.T, ttl time.Duration) (*RedisRepository, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisRepository(client, RedisRepoParams{TTL: ttl}), server
+}
+
+func TestRedisRepositoryRoomLifecycle(t *testing.T) {
+	ctx := context.Background()
+	repo, server := newTestRedisRepository(t, 0)
+	room := models.NewRoom("owner", map[string]string{"max_users": "10"})
+
+	if _, err := repo.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if _, err := repo.CreateRoom(ctx, room); !errors.Is(err, roomerrors.ErrRoomIDAlreadyExists) {
+		t.Fatalf("CreateRoom of the same ID: got %v, want ErrRoomIDAlreadyExists", err)
+	}
+	if owned, err := repo.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: "owner"}); err != nil || owned != 1 {
+		t.Fatalf("CountOwnedRooms = %d, %v, want 1", owned, err)
+	}
+
+	for _, user := range []models.User{
+		{ID: "owner", Name: "Owner"},
+		{ID: "guest", Name: "Guest", Metadata: map[string]string{"avatar": "1"}},
+		{ID: "guest", Name: "Guest 2"},
+	} {
+		if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: room.ID, UserFull: user}); err != nil {
+			t.Fatalf("JoinRoom(%s): %v", user.ID, err)
+		}
+	}
+
+	if err := repo.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: room.ID, CommandCallerUserID: "guest", KickedUserID: "owner"}); !errors.Is(err, roomerrors.ErrNotRoomOwner) {
+		t.Fatalf("kick by guest: got %v, want ErrNotRoomOwner", err)
+	}
+	if err := repo.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: room.ID, CommandCallerUserID: "owner", KickedUserID: "stranger"}); !errors.Is(err, roomerrors.ErrUserNotInRoom) {
+		t.Fatalf("kick of stranger: got %v, want ErrUserNotInRoom", err)
+	}
+
+	snapshot, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	if snapshot.Room.OwnerUserID != "owner" || snapshot.Room.Options["max_users"] != "10" {
+		t.Fatalf("room = %+v, want owner's room with options", snapshot.Room)
+	}
+	if len(snapshot.Users) != 2 || snapshot.Users[0].ID != "guest" || snapshot.Users[0].Name != "Guest 2" || snapshot.Users[1].ID != "owner" {
+		t.Fatalf("users = %+v, want guest (rejoined as Guest 2) and owner", snapshot.Users)
+	}
+
+	if err = repo.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: room.ID, CommandCallerUserID: "owner", KickedUserID: "guest"}); err != nil {
+		t.Fatalf("kick by owner: %v", err)
+	}
+	if isOwner, err := repo.IsRoomOwner(ctx, ports.IsRoomOwnerParams{RoomID: room.ID, UserID: "guest"}); err != nil || isOwner {
+		t.Fatalf("IsRoomOwner(guest) = %v, %v, want false", isOwner, err)
+	}
+
+	if err = repo.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: room.ID, UserID: "owner"}); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+	// erased by the delete script, not by pruning of CountOwnedRooms
+	if server.Exists(repo.ownerKey("owner")) {
+		t.Fatalf("owner's rooms set still exists after delete")
+	}
+	if _, err = repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Fatalf("RoomSnapshot of deleted room: got %v, want ErrRoomDoesntExist", err)
+	}
+	if err = repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: room.ID, UserFull: models.User{ID: "guest"}}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Fatalf("JoinRoom of deleted room: got %v, want ErrRoomDoesntExist", err)
+	}
+	if owned, err := repo.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: "owner"}); err != nil || owned != 0 {
+		t.Fatalf("CountOwnedRooms after delete = %d, %v, want 0", owned, err)
+	}
+}
+
+func TestRedisRepositoryAffectData(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestRedisRepository(t, 0)
+	room := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	affect := func(dataID string, action ports.Action, value *models.Value) error {
+		return repo.AffectData(ctx, ports.AffectDataParams{RoomID: room.ID, DataID: types.AnyText(dataID), Action: action, Value: value})
+	}
+
+	nested := models.MapValue(map[string]models.Value{"bytes": *models.BytesValue([]byte{0, 1}), "big": *models.IntValue(1 << 62)})
+	steps := []struct {
+		name    string
+		dataID  string
+		action  ports.Action
+		value   *models.Value
+		wantErr error
+	}{
+		{name: "set map", dataID: "map", action: ports.ActionSet, value: nested},
+		{name: "set scalar", dataID: "list", action: ports.ActionSet, value: models.StrValue("not a list")},
+		{name: "append replaces scalar", dataID: "list", action: ports.ActionAppend, value: models.IntValue(1)},
+		{name: "append", dataID: "list", action: ports.ActionAppend, value: models.FloatValue(2.5)},
+		{name: "append to missing", dataID: "other", action: ports.ActionAppend, value: models.BoolValue(true)},
+		{name: "remove", dataID: "list", action: ports.ActionRemove, value: models.IntValue(1)},
+		{name: "remove missing item", dataID: "list", action: ports.ActionRemove, value: models.IntValue(1), wantErr: roomerrors.ErrDataPieceDoesntExist},
+		{name: "delete", dataID: "other", action: ports.ActionDelete},
+		{name: "delete missing", dataID: "other", action: ports.ActionDelete, wantErr: roomerrors.ErrDataPieceDoesntExist},
+	}
+	for _, step := range steps {
+		if err := affect(step.dataID, step.action, step.value); !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.wantErr)
+		}
+	}
+
+	snapshot, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	if len(snapshot.Values) != 2 {
+		t.Fatalf("values = %+v, want map and list", snapshot.Values)
+	}
+	if value := snapshot.Values["map"]; !value.Equal(nested) {
+		t.Fatalf("map = %+v, want %+v", value, nested)
+	}
+	if value := snapshot.Values["list"]; !value.Equal(models.ListValue([]models.Value{*models.FloatValue(2.5)})) {
+		t.Fatalf("list = %+v, want [2.5]", value)
+	}
+
+	if err = repo.AffectData(ctx, ports.AffectDataParams{RoomID: models.RoomID(types.GenerateUUID()), DataID: "x", Action: ports.ActionAppend, Value: models.IntValue(1)}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Fatalf("append in missing room: got %v, want ErrRoomDoesntExist", err)
+	}
+}
+
+func TestRedisRepositoryListItemsAreKeptExactly(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestRedisRepository(t, 0)
+	room := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	affect := func(action ports.Action, value *models.Value) error {
+		return repo.AffectData(ctx, ports.AffectDataParams{RoomID: room.ID, DataID: "list", Action: action, Value: value})
+	}
+
+	// separators inside strings and nested values must not split items, int64 must not be rounded to double
+	items := []models.Value{
+		*models.StrValue(`a,b]},{"x":"\\"`),
+		*models.ListValue([]models.Value{*models.IntValue(1), *models.StrValue("[")}),
+		*models.IntValue(1<<62 + 1),
+		*models.MapValue(map[string]models.Value{"k": *models.StrValue("}")}),
+	}
+	for _, item := range items {
+		if err := affect(ports.ActionAppend, &item); err != nil {
+			t.Fatalf("append %+v: %v", item, err)
+		}
+	}
+	if err := affect(ports.ActionRemove, models.IntValue(1<<62)); !errors.Is(err, roomerrors.ErrDataPieceDoesntExist) {
+		t.Fatalf("remove of close int: got %v, want ErrDataPieceDoesntExist", err)
+	}
+	if err := affect(ports.ActionRemove, &items[1]); err != nil {
+		t.Fatalf("remove nested list: %v", err)
+	}
+
+	snapshot, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	want := models.ListValue([]models.Value{items[0], items[2], items[3]})
+	if value := snapshot.Values["list"]; !value.Equal(want) {
+		t.Fatalf("list = %+v, want %+v", value, want)
+	}
+}
+
+func TestRedisRepositoryConcurrentAppends(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestRedisRepository(t, 0)
+	room := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.AffectData(ctx, ports.AffectDataParams{RoomID: room.ID, DataID: "list", Action: ports.ActionAppend, Value: models.IntValue(int64(i))})
+			if err != nil {
+				t.Errorf("append %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	if value := snapshot.Values["list"]; value.Len() != 8 {
+		t.Fatalf("list has %d items, want 8: appends were lost", value.Len())
+	}
+}
+
+func TestRedisRepositoryRoomExpires(t *testing.T) {
+	ctx := context.Background()
+	repo, server := newTestRedisRepository(t, time.Minute)
+	room := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, room); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	// writes extend room's life
+	server.FastForward(40 * time.Second)
+	if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: room.ID, UserFull: models.User{ID: "guest", Name: "Guest"}}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	server.FastForward(40 * time.Second)
+	if _, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID}); err != nil {
+		t.Fatalf("RoomSnapshot after write: %v", err)
+	}
+
+	server.FastForward(time.Minute)
+	if _, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: room.ID}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Fatalf("RoomSnapshot of expired room: got %v, want ErrRoomDoesntExist", err)
+	}
+	if server.Exists(repo.roomKeys(room.ID)[2]) {
+		t.Fatal("members set isn't expired with the room")
+	}
+	if owned, err := repo.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: "owner"}); err != nil || owned != 0 {
+		t.Fatalf("CountOwnedRooms after expiry = %d, %v, want 0", owned, err)
+	}
+}
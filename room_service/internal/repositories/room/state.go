@@ -0,0 +1,118 @@
+package room
+
+import (
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// roomState - whole room in process memory, storages that change a room at once (in memory, MongoDB)
+// apply ports.RoomsPort changes to it, so they follow the contract the same way
+type roomState struct {
+	room   models.Room
+	users  map[types.NotEmptyText]models.User
+	values map[string]models.Value
+}
+
+// newRoomState - empty room, options are copied
+func newRoomState(room *models.Room) *roomState {
+	state := &roomState{
+		room:   *room,
+		users:  make(map[types.NotEmptyText]models.User),
+		values: make(map[string]models.Value),
+	}
+	state.room.Options = maps.Clone(room.Options)
+	return state
+}
+
+// join - add user, user's name and metadata are updated if one is already there
+func (s *roomState) join(user models.User) {
+	user.Metadata = maps.Clone(user.Metadata)
+	s.users[user.ID] = user
+}
+
+// leave - remove user, only the user or room owner can do it
+//
+// User not found -> errors.ErrUserNotInRoom
+// Another user is kicked not by room owner -> errors.ErrNotRoomOwner
+func (s *roomState) leave(param ports.LeaveRoomParams) error {
+	if param.CommandCallerUserID != param.KickedUserID && param.CommandCallerUserID != s.room.OwnerUserID {
+		return roomerrors.ErrNotRoomOwner
+	}
+	if _, ok := s.users[param.KickedUserID]; !ok {
+		return roomerrors.ErrUserNotInRoom
+	}
+	delete(s.users, param.KickedUserID)
+	return nil
+}
+
+// affectData - set/delete whole data field or append/remove list item
+//
+// APPEND to value that isn't a list replaces it with list of one item, see models.Value.Appended
+//
+// Data not found (DELETE, REMOVE of missing key or item) -> errors.ErrDataPieceDoesntExist
+func (s *roomState) affectData(params ports.AffectDataParams) error {
+	key := params.DataID.String()
+	var current *models.Value
+	if value, ok := s.values[key]; ok {
+		current = &value
+	}
+	switch params.Action {
+	case ports.ActionSet:
+		s.values[key] = *params.Value
+	case ports.ActionDelete:
+		if current == nil {
+			return roomerrors.ErrDataPieceDoesntExist
+		}
+		delete(s.values, key)
+	case ports.ActionAppend:
+		s.values[key] = *current.Appended(*params.Value)
+	case ports.ActionRemove:
+		next, removed := current.Removed(params.Value)
+		if !removed {
+			return roomerrors.ErrDataPieceDoesntExist
+		}
+		s.values[key] = *next
+	default:
+		return fmt.Errorf("unknown data action: %d", params.Action)
+	}
+	return nil
+}
+
+// replace - make users and values equal to params, they are copied
+func (s *roomState) replace(params ports.ReplaceRoomParams) {
+	s.users = make(map[types.NotEmptyText]models.User, len(params.Users))
+	for _, user := range params.Users {
+		replaced := *user
+		replaced.Metadata = maps.Clone(user.Metadata)
+		s.users[user.ID] = replaced
+	}
+	s.values = maps.Clone(params.Values)
+	if s.values == nil {
+		s.values = make(map[string]models.Value)
+	}
+}
+
+// snapshot - copy of room, users are ordered by ID
+func (s *roomState) snapshot() *models.RoomSnapshot {
+	snapshotRoom := s.room
+	snapshotRoom.Options = maps.Clone(s.room.Options)
+	snapshot := &models.RoomSnapshot{
+		Room:   &snapshotRoom,
+		Users:  make([]*models.User, 0, len(s.users)),
+		Values: maps.Clone(s.values),
+	}
+	for _, user := range s.users {
+		user.Metadata = maps.Clone(user.Metadata)
+		snapshot.Users = append(snapshot.Users, &user)
+	}
+	slices.SortFunc(snapshot.Users, func(a, b *models.User) int {
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return snapshot
+}
@@ -0,0 +1,17 @@
+package snapshotcache
+
+import (
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/ports/porttest"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/chempik1234/room-service/internal/service/roomservice"
+	"testing"
+	"time"
+)
+
+// cache must not change the contract of storage under it
+func TestRoomsRepositoryConformance(t *testing.T) {
+	porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+		return NewRoomsRepository(room.NewInMemoryRepository(), roomservice.SnapshotCodec{}, Params{MaxBytes: 1 << 20, TTL: time.Minute})
+	})
+}
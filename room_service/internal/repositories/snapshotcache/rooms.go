@@ -0,0 +1,469 @@
+package snapshotcache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"golang.org/x/sync/singleflight"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTTL         = 2 * time.Second
+	defaultLoadTimeout = 5 * time.Second
+	// lockStripes - writes of rooms are serialized by this many locks, rooms with the same lock wait for each other
+	lockStripes = 256
+)
+
+// Codec - serialization of cached snapshots, e.g. roomservice.SnapshotCodec
+type Codec interface {
+	// Encode - serialized snapshot
+	Encode(snapshot *models.RoomSnapshot) ([]byte, error)
+	// Decode - snapshot of Encode result, room's ID and owner may be empty: RoomsRepository sets them
+	Decode(encoded []byte) (*models.RoomSnapshot, error)
+}
+
+// RoomsRepository - ports.RoomsPort decorator that caches serialized snapshots per room
+//
+// Concurrent RoomSnapshot calls of one room are coalesced into one call of next, that call isn't cancelled
+// by callers (it has its own timeout), every caller stops waiting when its ctx is done.
+// Writes made through RoomsRepository are serialized per room and patch cached snapshot
+// (SET and DELETE of data, join, leave, replacement) or invalidate it (APPEND, REMOVE, room deletion),
+// so this instance reads its own writes. Writes of other instances are seen after TTL.
+//
+// Snapshots are stored serialized by Codec up to maxBytes of encoded size, the least recently used ones are evicted
+type RoomsRepository struct {
+	next   ports.RoomsPort
+	codec  Codec
+	params Params
+	// now - time source, replaced in tests
+	now   func() time.Time
+	loads singleflight.Group
+	stats snapshotCacheCounters
+	locks [lockStripes]sync.Mutex
+
+	mu      sync.Mutex
+	entries map[models.RoomID]*list.Element
+	// usage - LRU order, front is the most recently used, values are *snapshotEntry
+	usage *list.List
+	bytes int
+	// loading - rooms whose snapshot is being read from next, stale is set by writes, so the result isn't cached
+	loading map[models.RoomID]*snapshotLoad
+}
+
+// Params - params of RoomsRepository
+type Params struct {
+	// MaxBytes - max encoded size of stored snapshots, 0 - no limit
+	MaxBytes int
+	// TTL - snapshots are read from next again after it, so writes of other instances are seen, default 2s
+	TTL time.Duration
+	// LoadTimeout - timeout of snapshot read from next shared by concurrent callers, default 5s
+	LoadTimeout time.Duration
+}
+
+func (p Params) withDefaults() Params {
+	if p.TTL <= 0 {
+		p.TTL = defaultTTL
+	}
+	if p.LoadTimeout <= 0 {
+		p.LoadTimeout = defaultLoadTimeout
+	}
+	return p
+}
+
+// Stats - counters of RoomsRepository cache, see RoomsRepository.Stats
+type Stats struct {
+	// Hits - RoomSnapshot calls returned from cache
+	Hits uint64
+	// Misses - RoomSnapshot calls that read snapshot from next
+	Misses uint64
+	// Shared - RoomSnapshot calls that waited for snapshot read by another call
+	Shared uint64
+	// Evictions - snapshots erased because cache is out of maxBytes
+	Evictions uint64
+	// Rooms - snapshots stored right now
+	Rooms int
+	// Bytes - encoded size of stored snapshots
+	Bytes int
+}
+
+type snapshotCacheCounters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	shared    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type snapshotEntry struct {
+	roomID    models.RoomID
+	owner     types.NotEmptyText
+	encoded   []byte
+	size      int
+	expiresAt time.Time
+}
+
+type snapshotLoad struct {
+	stale bool
+}
+
+// loadResult - result of snapshot read shared by concurrent callers
+type loadResult struct {
+	owner   types.NotEmptyText
+	encoded []byte
+}
+
+// NewRoomsRepository - wrap next, snapshots are serialized by codec
+func NewRoomsRepository(next ports.RoomsPort, codec Codec, params Params) *RoomsRepository {
+	return &RoomsRepository{
+		next:    next,
+		codec:   codec,
+		params:  params.withDefaults(),
+		now:     time.Now,
+		entries: make(map[models.RoomID]*list.Element),
+		usage:   list.New(),
+		loading: make(map[models.RoomID]*snapshotLoad),
+	}
+}
+
+// Stats - current counters, safe to call concurrently
+func (s *RoomsRepository) Stats() Stats {
+	s.mu.Lock()
+	rooms, bytes := len(s.entries), s.bytes
+	s.mu.Unlock()
+	return Stats{
+		Hits:      s.stats.hits.Load(),
+		Misses:    s.stats.misses.Load(),
+		Shared:    s.stats.shared.Load(),
+		Evictions: s.stats.evictions.Load(),
+		Rooms:     rooms,
+		Bytes:     bytes,
+	}
+}
+
+// RoomSnapshot - decoded copy of cached snapshot or of the one read from next (once for all concurrent callers)
+func (s *RoomsRepository) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	result, err := s.snapshot(ctx, params.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := s.codec.Decode(result.encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cached snapshot: %w", err)
+	}
+	snapshot.Room.ID = params.RoomID
+	snapshot.Room.OwnerUserID = result.owner
+	return snapshot, nil
+}
+
+// EncodedSnapshot - snapshot of room serialized by Codec, cached or read from next (once for all concurrent callers)
+//
+// returned bytes are shared and must not be modified
+func (s *RoomsRepository) EncodedSnapshot(ctx context.Context, roomID models.RoomID) ([]byte, error) {
+	result, err := s.snapshot(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	return result.encoded, nil
+}
+
+// snapshot - cached entry of room or the one read from next
+func (s *RoomsRepository) snapshot(ctx context.Context, roomID models.RoomID) (loadResult, error) {
+	if result, ok := s.cached(roomID); ok {
+		s.stats.hits.Add(1)
+		return result, nil
+	}
+
+	// the read doesn't depend on the caller that started it: another caller might still wait for it.
+	// "shared" result of singleflight is true for the first caller too, so the read is marked by loaded
+	var loaded atomic.Bool
+	readCtx := context.WithoutCancel(ctx)
+	results := s.loads.DoChan(roomID.String(), func() (any, error) {
+		loaded.Store(true)
+		return s.read(readCtx, roomID)
+	})
+	select {
+	case <-ctx.Done():
+		return loadResult{}, ctx.Err()
+	case res := <-results:
+		if loaded.Load() {
+			s.stats.misses.Add(1)
+		} else {
+			s.stats.shared.Add(1)
+		}
+		if res.Err != nil {
+			return loadResult{}, res.Err
+		}
+		return res.Val.(loadResult), nil
+	}
+}
+
+// read - read snapshot of room from next within LoadTimeout and cache it unless a write happened meanwhile
+func (s *RoomsRepository) read(ctx context.Context, roomID models.RoomID) (loadResult, error) {
+	s.mu.Lock()
+	load := &snapshotLoad{}
+	s.loading[roomID] = load
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		// a stale read is forgotten, so the next one of room might be registered already
+		if s.loading[roomID] == load {
+			delete(s.loading, roomID)
+		}
+		s.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, s.params.LoadTimeout)
+	defer cancel()
+	snapshot, err := s.next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	if err != nil {
+		return loadResult{}, err
+	}
+	encoded, err := s.codec.Encode(snapshot)
+	if err != nil {
+		return loadResult{}, fmt.Errorf("error encoding snapshot: %w", err)
+	}
+	result := loadResult{owner: snapshot.Room.OwnerUserID, encoded: encoded}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !load.stale {
+		s.store(roomID, result, s.now().Add(s.params.TTL))
+	}
+	return result, nil
+}
+
+// CreateRoom - ports.RoomsPort.CreateRoom, new room isn't cached until it's read
+func (s *RoomsRepository) CreateRoom(ctx context.Context, params *models.Room) (*models.Room, error) {
+	return s.next.CreateRoom(ctx, params)
+}
+
+// DeleteRoom - ports.RoomsPort.DeleteRoom, snapshot is invalidated
+func (s *RoomsRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) error {
+	return s.write(params.RoomID, func() error {
+		return s.next.DeleteRoom(ctx, params)
+	}, nil)
+}
+
+// JoinRoom - ports.RoomsPort.JoinRoom, user is added into cached snapshot (or replaced if it's already there),
+// users stay sorted by ID like storages return them
+func (s *RoomsRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	user := params.UserFull
+	return s.write(params.RoomID, func() error {
+		return s.next.JoinRoom(ctx, params)
+	}, func(snapshot *models.RoomSnapshot) {
+		snapshot.Users = slices.DeleteFunc(snapshot.Users, func(member *models.User) bool {
+			return member.ID == user.ID
+		})
+		position, _ := slices.BinarySearchFunc(snapshot.Users, user.ID.String(), func(member *models.User, id string) int {
+			return strings.Compare(member.ID.String(), id)
+		})
+		snapshot.Users = slices.Insert(snapshot.Users, position, &user)
+	})
+}
+
+// LeaveRoom - ports.RoomsPort.LeaveRoom, user is removed from cached snapshot
+func (s *RoomsRepository) LeaveRoom(ctx context.Context, params ports.LeaveRoomParams) error {
+	return s.write(params.RoomID, func() error {
+		return s.next.LeaveRoom(ctx, params)
+	}, func(snapshot *models.RoomSnapshot) {
+		snapshot.Users = slices.DeleteFunc(snapshot.Users, func(member *models.User) bool {
+			return member.ID == params.KickedUserID
+		})
+	})
+}
+
+// AffectData - ports.RoomsPort.AffectData, SET and DELETE are applied to cached snapshot, other actions invalidate it
+func (s *RoomsRepository) AffectData(ctx context.Context, params ports.AffectDataParams) error {
+	var fn func(snapshot *models.RoomSnapshot)
+	key := params.DataID.String()
+	switch params.Action {
+	case ports.ActionSet:
+		value := *params.Value
+		fn = func(snapshot *models.RoomSnapshot) {
+			snapshot.Values[key] = value
+		}
+	case ports.ActionDelete:
+		fn = func(snapshot *models.RoomSnapshot) {
+			delete(snapshot.Values, key)
+		}
+	}
+	return s.write(params.RoomID, func() error {
+		return s.next.AffectData(ctx, params)
+	}, fn)
+}
+
+// ReplaceRoom - ports.RoomsPort.ReplaceRoom, users and values of cached snapshot are replaced
+func (s *RoomsRepository) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	return s.write(params.RoomID, func() error {
+		return s.next.ReplaceRoom(ctx, params)
+	}, func(snapshot *models.RoomSnapshot) {
+		// patched snapshot is encoded right away, so params aren't kept
+		snapshot.Users, snapshot.Values = params.Users, params.Values
+	})
+}
+
+// CountOwnedRooms - ports.RoomsPort.CountOwnedRooms, not cached
+func (s *RoomsRepository) CountOwnedRooms(ctx context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	return s.next.CountOwnedRooms(ctx, params)
+}
+
+// IsRoomOwner - ports.RoomsPort.IsRoomOwner, not cached
+func (s *RoomsRepository) IsRoomOwner(ctx context.Context, params ports.IsRoomOwnerParams) (bool, error) {
+	return s.next.IsRoomOwner(ctx, params)
+}
+
+// Ping - ports.RoomsPort.Ping
+func (s *RoomsRepository) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// Release - erase snapshots of rooms that aren't owned, see sharding.Router
+//
+// snapshots that are being read right now aren't cached
+func (s *RoomsRepository) Release(_ context.Context, owned func(roomID models.RoomID) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for roomID, load := range s.loading {
+		if !owned(roomID) {
+			s.forget(roomID, load)
+		}
+	}
+	for roomID, element := range s.entries {
+		if !owned(roomID) {
+			s.remove(element)
+		}
+	}
+	return nil
+}
+
+// write - call change and apply fn to cached snapshot of room, nil fn = invalidate it
+//
+// writes of one room are serialized, so patches are applied in the order of changes in next.
+// Error of change invalidates snapshot: change might be applied before the error (e.g. timeout)
+func (s *RoomsRepository) write(roomID models.RoomID, change func() error, fn func(snapshot *models.RoomSnapshot)) error {
+	lock := s.lock(roomID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := change(); err != nil {
+		s.patch(roomID, nil)
+		return err
+	}
+	s.patch(roomID, fn)
+	return nil
+}
+
+// patch - apply fn to decoded copy of cached snapshot of room, nil fn = invalidate it; lock of room must be held
+//
+// snapshot that is being read right now isn't cached, because it might be read before the write
+func (s *RoomsRepository) patch(roomID models.RoomID, fn func(snapshot *models.RoomSnapshot)) {
+	s.mu.Lock()
+	if load, ok := s.loading[roomID]; ok {
+		s.forget(roomID, load)
+	}
+	element, ok := s.entries[roomID]
+	if ok && fn == nil {
+		s.remove(element)
+	}
+	s.mu.Unlock()
+	if !ok || fn == nil {
+		return
+	}
+
+	// decoding and encoding are done without s.mu, entry is replaced only if it's still cached
+	entry := element.Value.(*snapshotEntry)
+	encoded, err := s.patched(entry.encoded, fn)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.entries[roomID]; !ok || current != element {
+		return
+	}
+	s.remove(element)
+	if err != nil {
+		return
+	}
+	// TTL isn't extended: writes of other instances must still be seen in time
+	s.store(roomID, loadResult{owner: entry.owner, encoded: encoded}, entry.expiresAt)
+}
+
+// forget - don't cache result of load and don't share it with later callers: they must read after the write,
+// s.mu must be locked
+func (s *RoomsRepository) forget(roomID models.RoomID, load *snapshotLoad) {
+	load.stale = true
+	delete(s.loading, roomID)
+	s.loads.Forget(roomID.String())
+}
+
+// patched - encoded snapshot with fn applied
+func (s *RoomsRepository) patched(encoded []byte, fn func(snapshot *models.RoomSnapshot)) ([]byte, error) {
+	snapshot, err := s.codec.Decode(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cached snapshot: %w", err)
+	}
+	fn(snapshot)
+	return s.codec.Encode(snapshot)
+}
+
+// cached - not expired entry of room
+func (s *RoomsRepository) cached(roomID models.RoomID) (loadResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[roomID]
+	if !ok {
+		return loadResult{}, false
+	}
+	entry := element.Value.(*snapshotEntry)
+	if !s.now().Before(entry.expiresAt) {
+		s.remove(element)
+		return loadResult{}, false
+	}
+	s.usage.MoveToFront(element)
+	return loadResult{owner: entry.owner, encoded: entry.encoded}, true
+}
+
+// store - cache snapshot and evict the least recently used ones out of MaxBytes, s.mu must be locked
+func (s *RoomsRepository) store(roomID models.RoomID, result loadResult, expiresAt time.Time) {
+	if element, ok := s.entries[roomID]; ok {
+		s.remove(element)
+	}
+	size := len(result.encoded) + len(result.owner.String())
+	if s.params.MaxBytes > 0 && size > s.params.MaxBytes {
+		return
+	}
+
+	s.entries[roomID] = s.usage.PushFront(&snapshotEntry{
+		roomID:    roomID,
+		owner:     result.owner,
+		encoded:   result.encoded,
+		size:      size,
+		expiresAt: expiresAt,
+	})
+	s.bytes += size
+	for s.params.MaxBytes > 0 && s.bytes > s.params.MaxBytes {
+		s.remove(s.usage.Back())
+		s.stats.evictions.Add(1)
+	}
+}
+
+// remove - erase entry, s.mu must be locked
+func (s *RoomsRepository) remove(element *list.Element) {
+	entry := element.Value.(*snapshotEntry)
+	s.usage.Remove(element)
+	delete(s.entries, entry.roomID)
+	s.bytes -= entry.size
+}
+
+func (s *RoomsRepository) lock(roomID models.RoomID) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(roomID.String()))
+	return &s.locks[hash.Sum32()%lockStripes]
+}
@@ -0,0 +1,395 @@
+package snapshotcache
+
+import (
+	"context"
+	"errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/service/roomservice"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRoomsRepo - ports.RoomsPort that returns snapshots with one "key" value, reads wait for release if it's set
+type fakeRoomsRepo struct {
+	ports.RoomsPort
+	calls atomic.Int32
+	// started - receives on every RoomSnapshot call, optional
+	started chan struct{}
+	// release - RoomSnapshot waits for it, optional
+	release chan struct{}
+	value   atomic.Pointer[models.Value]
+	// readErrors - ctx errors of finished RoomSnapshot calls, optional
+	readErrors chan error
+}
+
+func newFakeRoomsRepo() *fakeRoomsRepo {
+	repo := &fakeRoomsRepo{}
+	repo.value.Store(models.StrValue("v1"))
+	return repo
+}
+
+func (f *fakeRoomsRepo) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	f.calls.Add(1)
+	value := *f.value.Load()
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+	if f.release != nil {
+		<-f.release
+	}
+	if f.readErrors != nil {
+		f.readErrors <- ctx.Err()
+	}
+	return &models.RoomSnapshot{
+		Room:   &models.Room{ID: params.RoomID, OwnerUserID: "owner"},
+		Values: map[string]models.Value{"key": value},
+	}, nil
+}
+
+func (f *fakeRoomsRepo) AffectData(_ context.Context, params ports.AffectDataParams) error {
+	if params.Value != nil {
+		f.value.Store(params.Value)
+	}
+	return nil
+}
+
+func (f *fakeRoomsRepo) JoinRoom(_ context.Context, _ ports.JoinRoomParams) error {
+	return nil
+}
+
+func setKey(t *testing.T, cache *RoomsRepository, roomID models.RoomID, action ports.Action, value *models.Value) {
+	t.Helper()
+	err := cache.AffectData(context.Background(), ports.AffectDataParams{
+		RoomID: roomID, DataID: types.AnyText("key"), Action: action, Value: value,
+	})
+	if err != nil {
+		t.Fatalf("AffectData: %v", err)
+	}
+}
+
+func keyOf(t *testing.T, cache *RoomsRepository, roomID models.RoomID) (models.Value, bool) {
+	t.Helper()
+	snapshot, err := cache.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: roomID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	value, ok := snapshot.Values["key"]
+	return value, ok
+}
+
+func TestRoomSnapshotIsCoalesced(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	repo.started = make(chan struct{}, 1)
+	repo.release = make(chan struct{})
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{})
+	roomID := models.RoomID(types.GenerateUUID())
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, _ := keyOf(t, cache, roomID); !value.Equal(models.StrValue("v1")) {
+				t.Errorf("key = %+v, want v1", value)
+			}
+		}()
+	}
+	<-repo.started
+	close(repo.release)
+	wg.Wait()
+
+	stats := cache.Stats()
+	if calls := repo.calls.Load(); calls != 1 {
+		t.Fatalf("storage calls = %d, want 1", calls)
+	}
+	if stats.Misses != 1 || stats.Hits+stats.Shared != 9 {
+		t.Fatalf("stats = %+v, want 1 miss and 9 hits or shared reads", stats)
+	}
+}
+
+func TestWritesPatchAndInvalidateSnapshot(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{})
+	roomID := models.RoomID(types.GenerateUUID())
+
+	before, err := cache.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: roomID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+
+	setKey(t, cache, roomID, ports.ActionSet, models.StrValue("v2"))
+	if value, _ := keyOf(t, cache, roomID); !value.Equal(models.StrValue("v2")) {
+		t.Fatalf("key after SET = %+v, want v2", value)
+	}
+	if value := before.Values["key"]; !value.Equal(models.StrValue("v1")) {
+		t.Fatalf("snapshot returned before SET was changed: %+v", value)
+	}
+
+	setKey(t, cache, roomID, ports.ActionDelete, nil)
+	if _, ok := keyOf(t, cache, roomID); ok {
+		t.Fatal("key is in snapshot after DELETE")
+	}
+	if calls := repo.calls.Load(); calls != 1 {
+		t.Fatalf("storage calls after patches = %d, want 1", calls)
+	}
+
+	// APPEND isn't applied to cached snapshot, it's read again
+	setKey(t, cache, roomID, ports.ActionAppend, models.StrValue("v3"))
+	if value, _ := keyOf(t, cache, roomID); !value.Equal(models.StrValue("v3")) {
+		t.Fatalf("key after APPEND = %+v, want v3 from storage", value)
+	}
+	if calls := repo.calls.Load(); calls != 2 {
+		t.Fatalf("storage calls after APPEND = %d, want 2", calls)
+	}
+}
+
+func TestWriteDuringReadIsNotCachedStale(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	repo.started = make(chan struct{}, 1)
+	repo.release = make(chan struct{}, 1)
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{})
+	roomID := models.RoomID(types.GenerateUUID())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		keyOf(t, cache, roomID)
+	}()
+	<-repo.started
+	// snapshot with v1 is being read while v2 is written
+	setKey(t, cache, roomID, ports.ActionSet, models.StrValue("v2"))
+	repo.release <- struct{}{}
+	<-done
+
+	repo.started, repo.release = nil, nil
+	if value, _ := keyOf(t, cache, roomID); !value.Equal(models.StrValue("v2")) {
+		t.Fatalf("key = %+v, want v2: snapshot read before write was cached", value)
+	}
+}
+
+func TestReadAfterWriteDoesntShareReadBeforeWrite(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	repo.started = make(chan struct{}, 2)
+	repo.release = make(chan struct{})
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{})
+	roomID := models.RoomID(types.GenerateUUID())
+
+	first := make(chan models.Value, 1)
+	go func() {
+		value, _ := keyOf(t, cache, roomID)
+		first <- value
+	}()
+	<-repo.started
+	// slow read of v1 is in progress, v2 is written, the read started after the write must see v2
+	setKey(t, cache, roomID, ports.ActionSet, models.StrValue("v2"))
+	second := make(chan models.Value, 1)
+	go func() {
+		value, _ := keyOf(t, cache, roomID)
+		second <- value
+	}()
+	select {
+	case <-repo.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("read after write joined the read before write")
+	}
+	close(repo.release)
+
+	if value := <-first; !value.Equal(models.StrValue("v1")) {
+		t.Fatalf("read before write key = %+v, want v1", value)
+	}
+	if value := <-second; !value.Equal(models.StrValue("v2")) {
+		t.Fatalf("read after write key = %+v, want v2", value)
+	}
+	repo.started, repo.release = nil, nil
+	if value, _ := keyOf(t, cache, roomID); !value.Equal(models.StrValue("v2")) {
+		t.Fatalf("cached key = %+v, want v2", value)
+	}
+}
+
+func TestJoinedUsersStaySorted(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{TTL: time.Minute})
+	roomID := models.RoomID(types.GenerateUUID())
+	keyOf(t, cache, roomID)
+
+	for _, id := range []string{"b", "c", "a", "b"} {
+		err := cache.JoinRoom(context.Background(), ports.JoinRoomParams{
+			RoomID: roomID, UserFull: models.User{ID: types.NotEmptyText(id), Name: types.NotEmptyText(id)},
+		})
+		if err != nil {
+			t.Fatalf("JoinRoom: %v", err)
+		}
+	}
+
+	snapshot, err := cache.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: roomID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	var ids []string
+	for _, user := range snapshot.Users {
+		ids = append(ids, user.ID.String())
+	}
+	if strings.Join(ids, ",") != "a,b,c" {
+		t.Fatalf("users = %v, want a,b,c", ids)
+	}
+	if calls := repo.calls.Load(); calls != 1 {
+		t.Fatalf("storage calls = %d, want 1", calls)
+	}
+}
+
+func TestSnapshotsAreEvictedAndExpire(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	rooms := []models.RoomID{
+		models.RoomID(types.GenerateUUID()), models.RoomID(types.GenerateUUID()), models.RoomID(types.GenerateUUID()),
+	}
+	// encoded snapshot and owner, rooms' IDs have the same length
+	snapshot, _ := repo.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: rooms[0]})
+	encoded, err := roomservice.SnapshotCodec{}.Encode(snapshot)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	size := len(encoded) + len("owner")
+	repo.calls.Store(0)
+
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{MaxBytes: 2 * size, TTL: time.Second})
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	keyOf(t, cache, rooms[0])
+	keyOf(t, cache, rooms[1])
+	keyOf(t, cache, rooms[0])
+	keyOf(t, cache, rooms[2])
+	stats := cache.Stats()
+	if stats.Rooms != 2 || stats.Bytes != 2*size || stats.Evictions != 1 {
+		t.Fatalf("stats = %+v, want 2 rooms of %d bytes and 1 eviction", stats, 2*size)
+	}
+
+	// rooms[1] is the least recently used one
+	calls := repo.calls.Load()
+	keyOf(t, cache, rooms[0])
+	keyOf(t, cache, rooms[2])
+	if repo.calls.Load() != calls {
+		t.Fatal("recently used snapshots were evicted")
+	}
+
+	now = now.Add(time.Second)
+	keyOf(t, cache, rooms[0])
+	if repo.calls.Load() != calls+1 {
+		t.Fatal("expired snapshot was returned from cache")
+	}
+}
+
+func TestReleaseErasesSnapshotsThatAreNotOwned(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{})
+	kept, released := models.RoomID(types.GenerateUUID()), models.RoomID(types.GenerateUUID())
+	keyOf(t, cache, kept)
+	keyOf(t, cache, released)
+
+	if err := cache.Release(context.Background(), func(roomID models.RoomID) bool { return roomID == kept }); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if stats := cache.Stats(); stats.Rooms != 1 {
+		t.Fatalf("rooms = %d, want 1", stats.Rooms)
+	}
+	keyOf(t, cache, kept)
+	keyOf(t, cache, released)
+	if calls := repo.calls.Load(); calls != 3 {
+		t.Fatalf("storage calls = %d, want 3 (released room is read again)", calls)
+	}
+}
+
+func TestSnapshotsExpireByDefault(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{})
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+	roomID := models.RoomID(types.GenerateUUID())
+
+	keyOf(t, cache, roomID)
+	now = now.Add(defaultTTL)
+	keyOf(t, cache, roomID)
+	if calls := repo.calls.Load(); calls != 2 {
+		t.Fatalf("storage calls = %d, want 2: snapshot didn't expire without TTL", calls)
+	}
+}
+
+func TestRoomSnapshotKeepsRoomIDAndOwner(t *testing.T) {
+	cache := NewRoomsRepository(newFakeRoomsRepo(), roomservice.SnapshotCodec{}, Params{})
+	roomID := models.RoomID(types.GenerateUUID())
+
+	for range 2 {
+		snapshot, err := cache.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: roomID})
+		if err != nil {
+			t.Fatalf("RoomSnapshot: %v", err)
+		}
+		if snapshot.Room.ID != roomID || snapshot.Room.OwnerUserID != "owner" {
+			t.Fatalf("room = %+v, want ID %s and owner", snapshot.Room, roomID.String())
+		}
+	}
+}
+
+func TestCancelledCallerDoesntCancelSharedRead(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	repo.started = make(chan struct{}, 1)
+	repo.release = make(chan struct{})
+	repo.readErrors = make(chan error, 1)
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{})
+	roomID := models.RoomID(types.GenerateUUID())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+		first <- err
+	}()
+	<-repo.started
+	second := make(chan models.Value, 1)
+	go func() {
+		value, _ := keyOf(t, cache, roomID)
+		second <- value
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller got %v, want context.Canceled", err)
+	}
+	close(repo.release)
+	if err := <-repo.readErrors; err != nil {
+		t.Fatalf("shared read was cancelled by the first caller: %v", err)
+	}
+	if value := <-second; !value.Equal(models.StrValue("v1")) {
+		t.Fatalf("key = %+v, want v1", value)
+	}
+}
+
+func TestConcurrentWritesArePatchedInOrder(t *testing.T) {
+	repo := newFakeRoomsRepo()
+	cache := NewRoomsRepository(repo, roomservice.SnapshotCodec{}, Params{TTL: time.Minute})
+	roomID := models.RoomID(types.GenerateUUID())
+	keyOf(t, cache, roomID)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			setKey(t, cache, roomID, ports.ActionSet, models.IntValue(int64(i)))
+		}()
+	}
+	wg.Wait()
+
+	// the last write into storage must be the last patch of cached snapshot
+	value, _ := keyOf(t, cache, roomID)
+	if stored := repo.value.Load(); !value.Equal(stored) {
+		t.Fatalf("cached key = %+v, storage has %+v", value, stored)
+	}
+	if calls := repo.calls.Load(); calls != 1 {
+		t.Fatalf("storage calls = %d, want 1", calls)
+	}
+}
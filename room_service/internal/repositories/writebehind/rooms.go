@@ -0,0 +1,519 @@
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultFlushInterval - used when Params.FlushInterval isn't set
+const defaultFlushInterval = time.Second
+
+// RoomsRepository - ports.RoomsPort that serves rooms from memory and writes their changes into next in background
+//
+// Room is loaded from next on first access. Joins, leaves and data changes are applied in memory and make room
+// "dirty", dirty rooms are written into next every Params.FlushInterval (see Run) and on shutdown (see Flush):
+// users and values of room are replaced in one atomic write, so next never has a part of changes.
+// CreateRoom and DeleteRoom are written through, so CountOwnedRooms and taken IDs are exact.
+//
+// Bounded staleness: write into room whose changes aren't written for Params.MaxStaleness flushes them first,
+// flush error is returned and the write isn't made, so next is never behind for longer (plus one flush).
+//
+// Changes of other instances aren't seen, so a room must be served by one instance only
+type RoomsRepository struct {
+	next   ports.RoomsPort
+	memory *room.InMemoryRepository
+	params Params
+	// now - time source, replaced in tests
+	now   func() time.Time
+	stats writeBehindCounters
+
+	mu    sync.Mutex
+	rooms map[models.RoomID]*roomEntry
+	// dirty - rooms with changes that aren't written into next
+	dirty map[models.RoomID]dirtyState
+}
+
+// Params - params of RoomsRepository
+type Params struct {
+	// FlushInterval - time between flushes of dirty rooms, default 1s
+	FlushInterval time.Duration
+	// MaxStaleness - max age of change that isn't written into next, 0 - no limit
+	MaxStaleness time.Duration
+	// IdleTimeout - rooms without changes that aren't used for it are unloaded from memory, 0 - never
+	IdleTimeout time.Duration
+}
+
+func (p Params) withDefaults() Params {
+	if p.FlushInterval <= 0 {
+		p.FlushInterval = defaultFlushInterval
+	}
+	return p
+}
+
+// Stats - state of RoomsRepository, see RoomsRepository.Stats
+type Stats struct {
+	// Rooms - rooms loaded into memory
+	Rooms int
+	// DirtyRooms - rooms with changes that aren't written into next
+	DirtyRooms int
+	// FlushLag - age of the oldest change that isn't written into next
+	FlushLag time.Duration
+	// Loads - rooms read from next
+	Loads uint64
+	// Flushes - dirty rooms written into next
+	Flushes uint64
+	// FlushErrors - failed writes of dirty rooms, they are retried on next flush
+	FlushErrors uint64
+	// Evictions - idle rooms unloaded from memory
+	Evictions uint64
+}
+
+type writeBehindCounters struct {
+	loads       atomic.Uint64
+	flushes     atomic.Uint64
+	flushErrors atomic.Uint64
+	evictions   atomic.Uint64
+}
+
+// roomEntry - room in memory, mu is held while room is loaded or changed
+type roomEntry struct {
+	mu sync.Mutex
+	// flushMu - held while room is written into next, so writes of one room don't overlap
+	flushMu sync.Mutex
+	loaded  bool
+	// removed - entry is evicted, a new one must be taken from RoomsRepository.rooms
+	removed bool
+	// persisted - room as it's stored in next, nil if it doesn't exist
+	persisted *models.RoomSnapshot
+	usedAt    time.Time
+}
+
+// dirtyState - since is the time of the first change that isn't written, writes counts changes
+type dirtyState struct {
+	since  time.Time
+	writes uint64
+}
+
+// NewRoomsRepository - serve rooms of next from memory, call Run to write changes into next
+func NewRoomsRepository(next ports.RoomsPort, params Params) *RoomsRepository {
+	return &RoomsRepository{
+		next:   next,
+		memory: room.NewInMemoryRepository(),
+		params: params.withDefaults(),
+		now:    time.Now,
+		rooms:  make(map[models.RoomID]*roomEntry),
+		dirty:  make(map[models.RoomID]dirtyState),
+	}
+}
+
+// Run - flush dirty rooms and unload idle ones every Params.FlushInterval until ctx is done
+//
+// changes made after that are written by Flush, call it on shutdown
+func (s *RoomsRepository) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.params.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.Flush(ctx); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error(ctx, "failed to flush rooms", zap.Error(err))
+		}
+		s.evictIdle()
+	}
+}
+
+// Flush - write every dirty room into next, errors of rooms are joined
+func (s *RoomsRepository) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	entries := make(map[models.RoomID]*roomEntry, len(s.dirty))
+	for roomID := range s.dirty {
+		entries[roomID] = s.rooms[roomID]
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for roomID, entry := range entries {
+		if err := s.flushRoom(ctx, roomID, entry); err != nil {
+			errs = append(errs, fmt.Errorf("room %s: %w", roomID.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Release - write changes of rooms that aren't owned into next and unload them, see sharding.Router
+//
+// room whose changes can't be written stays loaded (and dirty), errors of rooms are joined;
+// room changed during release stays loaded, its changes are written by Run
+func (s *RoomsRepository) Release(ctx context.Context, owned func(roomID models.RoomID) bool) error {
+	s.mu.Lock()
+	entries := make(map[models.RoomID]*roomEntry)
+	for roomID, entry := range s.rooms {
+		if !owned(roomID) {
+			entries[roomID] = entry
+		}
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for roomID, entry := range entries {
+		if err := s.flushRoom(ctx, roomID, entry); err != nil {
+			errs = append(errs, fmt.Errorf("room %s: %w", roomID.String(), err))
+			continue
+		}
+		entry.mu.Lock()
+		s.mu.Lock()
+		if _, dirty := s.dirty[roomID]; !dirty && !entry.removed {
+			s.memory.Evict(roomID)
+			entry.removed = true
+			delete(s.rooms, roomID)
+		}
+		s.mu.Unlock()
+		entry.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// Stats - return current state and counters
+func (s *RoomsRepository) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{
+		Rooms:       len(s.rooms),
+		DirtyRooms:  len(s.dirty),
+		Loads:       s.stats.loads.Load(),
+		Flushes:     s.stats.flushes.Load(),
+		FlushErrors: s.stats.flushErrors.Load(),
+		Evictions:   s.stats.evictions.Load(),
+	}
+	now := s.now()
+	for _, state := range s.dirty {
+		stats.FlushLag = max(stats.FlushLag, now.Sub(state.since))
+	}
+	return stats
+}
+
+// CreateRoom - create room in next and in memory
+//
+// Create ID yourself, ID is taken -> errors.ErrRoomIDAlreadyExists
+func (s *RoomsRepository) CreateRoom(ctx context.Context, params *models.Room) (*models.Room, error) {
+	entry := s.lock(params.ID)
+	defer entry.mu.Unlock()
+	if entry.loaded && entry.persisted != nil {
+		return nil, roomerrors.ErrRoomIDAlreadyExists
+	}
+
+	created, err := s.next.CreateRoom(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.memory.CreateRoom(ctx, params); err != nil {
+		return nil, err
+	}
+	entry.loaded = true
+	entry.persisted, err = s.memory.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: params.ID})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// DeleteRoom - delete room from next and from memory, changes that aren't written are dropped
+//
+// Not found -> errors.ErrRoomDoesntExist
+// User isn't room owner -> errors.ErrNotRoomOwner
+func (s *RoomsRepository) DeleteRoom(ctx context.Context, params ports.DeleteRoomParams) error {
+	for {
+		entry, err := s.acquire(ctx, params.RoomID)
+		if err != nil {
+			return err
+		}
+		entry.mu.Unlock()
+
+		// flush that is in progress must not write into deleted room
+		entry.flushMu.Lock()
+		entry.mu.Lock()
+		if entry.removed {
+			entry.mu.Unlock()
+			entry.flushMu.Unlock()
+			continue
+		}
+		err = s.deleteRoom(ctx, params, entry)
+		entry.mu.Unlock()
+		entry.flushMu.Unlock()
+		return err
+	}
+}
+
+// deleteRoom - delete room from next and forget it, entry.mu and entry.flushMu must be locked
+func (s *RoomsRepository) deleteRoom(ctx context.Context, params ports.DeleteRoomParams, entry *roomEntry) error {
+	if entry.persisted == nil {
+		return roomerrors.ErrRoomDoesntExist
+	}
+	err := s.next.DeleteRoom(ctx, params)
+	if err != nil && !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		return err
+	}
+	s.forget(params.RoomID, entry)
+	return err
+}
+
+// JoinRoom - add user to room in memory, user's name and metadata are updated if one is already there
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *RoomsRepository) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	return s.write(ctx, params.RoomID, func() error {
+		return s.memory.JoinRoom(ctx, params)
+	})
+}
+
+// CountOwnedRooms - count rooms whose owner is given user (rooms are created and deleted in next right away)
+func (s *RoomsRepository) CountOwnedRooms(ctx context.Context, params ports.CountOwnedRoomsParams) (int, error) {
+	return s.next.CountOwnedRooms(ctx, params)
+}
+
+// IsRoomOwner - check if room's owner is given user (in memory)
+//
+// Not found -> errors.ErrRoomDoesntExist
+func (s *RoomsRepository) IsRoomOwner(ctx context.Context, params ports.IsRoomOwnerParams) (bool, error) {
+	entry, err := s.acquire(ctx, params.RoomID)
+	if err != nil {
+		return false, err
+	}
+	defer entry.mu.Unlock()
+	return s.memory.IsRoomOwner(ctx, params)
+}
+
+// LeaveRoom - remove user from room in memory, only the user or room owner can do it
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// User not found -> errors.ErrUserNotInRoom
+// Another user is kicked not by room owner -> errors.ErrNotRoomOwner
+func (s *RoomsRepository) LeaveRoom(ctx context.Context, param ports.LeaveRoomParams) error {
+	return s.write(ctx, param.RoomID, func() error {
+		return s.memory.LeaveRoom(ctx, param)
+	})
+}
+
+// RoomSnapshot - return a copy of room from memory
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *RoomsRepository) RoomSnapshot(ctx context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	entry, err := s.acquire(ctx, params.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	defer entry.mu.Unlock()
+	return s.memory.RoomSnapshot(ctx, params)
+}
+
+// ReplaceRoom - replace users and data of room in memory
+//
+// Room not found -> errors.ErrRoomDoesntExist
+func (s *RoomsRepository) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	return s.write(ctx, params.RoomID, func() error {
+		return s.memory.ReplaceRoom(ctx, params)
+	})
+}
+
+// AffectData - set/delete whole data field or append/remove list item in memory, see room.InMemoryRepository.AffectData
+//
+// Room not found -> errors.ErrRoomDoesntExist
+// Data not found (DELETE, REMOVE of missing key or item) -> errors.ErrDataPieceDoesntExist
+func (s *RoomsRepository) AffectData(ctx context.Context, params ports.AffectDataParams) error {
+	return s.write(ctx, params.RoomID, func() error {
+		return s.memory.AffectData(ctx, params)
+	})
+}
+
+// Ping - ping next
+func (s *RoomsRepository) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+// lock - return locked entry of room, it's created if there's none
+func (s *RoomsRepository) lock(roomID models.RoomID) *roomEntry {
+	for {
+		s.mu.Lock()
+		entry, ok := s.rooms[roomID]
+		if !ok {
+			entry = &roomEntry{}
+			s.rooms[roomID] = entry
+		}
+		s.mu.Unlock()
+
+		entry.mu.Lock()
+		if !entry.removed {
+			entry.usedAt = s.now()
+			return entry
+		}
+		entry.mu.Unlock()
+	}
+}
+
+// acquire - return locked entry of room loaded from next
+func (s *RoomsRepository) acquire(ctx context.Context, roomID models.RoomID) (*roomEntry, error) {
+	entry := s.lock(roomID)
+	if entry.loaded {
+		return entry, nil
+	}
+
+	snapshot, err := s.next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	if errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		entry.loaded = true
+		return entry, nil
+	}
+	if err == nil {
+		err = s.load(ctx, snapshot)
+	}
+	if err != nil {
+		s.memory.Evict(roomID)
+		entry.mu.Unlock()
+		return nil, fmt.Errorf("error loading room: %w", err)
+	}
+	s.stats.loads.Add(1)
+	entry.loaded = true
+	entry.persisted = snapshot
+	return entry, nil
+}
+
+// load - put snapshot into memory
+func (s *RoomsRepository) load(ctx context.Context, snapshot *models.RoomSnapshot) error {
+	if _, err := s.memory.CreateRoom(ctx, snapshot.Room); err != nil {
+		return err
+	}
+	return s.memory.ReplaceRoom(ctx, ports.ReplaceRoomParams{RoomID: snapshot.Room.ID, Users: snapshot.Users, Values: snapshot.Values})
+}
+
+// write - apply change to loaded room and mark it dirty, stale changes are flushed first (see Params.MaxStaleness)
+func (s *RoomsRepository) write(ctx context.Context, roomID models.RoomID, apply func() error) error {
+	if err := s.flushIfStale(ctx, roomID); err != nil {
+		return err
+	}
+	entry, err := s.acquire(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	defer entry.mu.Unlock()
+	if err = apply(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	state := s.dirty[roomID]
+	if state.writes == 0 {
+		state.since = s.now()
+	}
+	state.writes++
+	s.dirty[roomID] = state
+	s.mu.Unlock()
+	return nil
+}
+
+// flushIfStale - flush room if its oldest change is older than Params.MaxStaleness
+func (s *RoomsRepository) flushIfStale(ctx context.Context, roomID models.RoomID) error {
+	if s.params.MaxStaleness <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	state, dirty := s.dirty[roomID]
+	entry := s.rooms[roomID]
+	s.mu.Unlock()
+	if !dirty || s.now().Sub(state.since) < s.params.MaxStaleness {
+		return nil
+	}
+	if err := s.flushRoom(ctx, roomID, entry); err != nil {
+		return fmt.Errorf("error flushing stale room changes: %w", err)
+	}
+	return nil
+}
+
+// flushRoom - write users and values of room into next at once, see ports.RoomsPort.ReplaceRoom
+func (s *RoomsRepository) flushRoom(ctx context.Context, roomID models.RoomID, entry *roomEntry) error {
+	entry.flushMu.Lock()
+	defer entry.flushMu.Unlock()
+
+	entry.mu.Lock()
+	s.mu.Lock()
+	state, dirty := s.dirty[roomID]
+	s.mu.Unlock()
+	if !dirty {
+		entry.mu.Unlock()
+		return nil
+	}
+	snapshot, err := s.memory.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: roomID})
+	capturedAt := s.now()
+	entry.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = s.next.ReplaceRoom(ctx, ports.ReplaceRoomParams{RoomID: roomID, Users: snapshot.Users, Values: snapshot.Values})
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		// deleted by someone else, changes can't be written anymore
+		s.forget(roomID, entry)
+	}
+	if err != nil {
+		s.stats.flushErrors.Add(1)
+		return err
+	}
+
+	s.stats.flushes.Add(1)
+	entry.persisted = snapshot
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current := s.dirty[roomID]; current.writes == state.writes {
+		delete(s.dirty, roomID)
+	} else {
+		// changes made during flush aren't written, they are not older than the snapshot
+		s.dirty[roomID] = dirtyState{since: capturedAt, writes: current.writes - state.writes}
+	}
+	return nil
+}
+
+// forget - drop room from memory with its changes, entry.mu must be locked
+func (s *RoomsRepository) forget(roomID models.RoomID, entry *roomEntry) {
+	s.memory.Evict(roomID)
+	entry.persisted = nil
+	s.mu.Lock()
+	delete(s.dirty, roomID)
+	s.mu.Unlock()
+}
+
+// evictIdle - unload rooms that don't exist or have no changes and aren't used for Params.IdleTimeout
+//
+// busy rooms are skipped, they are used right now
+func (s *RoomsRepository) evictIdle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for roomID, entry := range s.rooms {
+		if _, dirty := s.dirty[roomID]; dirty || !entry.mu.TryLock() {
+			continue
+		}
+		idle := s.params.IdleTimeout > 0 && now.Sub(entry.usedAt) >= s.params.IdleTimeout
+		if entry.persisted == nil || idle {
+			s.memory.Evict(roomID)
+			entry.removed = true
+			delete(s.rooms, roomID)
+			if entry.loaded && entry.persisted != nil {
+				s.stats.evictions.Add(1)
+			}
+		}
+		entry.mu.Unlock()
+	}
+}
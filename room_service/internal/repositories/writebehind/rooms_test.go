@@ -0,0 +1,404 @@
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/ports/porttest"
+	"github.com/chempik1234/room-service/internal/repositories/mongotest"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/go-redis/redis/v8"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingRooms - next whose writes fail while failing is set, writes are counted
+type failingRooms struct {
+	*room.InMemoryRepository
+	failing atomic.Bool
+	writes  atomic.Int64
+}
+
+var errStorageDown = errors.New("storage is down")
+
+func (r *failingRooms) ReplaceRoom(ctx context.Context, params ports.ReplaceRoomParams) error {
+	r.writes.Add(1)
+	if r.failing.Load() {
+		return errStorageDown
+	}
+	return r.InMemoryRepository.ReplaceRoom(ctx, params)
+}
+
+func valueOf(snapshot *models.RoomSnapshot, key string) *models.Value {
+	value, ok := snapshot.Values[key]
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func TestRoomsRepositoryConformance(t *testing.T) {
+	t.Run("write behind", func(t *testing.T) {
+		porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+			return NewRoomsRepository(room.NewInMemoryRepository(), Params{})
+		})
+	})
+	// every write flushes previous changes first
+	t.Run("write through", func(t *testing.T) {
+		porttest.RunRoomsPortSuite(t, func(t *testing.T) ports.RoomsPort {
+			return NewRoomsRepository(room.NewInMemoryRepository(), Params{MaxStaleness: time.Nanosecond})
+		})
+	})
+}
+
+func TestRoomsRepositoryFlush(t *testing.T) {
+	ctx := context.Background()
+	next := room.NewInMemoryRepository()
+	repo := NewRoomsRepository(next, Params{})
+
+	created := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, created); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if _, err := next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID}); err != nil {
+		t.Fatalf("room isn't created in next: %v", err)
+	}
+
+	for _, user := range []models.User{{ID: "owner", Name: "Owner"}, {ID: "guest", Name: "Guest"}} {
+		if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: created.ID, UserFull: user}); err != nil {
+			t.Fatalf("JoinRoom: %v", err)
+		}
+	}
+	for _, params := range []ports.AffectDataParams{
+		{DataID: "score", Action: ports.ActionSet, Value: models.IntValue(1)},
+		{DataID: "list", Action: ports.ActionAppend, Value: models.StrValue("a")},
+		{DataID: "deleted", Action: ports.ActionSet, Value: models.IntValue(1)},
+	} {
+		params.RoomID = created.ID
+		if err := repo.AffectData(ctx, params); err != nil {
+			t.Fatalf("AffectData: %v", err)
+		}
+	}
+	snapshot, err := next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID})
+	if err != nil || len(snapshot.Users) != 0 || len(snapshot.Values) != 0 {
+		t.Fatalf("changes are written before flush: %+v, %v", snapshot, err)
+	}
+	if stats := repo.Stats(); stats.DirtyRooms != 1 {
+		t.Fatalf("dirty rooms = %d, want 1", stats.DirtyRooms)
+	}
+
+	if err = repo.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if err = repo.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: created.ID, CommandCallerUserID: "owner", KickedUserID: "guest"}); err != nil {
+		t.Fatalf("LeaveRoom: %v", err)
+	}
+	if err = repo.AffectData(ctx, ports.AffectDataParams{RoomID: created.ID, DataID: "deleted", Action: ports.ActionDelete}); err != nil {
+		t.Fatalf("AffectData: %v", err)
+	}
+	if err = repo.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// new instance loads the room from next
+	restarted := NewRoomsRepository(next, Params{})
+	snapshot, err = restarted.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot after restart: %v", err)
+	}
+	if len(snapshot.Users) != 1 || snapshot.Users[0].ID != "owner" {
+		t.Errorf("users = %+v, want owner only", snapshot.Users)
+	}
+	if len(snapshot.Values) != 2 || !valueOf(snapshot, "score").Equal(models.IntValue(1)) ||
+		!valueOf(snapshot, "list").Equal(models.ListValue([]models.Value{*models.StrValue("a")})) {
+		t.Errorf("values = %+v, want score and list", snapshot.Values)
+	}
+	if stats := repo.Stats(); stats.DirtyRooms != 0 || stats.Flushes != 2 || stats.FlushErrors != 0 {
+		t.Errorf("stats = %+v, want 2 flushes and no dirty rooms", stats)
+	}
+	if stats := restarted.Stats(); stats.Loads != 1 || stats.Rooms != 1 {
+		t.Errorf("stats of restarted = %+v, want 1 load", stats)
+	}
+}
+
+func TestRoomsRepositoryWritesDirtyRoomOnce(t *testing.T) {
+	ctx := context.Background()
+	next := &failingRooms{InMemoryRepository: room.NewInMemoryRepository()}
+	repo := NewRoomsRepository(next, Params{})
+	created := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, created); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	for i := range 10 {
+		err := repo.AffectData(ctx, ports.AffectDataParams{RoomID: created.ID, DataID: "score", Action: ports.ActionSet, Value: models.IntValue(int64(i))})
+		if err != nil {
+			t.Fatalf("AffectData: %v", err)
+		}
+	}
+	if err := repo.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if writes := next.writes.Load(); writes != 1 {
+		t.Fatalf("next got %d writes, want 1: room is written at once", writes)
+	}
+	if err := repo.Flush(ctx); err != nil || next.writes.Load() != 1 {
+		t.Fatalf("flush of clean room wrote into next: %d writes, %v", next.writes.Load(), err)
+	}
+}
+
+func TestRoomsRepositoryBoundedStaleness(t *testing.T) {
+	ctx := context.Background()
+	next := &failingRooms{InMemoryRepository: room.NewInMemoryRepository()}
+	repo := NewRoomsRepository(next, Params{MaxStaleness: time.Second})
+	now := time.Unix(1000, 0)
+	repo.now = func() time.Time { return now }
+
+	created := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, created); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	set := func(value int64) error {
+		return repo.AffectData(ctx, ports.AffectDataParams{RoomID: created.ID, DataID: "score", Action: ports.ActionSet, Value: models.IntValue(value)})
+	}
+
+	next.failing.Store(true)
+	if err := set(1); err != nil {
+		t.Fatalf("write while next is down: %v", err)
+	}
+	if err := repo.Flush(ctx); !errors.Is(err, errStorageDown) {
+		t.Fatalf("Flush: got %v, want errStorageDown", err)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if err := set(2); err != nil {
+		t.Fatalf("write within max staleness: %v", err)
+	}
+	if lag := repo.Stats().FlushLag; lag != 500*time.Millisecond {
+		t.Fatalf("flush lag = %v, want 500ms since the first write", lag)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if err := set(3); !errors.Is(err, errStorageDown) {
+		t.Fatalf("write after max staleness: got %v, want errStorageDown", err)
+	}
+	snapshot, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID})
+	if err != nil || !valueOf(snapshot, "score").Equal(models.IntValue(2)) {
+		t.Fatalf("rejected write is applied: %+v, %v", snapshot, err)
+	}
+
+	next.failing.Store(false)
+	if err = set(3); err != nil {
+		t.Fatalf("write after next is up: %v", err)
+	}
+	// stale changes are flushed by the write, the new one isn't
+	persisted, err := next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID})
+	if err != nil || !valueOf(persisted, "score").Equal(models.IntValue(2)) {
+		t.Fatalf("value in next = %+v, %v, want 2", persisted, err)
+	}
+	if stats := repo.Stats(); stats.DirtyRooms != 1 || stats.FlushLag != 0 || stats.FlushErrors != 2 {
+		t.Errorf("stats = %+v, want 1 fresh dirty room and 2 flush errors", stats)
+	}
+}
+
+func TestRoomsRepositoryBoundedStalenessWithStorage(t *testing.T) {
+	t.Run("redis", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+		t.Cleanup(func() { _ = client.Close() })
+		down := func(isDown bool) {
+			if isDown {
+				server.Close()
+			} else if err := server.Restart(); err != nil {
+				t.Fatalf("restarting redis: %v", err)
+			}
+		}
+		checkBoundedStaleness(t, room.NewRedisRepository(client, room.RedisRepoParams{}), down)
+	})
+	t.Run("mongodb", func(t *testing.T) {
+		client := mongotest.NewClient(t)
+		next, err := room.NewMongoDBRepository(context.Background(), client, room.MongoRepoParams{Database: mongotest.NewDatabase(t, client)})
+		if err != nil {
+			t.Fatalf("NewMongoDBRepository: %v", err)
+		}
+		checkBoundedStaleness(t, next, nil)
+	})
+}
+
+// checkBoundedStaleness - changes get into next within Params.MaxStaleness, write is rejected while next is down
+// (if down can stop it) and changes older than it can't be written
+func checkBoundedStaleness(t *testing.T, next ports.RoomsPort, down func(isDown bool)) {
+	ctx := context.Background()
+	repo := NewRoomsRepository(next, Params{MaxStaleness: time.Second})
+	now := time.Unix(1000, 0)
+	repo.now = func() time.Time { return now }
+
+	created := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, created); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	set := func(value int64) error {
+		return repo.AffectData(ctx, ports.AffectDataParams{RoomID: created.ID, DataID: "score", Action: ports.ActionSet, Value: models.IntValue(value)})
+	}
+	persisted := func() *models.RoomSnapshot {
+		t.Helper()
+		snapshot, err := next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID})
+		if err != nil {
+			t.Fatalf("RoomSnapshot of next: %v", err)
+		}
+		return snapshot
+	}
+
+	if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: created.ID, UserFull: models.User{ID: "owner", Name: "Owner"}}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	if err := set(1); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if err := set(2); err != nil {
+		t.Fatalf("write within max staleness: %v", err)
+	}
+	if snapshot := persisted(); len(snapshot.Users) != 0 || len(snapshot.Values) != 0 {
+		t.Fatalf("changes are written before flush: %+v", snapshot)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if down != nil {
+		down(true)
+		if err := set(3); err == nil {
+			t.Fatal("write after max staleness succeeded while next is down")
+		}
+		down(false)
+	}
+	if err := set(3); err != nil {
+		t.Fatalf("write after max staleness: %v", err)
+	}
+	// the whole room as it was before the write is in next
+	snapshot := persisted()
+	if len(snapshot.Users) != 1 || snapshot.Users[0].ID != "owner" || !valueOf(snapshot, "score").Equal(models.IntValue(2)) {
+		t.Fatalf("room in next = %+v, want owner and score 2", snapshot)
+	}
+
+	if err := repo.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if snapshot = persisted(); !valueOf(snapshot, "score").Equal(models.IntValue(3)) {
+		t.Fatalf("room in next after flush = %+v, want score 3", snapshot)
+	}
+	if stats := repo.Stats(); stats.DirtyRooms != 0 {
+		t.Errorf("stats = %+v, want no dirty rooms", stats)
+	}
+}
+
+func TestRoomsRepositoryDeleteDropsChanges(t *testing.T) {
+	ctx := context.Background()
+	next := room.NewInMemoryRepository()
+	repo := NewRoomsRepository(next, Params{})
+	created := models.NewRoom("owner", nil)
+	if _, err := repo.CreateRoom(ctx, created); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: created.ID, UserFull: models.User{ID: "owner", Name: "Owner"}}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+
+	if err := repo.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: created.ID, UserID: "owner"}); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+	if _, err := next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Fatalf("room isn't deleted from next: %v", err)
+	}
+	if stats := repo.Stats(); stats.DirtyRooms != 0 {
+		t.Fatalf("deleted room is dirty: %+v", stats)
+	}
+	if err := repo.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+}
+
+func TestRoomsRepositoryEvictsIdleRooms(t *testing.T) {
+	ctx := context.Background()
+	next := room.NewInMemoryRepository()
+	repo := NewRoomsRepository(next, Params{IdleTimeout: time.Minute})
+	now := time.Unix(1000, 0)
+	repo.now = func() time.Time { return now }
+
+	idle := models.NewRoom("owner", nil)
+	dirty := models.NewRoom("owner", nil)
+	for _, created := range []*models.Room{idle, dirty} {
+		if _, err := repo.CreateRoom(ctx, created); err != nil {
+			t.Fatalf("CreateRoom: %v", err)
+		}
+	}
+	if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: dirty.ID, UserFull: models.User{ID: "owner", Name: "Owner"}}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	// missing room isn't kept
+	if _, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: models.NewRoom("owner", nil).ID}); !errors.Is(err, roomerrors.ErrRoomDoesntExist) {
+		t.Fatalf("RoomSnapshot of missing room: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	repo.evictIdle()
+	if stats := repo.Stats(); stats.Rooms != 1 || stats.Evictions != 1 {
+		t.Fatalf("stats = %+v, want dirty room kept and idle one evicted", stats)
+	}
+	if _, err := repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: idle.ID}); err != nil {
+		t.Fatalf("evicted room isn't loaded again: %v", err)
+	}
+	if stats := repo.Stats(); stats.Loads != 1 {
+		t.Errorf("loads = %d, want 1", stats.Loads)
+	}
+}
+
+func TestRoomsRepositoryReleasesRoomsThatAreNotOwned(t *testing.T) {
+	ctx := context.Background()
+	next := &failingRooms{InMemoryRepository: room.NewInMemoryRepository()}
+	repo := NewRoomsRepository(next, Params{})
+
+	kept := models.NewRoom("owner", nil)
+	released := models.NewRoom("owner", nil)
+	for _, created := range []*models.Room{kept, released} {
+		if _, err := repo.CreateRoom(ctx, created); err != nil {
+			t.Fatalf("CreateRoom: %v", err)
+		}
+		if err := repo.JoinRoom(ctx, ports.JoinRoomParams{RoomID: created.ID, UserFull: models.User{ID: "owner", Name: "Owner"}}); err != nil {
+			t.Fatalf("JoinRoom: %v", err)
+		}
+	}
+	owned := func(roomID models.RoomID) bool { return roomID == kept.ID }
+
+	// changes that can't be written keep room loaded
+	next.failing.Store(true)
+	if err := repo.Release(ctx, owned); !errors.Is(err, errStorageDown) {
+		t.Fatalf("Release with failing next: %v", err)
+	}
+	if stats := repo.Stats(); stats.Rooms != 2 || stats.DirtyRooms != 2 {
+		t.Fatalf("stats = %+v, want both rooms loaded and dirty", stats)
+	}
+
+	next.failing.Store(false)
+	if err := repo.Release(ctx, owned); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if stats := repo.Stats(); stats.Rooms != 1 || stats.DirtyRooms != 1 {
+		t.Fatalf("stats = %+v, want only owned room loaded", stats)
+	}
+	snapshot, err := next.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: released.ID})
+	if err != nil || len(snapshot.Users) != 1 {
+		t.Fatalf("changes of released room aren't written: %+v, %v", snapshot, err)
+	}
+
+	// another instance changes released room, it's read again
+	if err = next.JoinRoom(ctx, ports.JoinRoomParams{RoomID: released.ID, UserFull: models.User{ID: "guest", Name: "Guest"}}); err != nil {
+		t.Fatalf("JoinRoom in next: %v", err)
+	}
+	if snapshot, err = repo.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: released.ID}); err != nil || len(snapshot.Users) != 2 {
+		t.Fatalf("released room isn't read from next again: %+v, %v", snapshot, err)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/chempik1234/room-service/internal/ports"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
-	"github.com/wb-go/wbf/retry"
 )
 
 type affectDataParams struct {
@@ -22,14 +21,19 @@ func (s *RoomService) affectDataInRoom(ctx context.Context, value *r.Value, data
 		return nil, fmt.Errorf("error deserializing value: %w", err)
 	}
 
-	err = retry.Do(func() error {
-		return s.roomsRepo.AffectData(ctx, ports.AffectDataParams{
-			RoomID: *params.RoomID,
-			DataID: params.DataID,
-			Action: params.Action,
-			Value:  plainValue,
-		})
-	}, s.retryStrategy)
+	affectParams := ports.AffectDataParams{
+		RoomID: *params.RoomID,
+		DataID: params.DataID,
+		Action: params.Action,
+		Value:  plainValue,
+	}
+	if err = s.checkAffectDataQuotas(ctx, affectParams); err != nil {
+		return payload, err
+	}
+
+	err = s.retry(ctx, "affect_data", func(ctx context.Context) error {
+		return s.roomsRepo.AffectData(ctx, affectParams)
+	})
 	if err != nil {
 		return payload, fmt.Errorf("failed to affect data in room: %w", err)
 	}
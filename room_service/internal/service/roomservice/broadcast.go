@@ -0,0 +1,254 @@
+package roomservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"hash/fnv"
+	"sync"
+)
+
+// isBroadcast - event changes room for everyone in it, so it's sent to every stream that joined a user there
+func isBroadcast(event *r.Event) bool {
+	switch event.GetPayload().(type) {
+	case *r.Event_JoinedRoom, *r.Event_LeftRoom, *r.Event_DataEdited, *r.Event_RoomDeleted:
+		return true
+	default:
+		return false
+	}
+}
+
+// roomLockStripes - commands of rooms are executed and published by this many locks, rooms with the same lock wait for each other
+const roomLockStripes = 256
+
+// executeAndPublish - processCommandOnce, command is executed and its result is published under lock of command's room
+//
+// so events of one room are published in the order its changes are made, even if commands are executed in parallel,
+// and quotas checked against the room (see Quotas) hold until the change is made.
+// Repeated command waits for the original one without the lock, so other rooms of the lock aren't blocked
+func (s *RoomService) executeAndPublish(ctx context.Context, streamID string, command *r.Command) (*r.Event, error) {
+	return s.processCommandOnce(ctx, command, func(ctx context.Context, command *r.Command) (*r.Event, error) {
+		if len(command.GetRoomId()) > 0 {
+			lock := s.roomLock(command.GetRoomId())
+			lock.Lock()
+			defer lock.Unlock()
+		}
+		returnEvent, err := s.processCommand(ctx, command)
+		if err == nil {
+			s.publishEvent(ctx, streamID, returnEvent)
+		}
+		return returnEvent, err
+	})
+}
+
+// publishEvent - publish command's result for other streams of the room (on any instance), see streamSubscriptions
+//
+// failure is only logged: the command is done, and its stream gets the result anyway
+func (s *RoomService) publishEvent(ctx context.Context, streamID string, event *r.Event) {
+	if s.eventBus == nil || !isBroadcast(event) {
+		return
+	}
+	payload, err := proto.Marshal(event)
+	if err == nil {
+		err = s.eventBus.Publish(ctx, &ports.RoomEvent{RoomID: event.GetRoomId(), Origin: streamID, Payload: payload})
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error(ctx, "failed to publish event", zap.Error(err))
+	}
+}
+
+func (s *RoomService) roomLock(roomID string) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(roomID))
+	return &s.roomLocks[hash.Sum32()%roomLockStripes]
+}
+
+// ownerLock - lock of rooms created by user, taken by createRoom while owned rooms are counted and the room is created
+//
+// it's one of room locks, create doesn't hold any room lock, so they don't wait for each other in a loop
+func (s *RoomService) ownerLock(ownerUserID types.NotEmptyText) *sync.Mutex {
+	return s.roomLock("owner/" + ownerUserID.String())
+}
+
+// roomStateEvent - current state of room for stream that might have lost its events:
+// FullRoom, or RoomDeleted if room doesn't exist anymore
+func (s *RoomService) roomStateEvent(ctx context.Context, roomID string) (*r.Event, error) {
+	roomIDParsed, err := types.NewUUID(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("room id '%s' - invalid uuid", roomID)
+	}
+	event := &r.Event{Timestamp: projectutils.NowTimestamp(), RoomId: roomID}
+	fullRoom, err := s.fullRoom(ctx, models.RoomID(roomIDParsed))
+	switch {
+	case errors.Is(err, roomerrors.ErrRoomDoesntExist):
+		event.Payload = &r.Event_RoomDeleted{RoomDeleted: &r.RoomDeletedEventBody{DeletedRoomId: roomID}}
+	case err != nil:
+		return nil, err
+	default:
+		event.Payload = &r.Event_FullRoom{FullRoom: fullRoom}
+	}
+	return event, nil
+}
+
+// streamSubscriptions - rooms whose events are sent into one stream: rooms where the stream joined a user
+//
+// stream is subscribed while at least one user it joined is in the room, its own events are skipped
+// (it gets them as results of its commands). If events of room might be lost, the stream gets the room's state
+// made by roomState
+type streamSubscriptions struct {
+	ctx       context.Context
+	bus       ports.EventBusPort
+	streamID  string
+	writer    *streamWriter
+	roomState func(ctx context.Context, roomID string) (*r.Event, error)
+
+	mu     sync.Mutex
+	closed bool
+	rooms  map[string]*roomSubscription
+}
+
+// roomSubscription - users joined to room through the stream
+type roomSubscription struct {
+	users       map[string]struct{}
+	unsubscribe func()
+}
+
+// newStreamSubscriptions - bus is optional (nil), then nothing is subscribed; ctx is stream's one, roomState reads rooms with it
+func newStreamSubscriptions(ctx context.Context, bus ports.EventBusPort, streamID string, writer *streamWriter, roomState func(ctx context.Context, roomID string) (*r.Event, error)) *streamSubscriptions {
+	return &streamSubscriptions{
+		ctx:       ctx,
+		bus:       bus,
+		streamID:  streamID,
+		writer:    writer,
+		roomState: roomState,
+		rooms:     make(map[string]*roomSubscription),
+	}
+}
+
+// track - subscribe or unsubscribe according to successfully processed command's event
+func (s *streamSubscriptions) track(ctx context.Context, event *r.Event) {
+	if s.bus == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	switch payload := event.GetPayload().(type) {
+	case *r.Event_JoinedRoom:
+		roomID := payload.JoinedRoom.GetRoomId()
+		subscription, ok := s.rooms[roomID]
+		if !ok {
+			unsubscribe, err := s.bus.Subscribe(ctx, roomID, s.handle)
+			if err != nil {
+				logging.FromContext(ctx).Error(ctx, "failed to subscribe to room events", zap.String("room_id", roomID), zap.Error(err))
+				return
+			}
+			subscription = &roomSubscription{users: make(map[string]struct{}), unsubscribe: unsubscribe}
+			s.rooms[roomID] = subscription
+		}
+		subscription.users[payload.JoinedRoom.GetUserFull().GetId()] = struct{}{}
+	case *r.Event_LeftRoom:
+		s.userLeft(payload.LeftRoom.GetRoomId(), payload.LeftRoom.GetKickedUserId())
+	case *r.Event_RoomDeleted:
+		s.unsubscribe(payload.RoomDeleted.GetDeletedRoomId())
+	}
+}
+
+// handle - send event of other stream into this one, users that left and deleted rooms are tracked
+func (s *streamSubscriptions) handle(roomEvent *ports.RoomEvent) {
+	if roomEvent.Resync {
+		s.resync(roomEvent.RoomID)
+		return
+	}
+	if roomEvent.Origin == s.streamID {
+		return
+	}
+	event := &r.Event{}
+	if err := proto.Unmarshal(roomEvent.Payload, event); err != nil {
+		return
+	}
+	// send fails only if stream is closed or too slow (then the writer handles it)
+	_ = s.writer.send(s.ctx, event)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch payload := event.GetPayload().(type) {
+	case *r.Event_LeftRoom:
+		s.userLeft(payload.LeftRoom.GetRoomId(), payload.LeftRoom.GetKickedUserId())
+	case *r.Event_RoomDeleted:
+		s.unsubscribe(payload.RoomDeleted.GetDeletedRoomId())
+	}
+}
+
+// resync - send state of room whose events might be lost, users of the stream that aren't in room anymore are forgotten
+func (s *streamSubscriptions) resync(roomID string) {
+	event, err := s.roomState(s.ctx, roomID)
+	if err != nil {
+		logging.FromContext(s.ctx).Error(s.ctx, "failed to resync room", zap.String("room_id", roomID), zap.Error(err))
+		return
+	}
+	_ = s.writer.send(s.ctx, event)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch payload := event.GetPayload().(type) {
+	case *r.Event_FullRoom:
+		subscription, ok := s.rooms[roomID]
+		if !ok {
+			return
+		}
+		inRoom := make(map[string]struct{}, len(payload.FullRoom.GetUsers()))
+		for _, user := range payload.FullRoom.GetUsers() {
+			inRoom[user.GetId()] = struct{}{}
+		}
+		for userID := range subscription.users {
+			if _, ok = inRoom[userID]; !ok {
+				s.userLeft(roomID, userID)
+			}
+		}
+	case *r.Event_RoomDeleted:
+		s.unsubscribe(roomID)
+	}
+}
+
+// close - unsubscribe from every room, must be called before the writer is closed
+func (s *streamSubscriptions) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for roomID := range s.rooms {
+		s.unsubscribe(roomID)
+	}
+}
+
+// userLeft - forget user, room is unsubscribed if the stream has no users there; mu must be held
+func (s *streamSubscriptions) userLeft(roomID, userID string) {
+	subscription, ok := s.rooms[roomID]
+	if !ok {
+		return
+	}
+	delete(subscription.users, userID)
+	if len(subscription.users) == 0 {
+		s.unsubscribe(roomID)
+	}
+}
+
+// unsubscribe - stop receiving events of room; mu must be held
+func (s *streamSubscriptions) unsubscribe(roomID string) {
+	if subscription, ok := s.rooms[roomID]; ok {
+		subscription.unsubscribe()
+		delete(s.rooms, roomID)
+	}
+}
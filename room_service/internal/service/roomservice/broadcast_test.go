@@ -0,0 +1,267 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/commandcache"
+	"github.com/chempik1234/room-service/internal/repositories/eventbus"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clientEventStream - grpc.BidiStreamingServer[r.Command, r.Event] of a client that sends commands one by one
+// and reads events as they come
+type clientEventStream struct {
+	grpc.ServerStream
+
+	ctx      context.Context
+	commands chan *r.Command
+	events   chan *r.Event
+}
+
+func newClientEventStream(ctx context.Context) *clientEventStream {
+	return &clientEventStream{ctx: ctx, commands: make(chan *r.Command, 10), events: make(chan *r.Event, 100)}
+}
+
+func (c *clientEventStream) Context() context.Context {
+	return c.ctx
+}
+
+func (c *clientEventStream) Recv() (*r.Command, error) {
+	select {
+	case command := <-c.commands:
+		return command, nil
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+func (c *clientEventStream) Send(event *r.Event) error {
+	c.events <- event
+	return nil
+}
+
+// expect - wait for the next event, it must be of type T
+func expect[T any](t *testing.T, c *clientEventStream, name string) *r.Event {
+	t.Helper()
+	select {
+	case event := <-c.events:
+		if _, ok := event.GetPayload().(T); !ok {
+			t.Fatalf("%s: unexpected event %v", name, event)
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s: no event", name)
+	}
+	return nil
+}
+
+func (c *clientEventStream) expectNothing(t *testing.T, name string) {
+	t.Helper()
+	select {
+	case event := <-c.events:
+		t.Fatalf("%s: unexpected event %v", name, event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStreamBroadcastsRoomChanges(t *testing.T) {
+	repo := room.NewInMemoryRepository()
+	owner, _ := types.NewNotEmptyText("owner")
+	newRoom, err := repo.CreateRoom(context.Background(), models.NewRoom(owner, map[string]string{"max_users": "10"}))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomID := newRoom.ID.String()
+
+	service := NewRoomService(repo, commandcache.NewInMemoryCommandCache(16, 1, 60000), nil,
+		eventbus.NewInProcessEventBus(0), nil, testRetryPolicy, StreamParams{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	alice, bob := newClientEventStream(ctx), newClientEventStream(ctx)
+	for _, stream := range []*clientEventStream{alice, bob} {
+		go func() { _ = service.Stream(stream) }()
+	}
+
+	newCommand := func(userID string) *r.Command {
+		return &r.Command{CommandId: types.GenerateUUID().String(), RoomId: &roomID, UserId: userID}
+	}
+	join := func(userID string) *r.Command {
+		command := newCommand(userID)
+		command.Payload = &r.Command_JoinRoom{JoinRoom: &r.JoinRoomCommandBody{UserFull: &r.User{Id: userID, Name: userID}}}
+		return command
+	}
+	leave := func(userID string) *r.Command {
+		command := newCommand(userID)
+		command.Payload = &r.Command_LeaveRoom{LeaveRoom: &r.LeaveRoomCommandBody{KickedUserId: userID}}
+		return command
+	}
+	setData := func(userID string, value int64) *r.Command {
+		command := newCommand(userID)
+		command.Payload = &r.Command_AffectData{AffectData: &r.SetAppendDeleteDataCommandBody{
+			DataId:    "score",
+			DataValue: &r.Value{Value: &r.Value_IntValue{IntValue: value}},
+		}}
+		return command
+	}
+
+	alice.commands <- join("alice")
+	expect[*r.Event_JoinedRoom](t, alice, "alice joined, result")
+
+	bob.commands <- join("bob")
+	expect[*r.Event_JoinedRoom](t, bob, "bob joined, result")
+	expect[*r.Event_JoinedRoom](t, alice, "bob joined, broadcast")
+
+	alice.commands <- setData("alice", 1)
+	expect[*r.Event_DataEdited](t, alice, "alice edited data, result")
+	expect[*r.Event_DataEdited](t, bob, "alice edited data, broadcast")
+	// result of own command comes once, not once more from the bus
+	alice.expectNothing(t, "alice edited data, duplicate")
+
+	bob.commands <- leave("bob")
+	expect[*r.Event_LeftRoom](t, bob, "bob left, result")
+	expect[*r.Event_LeftRoom](t, alice, "bob left, broadcast")
+
+	// stream of bob has no users in the room anymore, so it isn't subscribed to it
+	alice.commands <- setData("alice", 2)
+	expect[*r.Event_DataEdited](t, alice, "alice edited data again, result")
+	bob.expectNothing(t, "alice edited data again, broadcast")
+}
+
+func TestStreamResyncsRoomWithLostEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := room.NewInMemoryRepository()
+	owner, _ := types.NewNotEmptyText("owner")
+	newRoom, err := repo.CreateRoom(ctx, models.NewRoom(owner, map[string]string{"max_users": "10"}))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomID := newRoom.ID.String()
+	bus := eventbus.NewInProcessEventBus(0)
+	service := NewRoomService(repo, commandcache.NewInMemoryCommandCache(16, 1, 60000), nil, bus, nil, testRetryPolicy, StreamParams{}, nil)
+
+	stream := newClientEventStream(ctx)
+	go func() { _ = service.Stream(stream) }()
+	for _, userID := range []string{"alice", "bob"} {
+		stream.commands <- &r.Command{CommandId: types.GenerateUUID().String(), RoomId: &roomID, UserId: userID, Payload: &r.Command_JoinRoom{
+			JoinRoom: &r.JoinRoomCommandBody{UserFull: &r.User{Id: userID, Name: userID}},
+		}}
+		expect[*r.Event_JoinedRoom](t, stream, userID+" joined")
+	}
+
+	// changes whose events are lost
+	err = repo.AffectData(ctx, ports.AffectDataParams{RoomID: newRoom.ID, DataID: "score", Action: ports.ActionSet, Value: models.IntValue(1)})
+	if err != nil {
+		t.Fatalf("AffectData: %v", err)
+	}
+	err = repo.LeaveRoom(ctx, ports.LeaveRoomParams{RoomID: newRoom.ID, CommandCallerUserID: "alice", KickedUserID: "alice"})
+	if err != nil {
+		t.Fatalf("LeaveRoom: %v", err)
+	}
+	publishResync := func() {
+		if err := bus.Publish(ctx, &ports.RoomEvent{RoomID: roomID, Resync: true}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	publishResync()
+	fullRoom := expect[*r.Event_FullRoom](t, stream, "resync").GetFullRoom()
+	if fullRoom.GetRoom().GetValues()["score"].GetIntValue() != 1 || len(fullRoom.GetUsers()) != 1 {
+		t.Fatalf("resync sent %v, want score 1 and bob only", fullRoom)
+	}
+	if stats := bus.Stats(); stats.Subscriptions != 1 {
+		t.Fatalf("room is unsubscribed while bob is there: %+v", stats)
+	}
+
+	// deleted room is unsubscribed
+	if err = repo.DeleteRoom(ctx, ports.DeleteRoomParams{RoomID: newRoom.ID, UserID: owner}); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+	publishResync()
+	expect[*r.Event_RoomDeleted](t, stream, "resync of deleted room")
+	deadline := time.Now().Add(2 * time.Second)
+	for bus.Stats().Subscriptions != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("deleted room isn't unsubscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// orderedJoinsRepo - ports.RoomsPort that remembers users in the order they joined, joins return after random delay
+type orderedJoinsRepo struct {
+	ports.RoomsPort
+	mu     sync.Mutex
+	joined []string
+}
+
+func (o *orderedJoinsRepo) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	o.mu.Lock()
+	err := o.RoomsPort.JoinRoom(ctx, params)
+	if err == nil {
+		o.joined = append(o.joined, params.UserFull.ID.String())
+	}
+	o.mu.Unlock()
+	time.Sleep(time.Duration(rand.IntN(100)) * time.Microsecond)
+	return err
+}
+
+func TestEventsOfRoomArePublishedInOrderOfChanges(t *testing.T) {
+	ctx := context.Background()
+	repo := &orderedJoinsRepo{RoomsPort: room.NewInMemoryRepository()}
+	owner, _ := types.NewNotEmptyText("owner")
+	newRoom, err := repo.CreateRoom(ctx, models.NewRoom(owner, map[string]string{"max_users": "100"}))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomID := newRoom.ID.String()
+	bus := eventbus.NewInProcessEventBus(0)
+	service := NewRoomService(repo, nil, nil, bus, nil, testRetryPolicy, StreamParams{}, nil)
+
+	published := make(chan string, 100)
+	unsubscribe, err := bus.Subscribe(ctx, roomID, func(roomEvent *ports.RoomEvent) {
+		event := &r.Event{}
+		_ = proto.Unmarshal(roomEvent.Payload, event)
+		published <- event.GetJoinedRoom().GetUserFull().GetId()
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer unsubscribe()
+
+	// users join concurrently, events must come in the order they're joined
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID := fmt.Sprint("user-", i)
+			_, _ = service.ForwardCommand(ctx, &r.Command{RoomId: &roomID, UserId: userID, Payload: &r.Command_JoinRoom{
+				JoinRoom: &r.JoinRoomCommandBody{UserFull: &r.User{Id: userID, Name: userID}},
+			}})
+		}()
+	}
+	wg.Wait()
+
+	for i := range 50 {
+		select {
+		case userID := <-published:
+			if userID != repo.joined[i] {
+				t.Fatalf("event %d is join of %s, but %s joined", i, userID, repo.joined[i])
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("not every join is published")
+		}
+	}
+}
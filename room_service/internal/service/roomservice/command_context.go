@@ -0,0 +1,62 @@
+package roomservice
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// newCommandContext - ctx for processing one command received in a stream
+//
+// 1. derived from streamCtx, so client cancellation, deadline and gRPC metadata reach repositories
+//
+// 2. canceled if streams aren't drained in time on Shutdown
+//
+// 3. limited by RuntimeParams.CommandTimeout (if set)
+//
+// 4. stores request ID (from streamCtx, metadata or generated), command ID, user ID, trace ID (of the span in streamCtx or from metadata)
+// and child logger of the stream's logger with command's fields
+//
+// cancel must be called when command is processed
+func (s *RoomService) newCommandContext(streamCtx context.Context, command *r.Command) (context.Context, context.CancelFunc) {
+	ctx, cancelCtx := context.WithCancel(streamCtx)
+	stopCancelOnShutdown := context.AfterFunc(s.commandsCtx, cancelCtx)
+	cancel := func() {
+		stopCancelOnShutdown()
+		cancelCtx()
+	}
+
+	if timeout := s.RuntimeParams().CommandTimeout; timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancelParent := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelParent()
+		}
+	}
+
+	commandFields := []zap.Field{
+		zap.String(commandIDZapKey, command.GetCommandId()),
+		zap.String("room_id", command.GetRoomId()),
+		zap.String("user_id", command.GetUserId()),
+		zap.String("payload_type", commandPayloadType(command)),
+	}
+	// request ID is stored by interceptors or taken from metadata here, entries logged with ctx get it
+	if _, ok := logging.RequestIDFromContext(streamCtx); !ok {
+		ctx = logging.WithRequestID(ctx, projectutils.RequestIDFromIncomingContext(streamCtx))
+	}
+	ctx = projectutils.WithCommandID(ctx, command.GetCommandId())
+	ctx = projectutils.WithUserID(ctx, command.GetUserId())
+	if spanContext := trace.SpanContextFromContext(streamCtx); spanContext.HasTraceID() {
+		ctx = projectutils.WithTraceID(ctx, spanContext.TraceID().String())
+	} else if traceID := projectutils.TraceIDFromIncomingContext(streamCtx); len(traceID) > 0 {
+		ctx = projectutils.WithTraceID(ctx, traceID)
+	}
+
+	ctx = logging.WithLogger(ctx, logging.FromContext(streamCtx).With(commandFields...))
+	return ctx, cancel
+}
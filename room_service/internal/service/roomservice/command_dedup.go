@@ -0,0 +1,136 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+// commandInProgressPollInterval - how often a repeated command checks if the original one is finished
+const commandInProgressPollInterval = 50 * time.Millisecond
+
+// processCommandOnce - execute (e.g. processCommand), but idempotent by commandID (scoped by userID)
+//
+// 1. commandID is reserved by us -> execute, store result (or release commandID on failure)
+//
+// 2. command is done -> return stored result without executing
+//
+// 3. command is in progress -> wait for it (as long as ctx allows, i.e. command timeout) and do 2.
+// or, if it's released, try 1. again
+//
+// only execute is called under locks of the command (see executeAndPublish), a repeat waits without them.
+// If commandID is empty, no checks are applied
+func (s *RoomService) processCommandOnce(ctx context.Context, in *r.Command, execute func(ctx context.Context, in *r.Command) (*r.Event, error)) (*r.Event, error) {
+	if len(in.GetCommandId()) == 0 {
+		return execute(ctx, in)
+	}
+	commandID := scopedCommandID(in.GetUserId(), in.GetCommandId())
+
+	baseEvent := &r.Event{
+		Timestamp: projectutils.NowTimestamp(),
+		RoomId:    in.GetRoomId(),
+		UserId:    in.GetUserId(),
+	}
+
+	//region reserve command id or get stored result
+	for {
+		alreadySeen, err := s.commandIdShortCache.Reserve(ctx, commandID)
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "failed to reserve command_id in short cache", zap.Error(err))
+			return baseEvent, fmt.Errorf("failed to reserve command_id in short cache: %w", err)
+		}
+		if !alreadySeen {
+			break
+		}
+
+		record, err := s.awaitCommandRecord(ctx, commandID)
+		if err != nil {
+			logging.FromContext(ctx).Error(ctx, "failed to get command_id from short cache", zap.Error(err))
+			return baseEvent, fmt.Errorf("failed to get command_id from short cache: %w", err)
+		}
+		if record != nil && record.State == ports.CommandStateDone {
+			logging.FromContext(ctx).Info(ctx, "command_id is already done, returning stored result")
+			storedEvent := &r.Event{}
+			if err = proto.Unmarshal(record.Event, storedEvent); err != nil {
+				return baseEvent, fmt.Errorf("failed to decode stored result of command_id '%s': %w", commandID, err)
+			}
+			return storedEvent, nil
+		}
+		// released (failed or expired) -> try to reserve again
+	}
+	//endregion
+
+	//region execute
+	returnEvent, err := execute(ctx, in)
+	if err != nil {
+		// release commandID, so command can be retried
+		s.releaseCommandID(ctx, commandID)
+		return returnEvent, err
+	}
+
+	encodedEvent, errEncode := proto.Marshal(returnEvent)
+	if errEncode != nil {
+		logging.FromContext(ctx).Error(ctx, "failed to encode result of command", zap.Error(errEncode))
+		s.releaseCommandID(ctx, commandID)
+		return returnEvent, nil
+	}
+	s.saveCommandRecord(ctx, commandID, &ports.CommandRecord{State: ports.CommandStateDone, Event: encodedEvent})
+	//endregion
+
+	return returnEvent, nil
+}
+
+// awaitCommandRecord - get record of commandID, if it's in progress - wait until it's not or ctx is done
+//
+// returns nil if commandID is unknown
+func (s *RoomService) awaitCommandRecord(ctx context.Context, commandID string) (*ports.CommandRecord, error) {
+	ticker := time.NewTicker(commandInProgressPollInterval)
+	defer ticker.Stop()
+
+	for {
+		record, err := s.commandIdShortCache.Get(ctx, commandID)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil || record.State != ports.CommandStateInProgress {
+			return record, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("command_id '%s' is still in progress: %w", commandID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// saveCommandRecord - save record of commandID, errors are only logged because command result doesn't depend on them
+//
+// saved even if ctx is canceled, because command is already executed
+func (s *RoomService) saveCommandRecord(ctx context.Context, commandID string, record *ports.CommandRecord) {
+	if err := s.commandIdShortCache.Save(context.WithoutCancel(ctx), commandID, record); err != nil {
+		logging.FromContext(ctx).Error(ctx, "failed to save command_id into short cache", zap.Error(err))
+	}
+}
+
+// releaseCommandID - release commandID, errors are only logged because command result doesn't depend on them
+//
+// released even if ctx is canceled, otherwise retries would wait for commandID to expire
+func (s *RoomService) releaseCommandID(ctx context.Context, commandID string) {
+	if err := s.commandIdShortCache.Release(context.WithoutCancel(ctx), commandID); err != nil {
+		logging.FromContext(ctx).Error(ctx, "failed to release command_id in short cache", zap.Error(err))
+	}
+}
+
+// scopedCommandID - commandID that is unique per user, so different users can't collide on the same commandID
+//
+// userID length is included, so ("a:b", "c") and ("a", "b:c") give different results
+func scopedCommandID(userID string, commandID string) string {
+	return fmt.Sprintf("%d:%s:%s", len(userID), userID, commandID)
+}
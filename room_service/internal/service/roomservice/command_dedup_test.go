@@ -0,0 +1,203 @@
+package roomservice
+
+import (
+	"context"
+	"errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/commandcache"
+	"github.com/chempik1234/room-service/internal/repositories/eventbus"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"google.golang.org/protobuf/proto"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedRoomsRepo - ports.RoomsPort whose JoinRoom is counted, waits for gate (if set) and fails with err (if set)
+type gatedRoomsRepo struct {
+	ports.RoomsPort
+	joins   atomic.Int32
+	entered chan struct{}
+	gate    chan struct{}
+	err     error
+}
+
+func (g *gatedRoomsRepo) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	g.joins.Add(1)
+	if g.gate != nil {
+		g.entered <- struct{}{}
+		<-g.gate
+	}
+	if g.err != nil {
+		return g.err
+	}
+	return g.RoomsPort.JoinRoom(ctx, params)
+}
+
+func newDedupTestService(t *testing.T) (*RoomService, *gatedRoomsRepo, *commandcache.InMemoryCommandCache, string) {
+	storage := room.NewInMemoryRepository()
+	owner, _ := types.NewNotEmptyText("owner")
+	newRoom, err := storage.CreateRoom(context.Background(), models.NewRoom(owner, map[string]string{"max_users": "10"}))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	repo := &gatedRoomsRepo{RoomsPort: storage}
+	cache := commandcache.NewInMemoryCommandCache(16, 1, 60000)
+	service := NewRoomService(repo, cache, nil, nil, nil, testRetryPolicy, StreamParams{}, nil)
+	return service, repo, cache, newRoom.ID.String()
+}
+
+func newJoinCommand(roomID string, commandID string) *r.Command {
+	return &r.Command{CommandId: commandID, RoomId: &roomID, UserId: "alice", Payload: &r.Command_JoinRoom{
+		JoinRoom: &r.JoinRoomCommandBody{UserFull: &r.User{Id: "alice", Name: "alice"}},
+	}}
+}
+
+func TestProcessCommandOnceReplaysDoneCommand(t *testing.T) {
+	service, repo, cache, roomID := newDedupTestService(t)
+	ctx := context.Background()
+
+	first, err := service.processCommandOnce(ctx, newJoinCommand(roomID, "command-1"), service.processCommand)
+	if err != nil || first.GetJoinedRoom() == nil {
+		t.Fatalf("first execution = %v, %v, want joined room event", first, err)
+	}
+	record, _ := cache.Get(ctx, scopedCommandID("alice", "command-1"))
+	if record == nil || record.State != ports.CommandStateDone {
+		t.Fatalf("stored record = %+v, want done", record)
+	}
+
+	repeated, err := service.processCommandOnce(ctx, newJoinCommand(roomID, "command-1"), service.processCommand)
+	if err != nil || !proto.Equal(repeated, first) {
+		t.Fatalf("repeated execution = %v, %v, want stored event %v", repeated, err, first)
+	}
+	if joins := repo.joins.Load(); joins != 1 {
+		t.Fatalf("command is executed %d times, want 1", joins)
+	}
+
+	// the same commandID of another user is another command
+	other := newJoinCommand(roomID, "command-1")
+	other.UserId = "bob"
+	other.GetJoinRoom().UserFull = &r.User{Id: "bob", Name: "bob"}
+	if _, err = service.processCommandOnce(ctx, other, service.processCommand); err != nil || repo.joins.Load() != 2 {
+		t.Fatalf("command of another user isn't executed: %v, joins %d", err, repo.joins.Load())
+	}
+}
+
+func TestProcessCommandOnceWaitsForCommandInProgress(t *testing.T) {
+	service, repo, _, roomID := newDedupTestService(t)
+	repo.entered, repo.gate = make(chan struct{}, 1), make(chan struct{})
+	ctx := context.Background()
+
+	results := make(chan *r.Event, 2)
+	for range 2 {
+		go func() {
+			event, err := service.processCommandOnce(ctx, newJoinCommand(roomID, "command-1"), service.processCommand)
+			if err != nil {
+				t.Errorf("processCommandOnce: %v", err)
+			}
+			results <- event
+		}()
+	}
+
+	// one execution is in progress, the repeat waits for it instead of executing
+	<-repo.entered
+	select {
+	case event := <-results:
+		t.Fatalf("repeat returned %v while command is in progress", event)
+	case <-time.After(3 * commandInProgressPollInterval):
+	}
+	close(repo.gate)
+
+	for range 2 {
+		select {
+		case event := <-results:
+			if event.GetJoinedRoom() == nil {
+				t.Fatalf("unexpected event %v", event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("command isn't finished")
+		}
+	}
+	if joins := repo.joins.Load(); joins != 1 {
+		t.Fatalf("command is executed %d times, want 1", joins)
+	}
+}
+
+func TestProcessCommandOnceReleasesFailedCommand(t *testing.T) {
+	service, repo, cache, roomID := newDedupTestService(t)
+	repo.err = errors.New("storage is down")
+	ctx := context.Background()
+
+	if _, err := service.processCommandOnce(ctx, newJoinCommand(roomID, "command-1"), service.processCommand); err == nil {
+		t.Fatal("expected error of failed command")
+	}
+	if record, _ := cache.Get(ctx, scopedCommandID("alice", "command-1")); record != nil {
+		t.Fatalf("failed command isn't released: %+v", record)
+	}
+
+	// retry of failed command is executed again
+	repo.err = nil
+	event, err := service.processCommandOnce(ctx, newJoinCommand(roomID, "command-1"), service.processCommand)
+	if err != nil || event.GetJoinedRoom() == nil {
+		t.Fatalf("retry = %v, %v, want joined room event", event, err)
+	}
+	if joins := repo.joins.Load(); joins != 2 {
+		t.Fatalf("command is executed %d times, want 2", joins)
+	}
+}
+
+func TestRepeatedCommandWaitsWithoutRoomLock(t *testing.T) {
+	storage := room.NewInMemoryRepository()
+	owner, _ := types.NewNotEmptyText("owner")
+	newRoom, err := storage.CreateRoom(context.Background(), models.NewRoom(owner, map[string]string{"max_users": "10"}))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	roomID := newRoom.ID.String()
+	cache := commandcache.NewInMemoryCommandCache(16, 1, 60000)
+	service := NewRoomService(storage, cache, nil, eventbus.NewInProcessEventBus(0), nil, testRetryPolicy, StreamParams{}, nil)
+
+	// the original command is in progress somewhere else (e.g. another stream or instance)
+	if _, err = cache.Reserve(context.Background(), scopedCommandID("alice", "command-1")); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	// its repeat waits as long as its ctx (command timeout) allows
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	repeated := make(chan error, 1)
+	go func() {
+		_, err := service.executeAndPublish(ctx, "stream-1", newJoinCommand(roomID, "command-1"))
+		repeated <- err
+	}()
+	time.Sleep(3 * commandInProgressPollInterval)
+
+	// other commands of the room aren't blocked by the waiting repeat
+	other := newJoinCommand(roomID, "command-2")
+	other.UserId = "bob"
+	other.GetJoinRoom().UserFull = &r.User{Id: "bob", Name: "bob"}
+	done := make(chan error, 1)
+	go func() {
+		_, err := service.executeAndPublish(context.Background(), "stream-2", other)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("other command: %v", err)
+		}
+	case <-time.After(250 * time.Millisecond):
+		t.Fatal("other command of the room waits for the repeat")
+	}
+
+	select {
+	case err = <-repeated:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("repeat = %v, want deadline of its ctx", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("repeat doesn't stop waiting when its ctx is done")
+	}
+}
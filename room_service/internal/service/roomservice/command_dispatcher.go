@@ -0,0 +1,135 @@
+package roomservice
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// CommandOrdering is type for ordering modes of commands received in one stream ENUM
+type CommandOrdering string
+
+const (
+	// CommandOrderingStream - commands of a stream are executed one by one, in order they're received
+	CommandOrderingStream CommandOrdering = "stream"
+	// CommandOrderingRoom - commands of a stream are executed in order per room, different rooms are parallel
+	CommandOrderingRoom CommandOrdering = "room"
+	// CommandOrderingParallel - commands of a stream are executed in parallel, no order guaranteed
+	CommandOrderingParallel CommandOrdering = "parallel"
+)
+
+const (
+	defaultCommandQueueSize   = 64
+	defaultCommandRoomWorkers = 8
+)
+
+// CommandOrderingParams - how RoomService.Stream executes received commands
+type CommandOrderingParams struct {
+	Mode CommandOrdering
+	// QueueSize - max commands waiting for execution (per worker), receiving is paused when it's full
+	//
+	// in CommandOrderingParallel it's max commands executed at once
+	QueueSize int
+	// RoomWorkers - workers per stream in CommandOrderingRoom, rooms are spread over them by room ID hash
+	RoomWorkers int
+}
+
+// withDefaults - replace empty fields with defaults
+func (p CommandOrderingParams) withDefaults() CommandOrderingParams {
+	if len(p.Mode) == 0 {
+		p.Mode = CommandOrderingStream
+	}
+	if p.QueueSize <= 0 {
+		p.QueueSize = defaultCommandQueueSize
+	}
+	if p.RoomWorkers <= 0 {
+		p.RoomWorkers = defaultCommandRoomWorkers
+	}
+	return p
+}
+
+// commandDispatcher - executes commands of one stream according to CommandOrdering
+type commandDispatcher interface {
+	// dispatch - schedule fn for execution, blocks while queue is full (backpressure)
+	dispatch(roomID string, fn func())
+	// close - stop accepting commands and wait for all scheduled ones
+	close()
+}
+
+func newCommandDispatcher(params CommandOrderingParams) commandDispatcher {
+	switch params.Mode {
+	case CommandOrderingRoom:
+		return newLanesDispatcher(params.RoomWorkers, params.QueueSize)
+	case CommandOrderingParallel:
+		return &parallelDispatcher{slots: make(chan struct{}, params.QueueSize)}
+	default:
+		return newLanesDispatcher(1, params.QueueSize)
+	}
+}
+
+// lanesDispatcher - N sequential lanes, command goes to lane by room ID hash
+//
+// 1 lane = whole stream is sequential
+type lanesDispatcher struct {
+	lanes []chan func()
+	wg    sync.WaitGroup
+}
+
+func newLanesDispatcher(lanesAmount int, queueSize int) *lanesDispatcher {
+	d := &lanesDispatcher{lanes: make([]chan func(), lanesAmount)}
+	for i := range d.lanes {
+		lane := make(chan func(), queueSize)
+		d.lanes[i] = lane
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for fn := range lane {
+				fn()
+			}
+		}()
+	}
+	return d
+}
+
+func (d *lanesDispatcher) dispatch(roomID string, fn func()) {
+	d.lanes[d.lane(roomID)] <- fn
+}
+
+// lane - index of lane that executes commands of roomID
+func (d *lanesDispatcher) lane(roomID string) int {
+	if len(d.lanes) == 1 {
+		return 0
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(roomID))
+	return int(hash.Sum32() % uint32(len(d.lanes)))
+}
+
+func (d *lanesDispatcher) close() {
+	for _, lane := range d.lanes {
+		close(lane)
+	}
+	d.wg.Wait()
+}
+
+// parallelDispatcher - goroutine per command, but no more than len(slots) at once
+type parallelDispatcher struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (d *parallelDispatcher) dispatch(_ string, fn func()) {
+	d.slots <- struct{}{}
+	d.wg.Add(1)
+	go func() {
+		defer func() {
+			<-d.slots
+			d.wg.Done()
+		}()
+		fn()
+	}()
+}
+
+func (d *parallelDispatcher) close() {
+	d.wg.Wait()
+}
@@ -0,0 +1,225 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roomsOfDifferentLanes - two room IDs that go to different lanes of newLanesDispatcher(lanes, ...)
+func roomsOfDifferentLanes(t *testing.T, lanes int) (string, string) {
+	d := newLanesDispatcher(lanes, 1)
+	defer d.close()
+	for i := 1; i < 100; i++ {
+		roomID := fmt.Sprintf("room-%d", i)
+		if d.lane(roomID) != d.lane("room-0") {
+			return "room-0", roomID
+		}
+	}
+	t.Fatal("all rooms go to one lane")
+	return "", ""
+}
+
+func TestStreamDispatcherKeepsOrder(t *testing.T) {
+	d := newCommandDispatcher(CommandOrderingParams{Mode: CommandOrderingStream}.withDefaults())
+
+	var executed []int
+	var running atomic.Int32
+	for i := range 100 {
+		// commands of different rooms are ordered too
+		d.dispatch(fmt.Sprintf("room-%d", i%5), func() {
+			if running.Add(1) != 1 {
+				t.Error("commands of a stream are executed concurrently")
+			}
+			defer running.Add(-1)
+			executed = append(executed, i)
+		})
+	}
+	d.close()
+
+	if len(executed) != 100 {
+		t.Fatalf("executed %d commands, want 100", len(executed))
+	}
+	for i, value := range executed {
+		if value != i {
+			t.Fatalf("command %d is executed at position %d", value, i)
+		}
+	}
+}
+
+func TestRoomDispatcherKeepsOrderPerRoom(t *testing.T) {
+	const lanes = 4
+	roomA, roomB := roomsOfDifferentLanes(t, lanes)
+	d := newCommandDispatcher(CommandOrderingParams{Mode: CommandOrderingRoom, RoomWorkers: lanes}.withDefaults())
+
+	// room A is blocked, room B still goes on
+	blockA := make(chan struct{})
+	d.dispatch(roomA, func() { <-blockA })
+	doneB := make(chan struct{})
+	d.dispatch(roomB, func() { close(doneB) })
+	select {
+	case <-doneB:
+	case <-time.After(2 * time.Second):
+		t.Fatal("command of another room waits for the blocked room")
+	}
+	close(blockA)
+
+	var mu sync.Mutex
+	executed := map[string][]int{}
+	for i := range 200 {
+		roomID := fmt.Sprintf("room-%d", i%7)
+		d.dispatch(roomID, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			executed[roomID] = append(executed[roomID], i)
+		})
+	}
+	d.close()
+
+	for roomID, commands := range executed {
+		for i := 1; i < len(commands); i++ {
+			if commands[i] < commands[i-1] {
+				t.Fatalf("commands of %s are executed out of order: %v", roomID, commands)
+			}
+		}
+	}
+}
+
+func TestParallelDispatcherLimitsConcurrency(t *testing.T) {
+	const limit = 4
+	d := newCommandDispatcher(CommandOrderingParams{Mode: CommandOrderingParallel, QueueSize: limit}.withDefaults())
+
+	release := make(chan struct{})
+	var running, maxRunning atomic.Int32
+	started := make(chan struct{}, limit)
+	fn := func() {
+		current := running.Add(1)
+		for {
+			previous := maxRunning.Load()
+			if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		started <- struct{}{}
+		<-release
+		running.Add(-1)
+	}
+	for range limit {
+		d.dispatch("room", fn)
+	}
+	// commands of the same room are executed at once
+	for range limit {
+		<-started
+	}
+
+	blocked := make(chan struct{})
+	go func() {
+		d.dispatch("room", func() {})
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("dispatch doesn't block while all slots are busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-blocked
+	d.close()
+
+	if maxRunning.Load() != limit {
+		t.Fatalf("max commands executed at once = %d, want %d", maxRunning.Load(), limit)
+	}
+}
+
+func TestLanesDispatcherBlocksWhenQueueIsFull(t *testing.T) {
+	d := newCommandDispatcher(CommandOrderingParams{Mode: CommandOrderingStream, QueueSize: 2}.withDefaults())
+
+	release, started := make(chan struct{}), make(chan struct{})
+	d.dispatch("room", func() { close(started); <-release })
+	<-started
+	// queue of 2 is filled, the next dispatch waits (so the stream stops receiving)
+	var executed atomic.Int32
+	for range 2 {
+		d.dispatch("room", func() { executed.Add(1) })
+	}
+	blocked := make(chan struct{})
+	go func() {
+		d.dispatch("room", func() { executed.Add(1) })
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("dispatch doesn't block while queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-blocked
+	// close waits for all scheduled commands
+	d.close()
+	if executed.Load() != 3 {
+		t.Fatalf("executed %d queued commands, want 3", executed.Load())
+	}
+}
+
+func TestStreamOrdersCommandsPerRoom(t *testing.T) {
+	storage := room.NewInMemoryRepository()
+	owner, _ := types.NewNotEmptyText("owner")
+	rooms := make([]*models.Room, 3)
+	roomIDs := make([]string, len(rooms))
+	for i := range rooms {
+		newRoom, err := storage.CreateRoom(context.Background(), models.NewRoom(owner, map[string]string{"max_users": "10"}))
+		if err != nil {
+			t.Fatalf("CreateRoom: %v", err)
+		}
+		rooms[i], roomIDs[i] = newRoom, newRoom.ID.String()
+	}
+
+	const commandsPerRoom = 30
+	var commands []*r.Command
+	for i := range commandsPerRoom {
+		for _, roomID := range roomIDs {
+			commands = append(commands, &r.Command{RoomId: &roomID, UserId: "owner", Payload: &r.Command_AffectData{
+				AffectData: &r.SetAppendDeleteDataCommandBody{
+					DataId:      "counter",
+					DataValue:   &r.Value{Value: &r.Value_IntValue{IntValue: int64(i)}},
+					CommandMode: r.DateEditMode_APPEND,
+				},
+			}})
+		}
+	}
+
+	service := NewRoomService(storage, nil, nil, nil, nil, testRetryPolicy, StreamParams{
+		Ordering: CommandOrderingParams{Mode: CommandOrderingRoom, RoomWorkers: 3},
+	}, nil)
+	stream := newFakeEventStream(t, commands)
+	if err := service.Stream(stream); err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	if len(stream.sent) != len(commands) {
+		t.Fatalf("sent %d events, want %d", len(stream.sent), len(commands))
+	}
+	// items of every room are appended in order of its commands
+	expected := make([]models.Value, commandsPerRoom)
+	for i := range expected {
+		expected[i] = *models.IntValue(int64(i))
+	}
+	for _, newRoom := range rooms {
+		snapshot, err := storage.RoomSnapshot(context.Background(), ports.RoomSnapshotParams{RoomID: newRoom.ID})
+		if err != nil {
+			t.Fatalf("RoomSnapshot: %v", err)
+		}
+		if items := snapshot.Values["counter"]; !items.Equal(models.ListValue(expected)) {
+			t.Fatalf("room %s: items are appended out of order: %v", newRoom.ID.String(), items)
+		}
+	}
+}
@@ -6,21 +6,28 @@ import (
 	"github.com/chempik1234/room-service/internal/models"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
-	"github.com/wb-go/wbf/retry"
 )
 
 func (s *RoomService) createRoom(ctx context.Context, userID types.NotEmptyText, payload *r.Command_CreateRoom) (roomID models.RoomID, roomCreatedPayload *r.Event_RoomCreated, err error) {
 	newRoom := models.NewRoom(userID, payload.CreateRoom.GetRoomOptions())
+	if s.RuntimeParams().Quotas.MaxRoomsPerOwner > 0 {
+		lock := s.ownerLock(userID)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	if err = s.checkCreateRoomQuotas(ctx, userID, newRoom.Options); err != nil {
+		return roomID, roomCreatedPayload, err
+	}
 
 	//region create room logic
-	err = retry.Do(func() error {
+	err = s.retry(ctx, "create_room", func(ctx context.Context) error {
 		var err error
 		newRoom, err = s.roomsRepo.CreateRoom(ctx, newRoom)
 		if err != nil {
 			return err
 		}
 		return nil
-	}, s.retryStrategy)
+	})
 	if err != nil {
 		return roomID, roomCreatedPayload, fmt.Errorf("failed to create room: %w", err)
 	}
@@ -6,9 +6,8 @@ import (
 	"github.com/chempik1234/room-service/internal/models"
 	"github.com/chempik1234/room-service/internal/ports"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
-	"github.com/chempik1234/super-danis-library-golang/v2/pkg/logger"
+	"github.com/chempik1234/room-service/pkg/logging"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
-	"github.com/wb-go/wbf/retry"
 	"go.uber.org/zap"
 )
 
@@ -27,14 +26,17 @@ func (s *RoomService) joinRoom(ctx context.Context, params *roomServiceJoinRoomP
 	}
 
 	//region join room logic
-	err = retry.Do(func() error {
+	if err = s.checkJoinRoomQuotas(ctx, *params.roomID, userModel); err != nil {
+		return payload, err
+	}
+	err = s.retry(ctx, "join_room", func(ctx context.Context) error {
 		return s.roomsRepo.JoinRoom(ctx, ports.JoinRoomParams{
 			RoomID:   *params.roomID,
 			UserFull: userModel,
 		})
-	}, s.retryStrategy)
+	})
 	if err != nil {
-		logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to join room", zap.Error(err))
+		logging.FromContext(ctx).Error(ctx, "failed to join room", zap.Error(err))
 		return payload, fmt.Errorf("failed to join room: %w", err)
 	}
 	//endregion
@@ -7,7 +7,6 @@ import (
 	"github.com/chempik1234/room-service/internal/ports"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
-	"github.com/wb-go/wbf/retry"
 )
 
 type leaveRoomParams struct {
@@ -17,13 +16,13 @@ type leaveRoomParams struct {
 }
 
 func (s *RoomService) leaveRoom(ctx context.Context, params *leaveRoomParams) (payload *r.Event_LeftRoom, err error) {
-	err = retry.Do(func() error {
+	err = s.retry(ctx, "leave_room", func(ctx context.Context) error {
 		return s.roomsRepo.LeaveRoom(ctx, ports.LeaveRoomParams{
 			RoomID:              *params.roomID,
 			CommandCallerUserID: params.userID,
 			KickedUserID:        params.kickedUserID,
 		})
-	}, s.retryStrategy)
+	})
 	if err != nil {
 		return payload, fmt.Errorf("failed to leave room: %w", err)
 	}
@@ -0,0 +1,132 @@
+package roomservice
+
+import (
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"sync"
+)
+
+// roomPresence - which users are joined to which rooms through streams of this instance
+//
+// user is counted once per stream that joined him, so he's present until every such stream is closed (or he left)
+type roomPresence struct {
+	mu sync.Mutex
+	// rooms - roomID -> userID -> amount of streams that joined the user
+	rooms map[string]map[string]int
+}
+
+// presenceKey - one user in one room
+type presenceKey struct {
+	roomID string
+	userID string
+}
+
+func newRoomPresence() *roomPresence {
+	return &roomPresence{rooms: make(map[string]map[string]int)}
+}
+
+// memberCounts - members amount of every room that has at least one member
+func (p *roomPresence) memberCounts() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make([]int, 0, len(p.rooms))
+	for _, users := range p.rooms {
+		counts = append(counts, len(users))
+	}
+	return counts
+}
+
+func (p *roomPresence) join(key presenceKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users, ok := p.rooms[key.roomID]
+	if !ok {
+		users = make(map[string]int)
+		p.rooms[key.roomID] = users
+	}
+	users[key.userID]++
+}
+
+// release - one stream that joined the user is closed
+func (p *roomPresence) release(key presenceKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users, ok := p.rooms[key.roomID]
+	if !ok {
+		return
+	}
+	if users[key.userID] <= 1 {
+		delete(users, key.userID)
+	} else {
+		users[key.userID]--
+	}
+	if len(users) == 0 {
+		delete(p.rooms, key.roomID)
+	}
+}
+
+// leave - user left the room, no matter how many streams joined him
+func (p *roomPresence) leave(key presenceKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if users, ok := p.rooms[key.roomID]; ok {
+		delete(users, key.userID)
+		if len(users) == 0 {
+			delete(p.rooms, key.roomID)
+		}
+	}
+}
+
+func (p *roomPresence) deleteRoom(roomID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.rooms, roomID)
+}
+
+// streamPresence - joins made by one stream, released when the stream is closed
+type streamPresence struct {
+	global *roomPresence
+	mu     sync.Mutex
+	joined map[presenceKey]struct{}
+}
+
+func newStreamPresence(global *roomPresence) *streamPresence {
+	return &streamPresence{global: global, joined: make(map[presenceKey]struct{})}
+}
+
+// track - update presence according to successfully processed command's event
+func (p *streamPresence) track(event *r.Event) {
+	switch payload := event.GetPayload().(type) {
+	case *r.Event_JoinedRoom:
+		key := presenceKey{roomID: payload.JoinedRoom.GetRoomId(), userID: payload.JoinedRoom.GetUserFull().GetId()}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.joined[key]; !ok {
+			p.joined[key] = struct{}{}
+			p.global.join(key)
+		}
+	case *r.Event_LeftRoom:
+		key := presenceKey{roomID: payload.LeftRoom.GetRoomId(), userID: payload.LeftRoom.GetKickedUserId()}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.joined, key)
+		p.global.leave(key)
+	case *r.Event_RoomDeleted:
+		p.global.deleteRoom(payload.RoomDeleted.GetDeletedRoomId())
+	}
+}
+
+// close - release every join made by the stream
+func (p *streamPresence) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key := range p.joined {
+		p.global.release(key)
+	}
+	p.joined = make(map[presenceKey]struct{})
+}
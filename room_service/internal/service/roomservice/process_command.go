@@ -8,7 +8,7 @@ import (
 	"github.com/chempik1234/room-service/internal/ports"
 	"github.com/chempik1234/room-service/internal/projectutils"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
-	"github.com/chempik1234/super-danis-library-golang/v2/pkg/logger"
+	"github.com/chempik1234/room-service/pkg/logging"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
 	"go.uber.org/zap"
 )
@@ -16,9 +16,7 @@ import (
 func (s *RoomService) processCommand(ctx context.Context, in *r.Command) (*r.Event, error) {
 	var err error
 
-	//check command id no-repeat
-	commandID, err := s.noRepeatCommandID(ctx, in)
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "command received, processing", zap.String(commandIDZapKey, commandID))
+	logging.FromContext(ctx).Info(ctx, "command received, processing")
 
 	returnEvent := &r.Event{
 		Timestamp: projectutils.NowTimestamp(),
@@ -34,7 +32,7 @@ func (s *RoomService) processCommand(ctx context.Context, in *r.Command) (*r.Eve
 	var userIDValid types.NotEmptyText
 	userIDValid, err = types.NewNotEmptyText(in.GetUserId())
 	if err != nil {
-		logger.GetLoggerFromCtx(ctx).Warn(ctx, "someone entered empty userID")
+		logging.FromContext(ctx).Warn(ctx, "someone entered empty userID")
 		return returnEvent, errors.New("userID is empty")
 	}
 	//endregion
@@ -51,7 +49,7 @@ func (s *RoomService) processCommand(ctx context.Context, in *r.Command) (*r.Eve
 		var roomID models.RoomID
 		roomID, returnEvent.Payload, err = s.createRoom(ctx, userIDValid, payload)
 		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to create room", zap.Error(err))
+			logging.FromContext(ctx).Error(ctx, "failed to create room", zap.Error(err))
 		}
 		returnEvent.RoomId = roomID.String()
 		break
@@ -59,14 +57,14 @@ func (s *RoomService) processCommand(ctx context.Context, in *r.Command) (*r.Eve
 	case *r.Command_DeleteRoom:
 		returnEvent.Payload, err = s.deleteRoom(ctx, userIDValid, roomIDValidated)
 		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to delete room", zap.Error(err))
+			logging.FromContext(ctx).Error(ctx, "failed to delete room", zap.Error(err))
 		}
 		break
 		//endregion
 	case *r.Command_JoinRoom:
 		joinedUserID, joinedUserName, joinedUserMetadata, err := s.getJoinedUserFull(payload.JoinRoom.UserFull)
 		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to get joined user full", zap.Error(err))
+			logging.FromContext(ctx).Error(ctx, "failed to get joined user full", zap.Error(err))
 			return returnEvent, fmt.Errorf("failed to get joined user full: %w", err)
 		}
 		returnEvent.Payload, err = s.joinRoom(ctx, &roomServiceJoinRoomParams{
@@ -75,12 +73,16 @@ func (s *RoomService) processCommand(ctx context.Context, in *r.Command) (*r.Eve
 			joinedUserName:     joinedUserName,
 			joinedUserMetadata: joinedUserMetadata,
 		})
+		// err is declared in this case, so it must be returned here
+		if err != nil {
+			return returnEvent, err
+		}
 		break
 		//endregion
 	case *r.Command_LeaveRoom:
 		kickedUserIDValid, err := s.getKickedUserID(payload.LeaveRoom)
 		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to get kicked user id", zap.Error(err))
+			logging.FromContext(ctx).Error(ctx, "failed to get kicked user id", zap.Error(err))
 			return returnEvent, fmt.Errorf("failed to get kicked user id: %w", err)
 		}
 
@@ -90,7 +92,7 @@ func (s *RoomService) processCommand(ctx context.Context, in *r.Command) (*r.Eve
 			kickedUserID: kickedUserIDValid,
 		})
 		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to leave room", zap.Error(err))
+			logging.FromContext(ctx).Error(ctx, "failed to leave room", zap.Error(err))
 			return returnEvent, fmt.Errorf("failed to leave room: %w", err)
 		}
 		break
@@ -104,15 +106,14 @@ func (s *RoomService) processCommand(ctx context.Context, in *r.Command) (*r.Eve
 				Action: ports.Action(payload.AffectData.CommandMode),
 			})
 		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to affect data in room", zap.Error(err))
+			logging.FromContext(ctx).Error(ctx, "failed to affect data in room", zap.Error(err))
 			return returnEvent, fmt.Errorf("failed to affect data in room: %w", err)
 		}
 		break
 	case *r.Command_RefreshRoom:
-		// TODO: rate limiter per room
 		returnEvent.Payload, err = s.refreshRoom(ctx, roomIDValidated)
 		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to refresh room", zap.Error(err))
+			logging.FromContext(ctx).Error(ctx, "failed to refresh room", zap.Error(err))
 			return returnEvent, fmt.Errorf("failed to refresh room: %w", err)
 		}
 		break
@@ -0,0 +1,226 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+)
+
+// Quotas - limits of stored data, checked before RoomsPort is called, 0 = no limit
+//
+// sizes are counted with models.Value.Size, metadata size is the sum of keys' and values' lengths.
+// Commands that don't make usage bigger (e.g. DELETE) are always allowed, even if quota is already exceeded.
+//
+// Quotas of a room are checked against its snapshot under the room's lock (see executeAndPublish), and owned rooms
+// are counted under the owner's lock (see createRoom), so commands made by one instance can't exceed them together.
+// With sharding every room is changed by its owner instance only; creates of one user on different instances
+// may exceed MaxRoomsPerOwner by the amount of instances
+type Quotas struct {
+	// MaxRoomsPerOwner - existing rooms created by one user
+	MaxRoomsPerOwner int
+	// MaxMembers - users in one room
+	MaxMembers int
+	// MaxMetadataBytes - size of one user's metadata and of room options
+	MaxMetadataBytes int
+	// MaxKeys - data keys in one room
+	MaxKeys int
+	// MaxValueBytes - size of one data value
+	MaxValueBytes int
+	// MaxRoomBytes - size of all data values of one room, including keys
+	MaxRoomBytes int
+	// MaxListLength - items of one list, including nested lists
+	MaxListLength int
+	// MaxDepth - nesting of lists and maps in one data value, 1 = list or map of scalars
+	MaxDepth int
+}
+
+// roomQuotasSet - any quota that requires current room snapshot to be checked
+func (q Quotas) roomQuotasSet() bool {
+	return q.MaxKeys > 0 || q.MaxRoomBytes > 0
+}
+
+// valueQuotasSet - any quota of one data value
+func (q Quotas) valueQuotasSet() bool {
+	return q.MaxValueBytes > 0 || q.MaxListLength > 0 || q.MaxDepth > 0
+}
+
+// quota names, used in errors and metrics
+const (
+	quotaRoomsPerOwner = "max_rooms_per_owner"
+	quotaMembers       = "max_members"
+	quotaMetadataBytes = "max_metadata_bytes"
+	quotaKeys          = "max_keys"
+	quotaValueBytes    = "max_value_bytes"
+	quotaRoomBytes     = "max_room_bytes"
+	quotaListLength    = "max_list_length"
+	quotaDepth         = "max_depth"
+)
+
+// checkQuota - error with errors.ErrQuotaExceeded if usage exceeds limit (0 = no limit)
+//
+// previous - usage before the command, commands that don't make it bigger are allowed
+func (s *RoomService) checkQuota(quota string, limit int, previous int, usage int) error {
+	if limit <= 0 || usage <= limit || usage <= previous {
+		return nil
+	}
+	s.metrics.IncQuotaExceeded(quota)
+	return fmt.Errorf("%w: %s is %d, command makes it %d", roomerrors.ErrQuotaExceeded, quota, limit, usage)
+}
+
+// checkCreateRoomQuotas - room options size and amount of owner's rooms
+func (s *RoomService) checkCreateRoomQuotas(ctx context.Context, ownerUserID types.NotEmptyText, options map[string]string) error {
+	quotas := s.RuntimeParams().Quotas
+	if err := s.checkQuota(quotaMetadataBytes, quotas.MaxMetadataBytes, 0, metadataSize(options)); err != nil {
+		return err
+	}
+	if quotas.MaxRoomsPerOwner <= 0 {
+		return nil
+	}
+
+	var owned int
+	err := s.retry(ctx, "count_owned_rooms", func(ctx context.Context) error {
+		var errCount error
+		owned, errCount = s.roomsRepo.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: ownerUserID})
+		return errCount
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count owned rooms: %w", err)
+	}
+	return s.checkQuota(quotaRoomsPerOwner, quotas.MaxRoomsPerOwner, owned, owned+1)
+}
+
+// checkJoinRoomQuotas - user's metadata size and amount of members, user that is already a member may join again
+func (s *RoomService) checkJoinRoomQuotas(ctx context.Context, roomID models.RoomID, user models.User) error {
+	quotas := s.RuntimeParams().Quotas
+	if err := s.checkQuota(quotaMetadataBytes, quotas.MaxMetadataBytes, 0, metadataSize(user.Metadata)); err != nil {
+		return err
+	}
+	if quotas.MaxMembers <= 0 {
+		return nil
+	}
+
+	room, err := s.roomSnapshot(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	for _, member := range room.Users {
+		if member.ID == user.ID {
+			return nil
+		}
+	}
+	return s.checkQuota(quotaMembers, quotas.MaxMembers, len(room.Users), len(room.Users)+1)
+}
+
+// checkAffectDataQuotas - value that is stored after SET or APPEND and room's keys and size
+//
+// DELETE and REMOVE are always allowed
+func (s *RoomService) checkAffectDataQuotas(ctx context.Context, params ports.AffectDataParams) error {
+	quotas := s.RuntimeParams().Quotas
+	if params.Action != ports.ActionSet && params.Action != ports.ActionAppend {
+		return nil
+	}
+	if !quotas.valueQuotasSet() && !quotas.roomQuotasSet() {
+		return nil
+	}
+
+	// SET of value that is too big is rejected without reading the room
+	if params.Action == ports.ActionSet {
+		if err := s.checkValueQuotas(quotas, valueUsage{}, usageOf(params.Value)); err != nil {
+			return err
+		}
+		if !quotas.roomQuotasSet() {
+			return nil
+		}
+	}
+
+	room, err := s.roomSnapshot(ctx, params.RoomID)
+	if err != nil {
+		return err
+	}
+	key := params.DataID.String()
+	existing, exists := room.Values[key]
+
+	var previous, next valueUsage
+	if exists {
+		previous = usageOf(&existing)
+	}
+	if params.Action == ports.ActionSet {
+		next = usageOf(params.Value)
+	} else {
+		next = appendUsage(previous, exists && existing.IsList(), existing.Len(), params.Value)
+		if err = s.checkValueQuotas(quotas, previous, next); err != nil {
+			return err
+		}
+	}
+
+	keys := len(room.Values)
+	nextKeys := keys
+	if !exists {
+		nextKeys++
+	}
+	if err = s.checkQuota(quotaKeys, quotas.MaxKeys, keys, nextKeys); err != nil {
+		return err
+	}
+	// room size is the only quota that takes every value, it isn't counted if it isn't set
+	if quotas.MaxRoomBytes <= 0 {
+		return nil
+	}
+
+	roomBytes := 0
+	for valueKey, value := range room.Values {
+		roomBytes += len(valueKey) + value.Size()
+	}
+	nextRoomBytes := roomBytes - previous.size + next.size
+	if !exists {
+		nextRoomBytes += len(key)
+	}
+	return s.checkQuota(quotaRoomBytes, quotas.MaxRoomBytes, roomBytes, nextRoomBytes)
+}
+
+// checkValueQuotas - quotas of one data value that is changed from previous to next
+func (s *RoomService) checkValueQuotas(quotas Quotas, previous valueUsage, next valueUsage) error {
+	if err := s.checkQuota(quotaValueBytes, quotas.MaxValueBytes, previous.size, next.size); err != nil {
+		return err
+	}
+	if err := s.checkQuota(quotaListLength, quotas.MaxListLength, previous.maxListLength, next.maxListLength); err != nil {
+		return err
+	}
+	return s.checkQuota(quotaDepth, quotas.MaxDepth, previous.depth, next.depth)
+}
+
+// valueUsage - what one data value takes, see Quotas
+type valueUsage struct {
+	size          int
+	depth         int
+	maxListLength int
+}
+
+func usageOf(value *models.Value) valueUsage {
+	return valueUsage{size: value.Size(), depth: value.Depth(), maxListLength: value.MaxListLength()}
+}
+
+// appendUsage - usage of list after item is appended to it, missing (or not list) value becomes a list with one item
+func appendUsage(list valueUsage, isList bool, listLength int, item *models.Value) valueUsage {
+	if !isList {
+		list = usageOf(models.ListValue(nil))
+		listLength = 0
+	}
+	itemUsage := usageOf(item)
+	return valueUsage{
+		size:          list.size + itemUsage.size,
+		depth:         max(list.depth, itemUsage.depth+1),
+		maxListLength: max(list.maxListLength, listLength+1, itemUsage.maxListLength),
+	}
+}
+
+// metadataSize - sum of keys' and values' lengths
+func metadataSize(metadata map[string]string) int {
+	size := 0
+	for key, value := range metadata {
+		size += len(key) + len(value)
+	}
+	return size
+}
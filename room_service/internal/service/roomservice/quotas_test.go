@@ -0,0 +1,263 @@
+package roomservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// snapshotRoomsRepo - ports.RoomsPort that returns the same snapshot and owned rooms count, other methods aren't implemented
+type snapshotRoomsRepo struct {
+	ports.RoomsPort
+	snapshot *models.RoomSnapshot
+	owned    int
+}
+
+func (f *snapshotRoomsRepo) RoomSnapshot(context.Context, ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	return f.snapshot, nil
+}
+
+func (f *snapshotRoomsRepo) CountOwnedRooms(context.Context, ports.CountOwnedRoomsParams) (int, error) {
+	return f.owned, nil
+}
+
+func TestAffectDataQuotas(t *testing.T) {
+	roomID := models.RoomID(types.GenerateUUID())
+	repo := &snapshotRoomsRepo{snapshot: &models.RoomSnapshot{Values: map[string]models.Value{
+		// "list" 4 + list 1 + 2 * 9 = 23 bytes
+		"list": *models.ListValue([]models.Value{*models.IntValue(1), *models.IntValue(2)}),
+		// "big" 3 + 1 + 20 = 24 bytes
+		"big": *models.StrValue("01234567890123456789"),
+	}}}
+	service := NewRoomService(repo, nil, nil, nil, nil, testRetryPolicy, StreamParams{}, nil)
+
+	tests := []struct {
+		name     string
+		quotas   Quotas
+		action   ports.Action
+		dataID   string
+		value    *models.Value
+		exceeded bool
+	}{
+		{name: "no quotas", action: ports.ActionSet, dataID: "new", value: models.StrValue("whatever")},
+		{name: "value too big", quotas: Quotas{MaxValueBytes: 5}, action: ports.ActionSet, dataID: "new", value: models.StrValue("whatever"), exceeded: true},
+		{name: "value fits", quotas: Quotas{MaxValueBytes: 9}, action: ports.ActionSet, dataID: "new", value: models.StrValue("whatever")},
+		{name: "too deep", quotas: Quotas{MaxDepth: 1}, action: ports.ActionSet, dataID: "new",
+			value: models.ListValue([]models.Value{*models.ListValue(nil)}), exceeded: true},
+		{name: "new key over max keys", quotas: Quotas{MaxKeys: 2}, action: ports.ActionSet, dataID: "new", value: models.IntValue(1), exceeded: true},
+		{name: "existing key within max keys", quotas: Quotas{MaxKeys: 2}, action: ports.ActionSet, dataID: "big", value: models.IntValue(1)},
+		{name: "room too big", quotas: Quotas{MaxRoomBytes: 50}, action: ports.ActionSet, dataID: "x", value: models.IntValue(1), exceeded: true},
+		{name: "shrinking room over quota", quotas: Quotas{MaxRoomBytes: 30}, action: ports.ActionSet, dataID: "big", value: models.IntValue(1)},
+		{name: "append over list length", quotas: Quotas{MaxListLength: 2}, action: ports.ActionAppend, dataID: "list", value: models.IntValue(3), exceeded: true},
+		{name: "append within list length", quotas: Quotas{MaxListLength: 3}, action: ports.ActionAppend, dataID: "list", value: models.IntValue(3)},
+		{name: "append over value size", quotas: Quotas{MaxValueBytes: 25}, action: ports.ActionAppend, dataID: "list", value: models.IntValue(3), exceeded: true},
+		{name: "delete over quota", quotas: Quotas{MaxKeys: 1, MaxRoomBytes: 1}, action: ports.ActionDelete, dataID: "big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.UpdateRuntimeParams(RuntimeParams{RetryPolicy: testRetryPolicy, Quotas: tt.quotas})
+			err := service.checkAffectDataQuotas(context.Background(), ports.AffectDataParams{
+				RoomID: roomID,
+				DataID: types.NewAnyText(tt.dataID),
+				Action: tt.action,
+				Value:  tt.value,
+			})
+			if exceeded := errors.Is(err, roomerrors.ErrQuotaExceeded); exceeded != tt.exceeded {
+				t.Fatalf("quota exceeded = %v, want %v (err: %v)", exceeded, tt.exceeded, err)
+			}
+			if err != nil && !tt.exceeded {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMembersAndOwnedRoomsQuotas(t *testing.T) {
+	member, _ := types.NewNotEmptyText("member")
+	newcomer, _ := types.NewNotEmptyText("newcomer")
+	repo := &snapshotRoomsRepo{snapshot: &models.RoomSnapshot{Users: []*models.User{{ID: member}}}, owned: 2}
+	service := NewRoomService(repo, nil, nil, nil, nil, testRetryPolicy, StreamParams{}, nil)
+	service.UpdateRuntimeParams(RuntimeParams{
+		RetryPolicy: testRetryPolicy,
+		Quotas:      Quotas{MaxMembers: 1, MaxRoomsPerOwner: 2, MaxMetadataBytes: 8},
+	})
+	roomID := models.RoomID(types.GenerateUUID())
+
+	if err := service.checkJoinRoomQuotas(context.Background(), roomID, models.User{ID: member}); err != nil {
+		t.Errorf("member joining again: unexpected error %v", err)
+	}
+	if err := service.checkJoinRoomQuotas(context.Background(), roomID, models.User{ID: newcomer}); !errors.Is(err, roomerrors.ErrQuotaExceeded) {
+		t.Errorf("newcomer joining full room: expected quota error, got %v", err)
+	}
+	if err := service.checkJoinRoomQuotas(context.Background(), roomID, models.User{ID: member, Metadata: map[string]string{"avatar": "large"}}); !errors.Is(err, roomerrors.ErrQuotaExceeded) {
+		t.Errorf("big metadata: expected quota error, got %v", err)
+	}
+	if err := service.checkCreateRoomQuotas(context.Background(), member, nil); !errors.Is(err, roomerrors.ErrQuotaExceeded) {
+		t.Errorf("third owned room: expected quota error, got %v", err)
+	}
+}
+
+// slowRoomsRepo - ports.RoomsPort whose creates and joins take a while, so concurrent commands overlap
+type slowRoomsRepo struct {
+	ports.RoomsPort
+}
+
+func (f *slowRoomsRepo) CreateRoom(ctx context.Context, params *models.Room) (*models.Room, error) {
+	time.Sleep(time.Millisecond)
+	return f.RoomsPort.CreateRoom(ctx, params)
+}
+
+func (f *slowRoomsRepo) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	time.Sleep(time.Millisecond)
+	return f.RoomsPort.JoinRoom(ctx, params)
+}
+
+func TestConcurrentCommandsDontExceedQuotas(t *testing.T) {
+	ctx := context.Background()
+	storage := room.NewInMemoryRepository()
+	created, err := storage.CreateRoom(ctx, models.NewRoom("owner", nil))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	service := NewRoomService(&slowRoomsRepo{RoomsPort: storage}, nil, nil, nil, nil, testRetryPolicy, StreamParams{}, nil)
+	service.UpdateRuntimeParams(RuntimeParams{
+		RetryPolicy: testRetryPolicy,
+		Quotas:      Quotas{MaxMembers: 2, MaxRoomsPerOwner: 3},
+	})
+
+	// concurrently: 10 users join the room, its owner creates 10 more rooms
+	roomID := created.ID.String()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := range 10 {
+		userID := fmt.Sprintf("user-%d", i)
+		wg.Go(func() {
+			_, err := service.executeAndPublish(ctx, "stream-1", &r.Command{RoomId: &roomID, UserId: userID, Payload: &r.Command_JoinRoom{
+				JoinRoom: &r.JoinRoomCommandBody{UserFull: &r.User{Id: userID, Name: userID}},
+			}})
+			errs <- err
+		})
+		wg.Go(func() {
+			_, err := service.executeAndPublish(ctx, "stream-1", &r.Command{UserId: "owner", Payload: &r.Command_CreateRoom{
+				CreateRoom: &r.CreateRoomCommandBody{},
+			}})
+			errs <- err
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	exceeded := 0
+	for err = range errs {
+		if errors.Is(err, roomerrors.ErrQuotaExceeded) {
+			exceeded++
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// 8 joins and 8 creates are rejected: 2 members, 1 + 2 owned rooms
+	if exceeded != 16 {
+		t.Errorf("%d commands exceeded quotas, want 16", exceeded)
+	}
+	snapshot, err := storage.RoomSnapshot(ctx, ports.RoomSnapshotParams{RoomID: created.ID})
+	if err != nil {
+		t.Fatalf("RoomSnapshot: %v", err)
+	}
+	if len(snapshot.Users) != 2 {
+		t.Errorf("room has %d members, want 2", len(snapshot.Users))
+	}
+	if owned, _ := storage.CountOwnedRooms(ctx, ports.CountOwnedRoomsParams{OwnerUserID: "owner"}); owned != 3 {
+		t.Errorf("owner has %d rooms, want 3", owned)
+	}
+}
+
+func TestQuotasReloadedWhileCommandsRun(t *testing.T) {
+	ctx := context.Background()
+	storage := room.NewInMemoryRepository()
+	created, err := storage.CreateRoom(ctx, models.NewRoom("owner", nil))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	service := NewRoomService(storage, nil, nil, nil, nil, testRetryPolicy, StreamParams{}, nil)
+	limited := RuntimeParams{RetryPolicy: testRetryPolicy, Quotas: Quotas{MaxKeys: 4, MaxValueBytes: 16}}
+	// zero quotas are no limit
+	unlimited := RuntimeParams{RetryPolicy: testRetryPolicy}
+
+	roomID := created.ID.String()
+	set := func(dataID string) error {
+		_, err := service.executeAndPublish(ctx, "stream-1", &r.Command{RoomId: &roomID, UserId: "owner", Payload: &r.Command_AffectData{
+			AffectData: &r.SetAppendDeleteDataCommandBody{
+				DataId:      dataID,
+				DataValue:   &r.Value{Value: &r.Value_IntValue{IntValue: 1}},
+				CommandMode: r.DateEditMode_SET,
+			},
+		}})
+		return err
+	}
+
+	service.UpdateRuntimeParams(unlimited)
+	if err = set("existing"); err != nil {
+		t.Fatalf("set without quotas: %v", err)
+	}
+
+	// commands run while quotas are reloaded back and forth
+	stop := make(chan struct{})
+	var executed atomic.Int64
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := set(fmt.Sprintf("key-%d-%d", i, n%50)); err != nil && !errors.Is(err, roomerrors.ErrQuotaExceeded) {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				executed.Add(1)
+			}
+		})
+	}
+	for i := 0; executed.Load() < 2000; i++ {
+		if i%2 == 0 {
+			service.UpdateRuntimeParams(limited)
+		} else {
+			service.UpdateRuntimeParams(unlimited)
+		}
+		runtime.Gosched()
+	}
+	close(stop)
+	wg.Wait()
+	service.UpdateRuntimeParams(unlimited)
+	for _, dataID := range []string{"a", "b", "c", "d"} {
+		if err = set(dataID); err != nil {
+			t.Fatalf("set without quotas: %v", err)
+		}
+	}
+
+	// room has more keys than the quota: existing keys can be set, new ones can't be added
+	service.UpdateRuntimeParams(limited)
+	if err = set("existing"); err != nil {
+		t.Errorf("set of existing key over quota: %v", err)
+	}
+	if err = set("new"); !errors.Is(err, roomerrors.ErrQuotaExceeded) {
+		t.Errorf("new key over quota: %v, want quota exceeded", err)
+	}
+	service.UpdateRuntimeParams(unlimited)
+	if err = set("new"); err != nil {
+		t.Errorf("new key without quotas: %v", err)
+	}
+}
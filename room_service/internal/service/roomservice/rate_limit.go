@@ -0,0 +1,104 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/ports"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// Rate limit scopes, every command type has a bucket per scope
+const (
+	rateLimitScopeStream = "stream"
+	rateLimitScopeUser   = "user"
+	rateLimitScopeRoom   = "room"
+)
+
+// CommandRateLimits - limits of one command type, zero ports.RateLimit = no limit in that scope
+type CommandRateLimits struct {
+	// Room - shared by everyone who sends commands to the room
+	Room ports.RateLimit
+	// User - shared by every stream of the user
+	User ports.RateLimit
+	// Stream - one stream (connection)
+	Stream ports.RateLimit
+}
+
+// checkRateLimits - take a token from command's stream, user and room buckets (see RuntimeParams.RateLimits)
+//
+// Tokens are taken from all buckets at once or from none, so a command denied by one bucket doesn't drain the others.
+//
+// Command whose commandID is already in the command cache takes no tokens: it's a retransmit,
+// processCommandOnce returns the stored result (or waits for it) without executing the command again.
+//
+// empty bucket -> errors.RetryAfterError with errors.ErrRateLimited.
+// Rate limiter failures are logged and the command is allowed, so limiter's storage doesn't stop the service
+func (s *RoomService) checkRateLimits(ctx context.Context, streamID string, command *r.Command) error {
+	if s.rateLimiter == nil {
+		return nil
+	}
+	payloadType := commandPayloadType(command)
+	limits, ok := s.RuntimeParams().RateLimits[payloadType]
+	if !ok || s.isRepeatedCommand(ctx, command) {
+		return nil
+	}
+
+	scopes := []struct {
+		scope string
+		id    string
+		limit ports.RateLimit
+	}{
+		{scope: rateLimitScopeStream, id: streamID, limit: limits.Stream},
+		{scope: rateLimitScopeUser, id: command.GetUserId(), limit: limits.User},
+		{scope: rateLimitScopeRoom, id: command.GetRoomId(), limit: limits.Room},
+	}
+	buckets := make([]ports.RateLimitBucket, 0, len(scopes))
+	bucketScopes := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		// e.g. there's no room ID in CreateRoom
+		if scope.limit.Unlimited() || len(scope.id) == 0 {
+			continue
+		}
+		buckets = append(buckets, ports.RateLimitBucket{
+			Key:   fmt.Sprintf("%s:%s:%s", payloadType, scope.scope, scope.id),
+			Limit: scope.limit,
+		})
+		bucketScopes = append(bucketScopes, scope.scope)
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	allowed, denied, retryAfter, err := s.rateLimiter.Allow(ctx, buckets)
+	if err != nil {
+		logging.FromContext(ctx).Error(ctx, "rate limiter failed, command is allowed", zap.Error(err))
+		return nil
+	}
+	if !allowed {
+		scope := bucketScopes[denied]
+		s.metrics.IncRateLimited(payloadType, scope)
+		return &roomerrors.RetryAfterError{
+			Err:        fmt.Errorf("%s limit of %s: %w", scope, payloadType, roomerrors.ErrRateLimited),
+			RetryAfter: retryAfter,
+		}
+	}
+	return nil
+}
+
+// isRepeatedCommand - commandID of the command is already reserved or done
+//
+// command cache failures are logged and the command is treated as a new one
+func (s *RoomService) isRepeatedCommand(ctx context.Context, command *r.Command) bool {
+	if s.commandIdShortCache == nil || len(command.GetCommandId()) == 0 {
+		return false
+	}
+	record, err := s.commandIdShortCache.Get(ctx, scopedCommandID(command.GetUserId(), command.GetCommandId()))
+	if err != nil {
+		logging.FromContext(ctx).Error(ctx, "failed to get command_id from short cache, rate limits are checked", zap.Error(err))
+		return false
+	}
+	return record != nil
+}
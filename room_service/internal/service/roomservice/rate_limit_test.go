@@ -0,0 +1,199 @@
+package roomservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/commandcache"
+	"github.com/chempik1234/room-service/internal/repositories/ratelimit"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStreamRejectsRateLimitedCommands(t *testing.T) {
+	service := NewRoomService(&flakyRoomsRepo{calls: 1}, nil, ratelimit.NewInMemoryRateLimiter(), nil, nil, testRetryPolicy, StreamParams{}, nil)
+	service.UpdateRuntimeParams(RuntimeParams{
+		RetryPolicy: testRetryPolicy,
+		RateLimits: map[string]CommandRateLimits{
+			"refresh_room": {Room: ports.RateLimit{PerSecond: 0.001, Burst: 2}},
+		},
+	})
+
+	roomID := types.GenerateUUID().String()
+	commands := make([]*r.Command, 3)
+	for i := range commands {
+		commands[i] = &r.Command{
+			RoomId:  &roomID,
+			UserId:  "user-1",
+			Payload: &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}},
+		}
+	}
+	stream := newFakeEventStream(t, commands)
+
+	if err := service.Stream(stream); err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+	if len(stream.sent) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(stream.sent))
+	}
+	for i, event := range stream.sent[:2] {
+		if event.GetFullRoom() == nil {
+			t.Fatalf("event %d: expected full room, got %v", i, event)
+		}
+	}
+	rejected := stream.sent[2].GetErrorMessage()
+	if rejected == nil {
+		t.Fatalf("expected error event, got %v", stream.sent[2])
+	}
+	if rejected.GetCode() != r.ErrorCode_RESOURCE_EXHAUSTED {
+		t.Errorf("code = %v, want RESOURCE_EXHAUSTED", rejected.GetCode())
+	}
+	if rejected.GetRetryAfterMs() <= 0 {
+		t.Errorf("retry_after_ms = %d, want positive", rejected.GetRetryAfterMs())
+	}
+	if stream.sent[2].GetRoomId() != roomID {
+		t.Errorf("room_id = %q, want %q", stream.sent[2].GetRoomId(), roomID)
+	}
+}
+
+func TestStreamDoesNotRateLimitRepeatedCommands(t *testing.T) {
+	service := NewRoomService(&flakyRoomsRepo{calls: 1}, commandcache.NewInMemoryCommandCache(16, 1, 60000),
+		ratelimit.NewInMemoryRateLimiter(), nil, nil, testRetryPolicy, StreamParams{}, nil)
+	service.UpdateRuntimeParams(RuntimeParams{
+		RetryPolicy: testRetryPolicy,
+		RateLimits: map[string]CommandRateLimits{
+			"refresh_room": {Room: ports.RateLimit{PerSecond: 0.001, Burst: 1}},
+		},
+	})
+
+	roomID := types.GenerateUUID().String()
+	newCommand := func(commandID string) *r.Command {
+		return &r.Command{
+			CommandId: commandID,
+			RoomId:    &roomID,
+			UserId:    "user-1",
+			Payload:   &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}},
+		}
+	}
+	// retransmits of the first command take no tokens, the next command is limited
+	stream := newFakeEventStream(t, []*r.Command{newCommand("1"), newCommand("1"), newCommand("1"), newCommand("2")})
+
+	if err := service.Stream(stream); err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+	if len(stream.sent) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(stream.sent))
+	}
+	for i, event := range stream.sent[:3] {
+		if event.GetFullRoom() == nil {
+			t.Fatalf("event %d: expected full room, got %v", i, event)
+		}
+	}
+	if rejected := stream.sent[3].GetErrorMessage(); rejected.GetCode() != r.ErrorCode_RESOURCE_EXHAUSTED {
+		t.Fatalf("expected RESOURCE_EXHAUSTED for the new command, got %v", stream.sent[3])
+	}
+}
+
+func TestRateLimitDenialDoesNotDrainOtherBuckets(t *testing.T) {
+	service := NewRoomService(nil, nil, ratelimit.NewInMemoryRateLimiter(), nil, nil, testRetryPolicy, StreamParams{}, nil)
+	service.UpdateRuntimeParams(RuntimeParams{
+		RetryPolicy: testRetryPolicy,
+		RateLimits: map[string]CommandRateLimits{
+			"refresh_room": {
+				Stream: ports.RateLimit{PerSecond: 0.001, Burst: 2},
+				Room:   ports.RateLimit{PerSecond: 0.001, Burst: 1},
+			},
+		},
+	})
+	newCommand := func(roomID string) *r.Command {
+		return &r.Command{RoomId: &roomID, UserId: "user-1", Payload: &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}}}
+	}
+	ctx := context.Background()
+	roomA, roomB := types.GenerateUUID().String(), types.GenerateUUID().String()
+
+	if err := service.checkRateLimits(ctx, "stream-1", newCommand(roomA)); err != nil {
+		t.Fatalf("first command: %v", err)
+	}
+	// room bucket is empty, the stream bucket must keep its second token
+	err := service.checkRateLimits(ctx, "stream-1", newCommand(roomA))
+	if !errors.Is(err, roomerrors.ErrRateLimited) {
+		t.Fatalf("second command of room: %v, want rate limited", err)
+	}
+	if err = service.checkRateLimits(ctx, "stream-1", newCommand(roomB)); err != nil {
+		t.Fatalf("command of other room: %v, want allowed by the stream bucket", err)
+	}
+	if err = service.checkRateLimits(ctx, "stream-1", newCommand(types.GenerateUUID().String())); !errors.Is(err, roomerrors.ErrRateLimited) {
+		t.Fatalf("third command of stream: %v, want rate limited", err)
+	}
+}
+
+func TestRateLimitsReloadedWhileCommandsAreChecked(t *testing.T) {
+	service := NewRoomService(nil, nil, ratelimit.NewInMemoryRateLimiter(), nil, nil, testRetryPolicy, StreamParams{}, nil)
+	limited := RuntimeParams{
+		RetryPolicy: testRetryPolicy,
+		RateLimits: map[string]CommandRateLimits{
+			"refresh_room": {Room: ports.RateLimit{PerSecond: 0.001, Burst: 1}},
+		},
+	}
+	// zero limits and burst without rate are no limit
+	unlimited := RuntimeParams{
+		RetryPolicy: testRetryPolicy,
+		RateLimits: map[string]CommandRateLimits{
+			"refresh_room": {Room: ports.RateLimit{}, User: ports.RateLimit{Burst: 5}},
+		},
+	}
+	roomID := types.GenerateUUID().String()
+	command := &r.Command{RoomId: &roomID, UserId: "user-1", Payload: &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}}}
+	ctx := context.Background()
+
+	// commands are checked while limits are reloaded back and forth
+	stop := make(chan struct{})
+	var checked atomic.Int64
+	var wg sync.WaitGroup
+	for i := range 8 {
+		streamID := fmt.Sprintf("stream-%d", i)
+		wg.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := service.checkRateLimits(ctx, streamID, command); err != nil && !errors.Is(err, roomerrors.ErrRateLimited) {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				checked.Add(1)
+			}
+		})
+	}
+	for i := 0; checked.Load() < 2000; i++ {
+		if i%2 == 0 {
+			service.UpdateRuntimeParams(limited)
+		} else {
+			service.UpdateRuntimeParams(unlimited)
+		}
+		runtime.Gosched()
+	}
+	close(stop)
+	wg.Wait()
+
+	service.UpdateRuntimeParams(unlimited)
+	for i := range 10 {
+		if err := service.checkRateLimits(ctx, "stream-1", command); err != nil {
+			t.Fatalf("command %d without limits: %v", i, err)
+		}
+	}
+	// the room's bucket has at most 1 token whatever was taken under load
+	service.UpdateRuntimeParams(limited)
+	_ = service.checkRateLimits(ctx, "stream-1", command)
+	if err := service.checkRateLimits(ctx, "stream-1", command); !errors.Is(err, roomerrors.ErrRateLimited) {
+		t.Fatalf("command after limits are reloaded: %v, want rate limited", err)
+	}
+}
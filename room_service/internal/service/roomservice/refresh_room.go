@@ -6,27 +6,80 @@ import (
 	"github.com/chempik1234/room-service/internal/models"
 	"github.com/chempik1234/room-service/internal/ports"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
-	"github.com/chempik1234/super-danis-library-golang/v2/pkg/logger"
-	"github.com/wb-go/wbf/retry"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
+// encodedSnapshots - rooms storage that keeps snapshots serialized as FullRoomSnapshotEventBody (see SnapshotCodec),
+// e.g. snapshotcache.RoomsRepository; returned bytes are shared and must not be modified
+type encodedSnapshots interface {
+	EncodedSnapshot(ctx context.Context, roomID models.RoomID) ([]byte, error)
+}
+
 func (s *RoomService) refreshRoom(ctx context.Context, roomID *models.RoomID) (payload *r.Event_FullRoom, err error) {
 	//region snapshot room logic
+	fullRoom, err := s.fullRoom(ctx, *roomID)
+	if err != nil {
+		return payload, err
+	}
+	//endregion
+
+	//region result
+	return &r.Event_FullRoom{FullRoom: fullRoom}, nil
+	//endregion
+}
+
+// fullRoom - FullRoomSnapshotEventBody of room with retries, serialized one is decoded if rooms storage keeps it
+func (s *RoomService) fullRoom(ctx context.Context, roomID models.RoomID) (*r.FullRoomSnapshotEventBody, error) {
+	if cache, ok := s.roomsRepo.(encodedSnapshots); ok {
+		var encoded []byte
+		err := s.retry(ctx, "room_snapshot", func(ctx context.Context) error {
+			var errSnapshot error
+			encoded, errSnapshot = cache.EncodedSnapshot(ctx, roomID)
+			return errSnapshot
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get room snapshot: %w", err)
+		}
+		fullRoom := &r.FullRoomSnapshotEventBody{}
+		if err = proto.Unmarshal(encoded, fullRoom); err != nil {
+			return nil, fmt.Errorf("failed to decode room snapshot: %w", err)
+		}
+		return fullRoom, nil
+	}
+
+	room, err := s.roomSnapshot(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	fullRoom, err := fullRoomOf(room)
+	if err != nil {
+		logging.FromContext(ctx).Error(ctx, "failed to get room snapshot", zap.Error(err))
+		return nil, fmt.Errorf("failed to get room snapshot: %w", err)
+	}
+	return fullRoom, nil
+}
+
+// roomSnapshot - RoomsPort.RoomSnapshot with retries
+func (s *RoomService) roomSnapshot(ctx context.Context, roomID models.RoomID) (*models.RoomSnapshot, error) {
 	var room *models.RoomSnapshot
-	err = retry.Do(func() error {
+	err := s.retry(ctx, "room_snapshot", func(ctx context.Context) error {
 		var errSnapshot error
 		room, errSnapshot = s.roomsRepo.RoomSnapshot(ctx, ports.RoomSnapshotParams{
-			RoomID: *roomID,
+			RoomID: roomID,
 		})
 		return errSnapshot
-	}, s.retryStrategy)
+	})
 	if err != nil {
-		return payload, fmt.Errorf("failed to get room snapshot: %w", err)
+		return nil, fmt.Errorf("failed to get room snapshot: %w", err)
 	}
-	//endregion
+	return room, nil
+}
 
-	//region result
+// fullRoomOf - FullRoomSnapshotEventBody of snapshot, users keep their order
+func fullRoomOf(room *models.RoomSnapshot) (*r.FullRoomSnapshotEventBody, error) {
 	roomUsers := make([]*r.User, len(room.Users))
 	for i, user := range room.Users {
 		roomUsers[i] = &r.User{
@@ -37,21 +90,58 @@ func (s *RoomService) refreshRoom(ctx context.Context, roomID *models.RoomID) (p
 	}
 
 	roomValues := make(map[string]*r.Value, len(room.Values))
-
 	for key, value := range room.Values {
-		roomValues[key], err = PlainObjectToProtobufValue(value)
-		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to get room snapshot", zap.Error(err))
-			return payload, fmt.Errorf("failed to get room snapshot: %w", err)
+		var err error
+		if roomValues[key], err = PlainObjectToProtobufValue(value.Plain()); err != nil {
+			return nil, fmt.Errorf("error serializing value '%s': %w", key, err)
 		}
 	}
 
-	return &r.Event_FullRoom{
-		FullRoom: &r.FullRoomSnapshotEventBody{
-			Room:        &r.RoomData{Values: roomValues},
-			Users:       roomUsers,
-			RoomOptions: room.Room.Options,
-			RoomId:      room.Room.ID.String(),
-		},
+	return &r.FullRoomSnapshotEventBody{
+		Room:        &r.RoomData{Values: roomValues},
+		Users:       roomUsers,
+		RoomOptions: room.Room.Options,
+		RoomId:      room.Room.ID.String(),
 	}, nil
 }
+
+// SnapshotCodec - snapshotcache.Codec that serializes snapshot as FullRoomSnapshotEventBody,
+// the way RefreshRoom sends it (see encodedSnapshots)
+type SnapshotCodec struct{}
+
+// Encode - serialized FullRoomSnapshotEventBody of snapshot
+func (SnapshotCodec) Encode(snapshot *models.RoomSnapshot) ([]byte, error) {
+	fullRoom, err := fullRoomOf(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(fullRoom)
+}
+
+// Decode - snapshot of serialized FullRoomSnapshotEventBody, room has only options: ID and owner aren't in it
+func (SnapshotCodec) Decode(encoded []byte) (*models.RoomSnapshot, error) {
+	fullRoom := &r.FullRoomSnapshotEventBody{}
+	if err := proto.Unmarshal(encoded, fullRoom); err != nil {
+		return nil, fmt.Errorf("error decoding room snapshot: %w", err)
+	}
+	snapshot := &models.RoomSnapshot{
+		Room:   &models.Room{Options: fullRoom.GetRoomOptions()},
+		Users:  make([]*models.User, len(fullRoom.GetUsers())),
+		Values: make(map[string]models.Value, len(fullRoom.GetRoom().GetValues())),
+	}
+	for i, user := range fullRoom.GetUsers() {
+		snapshot.Users[i] = &models.User{
+			ID:       types.NotEmptyText(user.GetId()),
+			Name:     types.NotEmptyText(user.GetName()),
+			Metadata: user.GetMetadata(),
+		}
+	}
+	for key, protoValue := range fullRoom.GetRoom().GetValues() {
+		value, err := ProtobufValueToValueObject(protoValue)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding value '%s': %w", key, err)
+		}
+		snapshot.Values[key] = *value
+	}
+	return snapshot, nil
+}
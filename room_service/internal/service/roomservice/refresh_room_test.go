@@ -0,0 +1,73 @@
+package roomservice
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"testing"
+)
+
+func TestSnapshotCodecKeepsNestedValues(t *testing.T) {
+	values := map[string]models.Value{
+		"int":   *models.IntValue(1),
+		"float": *models.FloatValue(1.5),
+		"bytes": *models.BytesValue([]byte{1, 2}),
+		"list":  *models.ListValue([]models.Value{*models.StrValue("a"), *models.BoolValue(true)}),
+		"map": *models.MapValue(map[string]models.Value{
+			"nested": *models.ListValue([]models.Value{*models.IntValue(2)}),
+		}),
+	}
+	snapshot := &models.RoomSnapshot{
+		Room:   &models.Room{ID: models.RoomID(types.GenerateUUID()), OwnerUserID: "owner", Options: map[string]string{"a": "b"}},
+		Users:  []*models.User{{ID: "user", Name: "name", Metadata: map[string]string{"c": "d"}}},
+		Values: values,
+	}
+
+	encoded, err := SnapshotCodec{}.Encode(snapshot)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := SnapshotCodec{}.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	for key, value := range values {
+		if got := decoded.Values[key]; !got.Equal(&value) {
+			t.Errorf("value %s = %+v, want %+v", key, got, value)
+		}
+	}
+	if len(decoded.Users) != 1 || decoded.Users[0].ID != "user" || decoded.Users[0].Metadata["c"] != "d" {
+		t.Fatalf("users = %+v, want user with metadata", decoded.Users)
+	}
+	if decoded.Room.Options["a"] != "b" {
+		t.Fatalf("options = %+v, want a=b", decoded.Room.Options)
+	}
+}
+
+func TestRefreshRoomSendsListsAndMaps(t *testing.T) {
+	ctx := context.Background()
+	rooms := room.NewInMemoryRepository()
+	service := NewRoomService(rooms, nil, nil, nil, nil, testRetryPolicy, StreamParams{}, nil)
+	created, err := rooms.CreateRoom(ctx, models.NewRoom("owner", nil))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	err = rooms.AffectData(ctx, ports.AffectDataParams{
+		RoomID: created.ID, DataID: "list", Action: ports.ActionSet,
+		Value: models.ListValue([]models.Value{*models.IntValue(1)}),
+	})
+	if err != nil {
+		t.Fatalf("AffectData: %v", err)
+	}
+
+	payload, err := service.refreshRoom(ctx, &created.ID)
+	if err != nil {
+		t.Fatalf("refreshRoom: %v", err)
+	}
+	list := payload.FullRoom.GetRoom().GetValues()["list"].GetListValue().GetValues()
+	if len(list) != 1 || list[0].GetIntValue() != 1 {
+		t.Fatalf("list = %+v, want [1]", list)
+	}
+}
@@ -0,0 +1,56 @@
+package roomservice
+
+import (
+	"context"
+	"errors"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/tracing"
+	"github.com/chempik1234/room-service/pkg/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// retry - RetryPolicy.Do of current RuntimeParams
+//
+// repeated attempts and exhausted budget are counted in metrics by operation, every attempt is traced as a child span,
+// so fn must use the given ctx for repository calls
+func (s *RoomService) retry(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	attempt := 0
+	err := s.RuntimeParams().RetryPolicy.Do(ctx, func(ctx context.Context) error {
+		if attempt > 0 {
+			s.metrics.IncRetryAttempts(operation)
+		}
+		attempt++
+
+		attemptCtx, span := tracing.Tracer().Start(ctx, "retry "+operation, trace.WithAttributes(
+			attribute.String("retry.operation", operation),
+			attribute.Int("retry.attempt", attempt),
+		))
+		err := fn(attemptCtx)
+		tracing.End(span, err)
+		return err
+	})
+	if errors.Is(err, config.ErrRetryBudgetExhausted) {
+		s.metrics.IncRetryBudgetExhausted(operation)
+	}
+	return err
+}
+
+// isRetryable - domain errors (room doesn't exist, quota exceeded...) are never retried, others if they're transient
+func isRetryable(err error) bool {
+	for _, domainErr := range []error{
+		roomerrors.ErrRoomDoesntExist,
+		roomerrors.ErrRoomIDAlreadyExists,
+		roomerrors.ErrUserNotInRoom,
+		roomerrors.ErrDataPieceDoesntExist,
+		roomerrors.ErrQuotaExceeded,
+		roomerrors.ErrRateLimited,
+		// fail fast, breaker itself decides when storage is called again
+		roomerrors.ErrCircuitOpen,
+	} {
+		if errors.Is(err, domainErr) {
+			return false
+		}
+	}
+	return config.IsTransient(err)
+}
@@ -0,0 +1,34 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/pkg/config"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestRetryOnlyTransientErrors(t *testing.T) {
+	service := NewRoomService(nil, nil, nil, nil, nil, config.RetryPolicy{Attempts: 3}, StreamParams{}, nil)
+
+	for _, tt := range []struct {
+		err   error
+		calls int
+	}{
+		{err: fmt.Errorf("room 1: %w", roomerrors.ErrRoomDoesntExist), calls: 1},
+		{err: fmt.Errorf("%w: max_keys is 1", roomerrors.ErrQuotaExceeded), calls: 1},
+		{err: context.Canceled, calls: 1},
+		{err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, calls: 3},
+	} {
+		calls := 0
+		_ = service.retry(context.Background(), "test", func(context.Context) error {
+			calls++
+			return tt.err
+		})
+		if calls != tt.calls {
+			t.Errorf("%v: %d calls, want %d", tt.err, calls, tt.calls)
+		}
+	}
+}
@@ -3,87 +3,250 @@ package roomservice
 import (
 	"context"
 	"fmt"
+	"github.com/chempik1234/room-service/internal/metrics"
 	"github.com/chempik1234/room-service/internal/ports"
 	"github.com/chempik1234/room-service/internal/projectutils"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
-	"github.com/chempik1234/super-danis-library-golang/v2/pkg/logger"
-	"github.com/wb-go/wbf/retry"
+	"github.com/chempik1234/room-service/pkg/config"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const commandIDZapKey = "command_id"
 
 // RoomService is the grpc handler class (without handler abstraction)
 //
-// Implements RoomServiceServer
+// Implements RoomServiceServer and RoomShardServiceServer
 type RoomService struct {
 	r.RoomServiceServer
+	r.UnimplementedRoomShardServiceServer
 	// execute commands and store data
 	roomsRepo ports.RoomsPort
 	// no-repeat
 	commandIdShortCache ports.CommandIDShortCache
-	retryStrategy       retry.Strategy
+	// rateLimiter - optional, nil means commands aren't limited
+	rateLimiter ports.RateLimiter
+	// eventBus - optional, nil means events aren't broadcast, every stream gets results of its own commands only
+	eventBus ports.EventBusPort
+	// shardRouter - optional, nil means every room is served by this instance
+	shardRouter ShardRouter
+	// runtimeParams - see UpdateRuntimeParams
+	runtimeParams atomic.Pointer[RuntimeParams]
+	// how commands are received and events are sent in one stream
+	streamParams StreamParams
+	// metrics - optional, nil means nothing is recorded
+	metrics *metrics.Metrics
+	// presence - who is joined to which room through streams of this instance
+	presence *roomPresence
+	// roomLocks - commands of one room are executed and published one by one, see executeAndPublish
+	roomLocks [roomLockStripes]sync.Mutex
+
+	//region lifecycle, see Shutdown
+	// commandsCtx - parent of every command's ctx, canceled if streams aren't drained on Shutdown in time
+	commandsCtx    context.Context
+	cancelCommands context.CancelFunc
+	// shuttingDown - closed on Shutdown, streams stop receiving commands
+	shuttingDown chan struct{}
+	// lifecycleMu - guards shuttingDown closing and activeStreams adding, so no stream starts after Shutdown
+	lifecycleMu sync.Mutex
+	// activeStreams - Stream handlers that haven't returned yet
+	activeStreams sync.WaitGroup
+	//endregion
+}
+
+// StreamParams - settings of every RoomService.Stream
+type StreamParams struct {
+	// Ordering - how commands of one stream are ordered
+	Ordering CommandOrderingParams
+	// Outbound - how events are sent into one stream
+	Outbound StreamWriterParams
+	// CommandTimeout - max time of processing one command, 0 = no limit (only stream's deadline)
+	//
+	// initial value of RuntimeParams.CommandTimeout
+	CommandTimeout time.Duration
 }
 
 // NewRoomService creates a new RoomService
-func NewRoomService(roomsRepo ports.RoomsPort, commandIdShortCache ports.CommandIDShortCache, retryStrategy retry.Strategy) *RoomService {
-	return &RoomService{
+//
+// rateLimiter, eventBus, shardRouter and m are optional (nil), active rooms and members per room are registered in m
+//
+// retryPolicy and streamParams.CommandTimeout can be changed later (and rate limits can be set), see UpdateRuntimeParams.
+// retryPolicy.Retryable is replaced with isRetryable
+func NewRoomService(roomsRepo ports.RoomsPort, commandIdShortCache ports.CommandIDShortCache, rateLimiter ports.RateLimiter, eventBus ports.EventBusPort, shardRouter ShardRouter, retryPolicy config.RetryPolicy, streamParams StreamParams, m *metrics.Metrics) *RoomService {
+	streamParams.Ordering = streamParams.Ordering.withDefaults()
+	streamParams.Outbound = streamParams.Outbound.withDefaults()
+	commandsCtx, cancelCommands := context.WithCancel(context.Background())
+	s := &RoomService{
 		roomsRepo:           roomsRepo,
-		retryStrategy:       retryStrategy,
 		commandIdShortCache: commandIdShortCache,
+		rateLimiter:         rateLimiter,
+		eventBus:            eventBus,
+		shardRouter:         shardRouter,
+		streamParams:        streamParams,
+		metrics:             m,
+		presence:            newRoomPresence(),
+		commandsCtx:         commandsCtx,
+		cancelCommands:      cancelCommands,
+		shuttingDown:        make(chan struct{}),
 	}
+	s.UpdateRuntimeParams(RuntimeParams{
+		RetryPolicy:    retryPolicy,
+		CommandTimeout: streamParams.CommandTimeout,
+	})
+	if m != nil {
+		m.RegisterRoomPresence(s.presence.memberCounts)
+	}
+	return s
 }
 
 // Stream - is the handler for life-cycle endpoint Stream
 //
 // Incoming commands - output Events with deltas or full snapshots (e.g. on room join)
+//
+// Commands are ordered according to CommandOrderingParams, receiving is paused while the queue is full.
+//
+// Events are sent only by streamWriter, the stream is closed if it fails (e.g. slow consumer)
+//
+// Commands of rooms owned by other instances are forwarded to them (see ShardRouter)
+//
+// Results that change a room are published into the event bus, the stream gets events of rooms where it joined users
+// (see streamSubscriptions)
+//
+// On Shutdown stream stops receiving, sends ServerShuttingDown event, finishes received commands and returns
 func (s *RoomService) Stream(stream grpc.BidiStreamingServer[r.Command, r.Event]) error {
+	if !s.enterStream() {
+		return status.Error(codes.Unavailable, shuttingDownReason)
+	}
+	defer s.activeStreams.Done()
+
+	s.metrics.StreamOpened()
+	defer s.metrics.StreamClosed()
+
+	streamCtx := stream.Context()
+	// streamID - key of stream's rate limit buckets
+	streamID := types.GenerateUUID().String()
 	var err error
-	var received *r.Command
+
+	writer := newStreamWriter(stream, s.streamParams.Outbound, s.metrics)
+	dispatcher := newCommandDispatcher(s.streamParams.Ordering)
+	presence := newStreamPresence(s.presence)
+	subscriptions := newStreamSubscriptions(streamCtx, s.eventBus, streamID, writer, s.roomStateEvent)
+	// don't return (and close the stream) while commands are still executed and their events are sent,
+	// commands waiting for space in the outbound queue give up when their ctx is done (timeout or Shutdown deadline)
+	defer func() {
+		dispatcher.close()
+		subscriptions.close()
+		// stalled consumer keeps the queue full, it's abandoned when streams aren't drained in time
+		writer.close(s.commandsCtx)
+		presence.close()
+		if dropped := writer.droppedAmount(); dropped > 0 {
+			logging.FromContext(streamCtx).Warn(streamCtx, "stream finished, some events were dropped (slow consumer)", zap.Uint64("dropped", dropped))
+		}
+	}()
+
+	stopReceiving := make(chan struct{})
+	defer close(stopReceiving)
+	incoming := receiveCommands(stream, stopReceiving)
 
 	// main cycle
 	for {
 		// 1) receive object
 
 		// region try to receive
-		received, err = stream.Recv()
-		if err == io.EOF {
+		var received receivedCommand
+		select {
+		case <-s.shuttingDown:
+			if err = writer.send(s.commandsCtx, newShuttingDownEvent()); err != nil {
+				logging.FromContext(streamCtx).Error(streamCtx, "failed to send shutting down event", zap.Error(err))
+			}
+			return nil
+		case <-writer.failedChan():
+			return fmt.Errorf("error sending gRPC stream out_: %w", writer.err())
+		case received = <-incoming:
+		}
+		if received.err == io.EOF {
 			return nil
-		} else if err != nil {
-			return fmt.Errorf("error receiving gRPC stream in_: %w", err)
+		} else if received.err != nil {
+			return fmt.Errorf("error receiving gRPC stream in_: %w", received.err)
 		}
+		command := received.command
 		// endregion
 
-		// 2) ctx - commandScopeCtx stores command ID and logger
-		commandScopeCtx, err := logger.New(context.WithValue(context.Background(), logger.KeyForRequestID, projectutils.GenerateRequestID()))
-		if err != nil {
-			return fmt.Errorf("failed to init logger: %w", err)
-		}
+		// 2) execute command according to ordering
+		dispatcher.dispatch(command.GetRoomId(), func() {
+			// 2.1) ctx - commandScopeCtx is derived from stream ctx, stores command's span, request ID, user ID and child logger
+			spanCtx, span := startCommandSpan(stream.Context(), command)
+			commandScopeCtx, cancel := s.newCommandContext(spanCtx, command)
+			defer cancel()
 
-		// 3) execute command async-ly
-		go func() {
-			// 3.1) try to execute
-			returnEvent, err := s.processCommand(commandScopeCtx, received)
+			// 2.2) try to execute (here or on owner of the room) if rate limits allow
+			start := time.Now()
+			var returnEvent *r.Event
+			err := s.checkRateLimits(commandScopeCtx, streamID, command)
+			if err != nil {
+				returnEvent = &r.Event{
+					Timestamp: projectutils.NowTimestamp(),
+					RoomId:    command.GetRoomId(),
+					UserId:    command.GetUserId(),
+				}
+			} else {
+				returnEvent, err = s.executeCommand(commandScopeCtx, streamID, command)
+			}
+			s.metrics.ObserveCommand(commandPayloadType(command), err, time.Since(start))
+			endCommandSpan(span, command, returnEvent, err)
 			if err != nil {
 				// if failed, send error
-				logger.GetLoggerFromCtx(commandScopeCtx).Error(commandScopeCtx, "error processing command", zap.Error(err))
-				s.sendError(commandScopeCtx, stream, returnEvent, err)
+				logging.FromContext(commandScopeCtx).Error(commandScopeCtx, "error processing command", zap.Error(err))
+				s.sendError(commandScopeCtx, writer, returnEvent, err)
 				return
 			}
 
-			// 3.2) send result if OK
-			err = retry.Do(func() error { return stream.Send(returnEvent) }, s.retryStrategy)
+			// 2.3) send result if OK (it's broadcast to other streams of the room by executeCommand)
+			presence.track(returnEvent)
+			subscriptions.track(commandScopeCtx, returnEvent)
+			err = writer.send(commandScopeCtx, returnEvent)
 			if err != nil {
-				// if failed to send, then try to send error about step 2
-				logger.GetLoggerFromCtx(commandScopeCtx).Error(commandScopeCtx, "failed to send event", zap.Error(err))
-				s.sendError(commandScopeCtx, stream, returnEvent, err)
+				logging.FromContext(commandScopeCtx).Error(commandScopeCtx, "failed to send event", zap.Error(err))
 			}
-		}()
+		})
 	}
 }
 
+// receivedCommand - result of one stream.Recv call
+type receivedCommand struct {
+	command *r.Command
+	err     error
+}
+
+// receiveCommands - call stream.Recv in a goroutine until error (including io.EOF) or stop
+//
+// so Stream can wait for both incoming commands and streamWriter failure
+func receiveCommands(stream grpc.BidiStreamingServer[r.Command, r.Event], stop <-chan struct{}) <-chan receivedCommand {
+	incoming := make(chan receivedCommand)
+	go func() {
+		for {
+			command, err := stream.Recv()
+			select {
+			case incoming <- receivedCommand{command: command, err: err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return incoming
+}
+
 // SingleCommand - is the handler for single command endpoint SingleCommand
 //
 // One incoming command - full room snapshot after command execution (or simple message about deleted room)
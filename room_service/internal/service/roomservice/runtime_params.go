@@ -0,0 +1,33 @@
+package roomservice
+
+import (
+	"github.com/chempik1234/room-service/pkg/config"
+	"time"
+)
+
+// RuntimeParams - settings of RoomService that can be changed while it's running, see RoomService.UpdateRuntimeParams
+//
+// initial values are given to NewRoomService
+type RuntimeParams struct {
+	// RetryPolicy - retries of repository operations, Retryable is always replaced with isRetryable
+	RetryPolicy config.RetryPolicy
+	// CommandTimeout - max time of processing one command, 0 = no limit (only stream's deadline)
+	CommandTimeout time.Duration
+	// RateLimits - by payload type ("create_room", "affect_data", ...), command types that aren't set aren't limited
+	RateLimits map[string]CommandRateLimits
+	// Quotas - limits of stored data, zero value = no limits
+	Quotas Quotas
+}
+
+// UpdateRuntimeParams - atomically replace RuntimeParams, open streams aren't interrupted
+//
+// commands that are already executed keep using previous params for operations that are already started
+func (s *RoomService) UpdateRuntimeParams(params RuntimeParams) {
+	params.RetryPolicy.Retryable = isRetryable
+	s.runtimeParams.Store(&params)
+}
+
+// RuntimeParams - current RuntimeParams
+func (s *RoomService) RuntimeParams() RuntimeParams {
+	return *s.runtimeParams.Load()
+}
@@ -4,45 +4,46 @@ import (
 	"fmt"
 	"github.com/chempik1234/room-service/internal/models"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
-	"reflect"
 )
 
-// PlainObjectToProtobufValue - convert regular object to room_service.Value
+// PlainObjectToProtobufValue - convert regular object (see models.Value.Plain) to room_service.Value,
+// nil is Value without oneof
 func PlainObjectToProtobufValue(value any) (*r.Value, error) {
-	var curValue *r.Value
-	switch reflect.ValueOf(value).Kind() {
-	case reflect.Int64:
-		curValue = &r.Value{Value: &r.Value_IntValue{IntValue: value.(int64)}}
-	case reflect.Float64:
-		curValue = &r.Value{Value: &r.Value_FloatValue{FloatValue: value.(float64)}}
-	case reflect.String:
-		curValue = &r.Value{Value: &r.Value_StringValue{StringValue: value.(string)}}
-	case reflect.Bool:
-		curValue = &r.Value{Value: &r.Value_BoolValue{BoolValue: value.(bool)}}
-	case reflect.Map:
-		mapObjects := value.(map[string]any)
-		resultMap := make(map[string]*r.Value)
-		var err error
-		for key, valObj := range mapObjects {
-			resultMap[key], err = PlainObjectToProtobufValue(valObj)
+	switch v := value.(type) {
+	case nil:
+		return &r.Value{}, nil
+	case int64:
+		return &r.Value{Value: &r.Value_IntValue{IntValue: v}}, nil
+	case float64:
+		return &r.Value{Value: &r.Value_FloatValue{FloatValue: v}}, nil
+	case string:
+		return &r.Value{Value: &r.Value_StringValue{StringValue: v}}, nil
+	case bool:
+		return &r.Value{Value: &r.Value_BoolValue{BoolValue: v}}, nil
+	case []byte:
+		return &r.Value{Value: &r.Value_BinaryValue{BinaryValue: v}}, nil
+	case map[string]any:
+		resultMap := make(map[string]*r.Value, len(v))
+		for key, valObj := range v {
+			item, err := PlainObjectToProtobufValue(valObj)
 			if err != nil {
 				return nil, fmt.Errorf("error serializing map to Value: %w", err)
 			}
+			resultMap[key] = item
 		}
-	case reflect.Slice:
-		listObjects := value.([]any)
-		list := make([]*r.Value, len(listObjects))
-		var err error
-		for index, item := range listObjects {
-			list[index], err = PlainObjectToProtobufValue(item)
-			if err != nil {
+		return &r.Value{Value: &r.Value_MapValue{MapValue: &r.MapValue{Values: resultMap}}}, nil
+	case []any:
+		list := make([]*r.Value, len(v))
+		for index, item := range v {
+			var err error
+			if list[index], err = PlainObjectToProtobufValue(item); err != nil {
 				return nil, fmt.Errorf("error serializing list to Value: %w", err)
 			}
 		}
+		return &r.Value{Value: &r.Value_ListValue{ListValue: &r.ListValue{Values: list}}}, nil
 	default:
-		return nil, fmt.Errorf("unknown type: %v", reflect.TypeOf(value).Kind())
+		return nil, fmt.Errorf("unknown type: %T", value)
 	}
-	return curValue, nil
 }
 
 // ProtobufValueToPlainObject - convert room_service.Value to regular object
@@ -94,6 +95,8 @@ func ProtobufValueToValueObject(protoValue *r.Value) (val *models.Value, err err
 	}
 
 	switch v := protoValue.Value.(type) {
+	case nil:
+		val = models.EmptyValue()
 	case *r.Value_IntValue:
 		val = models.IntValue(v.IntValue)
 	case *r.Value_FloatValue:
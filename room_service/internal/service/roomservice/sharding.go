@@ -0,0 +1,103 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ShardRouter - finds instance that owns room and forwards commands to it, see sharding.Router
+type ShardRouter interface {
+	// Owner - address of instance that owns room, local = it's this instance
+	Owner(roomID string) (addr string, local bool)
+	// Forward - execute command on instance addr (its RoomShardService.ForwardCommand)
+	Forward(ctx context.Context, addr string, command *r.Command) (*r.Event, error)
+}
+
+// notOwnerReason - forwarded command is rejected by instance that doesn't own its room
+const notOwnerReason = "room isn't owned by this instance"
+
+// forwardedCommandError - forwarded command failed on the owner, event is the error event made by it
+type forwardedCommandError struct {
+	event *r.Event
+}
+
+// Error - implements error
+func (e *forwardedCommandError) Error() string {
+	return e.event.GetErrorMessage().GetError()
+}
+
+// executeCommand - executeAndPublish here or on instance that owns command's room, streamID is stream that received it
+//
+// commands without room (CreateRoom) are executed here, new room is read from storage by its owner
+func (s *RoomService) executeCommand(ctx context.Context, streamID string, command *r.Command) (*r.Event, error) {
+	if s.shardRouter != nil && len(command.GetRoomId()) > 0 {
+		if addr, local := s.shardRouter.Owner(command.GetRoomId()); !local {
+			return s.forwardCommand(ctx, addr, streamID, command)
+		}
+	}
+	return s.executeAndPublish(ctx, streamID, command)
+}
+
+// forwardCommand - execute command on instance addr, streamID is sent in metadata so the owner publishes result with it
+//
+// owner is unreachable -> errors.ErrOwnerUnavailable, command failed on owner -> forwardedCommandError
+func (s *RoomService) forwardCommand(ctx context.Context, addr string, streamID string, command *r.Command) (*r.Event, error) {
+	logging.FromContext(ctx).Info(ctx, "forwarding command to instance that owns the room", zap.String("owner", addr))
+	ctx = metadata.AppendToOutgoingContext(ctx, projectutils.MetadataKeyStreamID, streamID)
+	event, err := s.shardRouter.Forward(ctx, addr, command)
+	if err != nil {
+		baseEvent := &r.Event{
+			Timestamp: projectutils.NowTimestamp(),
+			RoomId:    command.GetRoomId(),
+			UserId:    command.GetUserId(),
+		}
+		return baseEvent, fmt.Errorf("%w: %v", roomerrors.ErrOwnerUnavailable, err)
+	}
+	if event.GetErrorMessage() != nil {
+		return event, &forwardedCommandError{event: event}
+	}
+	return event, nil
+}
+
+// ForwardCommand - is the handler for RoomShardService.ForwardCommand: command of room owned by this instance,
+// received by stream of another instance
+//
+// Command of room this instance doesn't own (instances disagree during rebalance) is rejected with FailedPrecondition,
+// so a room is never changed by two instances; the stream that received it gets UNAVAILABLE error and may retry.
+// Failed command returns event with ErrorMessage, gRPC error means the command isn't executed.
+//
+// Rate limits are checked by the instance whose stream received the command
+func (s *RoomService) ForwardCommand(ctx context.Context, command *r.Command) (*r.Event, error) {
+	// forwarded command is drained on Shutdown like a stream
+	if !s.enterStream() {
+		return nil, status.Error(codes.Unavailable, shuttingDownReason)
+	}
+	defer s.activeStreams.Done()
+
+	if s.shardRouter != nil && len(command.GetRoomId()) > 0 {
+		if _, local := s.shardRouter.Owner(command.GetRoomId()); !local {
+			return nil, status.Error(codes.FailedPrecondition, notOwnerReason)
+		}
+	}
+
+	spanCtx, span := startCommandSpan(ctx, command)
+	commandScopeCtx, cancel := s.newCommandContext(spanCtx, command)
+	defer cancel()
+
+	// command is observed in metrics by the instance whose stream received it, result is published by owner
+	returnEvent, err := s.executeAndPublish(commandScopeCtx, projectutils.StreamIDFromIncomingContext(ctx), command)
+	endCommandSpan(span, command, returnEvent, err)
+	if err != nil {
+		logging.FromContext(commandScopeCtx).Error(commandScopeCtx, "error processing forwarded command", zap.Error(err))
+		return newErrorEvent(returnEvent, err), nil
+	}
+	return returnEvent, nil
+}
@@ -0,0 +1,120 @@
+package roomservice
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/membership"
+	"github.com/chempik1234/room-service/internal/repositories/room"
+	"github.com/chempik1234/room-service/internal/sharding"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// countingRoomsRepo - ports.RoomsPort that counts JoinRoom calls of one instance
+type countingRoomsRepo struct {
+	ports.RoomsPort
+	joins atomic.Int32
+}
+
+func (c *countingRoomsRepo) JoinRoom(ctx context.Context, params ports.JoinRoomParams) error {
+	c.joins.Add(1)
+	return c.RoomsPort.JoinRoom(ctx, params)
+}
+
+// shardedInstance - RoomService with router, its RoomShardService is served on addr
+type shardedInstance struct {
+	addr    string
+	repo    *countingRoomsRepo
+	router  *sharding.Router
+	service *RoomService
+}
+
+func startShardedInstances(t *testing.T, storage ports.RoomsPort, n int) []*shardedInstance {
+	instances := make([]*shardedInstance, n)
+	listeners := make([]net.Listener, n)
+	members := make([]string, n)
+	for i := range instances {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i], members[i] = listener, listener.Addr().String()
+	}
+	for i := range instances {
+		instance := &shardedInstance{addr: members[i], repo: &countingRoomsRepo{RoomsPort: storage}}
+		instance.router = sharding.NewRouter(instance.addr, membership.NewStaticMembership(members), sharding.Params{})
+		if err := instance.router.Refresh(context.Background()); err != nil {
+			t.Fatalf("Refresh: %v", err)
+		}
+		instance.service = NewRoomService(instance.repo, nil, nil, nil, instance.router, testRetryPolicy, StreamParams{}, nil)
+
+		server := grpc.NewServer()
+		r.RegisterRoomShardServiceServer(server, instance.service)
+		go func() { _ = server.Serve(listeners[i]) }()
+		t.Cleanup(func() {
+			server.Stop()
+			_ = instance.router.Close()
+		})
+		instances[i] = instance
+	}
+	return instances
+}
+
+func TestStreamForwardsCommandsToRoomOwner(t *testing.T) {
+	storage := room.NewInMemoryRepository()
+	instances := startShardedInstances(t, storage, 2)
+	local, owner := instances[0], instances[1]
+
+	// room owned by the other instance
+	ownerUserID, _ := types.NewNotEmptyText("owner")
+	var roomID string
+	for {
+		newRoom, err := storage.CreateRoom(context.Background(), models.NewRoom(ownerUserID, map[string]string{"max_users": "10"}))
+		if err != nil {
+			t.Fatalf("CreateRoom: %v", err)
+		}
+		roomID = newRoom.ID.String()
+		if addr, _ := local.router.Owner(roomID); addr == owner.addr {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := newClientEventStream(ctx)
+	go func() { _ = local.service.Stream(stream) }()
+
+	stream.commands <- &r.Command{RoomId: &roomID, UserId: "alice", Payload: &r.Command_JoinRoom{
+		JoinRoom: &r.JoinRoomCommandBody{UserFull: &r.User{Id: "alice", Name: "alice"}},
+	}}
+	expect[*r.Event_JoinedRoom](t, stream, "join of room owned by other instance")
+	if local.repo.joins.Load() != 0 || owner.repo.joins.Load() != 1 {
+		t.Fatalf("join is executed %d times locally and %d times by owner, want 0 and 1",
+			local.repo.joins.Load(), owner.repo.joins.Load())
+	}
+
+	// error is made by the owner and returned as it is
+	stream.commands <- &r.Command{RoomId: &roomID, UserId: "alice", Payload: &r.Command_LeaveRoom{
+		LeaveRoom: &r.LeaveRoomCommandBody{KickedUserId: "bob"},
+	}}
+	expect[*r.Event_ErrorMessage](t, stream, "kick of user that isn't in room owned by other instance")
+
+	// forwarded command is rejected by instance that doesn't own the room, so the room isn't changed by two instances
+	joins := local.repo.joins.Load()
+	event, err := local.service.ForwardCommand(context.Background(), &r.Command{RoomId: &roomID, UserId: "bob", Payload: &r.Command_JoinRoom{
+		JoinRoom: &r.JoinRoomCommandBody{UserFull: &r.User{Id: "bob", Name: "bob"}},
+	}})
+	if status.Code(err) != codes.FailedPrecondition || event != nil {
+		t.Fatalf("forwarded command is executed by instance that doesn't own the room: %v, %v", event, err)
+	}
+	if local.repo.joins.Load() != joins {
+		t.Fatal("rejected command joined user")
+	}
+}
@@ -0,0 +1,67 @@
+package roomservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+)
+
+const shuttingDownReason = "server is shutting down"
+
+// Shutdown - gracefully finish all streams
+//
+// 1. stop accepting new commands in every stream
+//
+// 2. send ServerShuttingDown event to every stream
+//
+// 3. wait for already received commands to be processed and their events to be sent, then streams are closed
+//
+// If ctx is done before 3. is finished, commands that are still executed are canceled and ctx error is returned
+func (s *RoomService) Shutdown(ctx context.Context) error {
+	s.lifecycleMu.Lock()
+	select {
+	case <-s.shuttingDown:
+	default:
+		close(s.shuttingDown)
+	}
+	s.lifecycleMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.activeStreams.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		s.cancelCommands()
+		return fmt.Errorf("streams are not drained before deadline, in-flight commands are canceled: %w", ctx.Err())
+	}
+}
+
+// enterStream - register new Stream handler, false if service is shutting down
+func (s *RoomService) enterStream() bool {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+
+	select {
+	case <-s.shuttingDown:
+		return false
+	default:
+		s.activeStreams.Add(1)
+		return true
+	}
+}
+
+// newShuttingDownEvent - event that is sent into every stream on Shutdown
+func newShuttingDownEvent() *r.Event {
+	return &r.Event{
+		Timestamp: projectutils.NowTimestamp(),
+		Payload: &r.Event_ServerShuttingDown{
+			ServerShuttingDown: &r.ServerShuttingDownEventBody{Reason: shuttingDownReason},
+		},
+	}
+}
@@ -0,0 +1,105 @@
+package roomservice
+
+import (
+	"context"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsOpenStreams(t *testing.T) {
+	service := NewRoomService(nil, nil, nil, nil, nil, testRetryPolicy, StreamParams{}, nil)
+
+	// the client never closes it's side, so only Shutdown finishes the stream
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	defer cancelStream()
+	stream := &fakeEventStream{t: t, ctx: streamCtx, commands: make(chan *r.Command, 1)}
+	stream.commands <- &r.Command{Payload: &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}}}
+
+	streamResult := make(chan error, 1)
+	go func() { streamResult <- service.Stream(stream) }()
+
+	// wait until the command is received
+	deadline := time.Now().Add(time.Second)
+	for len(stream.commands) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
+	defer cancelShutdown()
+	if err := service.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if err := <-streamResult; err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	// already received command is processed, no matter if it's finished before or after shutdown started
+	var shuttingDownEvents, commandResults int
+	for _, event := range stream.sent {
+		switch {
+		case event.GetServerShuttingDown() != nil:
+			shuttingDownEvents++
+		case event.GetErrorMessage() != nil:
+			commandResults++
+		}
+	}
+	if shuttingDownEvents != 1 || commandResults != 1 || len(stream.sent) != 2 {
+		t.Fatalf("expected 1 shutting down event and 1 command result, got %v", stream.sent)
+	}
+
+	if err := service.Stream(stream); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected UNAVAILABLE for stream opened after shutdown, got %v", err)
+	}
+}
+
+// stalledEventStream - fakeEventStream whose client never reads: Send waits for gate
+type stalledEventStream struct {
+	*fakeEventStream
+	gate chan struct{}
+}
+
+func (s *stalledEventStream) Send(*r.Event) error {
+	<-s.gate
+	return nil
+}
+
+func TestShutdownDoesntHangOnStalledConsumer(t *testing.T) {
+	service := NewRoomService(nil, nil, nil, nil, nil, testRetryPolicy, StreamParams{
+		Ordering: CommandOrderingParams{Mode: CommandOrderingParallel, QueueSize: 8},
+		Outbound: StreamWriterParams{BufferSize: 1, SlowConsumerPolicy: SlowConsumerBlock},
+	}, nil)
+
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	defer cancelStream()
+	commands := make(chan *r.Command, 8)
+	for range cap(commands) {
+		commands <- &r.Command{Payload: &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}}}
+	}
+	stream := &stalledEventStream{
+		fakeEventStream: &fakeEventStream{t: t, ctx: streamCtx, commands: commands},
+		gate:            make(chan struct{}),
+	}
+	defer close(stream.gate)
+
+	streamResult := make(chan error, 1)
+	go func() { streamResult <- service.Stream(stream) }()
+	deadline := time.Now().Add(time.Second)
+	for len(commands) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// commands wait for space in the full outbound queue, the drain deadline releases them
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShutdown()
+	if err := service.Shutdown(shutdownCtx); err == nil {
+		t.Fatal("expected shutdown error: stalled stream can't be drained")
+	}
+	select {
+	case <-streamResult:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream with stalled consumer isn't finished after drain deadline")
+	}
+}
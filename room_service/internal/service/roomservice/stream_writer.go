@@ -0,0 +1,240 @@
+package roomservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/metrics"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+)
+
+// SlowConsumerPolicy is type for what to do when stream's outbound queue is full ENUM
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerBlock - wait until there's space in the queue (the sender is paused)
+	SlowConsumerBlock SlowConsumerPolicy = "block"
+	// SlowConsumerDrop - drop the event
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+	// SlowConsumerDisconnect - close the stream with RESOURCE_EXHAUSTED
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+const defaultOutboundBufferSize = 256
+
+// errStreamWriterClosed - event is sent after stream is finished
+var errStreamWriterClosed = errors.New("stream writer is closed")
+
+// errEventDropped - event is dropped because of SlowConsumerDrop
+var errEventDropped = errors.New("outbound queue is full, event dropped")
+
+// StreamWriterParams - how events are sent into one stream
+type StreamWriterParams struct {
+	// BufferSize - max events waiting to be sent
+	BufferSize int
+	// SlowConsumerPolicy - what to do when BufferSize events are already waiting
+	SlowConsumerPolicy SlowConsumerPolicy
+}
+
+// withDefaults - replace empty fields with defaults
+func (p StreamWriterParams) withDefaults() StreamWriterParams {
+	if p.BufferSize <= 0 {
+		p.BufferSize = defaultOutboundBufferSize
+	}
+	if len(p.SlowConsumerPolicy) == 0 {
+		p.SlowConsumerPolicy = SlowConsumerBlock
+	}
+	return p
+}
+
+// eventSender - the sending part of a stream, e.g. grpc.BidiStreamingServer[r.Command, r.Event]
+type eventSender interface {
+	Send(*r.Event) error
+}
+
+// streamWriter - the only one who calls Send of a stream
+//
+// grpc-go forbids concurrent Send calls, so results, errors and broadcast events
+// are put into the outbound queue and sent by a single goroutine
+type streamWriter struct {
+	sender  eventSender
+	params  StreamWriterParams
+	metrics *metrics.Metrics
+
+	queue chan *r.Event
+	// mu - guards closed, so nobody starts sending after close, it isn't held while send waits for the queue
+	mu     sync.RWMutex
+	closed bool
+	// closing - closed by close, senders waiting for space in the queue give up
+	closing chan struct{}
+	// sending - send calls that passed the closed check, queue is drained after all of them return
+	sending sync.WaitGroup
+	// done - closed when all queued events are handled
+	done chan struct{}
+
+	// failed - closed when stream must be disconnected, failErr is the reason
+	failed   chan struct{}
+	failOnce sync.Once
+	failErr  error
+
+	dropped atomic.Uint64
+}
+
+// newStreamWriter - create streamWriter and start it's goroutine, call streamWriter.close when stream is finished
+//
+// m is optional (nil), queue depth is recorded in it
+func newStreamWriter(sender eventSender, params StreamWriterParams, m *metrics.Metrics) *streamWriter {
+	params = params.withDefaults()
+	w := &streamWriter{
+		sender:  sender,
+		params:  params,
+		metrics: m,
+		queue:   make(chan *r.Event, params.BufferSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		failed:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// send - put event into the outbound queue, SlowConsumerPolicy is applied if it's full
+//
+// safe for concurrent use, SlowConsumerBlock waits until there's space in the queue, ctx is done
+// or the writer is closed/failed, so a stalled consumer blocks the sender for no longer than ctx allows
+func (w *streamWriter) send(ctx context.Context, event *r.Event) error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return errStreamWriterClosed
+	}
+	w.sending.Add(1)
+	w.mu.RUnlock()
+	defer w.sending.Done()
+
+	select {
+	case <-w.failed:
+		return w.failErr
+	default:
+	}
+
+	// counted before it's queued, so the writer never decrements it first
+	w.metrics.AddOutboundQueueDepth(1)
+
+	switch w.params.SlowConsumerPolicy {
+	case SlowConsumerDrop:
+		select {
+		case w.queue <- event:
+		default:
+			w.metrics.AddOutboundQueueDepth(-1)
+			w.dropped.Add(1)
+			return errEventDropped
+		}
+	case SlowConsumerDisconnect:
+		select {
+		case w.queue <- event:
+		default:
+			w.metrics.AddOutboundQueueDepth(-1)
+			w.fail(status.Error(codes.ResourceExhausted, "stream consumer is too slow, outbound queue is full"))
+			return w.failErr
+		}
+	default:
+		select {
+		case w.queue <- event:
+		case <-w.closing:
+			w.metrics.AddOutboundQueueDepth(-1)
+			return errStreamWriterClosed
+		case <-ctx.Done():
+			w.metrics.AddOutboundQueueDepth(-1)
+			return ctx.Err()
+		case <-w.failed:
+			w.metrics.AddOutboundQueueDepth(-1)
+			return w.failErr
+		}
+	}
+	return nil
+}
+
+// failedChan - closed when stream must be disconnected, see err
+func (w *streamWriter) failedChan() <-chan struct{} {
+	return w.failed
+}
+
+// err - reason why stream must be disconnected, nil if it mustn't
+func (w *streamWriter) err() error {
+	select {
+	case <-w.failed:
+		return w.failErr
+	default:
+		return nil
+	}
+}
+
+// droppedAmount - how many events are dropped by SlowConsumerDrop
+func (w *streamWriter) droppedAmount() uint64 {
+	return w.dropped.Load()
+}
+
+// close - stop accepting events and wait until queued ones are sent (or discarded after failure) or ctx is done
+//
+// senders blocked on the full queue return errStreamWriterClosed. If ctx is done first (consumer is stalled),
+// the writer fails and the rest of the queue is discarded, Send in progress fails once the stream is finished
+func (w *streamWriter) close(ctx context.Context) {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		w.fail(fmt.Errorf("error draining outbound queue: %w", ctx.Err()))
+	}
+}
+
+func (w *streamWriter) run() {
+	defer close(w.done)
+
+	for {
+		select {
+		case event := <-w.queue:
+			w.handle(event)
+		case <-w.closing:
+			// no event is queued after all senders return, so the rest of the queue is drained without waiting
+			w.sending.Wait()
+			for {
+				select {
+				case event := <-w.queue:
+					w.handle(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle - send queued event, or discard it after failure, so nobody is blocked on the full queue
+func (w *streamWriter) handle(event *r.Event) {
+	w.metrics.AddOutboundQueueDepth(-1)
+	if w.err() != nil {
+		return
+	}
+	// failed Send means the stream is broken, it's not retried
+	if err := w.sender.Send(event); err != nil {
+		w.fail(err)
+	}
+}
+
+func (w *streamWriter) fail(err error) {
+	w.failOnce.Do(func() {
+		w.failErr = err
+		close(w.failed)
+	})
+}
@@ -0,0 +1,269 @@
+package roomservice
+
+import (
+	"context"
+	"errors"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeEventStream - grpc.BidiStreamingServer[r.Command, r.Event] that fails the test on concurrent Send calls
+//
+// sent is appended without a lock, so the race detector catches concurrent sends too
+type fakeEventStream struct {
+	grpc.ServerStream
+
+	t        *testing.T
+	ctx      context.Context
+	commands chan *r.Command
+	inFlight atomic.Int32
+	delay    time.Duration
+	sent     []*r.Event
+}
+
+func newFakeEventStream(t *testing.T, commands []*r.Command) *fakeEventStream {
+	in := make(chan *r.Command, len(commands))
+	for _, command := range commands {
+		in <- command
+	}
+	close(in)
+	return &fakeEventStream{t: t, ctx: context.Background(), commands: in}
+}
+
+func (f *fakeEventStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeEventStream) Recv() (*r.Command, error) {
+	select {
+	case command, ok := <-f.commands:
+		if !ok {
+			return nil, io.EOF
+		}
+		return command, nil
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
+
+func (f *fakeEventStream) Send(event *r.Event) error {
+	if f.inFlight.Add(1) != 1 {
+		f.t.Error("concurrent Send calls on one stream")
+	}
+	defer f.inFlight.Add(-1)
+
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	f.sent = append(f.sent, event)
+	return nil
+}
+
+var testRetryPolicy = config.RetryPolicy{Attempts: 1}
+
+func TestStreamWriterSerializesConcurrentSends(t *testing.T) {
+	const senders, eventsPerSender = 64, 200
+
+	stream := newFakeEventStream(t, nil)
+	writer := newStreamWriter(stream, StreamWriterParams{BufferSize: 8}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < eventsPerSender; j++ {
+				if err := writer.send(context.Background(), &r.Event{Timestamp: int64(j)}); err != nil {
+					t.Errorf("unexpected send error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	writer.close(context.Background())
+
+	if len(stream.sent) != senders*eventsPerSender {
+		t.Fatalf("expected %d sent events, got %d", senders*eventsPerSender, len(stream.sent))
+	}
+	if err := writer.send(context.Background(), &r.Event{}); !errors.Is(err, errStreamWriterClosed) {
+		t.Fatalf("expected errStreamWriterClosed after close, got %v", err)
+	}
+}
+
+func TestStreamWriterSlowConsumerPolicies(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		stream := newFakeEventStream(t, nil)
+		stream.delay = 10 * time.Millisecond
+		writer := newStreamWriter(stream, StreamWriterParams{BufferSize: 1, SlowConsumerPolicy: SlowConsumerDrop}, nil)
+
+		for i := 0; i < 10; i++ {
+			_ = writer.send(context.Background(), &r.Event{})
+		}
+		writer.close(context.Background())
+
+		if writer.droppedAmount() == 0 {
+			t.Fatal("expected some events to be dropped")
+		}
+		if uint64(len(stream.sent))+writer.droppedAmount() != 10 {
+			t.Fatalf("sent %d + dropped %d != 10", len(stream.sent), writer.droppedAmount())
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		stream := newFakeEventStream(t, nil)
+		stream.delay = 10 * time.Millisecond
+		writer := newStreamWriter(stream, StreamWriterParams{BufferSize: 1, SlowConsumerPolicy: SlowConsumerDisconnect}, nil)
+
+		var err error
+		for i := 0; i < 10 && err == nil; i++ {
+			err = writer.send(context.Background(), &r.Event{})
+		}
+		writer.close(context.Background())
+
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("expected RESOURCE_EXHAUSTED, got %v", err)
+		}
+		select {
+		case <-writer.failedChan():
+		default:
+			t.Fatal("expected writer to be failed")
+		}
+	})
+}
+
+// gatedSender - eventSender whose Send waits for gate
+type gatedSender struct {
+	gate chan struct{}
+	sent atomic.Int32
+}
+
+func (g *gatedSender) Send(*r.Event) error {
+	<-g.gate
+	g.sent.Add(1)
+	return nil
+}
+
+func TestStreamWriterCloseDoesNotWaitForBlockedSenders(t *testing.T) {
+	sender := &gatedSender{gate: make(chan struct{})}
+	writer := newStreamWriter(sender, StreamWriterParams{BufferSize: 1, SlowConsumerPolicy: SlowConsumerBlock}, nil)
+
+	// the first event is in Send, the second one fills the queue
+	for range 2 {
+		if err := writer.send(context.Background(), &r.Event{}); err != nil {
+			t.Fatalf("unexpected send error: %v", err)
+		}
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- writer.send(context.Background(), &r.Event{}) }()
+	select {
+	case err := <-blocked:
+		t.Fatalf("send doesn't wait for space in the queue: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		writer.close(context.Background())
+		close(closed)
+	}()
+	// blocked sender gives up on close, while the consumer is still stuck
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, errStreamWriterClosed) {
+			t.Fatalf("expected errStreamWriterClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked send isn't released by close")
+	}
+
+	// queued events are still sent
+	close(sender.gate)
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close doesn't return")
+	}
+	if sent := sender.sent.Load(); sent != 2 {
+		t.Fatalf("sent %d events, want 2", sent)
+	}
+}
+
+func TestStreamWriterBlockedSendGivesUpWhenCtxIsDone(t *testing.T) {
+	sender := &gatedSender{gate: make(chan struct{})}
+	defer close(sender.gate)
+	writer := newStreamWriter(sender, StreamWriterParams{BufferSize: 1, SlowConsumerPolicy: SlowConsumerBlock}, nil)
+
+	for range 2 {
+		if err := writer.send(context.Background(), &r.Event{}); err != nil {
+			t.Fatalf("unexpected send error: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := writer.send(ctx, &r.Event{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestStreamWriterCloseAbandonsStalledConsumer(t *testing.T) {
+	sender := &gatedSender{gate: make(chan struct{})}
+	defer close(sender.gate)
+	writer := newStreamWriter(sender, StreamWriterParams{BufferSize: 1, SlowConsumerPolicy: SlowConsumerBlock}, nil)
+	for range 2 {
+		if err := writer.send(context.Background(), &r.Event{}); err != nil {
+			t.Fatalf("unexpected send error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	closed := make(chan struct{})
+	go func() {
+		writer.close(ctx)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close waits for stalled consumer after ctx is done")
+	}
+	if writer.err() == nil {
+		t.Fatal("abandoned writer isn't failed, the rest of the queue would be sent")
+	}
+}
+
+func TestStreamParallelCommandsShareOneWriter(t *testing.T) {
+	const commandsAmount = 500
+
+	// commands without userID fail validation before touching repositories, so each produces an error event
+	commands := make([]*r.Command, commandsAmount)
+	for i := range commands {
+		commands[i] = &r.Command{Payload: &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}}}
+	}
+	stream := newFakeEventStream(t, commands)
+
+	service := NewRoomService(nil, nil, nil, nil, nil, testRetryPolicy, StreamParams{
+		Ordering: CommandOrderingParams{Mode: CommandOrderingParallel, QueueSize: 32},
+		Outbound: StreamWriterParams{BufferSize: 4},
+	}, nil)
+	if err := service.Stream(stream); err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+
+	if len(stream.sent) != commandsAmount {
+		t.Fatalf("expected %d sent events, got %d", commandsAmount, len(stream.sent))
+	}
+	for _, event := range stream.sent {
+		if event.GetErrorMessage() == nil {
+			t.Fatalf("expected error event, got %v", event)
+		}
+	}
+}
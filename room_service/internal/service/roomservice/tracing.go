@@ -0,0 +1,40 @@
+package roomservice
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/tracing"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes of a command
+const (
+	spanAttributeCommandID   = "command_id"
+	spanAttributeRoomID      = "room_id"
+	spanAttributeUserID      = "user_id"
+	spanAttributePayloadType = "payload_type"
+)
+
+// startCommandSpan - span of processing one command
+//
+// ctx is the stream's ctx, so the span is a child of trace context received in gRPC metadata (if any)
+func startCommandSpan(ctx context.Context, command *r.Command) (context.Context, trace.Span) {
+	payloadType := commandPayloadType(command)
+	return tracing.Tracer().Start(ctx, "command "+payloadType, trace.WithAttributes(
+		attribute.String(spanAttributeCommandID, command.GetCommandId()),
+		attribute.String(spanAttributeRoomID, command.GetRoomId()),
+		attribute.String(spanAttributeUserID, command.GetUserId()),
+		attribute.String(spanAttributePayloadType, payloadType),
+	))
+}
+
+// endCommandSpan - end span of startCommandSpan with the command's result
+//
+// room ID is updated from event, because it's unknown before the room is created
+func endCommandSpan(span trace.Span, command *r.Command, event *r.Event, err error) {
+	if roomID := event.GetRoomId(); len(roomID) > 0 && roomID != command.GetRoomId() {
+		span.SetAttributes(attribute.String(spanAttributeRoomID, roomID))
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,136 @@
+package roomservice
+
+import (
+	"context"
+	"github.com/chempik1234/room-service/internal/models"
+	"github.com/chempik1234/room-service/internal/ports"
+	"github.com/chempik1234/room-service/internal/repositories/commandcache"
+	"github.com/chempik1234/room-service/internal/repositories/instrumented"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/config"
+	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyRoomsRepo - ports.RoomsPort whose RoomSnapshot fails the first call with network error, other methods aren't implemented
+type flakyRoomsRepo struct {
+	ports.RoomsPort
+	calls int
+}
+
+func (f *flakyRoomsRepo) RoomSnapshot(_ context.Context, params ports.RoomSnapshotParams) (*models.RoomSnapshot, error) {
+	f.calls++
+	if f.calls == 1 {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	return &models.RoomSnapshot{Room: &models.Room{ID: params.RoomID}}, nil
+}
+
+func TestStreamCommandSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previousProvider)
+
+	service := NewRoomService(
+		instrumented.NewRoomsRepository(&flakyRoomsRepo{}, nil),
+		instrumented.NewCommandCache(commandcache.NewInMemoryCommandCache(16, 1, 60000), nil),
+		nil,
+		nil,
+		nil,
+		config.RetryPolicy{Attempts: 2, Delay: time.Millisecond},
+		StreamParams{},
+		nil,
+	)
+
+	// trace context as it's extracted from incoming metadata by the gRPC stats handler
+	remoteParent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	roomID := types.GenerateUUID().String()
+	stream := newFakeEventStream(t, []*r.Command{{
+		CommandId: "command-1",
+		RoomId:    &roomID,
+		UserId:    "user-1",
+		Payload:   &r.Command_RefreshRoom{RefreshRoom: &r.RefreshRoomCommandBody{}},
+	}})
+	stream.ctx = trace.ContextWithRemoteSpanContext(context.Background(), remoteParent)
+
+	if err := service.Stream(stream); err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+	if len(stream.sent) != 1 || stream.sent[0].GetFullRoom() == nil {
+		t.Fatalf("expected full room event, got %v", stream.sent)
+	}
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	commandSpans := spans["command refresh_room"]
+	if len(commandSpans) != 1 {
+		t.Fatalf("expected 1 command span, got %v", spans)
+	}
+	commandSpan := commandSpans[0]
+	if commandSpan.Parent().SpanID() != remoteParent.SpanID() || commandSpan.SpanContext().TraceID() != remoteParent.TraceID() {
+		t.Fatalf("command span isn't a child of incoming trace context")
+	}
+	wantAttributes := map[attribute.Key]string{
+		spanAttributeCommandID:   "command-1",
+		spanAttributeRoomID:      roomID,
+		spanAttributeUserID:      "user-1",
+		spanAttributePayloadType: "refresh_room",
+	}
+	for _, kv := range commandSpan.Attributes() {
+		if want, ok := wantAttributes[kv.Key]; ok {
+			if kv.Value.AsString() != want {
+				t.Errorf("attribute %s = %q, want %q", kv.Key, kv.Value.AsString(), want)
+			}
+			delete(wantAttributes, kv.Key)
+		}
+	}
+	if len(wantAttributes) > 0 {
+		t.Errorf("missing command span attributes: %v", wantAttributes)
+	}
+
+	attemptSpans := spans["retry room_snapshot"]
+	if len(attemptSpans) != 2 {
+		t.Fatalf("expected 2 retry attempt spans, got %d", len(attemptSpans))
+	}
+	if attemptSpans[0].Status().Code != codes.Error || attemptSpans[1].Status().Code == codes.Error {
+		t.Errorf("expected only first attempt to fail")
+	}
+
+	repoSpans := spans["rooms.room_snapshot"]
+	if len(repoSpans) != 2 {
+		t.Fatalf("expected 2 repository call spans, got %d", len(repoSpans))
+	}
+	for i, attemptSpan := range attemptSpans {
+		if attemptSpan.Parent().SpanID() != commandSpan.SpanContext().SpanID() {
+			t.Errorf("retry attempt %d isn't a child of command span", i+1)
+		}
+		if repoSpans[i].Parent().SpanID() != attemptSpan.SpanContext().SpanID() {
+			t.Errorf("repository call %d isn't a child of retry attempt span", i+1)
+		}
+	}
+
+	for _, name := range []string{"command_cache.reserve", "command_cache.save"} {
+		if len(spans[name]) != 1 || spans[name][0].Parent().SpanID() != commandSpan.SpanContext().SpanID() {
+			t.Errorf("expected 1 %s span, child of command span", name)
+		}
+	}
+}
@@ -2,24 +2,67 @@ package roomservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	roomerrors "github.com/chempik1234/room-service/internal/errors"
 	"github.com/chempik1234/room-service/internal/models"
 	r "github.com/chempik1234/room-service/pkg/api/room_service"
-	"github.com/chempik1234/super-danis-library-golang/v2/pkg/logger"
+	"github.com/chempik1234/room-service/pkg/logging"
 	"github.com/chempik1234/super-danis-library-golang/v2/pkg/types"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
 )
 
-func (s *RoomService) sendError(ctx context.Context, stream grpc.BidiStreamingServer[r.Command, r.Event], baseEvent *r.Event, err error) {
-	err2 := stream.Send(&r.Event{
-		Timestamp: baseEvent.Timestamp,
-		RoomId:    baseEvent.RoomId,
-		UserId:    baseEvent.UserId,
-		Payload:   &r.Event_ErrorMessage{ErrorMessage: &r.ErrorMessage{Error: err.Error()}},
-	})
+func (s *RoomService) sendError(ctx context.Context, writer *streamWriter, baseEvent *r.Event, err error) {
+	err2 := writer.send(ctx, newErrorEvent(baseEvent, err))
 	if err2 != nil {
-		logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to send error", zap.Error(err), zap.String("original_error", err.Error()))
+		logging.FromContext(ctx).Error(ctx, "failed to send error", zap.Error(err2), zap.String("original_error", err.Error()))
+	}
+}
+
+// newErrorEvent - make event with ErrorMessage payload, other fields are copied from baseEvent
+//
+// rate limited or quota exceeded err -> RESOURCE_EXHAUSTED code, failing storage or unreachable owner -> UNAVAILABLE,
+// retry_after_ms if it's known; error of forwarded command is returned as the owner made it
+func newErrorEvent(baseEvent *r.Event, err error) *r.Event {
+	var forwardedErr *forwardedCommandError
+	if errors.As(err, &forwardedErr) {
+		return forwardedErr.event
+	}
+	message := &r.ErrorMessage{Error: err.Error()}
+	if errors.Is(err, roomerrors.ErrRateLimited) || errors.Is(err, roomerrors.ErrQuotaExceeded) {
+		message.Code = r.ErrorCode_RESOURCE_EXHAUSTED
+	} else if errors.Is(err, roomerrors.ErrCircuitOpen) || errors.Is(err, roomerrors.ErrOwnerUnavailable) {
+		message.Code = r.ErrorCode_UNAVAILABLE
+	}
+	var retryAfterErr *roomerrors.RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		message.RetryAfterMs = retryAfterErr.RetryAfter.Milliseconds()
+	}
+	return &r.Event{
+		Timestamp: baseEvent.GetTimestamp(),
+		RoomId:    baseEvent.GetRoomId(),
+		UserId:    baseEvent.GetUserId(),
+		Payload:   &r.Event_ErrorMessage{ErrorMessage: message},
+	}
+}
+
+// commandPayloadType - name of command's payload type for metrics and logs
+func commandPayloadType(in *r.Command) string {
+	switch in.GetPayload().(type) {
+	case *r.Command_CreateRoom:
+		return "create_room"
+	case *r.Command_DeleteRoom:
+		return "delete_room"
+	case *r.Command_JoinRoom:
+		return "join_room"
+	case *r.Command_LeaveRoom:
+		return "leave_room"
+	case *r.Command_AffectData:
+		return "affect_data"
+	case *r.Command_RefreshRoom:
+		return "refresh_room"
+	default:
+		return "unknown"
 	}
 }
 
@@ -30,7 +73,7 @@ func (s *RoomService) sendError(ctx context.Context, stream grpc.BidiStreamingSe
 // if it's other command, we ensure roomID is valid.
 func (s *RoomService) getValidRoomID(in *r.Command) (roomIDValidated *models.RoomID, err error) {
 	// it's only omitted in create room
-	switch _ := in.Payload.(type) {
+	switch in.Payload.(type) {
 	case *r.Command_CreateRoom:
 		// skip, generate locally
 		break
@@ -69,29 +112,3 @@ func (s *RoomService) getKickedUserID(leaveRoom *r.LeaveRoomCommandBody) (kicked
 	}
 	return kickedUserIDValid, nil
 }
-
-// noRepeatCommandID - get, save and return commandID.
-//
-// commandID is valid if it's command hasn't been executed already
-//
-// if it's valid, it's saved, else -> error
-//
-// If commandID is empty, no checks are applied
-func (s *RoomService) noRepeatCommandID(ctx context.Context, in *r.Command) (string, error) {
-	commandID := in.GetCommandId()
-	if len(commandID) > 0 {
-		commandIDExists, err := s.commandIdShortCache.Exists(ctx, commandID)
-		if err != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to get command_id from short cache", zap.String(commandIDZapKey, commandID), zap.Error(err))
-			return "", fmt.Errorf("failed to get command_id from short cache: %w", err)
-		}
-		if commandIDExists {
-			logger.GetLoggerFromCtx(ctx).Info(ctx, "command_id exists in short cache, SKIPPED", zap.String(commandIDZapKey, commandID))
-			return "", fmt.Errorf("command_id '%s' exists in short cache, SKIPPED", commandID)
-		}
-		if s.commandIdShortCache.Save(ctx, in.CommandId) != nil {
-			logger.GetLoggerFromCtx(ctx).Error(ctx, "failed to set command_id into short cache", zap.String(commandIDZapKey, commandID), zap.Error(err))
-		}
-	}
-	return commandID, nil
-}
@@ -0,0 +1,79 @@
+package sharding
+
+import (
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// defaultVirtualNodes - used when virtual nodes of Ring aren't set
+const defaultVirtualNodes = 128
+
+// Ring - consistent hashing of room IDs over members
+//
+// Every member has virtualNodes points on a ring of 64-bit hashes, room belongs to the member of the first point
+// at or after hash of its ID. When a member joins or leaves, only rooms of its points move.
+// Rings of the same members are equal, no matter in which order members are given
+type Ring struct {
+	members []string
+	points  []ringPoint
+}
+
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+// NewRing - return ring of members (duplicates are ignored), virtualNodes is 128 by default
+func NewRing(members []string, virtualNodes int) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	members = slices.Compact(slices.Sorted(slices.Values(members)))
+
+	points := make([]ringPoint, 0, len(members)*virtualNodes)
+	for _, member := range members {
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, ringPoint{hash: hashKey(member + "#" + strconv.Itoa(i)), member: member})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].member < points[j].member
+	})
+	return &Ring{members: members, points: points}
+}
+
+// Owner - member that owns room, "" if ring has no members
+func (r *Ring) Owner(roomID string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := hashKey(roomID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].member
+}
+
+// Members - sorted members of ring
+func (r *Ring) Members() []string {
+	return slices.Clone(r.members)
+}
+
+// hashKey - FNV-1a spread with splitmix64 finalizer, similar keys ("member#1", "member#2") get distant points
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,65 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+func roomIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("room-%d", i)
+	}
+	return ids
+}
+
+func TestRingSpreadsRoomsEvenly(t *testing.T) {
+	members := []string{"a:50051", "b:50051", "c:50051", "d:50051"}
+	ring := NewRing(members, 0)
+
+	counts := make(map[string]int)
+	rooms := roomIDs(40000)
+	for _, roomID := range rooms {
+		counts[ring.Owner(roomID)]++
+	}
+	expected := len(rooms) / len(members)
+	for _, member := range members {
+		if counts[member] < expected*3/4 || counts[member] > expected*5/4 {
+			t.Errorf("member %s owns %d rooms, expected about %d", member, counts[member], expected)
+		}
+	}
+
+	reordered := NewRing([]string{"d:50051", "b:50051", "a:50051", "c:50051", "a:50051"}, 0)
+	for _, roomID := range rooms {
+		if ring.Owner(roomID) != reordered.Owner(roomID) {
+			t.Fatalf("owner of %s depends on order of members", roomID)
+		}
+	}
+}
+
+func TestRingMovesOnlyRoomsOfChangedMember(t *testing.T) {
+	before := NewRing([]string{"a", "b", "c"}, 0)
+	joined := NewRing([]string{"a", "b", "c", "d"}, 0)
+	left := NewRing([]string{"a", "c"}, 0)
+
+	moved := 0
+	for _, roomID := range roomIDs(10000) {
+		owner := before.Owner(roomID)
+		if next := joined.Owner(roomID); next != owner {
+			moved++
+			if next != "d" {
+				t.Fatalf("room %s moved from %s to %s, not to the joined member", roomID, owner, next)
+			}
+		}
+		if next := left.Owner(roomID); next != owner && owner != "b" {
+			t.Fatalf("room %s moved from %s to %s, though its owner didn't leave", roomID, owner, next)
+		}
+	}
+	if moved == 0 {
+		t.Fatal("no rooms moved to the joined member")
+	}
+
+	if owner := NewRing(nil, 0).Owner("room"); owner != "" {
+		t.Fatalf("ring without members returned owner %q", owner)
+	}
+}
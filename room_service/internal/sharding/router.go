@@ -0,0 +1,247 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/chempik1234/room-service/internal/ports"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"github.com/chempik1234/room-service/pkg/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRefreshInterval - used when Params.RefreshInterval isn't set
+const defaultRefreshInterval = 5 * time.Second
+
+// Router - finds instance that owns room (see Ring) and forwards commands to it over RoomShardService
+//
+// Members are read from ports.MembershipPort by Refresh, Run calls it every Params.RefreshInterval.
+// When members change, rooms are rebalanced: handlers of OnRebalance release rooms that this instance
+// didn't own before or doesn't own now (e.g. write-behind flushes and unloads them) before the new ring takes effect,
+// so the owner reads them from storage.
+//
+// Instances see membership changes at different moments, so during rebalance a room may be served
+// by two instances for up to RefreshInterval
+type Router struct {
+	self       string
+	membership ports.MembershipPort
+	params     Params
+	ring       atomic.Pointer[Ring]
+
+	// refreshMu - held while ring is replaced and rooms are released, so rebalances don't overlap
+	refreshMu sync.Mutex
+	handlers  []RebalanceFunc
+
+	clientsMu sync.Mutex
+	clients   map[string]*grpc.ClientConn
+
+	forwarded     atomic.Uint64
+	forwardErrors atomic.Uint64
+	rebalances    atomic.Uint64
+}
+
+// Params - params of Router
+type Params struct {
+	// VirtualNodes - points of every member on Ring, default 128
+	VirtualNodes int
+	// RefreshInterval - time between reads of members by Run, default 5s
+	RefreshInterval time.Duration
+	// DialOptions - options of connections to other instances, insecure by default
+	DialOptions []grpc.DialOption
+}
+
+func (p Params) withDefaults() Params {
+	if p.RefreshInterval <= 0 {
+		p.RefreshInterval = defaultRefreshInterval
+	}
+	if len(p.DialOptions) == 0 {
+		p.DialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return p
+}
+
+// RebalanceFunc - release every room for which owned returns false
+type RebalanceFunc func(ctx context.Context, owned func(roomID string) bool) error
+
+// Stats - counters of Router, see Router.Stats
+type Stats struct {
+	// Members - instances on ring right now
+	Members int
+	// Forwarded - commands forwarded to other instances
+	Forwarded uint64
+	// ForwardErrors - commands that weren't forwarded (e.g. owner is unreachable)
+	ForwardErrors uint64
+	// Rebalances - membership changes
+	Rebalances uint64
+}
+
+// NewRouter - return new Router of instance self (its address among members), call Refresh before use
+//
+// until members are read every room is local
+func NewRouter(self string, membership ports.MembershipPort, params Params) *Router {
+	router := &Router{
+		self:       self,
+		membership: membership,
+		params:     params.withDefaults(),
+		clients:    make(map[string]*grpc.ClientConn),
+	}
+	router.ring.Store(NewRing(nil, params.VirtualNodes))
+	return router
+}
+
+// OnRebalance - call handler when members change, must be called before Refresh
+func (rt *Router) OnRebalance(handler RebalanceFunc) {
+	rt.handlers = append(rt.handlers, handler)
+}
+
+// Run - Refresh every Params.RefreshInterval until ctx is done
+func (rt *Router) Run(ctx context.Context) {
+	ticker := time.NewTicker(rt.params.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := rt.Refresh(ctx); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error(ctx, "failed to refresh members", zap.Error(err))
+		}
+	}
+}
+
+// Refresh - read members, if they're changed rebalance rooms and replace ring, errors of handlers are joined
+//
+// handlers release rooms before the new ring takes effect, so new owners read them from storage after this instance
+// wrote them; if a handler fails, the ring is kept and the next Refresh rebalances again. Rooms changed by commands
+// executed meanwhile are released by the second call of handlers, after the ring is replaced
+func (rt *Router) Refresh(ctx context.Context) error {
+	members, err := rt.membership.Members(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading members: %w", err)
+	}
+
+	rt.refreshMu.Lock()
+	defer rt.refreshMu.Unlock()
+	previous, next := rt.ring.Load(), NewRing(members, rt.params.VirtualNodes)
+	if slices.Equal(previous.Members(), next.Members()) {
+		return nil
+	}
+	logging.FromContext(ctx).Info(ctx, "members changed, rebalancing rooms",
+		zap.Strings("previous", previous.Members()), zap.Strings("members", next.Members()))
+
+	owned := func(roomID string) bool {
+		return rt.ownedBy(previous, roomID) && rt.ownedBy(next, roomID)
+	}
+	if err = rt.release(ctx, owned); err != nil {
+		return err
+	}
+
+	rt.ring.Store(next)
+	rt.rebalances.Add(1)
+	rt.closeClients(next)
+	if !slices.Contains(next.Members(), rt.self) {
+		logging.FromContext(ctx).Warn(ctx, "this instance isn't a member, every command is forwarded", zap.String("self", rt.self))
+	}
+	return rt.release(ctx, owned)
+}
+
+// Owner - address of instance that owns room, local = it's this instance
+func (rt *Router) Owner(roomID string) (addr string, local bool) {
+	ring := rt.ring.Load()
+	return ring.Owner(roomID), rt.ownedBy(ring, roomID)
+}
+
+// Forward - execute command on instance addr, see RoomShardService.ForwardCommand
+func (rt *Router) Forward(ctx context.Context, addr string, command *r.Command) (*r.Event, error) {
+	conn, err := rt.client(addr)
+	if err == nil {
+		var event *r.Event
+		event, err = r.NewRoomShardServiceClient(conn).ForwardCommand(ctx, command)
+		if err == nil {
+			rt.forwarded.Add(1)
+			return event, nil
+		}
+	}
+	rt.forwardErrors.Add(1)
+	return nil, fmt.Errorf("error forwarding command to %s: %w", addr, err)
+}
+
+// Stats - return current counters
+func (rt *Router) Stats() Stats {
+	return Stats{
+		Members:       len(rt.ring.Load().Members()),
+		Forwarded:     rt.forwarded.Load(),
+		ForwardErrors: rt.forwardErrors.Load(),
+		Rebalances:    rt.rebalances.Load(),
+	}
+}
+
+// Close - close connections to other instances
+func (rt *Router) Close() error {
+	rt.clientsMu.Lock()
+	defer rt.clientsMu.Unlock()
+	var errs []error
+	for addr, conn := range rt.clients {
+		errs = append(errs, conn.Close())
+		delete(rt.clients, addr)
+	}
+	return errors.Join(errs...)
+}
+
+// release - call every handler of OnRebalance, errors are joined
+func (rt *Router) release(ctx context.Context, owned func(roomID string) bool) error {
+	var errs []error
+	for _, handler := range rt.handlers {
+		if err := handler(ctx, owned); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("error releasing rooms: %w", err)
+	}
+	return nil
+}
+
+// ownedBy - room is owned by this instance on ring, every room is local if ring has no members
+func (rt *Router) ownedBy(ring *Ring, roomID string) bool {
+	owner := ring.Owner(roomID)
+	return len(owner) == 0 || owner == rt.self
+}
+
+// client - connection to instance addr, it's created on first use
+func (rt *Router) client(addr string) (*grpc.ClientConn, error) {
+	rt.clientsMu.Lock()
+	defer rt.clientsMu.Unlock()
+	if conn, ok := rt.clients[addr]; ok {
+		return conn, nil
+	}
+	// stats handler injects trace context into outgoing metadata, so command spans of owner continue the trace
+	options := append(slices.Clone(rt.params.DialOptions), grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	conn, err := grpc.NewClient(addr, options...)
+	if err != nil {
+		return nil, err
+	}
+	rt.clients[addr] = conn
+	return conn, nil
+}
+
+// closeClients - close connections to instances that aren't members of ring
+func (rt *Router) closeClients(ring *Ring) {
+	members := ring.Members()
+	rt.clientsMu.Lock()
+	defer rt.clientsMu.Unlock()
+	for addr, conn := range rt.clients {
+		if !slices.Contains(members, addr) {
+			_ = conn.Close()
+			delete(rt.clients, addr)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"github.com/chempik1234/room-service/internal/repositories/membership"
+	r "github.com/chempik1234/room-service/pkg/api/room_service"
+	"google.golang.org/grpc"
+	"net"
+	"testing"
+)
+
+func TestRouterRebalancesOnMembershipChange(t *testing.T) {
+	members := membership.NewStaticMembership([]string{"a", "b"})
+	router := NewRouter("a", members, Params{})
+	defer func() { _ = router.Close() }()
+
+	// rebalance - call of handler: rooms it releases and members of ring at that moment
+	type rebalance struct {
+		owned   func(roomID string) bool
+		members int
+	}
+	var rebalances []rebalance
+	router.OnRebalance(func(_ context.Context, owned func(roomID string) bool) error {
+		rebalances = append(rebalances, rebalance{owned: owned, members: router.Stats().Members})
+		return nil
+	})
+	ctx := context.Background()
+	if err := router.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if err := router.Refresh(ctx); err != nil || len(rebalances) != 2 {
+		t.Fatalf("expected one rebalance for unchanged members, got %d handler calls (err: %v)", len(rebalances), err)
+	}
+
+	before := NewRing([]string{"a", "b"}, 0)
+	after := NewRing([]string{"a", "b", "c"}, 0)
+	members.Set([]string{"c", "b", "a"})
+	if err := router.Refresh(ctx); err != nil || len(rebalances) != 4 {
+		t.Fatalf("expected rebalance for changed members, got %d handler calls (err: %v)", len(rebalances), err)
+	}
+	// rooms are released before the new ring takes effect, then once more after it
+	if rebalances[2].members != 2 || rebalances[3].members != 3 {
+		t.Fatalf("handlers are called with %d and %d members on ring, want 2 and 3", rebalances[2].members, rebalances[3].members)
+	}
+
+	for _, roomID := range roomIDs(1000) {
+		owner, local := router.Owner(roomID)
+		if owner != after.Owner(roomID) || local != (owner == "a") {
+			t.Fatalf("Owner(%s) = %s, %v, want %s", roomID, owner, local, after.Owner(roomID))
+		}
+		// rooms owned both before and after are kept, the rest is released
+		keep := before.Owner(roomID) == "a" && after.Owner(roomID) == "a"
+		if owned := rebalances[2].owned(roomID); owned != keep {
+			t.Fatalf("owned(%s) = %v, want %v", roomID, owned, keep)
+		}
+	}
+	if stats := router.Stats(); stats.Members != 3 || stats.Rebalances != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRouterKeepsRingIfRoomsAreNotReleased(t *testing.T) {
+	members := membership.NewStaticMembership([]string{"a", "b"})
+	router := NewRouter("a", members, Params{})
+	defer func() { _ = router.Close() }()
+	ctx := context.Background()
+	if err := router.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	releaseErr := errors.New("storage is down")
+	router.OnRebalance(func(context.Context, func(roomID string) bool) error {
+		return releaseErr
+	})
+	members.Set([]string{"a", "b", "c"})
+	if err := router.Refresh(ctx); !errors.Is(err, releaseErr) {
+		t.Fatalf("Refresh = %v, want error of handler", err)
+	}
+	if stats := router.Stats(); stats.Members != 2 || stats.Rebalances != 1 {
+		t.Fatalf("ring is replaced though rooms aren't released: %+v", stats)
+	}
+
+	// the next Refresh rebalances again
+	releaseErr = nil
+	if err := router.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if stats := router.Stats(); stats.Members != 3 || stats.Rebalances != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// echoShardServer - RoomShardService that returns event of the forwarded command's room
+type echoShardServer struct {
+	r.UnimplementedRoomShardServiceServer
+}
+
+func (echoShardServer) ForwardCommand(_ context.Context, command *r.Command) (*r.Event, error) {
+	return &r.Event{RoomId: command.GetRoomId(), UserId: command.GetUserId()}, nil
+}
+
+func TestRouterForwardsCommands(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	r.RegisterRoomShardServiceServer(server, echoShardServer{})
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	owner := listener.Addr().String()
+	router := NewRouter("self", membership.NewStaticMembership([]string{owner}), Params{})
+	defer func() { _ = router.Close() }()
+	if err = router.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	roomID := "room"
+	addr, local := router.Owner(roomID)
+	if addr != owner || local {
+		t.Fatalf("Owner = %s, %v, want %s, false", addr, local, owner)
+	}
+	event, err := router.Forward(context.Background(), addr, &r.Command{RoomId: &roomID, UserId: "user"})
+	if err != nil || event.GetRoomId() != roomID || event.GetUserId() != "user" {
+		t.Fatalf("Forward = %v, %v", event, err)
+	}
+
+	server.Stop()
+	if _, err = router.Forward(context.Background(), addr, &r.Command{RoomId: &roomID}); err == nil {
+		t.Fatal("expected error forwarding to stopped instance")
+	}
+	if stats := router.Stats(); stats.Forwarded != 1 || stats.ForwardErrors != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
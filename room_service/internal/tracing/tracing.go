@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+// InstrumentationName - name of the tracer used by the service
+const InstrumentationName = "github.com/chempik1234/room-service"
+
+// Exporter - where finished spans are sent
+type Exporter string
+
+const (
+	// ExporterNone - spans aren't recorded (trace context is still propagated)
+	ExporterNone Exporter = "none"
+	// ExporterStdout - spans are written to stdout as JSON, for local debugging
+	ExporterStdout Exporter = "stdout"
+	// ExporterOTLP - spans are sent to OTLP collector over gRPC
+	ExporterOTLP Exporter = "otlp"
+)
+
+// Params - settings of Setup
+type Params struct {
+	Exporter Exporter
+	// ServiceName - "service.name" resource attribute
+	ServiceName string
+	// OTLPEndpoint - host:port of OTLP collector, empty = OTEL_EXPORTER_OTLP_* env or localhost:4317
+	OTLPEndpoint string
+	// OTLPInsecure - connect to OTLP collector without TLS
+	OTLPInsecure bool
+	// SampleRatio - share of traces started here that are sampled (0 = never, 1 = every trace), parent's decision is respected
+	SampleRatio float64
+}
+
+// ShutdownFunc - flushes and stops span exporter
+type ShutdownFunc func(ctx context.Context) error
+
+// Setup - install global tracer provider and W3C trace context propagator
+//
+// Propagator is installed even with ExporterNone, so trace context of incoming requests is passed further
+func Setup(ctx context.Context, params Params) (ShutdownFunc, error) {
+	if params.SampleRatio < 0 || params.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing sample ratio must be within [0, 1], got %v", params.SampleRatio)
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch params.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		options := make([]otlptracegrpc.Option, 0, 2)
+		if len(params.OTLPEndpoint) > 0 {
+			options = append(options, otlptracegrpc.WithEndpoint(params.OTLPEndpoint))
+		}
+		if params.OTLPInsecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: '%s' (Use one of these: 'none', 'stdout', 'otlp')", params.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create '%s' span exporter: %w", params.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(params.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(params.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer - tracer of the service, taken from global provider on every call, so Setup may be called later
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// End - record err (if any) into span and end it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -21,6 +21,56 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// numbers match gRPC status codes
+type ErrorCode int32
+
+const (
+	ErrorCode_UNKNOWN_ERROR      ErrorCode = 0
+	ErrorCode_RESOURCE_EXHAUSTED ErrorCode = 8  // rate limit or quota exceeded
+	ErrorCode_UNAVAILABLE        ErrorCode = 14 // storage is failing, retry later
+)
+
+// Enum value maps for ErrorCode.
+var (
+	ErrorCode_name = map[int32]string{
+		0:  "UNKNOWN_ERROR",
+		8:  "RESOURCE_EXHAUSTED",
+		14: "UNAVAILABLE",
+	}
+	ErrorCode_value = map[string]int32{
+		"UNKNOWN_ERROR":      0,
+		"RESOURCE_EXHAUSTED": 8,
+		"UNAVAILABLE":        14,
+	}
+)
+
+func (x ErrorCode) Enum() *ErrorCode {
+	p := new(ErrorCode)
+	*p = x
+	return p
+}
+
+func (x ErrorCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_room_service_room_service_proto_enumTypes[0].Descriptor()
+}
+
+func (ErrorCode) Type() protoreflect.EnumType {
+	return &file_api_room_service_room_service_proto_enumTypes[0]
+}
+
+func (x ErrorCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorCode.Descriptor instead.
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return file_api_room_service_room_service_proto_rawDescGZIP(), []int{0}
+}
+
 type DateEditMode int32
 
 const (
@@ -57,11 +107,11 @@ func (x DateEditMode) String() string {
 }
 
 func (DateEditMode) Descriptor() protoreflect.EnumDescriptor {
-	return file_api_room_service_room_service_proto_enumTypes[0].Descriptor()
+	return file_api_room_service_room_service_proto_enumTypes[1].Descriptor()
 }
 
 func (DateEditMode) Type() protoreflect.EnumType {
-	return &file_api_room_service_room_service_proto_enumTypes[0]
+	return &file_api_room_service_room_service_proto_enumTypes[1]
 }
 
 func (x DateEditMode) Number() protoreflect.EnumNumber {
@@ -70,13 +120,15 @@ func (x DateEditMode) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use DateEditMode.Descriptor instead.
 func (DateEditMode) EnumDescriptor() ([]byte, []int) {
-	return file_api_room_service_room_service_proto_rawDescGZIP(), []int{0}
+	return file_api_room_service_room_service_proto_rawDescGZIP(), []int{1}
 }
 
 // --------------------- universal types
 type ErrorMessage struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Error         string                 `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	Code          ErrorCode              `protobuf:"varint,2,opt,name=code,proto3,enum=api.ErrorCode" json:"code,omitempty"`
+	RetryAfterMs  int64                  `protobuf:"varint,3,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"` // set if command may succeed when repeated later (e.g. RESOURCE_EXHAUSTED)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -118,6 +170,20 @@ func (x *ErrorMessage) GetError() string {
 	return ""
 }
 
+func (x *ErrorMessage) GetCode() ErrorCode {
+	if x != nil {
+		return x.Code
+	}
+	return ErrorCode_UNKNOWN_ERROR
+}
+
+func (x *ErrorMessage) GetRetryAfterMs() int64 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
 type Value struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Types that are valid to be assigned to Value:
@@ -475,7 +541,7 @@ func (x *RoomData) GetValues() map[string]*Value {
 
 type Command struct {
 	state     protoimpl.MessageState `protogen:"open.v1"`
-	CommandId string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"` // no-repeat
+	CommandId string                 `protobuf:"bytes,1,opt,name=command_id,json=commandId,proto3" json:"command_id,omitempty"` // no-repeat, but if commandID is empty, no checks are applied
 	Timestamp int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	RoomId    *string                `protobuf:"bytes,3,opt,name=room_id,json=roomId,proto3,oneof" json:"room_id,omitempty"`
 	UserId    string                 `protobuf:"bytes,4,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
@@ -831,9 +897,9 @@ func (x *LeaveRoomCommandBody) GetKickedUserId() string {
 type SetAppendDeleteDataCommandBody struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	DataId        string                 `protobuf:"bytes,1,opt,name=data_id,json=dataId,proto3" json:"data_id,omitempty"`
-	DataValue     *Value                 `protobuf:"bytes,2,opt,name=data_value,json=dataValue,proto3,oneof" json:"data_value,omitempty"` // no need for delete
+	DataValue     *Value                 `protobuf:"bytes,2,opt,name=data_value,json=dataValue,proto3,oneof" json:"data_value,omitempty"` // optional because no need for delete
 	CommandMode   DateEditMode           `protobuf:"varint,3,opt,name=command_mode,json=commandMode,proto3,enum=api.DateEditMode" json:"command_mode,omitempty"`
-	ItemIndex     *string                `protobuf:"bytes,4,opt,name=item_index,json=itemIndex,proto3,oneof" json:"item_index,omitempty"` // map key or list index
+	ItemIndex     *string                `protobuf:"bytes,4,opt,name=item_index,json=itemIndex,proto3,oneof" json:"item_index,omitempty"` // map key or list index - if used, SET/REMOVE modes affect only ITEM, not WHOLE VALUE
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -955,6 +1021,7 @@ type Event struct {
 	//	*Event_DataEdited
 	//	*Event_FullRoom
 	//	*Event_ErrorMessage
+	//	*Event_ServerShuttingDown
 	Payload       isEvent_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -1081,6 +1148,15 @@ func (x *Event) GetErrorMessage() *ErrorMessage {
 	return nil
 }
 
+func (x *Event) GetServerShuttingDown() *ServerShuttingDownEventBody {
+	if x != nil {
+		if x, ok := x.Payload.(*Event_ServerShuttingDown); ok {
+			return x.ServerShuttingDown
+		}
+	}
+	return nil
+}
+
 type isEvent_Payload interface {
 	isEvent_Payload()
 }
@@ -1113,6 +1189,10 @@ type Event_ErrorMessage struct {
 	ErrorMessage *ErrorMessage `protobuf:"bytes,50,opt,name=error_message,json=errorMessage,proto3,oneof"`
 }
 
+type Event_ServerShuttingDown struct {
+	ServerShuttingDown *ServerShuttingDownEventBody `protobuf:"bytes,60,opt,name=server_shutting_down,json=serverShuttingDown,proto3,oneof"`
+}
+
 func (*Event_RoomCreated) isEvent_Payload() {}
 
 func (*Event_RoomDeleted) isEvent_Payload() {}
@@ -1127,6 +1207,8 @@ func (*Event_FullRoom) isEvent_Payload() {}
 
 func (*Event_ErrorMessage) isEvent_Payload() {}
 
+func (*Event_ServerShuttingDown) isEvent_Payload() {}
+
 // bodies of event: can't be used on their own
 type RoomCreatedEventBody struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -1465,6 +1547,51 @@ func (x *FullRoomSnapshotEventBody) GetRoomId() string {
 	return ""
 }
 
+// server doesn't accept commands anymore, already received ones are still processed, then stream is closed
+type ServerShuttingDownEventBody struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Reason        string                 `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerShuttingDownEventBody) Reset() {
+	*x = ServerShuttingDownEventBody{}
+	mi := &file_api_room_service_room_service_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerShuttingDownEventBody) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerShuttingDownEventBody) ProtoMessage() {}
+
+func (x *ServerShuttingDownEventBody) ProtoReflect() protoreflect.Message {
+	mi := &file_api_room_service_room_service_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerShuttingDownEventBody.ProtoReflect.Descriptor instead.
+func (*ServerShuttingDownEventBody) Descriptor() ([]byte, []int) {
+	return file_api_room_service_room_service_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ServerShuttingDownEventBody) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
 type SingleEvent struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Types that are valid to be assigned to Result:
@@ -1478,7 +1605,7 @@ type SingleEvent struct {
 
 func (x *SingleEvent) Reset() {
 	*x = SingleEvent{}
-	mi := &file_api_room_service_room_service_proto_msgTypes[20]
+	mi := &file_api_room_service_room_service_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1490,7 +1617,7 @@ func (x *SingleEvent) String() string {
 func (*SingleEvent) ProtoMessage() {}
 
 func (x *SingleEvent) ProtoReflect() protoreflect.Message {
-	mi := &file_api_room_service_room_service_proto_msgTypes[20]
+	mi := &file_api_room_service_room_service_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1503,7 +1630,7 @@ func (x *SingleEvent) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SingleEvent.ProtoReflect.Descriptor instead.
 func (*SingleEvent) Descriptor() ([]byte, []int) {
-	return file_api_room_service_room_service_proto_rawDescGZIP(), []int{20}
+	return file_api_room_service_room_service_proto_rawDescGZIP(), []int{21}
 }
 
 func (x *SingleEvent) GetResult() isSingleEvent_Result {
@@ -1551,9 +1678,11 @@ var File_api_room_service_room_service_proto protoreflect.FileDescriptor
 
 const file_api_room_service_room_service_proto_rawDesc = "" +
 	"\n" +
-	"#api/room_service/room_service.proto\x12\x03api\"$\n" +
+	"#api/room_service/room_service.proto\x12\x03api\"n\n" +
 	"\fErrorMessage\x12\x14\n" +
-	"\x05error\x18\x01 \x01(\tR\x05error\"\x9c\x02\n" +
+	"\x05error\x18\x01 \x01(\tR\x05error\x12\"\n" +
+	"\x04code\x18\x02 \x01(\x0e2\x0e.api.ErrorCodeR\x04code\x12$\n" +
+	"\x0eretry_after_ms\x18\x03 \x01(\x03R\fretryAfterMs\"\x9c\x02\n" +
 	"\x05Value\x12#\n" +
 	"\fstring_value\x18\x01 \x01(\tH\x00R\vstringValue\x12\x1d\n" +
 	"\tint_value\x18\x02 \x01(\x03H\x00R\bintValue\x12!\n" +
@@ -1630,7 +1759,7 @@ const file_api_room_service_room_service_proto_rawDesc = "" +
 	"\v_data_valueB\r\n" +
 	"\v_item_index\";\n" +
 	"\x16RefreshRoomCommandBody\x12!\n" +
-	"\frefresh_room\x18\x01 \x01(\bR\vrefreshRoom\"\x8c\x04\n" +
+	"\frefresh_room\x18\x01 \x01(\bR\vrefreshRoom\"\xe2\x04\n" +
 	"\x05Event\x12\x1c\n" +
 	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x17\n" +
 	"\aroom_id\x18\x03 \x01(\tR\x06roomId\x12\x17\n" +
@@ -1644,7 +1773,8 @@ const file_api_room_service_room_service_proto_rawDesc = "" +
 	"\vdata_edited\x18\x1e \x01(\v2\x18.api.DataEditedEventBodyH\x00R\n" +
 	"dataEdited\x12=\n" +
 	"\tfull_room\x18( \x01(\v2\x1e.api.FullRoomSnapshotEventBodyH\x00R\bfullRoom\x128\n" +
-	"\rerror_message\x182 \x01(\v2\x11.api.ErrorMessageH\x00R\ferrorMessageB\t\n" +
+	"\rerror_message\x182 \x01(\v2\x11.api.ErrorMessageH\x00R\ferrorMessage\x12T\n" +
+	"\x14server_shutting_down\x18< \x01(\v2 .api.ServerShuttingDownEventBodyH\x00R\x12serverShuttingDownB\t\n" +
 	"\apayload\"\xbe\x01\n" +
 	"\x14RoomCreatedEventBody\x12M\n" +
 	"\froom_options\x18\x01 \x03(\v2*.api.RoomCreatedEventBody.RoomOptionsEntryR\vroomOptions\x12\x17\n" +
@@ -1675,11 +1805,17 @@ const file_api_room_service_room_service_proto_rawDesc = "" +
 	"\aroom_id\x18\x04 \x01(\tR\x06roomId\x1a>\n" +
 	"\x10RoomOptionsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x96\x01\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"5\n" +
+	"\x1bServerShuttingDownEventBody\x12\x16\n" +
+	"\x06reason\x18\x01 \x01(\tR\x06reason\"\x96\x01\n" +
 	"\vSingleEvent\x12=\n" +
 	"\tfull_room\x18\x01 \x01(\v2\x1e.api.FullRoomSnapshotEventBodyH\x00R\bfullRoom\x12>\n" +
 	"\froom_deleted\x18\x02 \x01(\v2\x19.api.RoomDeletedEventBodyH\x00R\vroomDeletedB\b\n" +
-	"\x06result*;\n" +
+	"\x06result*G\n" +
+	"\tErrorCode\x12\x11\n" +
+	"\rUNKNOWN_ERROR\x10\x00\x12\x16\n" +
+	"\x12RESOURCE_EXHAUSTED\x10\b\x12\x0f\n" +
+	"\vUNAVAILABLE\x10\x0e*;\n" +
 	"\fDateEditMode\x12\a\n" +
 	"\x03SET\x10\x00\x12\n" +
 	"\n" +
@@ -1691,7 +1827,10 @@ const file_api_room_service_room_service_proto_rawDesc = "" +
 	"\vRoomService\x12&\n" +
 	"\x06Stream\x12\f.api.Command\x1a\n" +
 	".api.Event(\x010\x01\x12/\n" +
-	"\rSingleCommand\x12\f.api.Command\x1a\x10.api.SingleEventB\x16Z\x14pkg/api/room_serviceb\x06proto3"
+	"\rSingleCommand\x12\f.api.Command\x1a\x10.api.SingleEvent2>\n" +
+	"\x10RoomShardService\x12*\n" +
+	"\x0eForwardCommand\x12\f.api.Command\x1a\n" +
+	".api.EventB\x16Z\x14pkg/api/room_serviceb\x06proto3"
 
 var (
 	file_api_room_service_room_service_proto_rawDescOnce sync.Once
@@ -1705,82 +1844,88 @@ func file_api_room_service_room_service_proto_rawDescGZIP() []byte {
 	return file_api_room_service_room_service_proto_rawDescData
 }
 
-var file_api_room_service_room_service_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_api_room_service_room_service_proto_msgTypes = make([]protoimpl.MessageInfo, 27)
+var file_api_room_service_room_service_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_api_room_service_room_service_proto_msgTypes = make([]protoimpl.MessageInfo, 28)
 var file_api_room_service_room_service_proto_goTypes = []any{
-	(DateEditMode)(0),                      // 0: api.DateEditMode
-	(*ErrorMessage)(nil),                   // 1: api.ErrorMessage
-	(*Value)(nil),                          // 2: api.Value
-	(*ListValue)(nil),                      // 3: api.ListValue
-	(*MapValue)(nil),                       // 4: api.MapValue
-	(*User)(nil),                           // 5: api.User
-	(*RoomData)(nil),                       // 6: api.RoomData
-	(*Command)(nil),                        // 7: api.Command
-	(*CreateRoomCommandBody)(nil),          // 8: api.CreateRoomCommandBody
-	(*DeleteRoomCommandBody)(nil),          // 9: api.DeleteRoomCommandBody
-	(*JoinRoomCommandBody)(nil),            // 10: api.JoinRoomCommandBody
-	(*LeaveRoomCommandBody)(nil),           // 11: api.LeaveRoomCommandBody
-	(*SetAppendDeleteDataCommandBody)(nil), // 12: api.SetAppendDeleteDataCommandBody
-	(*RefreshRoomCommandBody)(nil),         // 13: api.RefreshRoomCommandBody
-	(*Event)(nil),                          // 14: api.Event
-	(*RoomCreatedEventBody)(nil),           // 15: api.RoomCreatedEventBody
-	(*RoomDeletedEventBody)(nil),           // 16: api.RoomDeletedEventBody
-	(*JoinedRoomEventBody)(nil),            // 17: api.JoinedRoomEventBody
-	(*LeftRoomEventBody)(nil),              // 18: api.LeftRoomEventBody
-	(*DataEditedEventBody)(nil),            // 19: api.DataEditedEventBody
-	(*FullRoomSnapshotEventBody)(nil),      // 20: api.FullRoomSnapshotEventBody
-	(*SingleEvent)(nil),                    // 21: api.SingleEvent
-	nil,                                    // 22: api.MapValue.ValuesEntry
-	nil,                                    // 23: api.User.MetadataEntry
-	nil,                                    // 24: api.RoomData.ValuesEntry
-	nil,                                    // 25: api.CreateRoomCommandBody.RoomOptionsEntry
-	nil,                                    // 26: api.RoomCreatedEventBody.RoomOptionsEntry
-	nil,                                    // 27: api.FullRoomSnapshotEventBody.RoomOptionsEntry
+	(ErrorCode)(0),                         // 0: api.ErrorCode
+	(DateEditMode)(0),                      // 1: api.DateEditMode
+	(*ErrorMessage)(nil),                   // 2: api.ErrorMessage
+	(*Value)(nil),                          // 3: api.Value
+	(*ListValue)(nil),                      // 4: api.ListValue
+	(*MapValue)(nil),                       // 5: api.MapValue
+	(*User)(nil),                           // 6: api.User
+	(*RoomData)(nil),                       // 7: api.RoomData
+	(*Command)(nil),                        // 8: api.Command
+	(*CreateRoomCommandBody)(nil),          // 9: api.CreateRoomCommandBody
+	(*DeleteRoomCommandBody)(nil),          // 10: api.DeleteRoomCommandBody
+	(*JoinRoomCommandBody)(nil),            // 11: api.JoinRoomCommandBody
+	(*LeaveRoomCommandBody)(nil),           // 12: api.LeaveRoomCommandBody
+	(*SetAppendDeleteDataCommandBody)(nil), // 13: api.SetAppendDeleteDataCommandBody
+	(*RefreshRoomCommandBody)(nil),         // 14: api.RefreshRoomCommandBody
+	(*Event)(nil),                          // 15: api.Event
+	(*RoomCreatedEventBody)(nil),           // 16: api.RoomCreatedEventBody
+	(*RoomDeletedEventBody)(nil),           // 17: api.RoomDeletedEventBody
+	(*JoinedRoomEventBody)(nil),            // 18: api.JoinedRoomEventBody
+	(*LeftRoomEventBody)(nil),              // 19: api.LeftRoomEventBody
+	(*DataEditedEventBody)(nil),            // 20: api.DataEditedEventBody
+	(*FullRoomSnapshotEventBody)(nil),      // 21: api.FullRoomSnapshotEventBody
+	(*ServerShuttingDownEventBody)(nil),    // 22: api.ServerShuttingDownEventBody
+	(*SingleEvent)(nil),                    // 23: api.SingleEvent
+	nil,                                    // 24: api.MapValue.ValuesEntry
+	nil,                                    // 25: api.User.MetadataEntry
+	nil,                                    // 26: api.RoomData.ValuesEntry
+	nil,                                    // 27: api.CreateRoomCommandBody.RoomOptionsEntry
+	nil,                                    // 28: api.RoomCreatedEventBody.RoomOptionsEntry
+	nil,                                    // 29: api.FullRoomSnapshotEventBody.RoomOptionsEntry
 }
 var file_api_room_service_room_service_proto_depIdxs = []int32{
-	3,  // 0: api.Value.list_value:type_name -> api.ListValue
-	4,  // 1: api.Value.map_value:type_name -> api.MapValue
-	2,  // 2: api.ListValue.values:type_name -> api.Value
-	22, // 3: api.MapValue.values:type_name -> api.MapValue.ValuesEntry
-	23, // 4: api.User.metadata:type_name -> api.User.MetadataEntry
-	24, // 5: api.RoomData.values:type_name -> api.RoomData.ValuesEntry
-	8,  // 6: api.Command.create_room:type_name -> api.CreateRoomCommandBody
-	9,  // 7: api.Command.delete_room:type_name -> api.DeleteRoomCommandBody
-	10, // 8: api.Command.join_room:type_name -> api.JoinRoomCommandBody
-	11, // 9: api.Command.leave_room:type_name -> api.LeaveRoomCommandBody
-	12, // 10: api.Command.affect_data:type_name -> api.SetAppendDeleteDataCommandBody
-	13, // 11: api.Command.refresh_room:type_name -> api.RefreshRoomCommandBody
-	25, // 12: api.CreateRoomCommandBody.room_options:type_name -> api.CreateRoomCommandBody.RoomOptionsEntry
-	5,  // 13: api.JoinRoomCommandBody.user_full:type_name -> api.User
-	2,  // 14: api.SetAppendDeleteDataCommandBody.data_value:type_name -> api.Value
-	0,  // 15: api.SetAppendDeleteDataCommandBody.command_mode:type_name -> api.DateEditMode
-	15, // 16: api.Event.room_created:type_name -> api.RoomCreatedEventBody
-	16, // 17: api.Event.room_deleted:type_name -> api.RoomDeletedEventBody
-	17, // 18: api.Event.joined_room:type_name -> api.JoinedRoomEventBody
-	18, // 19: api.Event.left_room:type_name -> api.LeftRoomEventBody
-	19, // 20: api.Event.data_edited:type_name -> api.DataEditedEventBody
-	20, // 21: api.Event.full_room:type_name -> api.FullRoomSnapshotEventBody
-	1,  // 22: api.Event.error_message:type_name -> api.ErrorMessage
-	26, // 23: api.RoomCreatedEventBody.room_options:type_name -> api.RoomCreatedEventBody.RoomOptionsEntry
-	5,  // 24: api.JoinedRoomEventBody.user_full:type_name -> api.User
-	2,  // 25: api.DataEditedEventBody.data_value:type_name -> api.Value
-	0,  // 26: api.DataEditedEventBody.command_mode:type_name -> api.DateEditMode
-	6,  // 27: api.FullRoomSnapshotEventBody.room:type_name -> api.RoomData
-	5,  // 28: api.FullRoomSnapshotEventBody.users:type_name -> api.User
-	27, // 29: api.FullRoomSnapshotEventBody.room_options:type_name -> api.FullRoomSnapshotEventBody.RoomOptionsEntry
-	20, // 30: api.SingleEvent.full_room:type_name -> api.FullRoomSnapshotEventBody
-	16, // 31: api.SingleEvent.room_deleted:type_name -> api.RoomDeletedEventBody
-	2,  // 32: api.MapValue.ValuesEntry.value:type_name -> api.Value
-	2,  // 33: api.RoomData.ValuesEntry.value:type_name -> api.Value
-	7,  // 34: api.RoomService.Stream:input_type -> api.Command
-	7,  // 35: api.RoomService.SingleCommand:input_type -> api.Command
-	14, // 36: api.RoomService.Stream:output_type -> api.Event
-	21, // 37: api.RoomService.SingleCommand:output_type -> api.SingleEvent
-	36, // [36:38] is the sub-list for method output_type
-	34, // [34:36] is the sub-list for method input_type
-	34, // [34:34] is the sub-list for extension type_name
-	34, // [34:34] is the sub-list for extension extendee
-	0,  // [0:34] is the sub-list for field type_name
+	0,  // 0: api.ErrorMessage.code:type_name -> api.ErrorCode
+	4,  // 1: api.Value.list_value:type_name -> api.ListValue
+	5,  // 2: api.Value.map_value:type_name -> api.MapValue
+	3,  // 3: api.ListValue.values:type_name -> api.Value
+	24, // 4: api.MapValue.values:type_name -> api.MapValue.ValuesEntry
+	25, // 5: api.User.metadata:type_name -> api.User.MetadataEntry
+	26, // 6: api.RoomData.values:type_name -> api.RoomData.ValuesEntry
+	9,  // 7: api.Command.create_room:type_name -> api.CreateRoomCommandBody
+	10, // 8: api.Command.delete_room:type_name -> api.DeleteRoomCommandBody
+	11, // 9: api.Command.join_room:type_name -> api.JoinRoomCommandBody
+	12, // 10: api.Command.leave_room:type_name -> api.LeaveRoomCommandBody
+	13, // 11: api.Command.affect_data:type_name -> api.SetAppendDeleteDataCommandBody
+	14, // 12: api.Command.refresh_room:type_name -> api.RefreshRoomCommandBody
+	27, // 13: api.CreateRoomCommandBody.room_options:type_name -> api.CreateRoomCommandBody.RoomOptionsEntry
+	6,  // 14: api.JoinRoomCommandBody.user_full:type_name -> api.User
+	3,  // 15: api.SetAppendDeleteDataCommandBody.data_value:type_name -> api.Value
+	1,  // 16: api.SetAppendDeleteDataCommandBody.command_mode:type_name -> api.DateEditMode
+	16, // 17: api.Event.room_created:type_name -> api.RoomCreatedEventBody
+	17, // 18: api.Event.room_deleted:type_name -> api.RoomDeletedEventBody
+	18, // 19: api.Event.joined_room:type_name -> api.JoinedRoomEventBody
+	19, // 20: api.Event.left_room:type_name -> api.LeftRoomEventBody
+	20, // 21: api.Event.data_edited:type_name -> api.DataEditedEventBody
+	21, // 22: api.Event.full_room:type_name -> api.FullRoomSnapshotEventBody
+	2,  // 23: api.Event.error_message:type_name -> api.ErrorMessage
+	22, // 24: api.Event.server_shutting_down:type_name -> api.ServerShuttingDownEventBody
+	28, // 25: api.RoomCreatedEventBody.room_options:type_name -> api.RoomCreatedEventBody.RoomOptionsEntry
+	6,  // 26: api.JoinedRoomEventBody.user_full:type_name -> api.User
+	3,  // 27: api.DataEditedEventBody.data_value:type_name -> api.Value
+	1,  // 28: api.DataEditedEventBody.command_mode:type_name -> api.DateEditMode
+	7,  // 29: api.FullRoomSnapshotEventBody.room:type_name -> api.RoomData
+	6,  // 30: api.FullRoomSnapshotEventBody.users:type_name -> api.User
+	29, // 31: api.FullRoomSnapshotEventBody.room_options:type_name -> api.FullRoomSnapshotEventBody.RoomOptionsEntry
+	21, // 32: api.SingleEvent.full_room:type_name -> api.FullRoomSnapshotEventBody
+	17, // 33: api.SingleEvent.room_deleted:type_name -> api.RoomDeletedEventBody
+	3,  // 34: api.MapValue.ValuesEntry.value:type_name -> api.Value
+	3,  // 35: api.RoomData.ValuesEntry.value:type_name -> api.Value
+	8,  // 36: api.RoomService.Stream:input_type -> api.Command
+	8,  // 37: api.RoomService.SingleCommand:input_type -> api.Command
+	8,  // 38: api.RoomShardService.ForwardCommand:input_type -> api.Command
+	15, // 39: api.RoomService.Stream:output_type -> api.Event
+	23, // 40: api.RoomService.SingleCommand:output_type -> api.SingleEvent
+	15, // 41: api.RoomShardService.ForwardCommand:output_type -> api.Event
+	39, // [39:42] is the sub-list for method output_type
+	36, // [36:39] is the sub-list for method input_type
+	36, // [36:36] is the sub-list for extension type_name
+	36, // [36:36] is the sub-list for extension extendee
+	0,  // [0:36] is the sub-list for field type_name
 }
 
 func init() { file_api_room_service_room_service_proto_init() }
@@ -1814,9 +1959,10 @@ func file_api_room_service_room_service_proto_init() {
 		(*Event_DataEdited)(nil),
 		(*Event_FullRoom)(nil),
 		(*Event_ErrorMessage)(nil),
+		(*Event_ServerShuttingDown)(nil),
 	}
 	file_api_room_service_room_service_proto_msgTypes[18].OneofWrappers = []any{}
-	file_api_room_service_room_service_proto_msgTypes[20].OneofWrappers = []any{
+	file_api_room_service_room_service_proto_msgTypes[21].OneofWrappers = []any{
 		(*SingleEvent_FullRoom)(nil),
 		(*SingleEvent_RoomDeleted)(nil),
 	}
@@ -1825,10 +1971,10 @@ func file_api_room_service_room_service_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_room_service_room_service_proto_rawDesc), len(file_api_room_service_room_service_proto_rawDesc)),
-			NumEnums:      1,
-			NumMessages:   27,
+			NumEnums:      2,
+			NumMessages:   28,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_api_room_service_room_service_proto_goTypes,
 		DependencyIndexes: file_api_room_service_room_service_proto_depIdxs,
@@ -156,3 +156,111 @@ var RoomService_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "api/room_service/room_service.proto",
 }
+
+const (
+	RoomShardService_ForwardCommand_FullMethodName = "/api.RoomShardService/ForwardCommand"
+)
+
+// RoomShardServiceClient is the client API for RoomShardService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// internal service of room service instances: command of a room is executed by the instance that owns the room
+type RoomShardServiceClient interface {
+	// execute command on this instance, failed command returns Event with error_message
+	ForwardCommand(ctx context.Context, in *Command, opts ...grpc.CallOption) (*Event, error)
+}
+
+type roomShardServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRoomShardServiceClient(cc grpc.ClientConnInterface) RoomShardServiceClient {
+	return &roomShardServiceClient{cc}
+}
+
+func (c *roomShardServiceClient) ForwardCommand(ctx context.Context, in *Command, opts ...grpc.CallOption) (*Event, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Event)
+	err := c.cc.Invoke(ctx, RoomShardService_ForwardCommand_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RoomShardServiceServer is the server API for RoomShardService service.
+// All implementations must embed UnimplementedRoomShardServiceServer
+// for forward compatibility.
+//
+// internal service of room service instances: command of a room is executed by the instance that owns the room
+type RoomShardServiceServer interface {
+	// execute command on this instance, failed command returns Event with error_message
+	ForwardCommand(context.Context, *Command) (*Event, error)
+	mustEmbedUnimplementedRoomShardServiceServer()
+}
+
+// UnimplementedRoomShardServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRoomShardServiceServer struct{}
+
+func (UnimplementedRoomShardServiceServer) ForwardCommand(context.Context, *Command) (*Event, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ForwardCommand not implemented")
+}
+func (UnimplementedRoomShardServiceServer) mustEmbedUnimplementedRoomShardServiceServer() {}
+func (UnimplementedRoomShardServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeRoomShardServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RoomShardServiceServer will
+// result in compilation errors.
+type UnsafeRoomShardServiceServer interface {
+	mustEmbedUnimplementedRoomShardServiceServer()
+}
+
+func RegisterRoomShardServiceServer(s grpc.ServiceRegistrar, srv RoomShardServiceServer) {
+	// If the following call pancis, it indicates UnimplementedRoomShardServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RoomShardService_ServiceDesc, srv)
+}
+
+func _RoomShardService_ForwardCommand_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Command)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoomShardServiceServer).ForwardCommand(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoomShardService_ForwardCommand_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoomShardServiceServer).ForwardCommand(ctx, req.(*Command))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RoomShardService_ServiceDesc is the grpc.ServiceDesc for RoomShardService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RoomShardService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.RoomShardService",
+	HandlerType: (*RoomShardServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ForwardCommand",
+			Handler:    _RoomShardService_ForwardCommand_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api/room_service/room_service.proto",
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"github.com/wb-go/wbf/retry"
 	"time"
 )
@@ -11,9 +13,45 @@ import (
 //
 // supposed to be used for multiple things like RABBITMQ_RETRIES, EMAIL_RETRIES, etc.
 type RetryStrategyConfig struct {
-	Attempts          int     `env:"ATTEMPTS" envDefault:"3"`
-	DelayMilliseconds int     `env:"DELAY_MILLISECONDS" envDefault:"500"`
-	Backoff           float64 `env:"BACKOFF" envDefault:"1"`
+	Attempts          int     `yaml:"attempts" env:"ATTEMPTS" env-default:"3"`
+	DelayMilliseconds int     `yaml:"delay_milliseconds" env:"DELAY_MILLISECONDS" env-default:"500"`
+	Backoff           float64 `yaml:"backoff" env:"BACKOFF" env-default:"1"`
+	// MaxDelayMilliseconds - max delay between attempts, 0 = no limit (only for RetryPolicy)
+	MaxDelayMilliseconds int `yaml:"max_delay_milliseconds" env:"MAX_DELAY_MILLISECONDS" env-default:"5000"`
+	// Jitter - max share of delay that is randomly cut, within [0, 1] (only for RetryPolicy)
+	Jitter float64 `yaml:"jitter" env:"JITTER" env-default:"0.2"`
+	// BudgetMaxTokens - retry budget size, 0 = no budget, see RetryBudget (only for RetryPolicy)
+	BudgetMaxTokens float64 `yaml:"budget_max_tokens" env:"BUDGET_MAX_TOKENS" env-default:"100"`
+	// BudgetTokenRatio - tokens returned to retry budget by every success
+	BudgetTokenRatio float64 `yaml:"budget_token_ratio" env:"BUDGET_TOKEN_RATIO" env-default:"0.1"`
+}
+
+// Validate - at least one attempt, non-negative delays, backoff (delay multiplier) >= 1, jitter within [0, 1],
+// non-negative budget
+func (cfg *RetryStrategyConfig) Validate() error {
+	var errs []error
+	if cfg.Attempts < 1 {
+		errs = append(errs, fmt.Errorf("attempts: must be at least 1, got %d", cfg.Attempts))
+	}
+	if cfg.DelayMilliseconds < 0 {
+		errs = append(errs, fmt.Errorf("delay_milliseconds: must not be negative, got %d", cfg.DelayMilliseconds))
+	}
+	if cfg.Backoff < 1 {
+		errs = append(errs, fmt.Errorf("backoff: must be at least 1, got %v", cfg.Backoff))
+	}
+	if cfg.MaxDelayMilliseconds < 0 {
+		errs = append(errs, fmt.Errorf("max_delay_milliseconds: must not be negative, got %d", cfg.MaxDelayMilliseconds))
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		errs = append(errs, fmt.Errorf("jitter: must be within [0, 1], got %v", cfg.Jitter))
+	}
+	if cfg.BudgetMaxTokens < 0 {
+		errs = append(errs, fmt.Errorf("budget_max_tokens: must not be negative, got %v", cfg.BudgetMaxTokens))
+	}
+	if cfg.BudgetTokenRatio < 0 {
+		errs = append(errs, fmt.Errorf("budget_token_ratio: must not be negative, got %v", cfg.BudgetTokenRatio))
+	}
+	return errors.Join(errs...)
 }
 
 // ToStrategy converts an already read config to usable format which is retry.Strategy
@@ -28,3 +66,31 @@ func (cfg *RetryStrategyConfig) ToStrategy() retry.Strategy {
 		Backoff:  cfg.Backoff,
 	}
 }
+
+// ToPolicy converts an already read config to RetryPolicy
+//
+// retryable - which errors are retried (nil = IsTransient), budget - shared by operations (nil = no budget), see ToBudget
+//
+// Example:
+//
+//	budget := cfg.RetryConfig.ToBudget()
+//	policy := cfg.RetryConfig.ToPolicy(nil, budget)
+func (cfg *RetryStrategyConfig) ToPolicy(retryable func(err error) bool, budget *RetryBudget) RetryPolicy {
+	return RetryPolicy{
+		Attempts:  cfg.Attempts,
+		Delay:     time.Duration(cfg.DelayMilliseconds) * time.Millisecond,
+		Backoff:   cfg.Backoff,
+		MaxDelay:  time.Duration(cfg.MaxDelayMilliseconds) * time.Millisecond,
+		Jitter:    cfg.Jitter,
+		Retryable: retryable,
+		Budget:    budget,
+	}
+}
+
+// ToBudget - new RetryBudget, nil if BudgetMaxTokens = 0
+func (cfg *RetryStrategyConfig) ToBudget() *RetryBudget {
+	if cfg.BudgetMaxTokens <= 0 {
+		return nil
+	}
+	return NewRetryBudget(cfg.BudgetMaxTokens, cfg.BudgetTokenRatio)
+}
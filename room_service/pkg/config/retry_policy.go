@@ -0,0 +1,172 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrRetryBudgetExhausted - operation wasn't retried because RetryBudget has no tokens, wraps the last error
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryPolicy - retries of transient errors with capped, jittered exponential backoff
+//
+// delay before attempt n+1 is Delay * Backoff^(n-1), capped by MaxDelay, then reduced by random share up to Jitter.
+// Attempt isn't made if ctx is done or its deadline comes before the delay ends
+type RetryPolicy struct {
+	// Attempts - max attempts including the first one, < 1 = 1
+	Attempts int
+	Delay    time.Duration
+	// Backoff - delay multiplier, < 1 = 1
+	Backoff float64
+	// MaxDelay - max delay between attempts, 0 = no limit
+	MaxDelay time.Duration
+	// Jitter - max share of delay that is randomly cut, within [0, 1]
+	Jitter float64
+	// Retryable - which errors are retried, nil = IsTransient
+	Retryable func(err error) bool
+	// Budget - shared by every operation, nil = no budget
+	Budget *RetryBudget
+}
+
+// Do - call fn until it succeeds, returns not retryable error or attempts are over
+//
+// the last error is returned, wrapped with ErrRetryBudgetExhausted if budget stopped retries
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil {
+			p.Budget.onSuccess()
+			return nil
+		}
+		// only transient failures take budget, e.g. "not found" doesn't mean storage is failing
+		if !retryable(err) {
+			return err
+		}
+		p.Budget.onFailure()
+		if attempt >= p.Attempts || ctx.Err() != nil {
+			return err
+		}
+		delay := p.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		if !p.Budget.allowRetry() {
+			return fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay - time to wait after attempt failed
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := float64(p.Delay) * math.Pow(math.Max(p.Backoff, 1), float64(attempt-1))
+	if p.MaxDelay > 0 {
+		delay = math.Min(delay, float64(p.MaxDelay))
+	}
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	return time.Duration(delay * (1 - jitter*rand.Float64()))
+}
+
+// IsTransient - error may not happen again: network errors, timeouts, gRPC UNAVAILABLE, ABORTED and DEADLINE_EXCEEDED,
+// errors labeled as retryable by the driver (e.g. MongoDB "RetryableWriteError")
+//
+// context.Canceled and unknown errors aren't transient
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var labeled interface{ HasErrorLabel(string) bool }
+	if errors.As(err, &labeled) {
+		for _, label := range []string{"RetryableWriteError", "TransientTransactionError", "NetworkError"} {
+			if labeled.HasErrorLabel(label) {
+				return true
+			}
+		}
+	}
+
+	if grpcStatus, ok := status.FromError(err); ok {
+		switch grpcStatus.Code() {
+		case codes.Unavailable, codes.Aborted, codes.DeadlineExceeded:
+			return true
+		}
+	}
+	return false
+}
+
+// RetryBudget - limits retries of all operations, so failing storage isn't flooded with them (retry storm)
+//
+// token bucket like in gRPC retry throttling: every transient failure takes one token, every success returns ratio,
+// retries are allowed while more than half of max tokens are left. Safe for concurrent use, nil = no budget
+type RetryBudget struct {
+	mu        sync.Mutex
+	tokens    float64
+	maxTokens float64
+	ratio     float64
+}
+
+// NewRetryBudget - create full RetryBudget
+//
+// ratio - tokens returned by success, e.g. 0.1 = about one retry per 10 successes while storage fails
+func NewRetryBudget(maxTokens float64, ratio float64) *RetryBudget {
+	return &RetryBudget{tokens: maxTokens, maxTokens: maxTokens, ratio: ratio}
+}
+
+func (b *RetryBudget) onSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.maxTokens, b.tokens+b.ratio)
+}
+
+func (b *RetryBudget) onFailure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Max(0, b.tokens-1)
+}
+
+func (b *RetryBudget) allowRetry() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens > b.maxTokens/2
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var errNetwork = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network", err: fmt.Errorf("failed to read: %w", errNetwork), want: true},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: true},
+		{name: "grpc unavailable", err: status.Error(codes.Unavailable, "down"), want: true},
+		{name: "grpc permission denied", err: status.Error(codes.PermissionDenied, "no"), want: false},
+		{name: "canceled", err: fmt.Errorf("failed: %w", context.Canceled), want: false},
+		{name: "unknown", err: errors.New("not authorized on rooms_db"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	failing := func(err error, calls *int) func(context.Context) error {
+		return func(context.Context) error {
+			*calls++
+			return err
+		}
+	}
+
+	t.Run("transient error is retried until attempts are over", func(t *testing.T) {
+		calls := 0
+		err := RetryPolicy{Attempts: 3, Delay: time.Millisecond}.Do(context.Background(), failing(errNetwork, &calls))
+		if !errors.Is(err, syscall.ECONNRESET) || calls != 3 {
+			t.Errorf("err = %v, calls = %d, want network error after 3 calls", err, calls)
+		}
+	})
+
+	t.Run("not retryable error isn't retried", func(t *testing.T) {
+		calls := 0
+		errDomain := errors.New("room does not exist")
+		err := RetryPolicy{Attempts: 3}.Do(context.Background(), failing(errDomain, &calls))
+		if err != errDomain || calls != 1 {
+			t.Errorf("err = %v, calls = %d, want domain error after 1 call", err, calls)
+		}
+	})
+
+	t.Run("delay past ctx deadline isn't waited", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		calls := 0
+		start := time.Now()
+		err := RetryPolicy{Attempts: 3, Delay: time.Second}.Do(ctx, failing(errNetwork, &calls))
+		if !errors.Is(err, syscall.ECONNRESET) || calls != 1 || time.Since(start) > 40*time.Millisecond {
+			t.Errorf("err = %v, calls = %d after %s, want immediate network error", err, calls, time.Since(start))
+		}
+	})
+
+	t.Run("exhausted budget stops retries", func(t *testing.T) {
+		budget := NewRetryBudget(4, 1)
+		policy := RetryPolicy{Attempts: 10, Budget: budget}
+		calls := 0
+		// 4 tokens: failures take 2 of them before retries stop at max/2
+		err := policy.Do(context.Background(), failing(errNetwork, &calls))
+		if !errors.Is(err, ErrRetryBudgetExhausted) || !errors.Is(err, syscall.ECONNRESET) || calls != 2 {
+			t.Errorf("err = %v, calls = %d, want exhausted budget after 2 calls", err, calls)
+		}
+
+		// successes return tokens
+		for range 3 {
+			_ = policy.Do(context.Background(), func(context.Context) error { return nil })
+		}
+		calls = 0
+		_ = policy.Do(context.Background(), failing(errNetwork, &calls))
+		if calls != 2 {
+			t.Errorf("calls = %d after budget refill, want 2", calls)
+		}
+	})
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Delay: 100 * time.Millisecond, Backoff: 2, MaxDelay: 300 * time.Millisecond, Jitter: 0.5}
+	for attempt, maxDelay := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 300 * time.Millisecond} {
+		for range 20 {
+			delay := policy.delay(attempt)
+			if delay > maxDelay || delay < maxDelay/2 {
+				t.Fatalf("delay after attempt %d = %s, want within [%s, %s]", attempt, delay, maxDelay/2, maxDelay)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Format - how log entries are encoded
+type Format string
+
+const (
+	// FormatJSON - one JSON object per entry
+	FormatJSON Format = "json"
+	// FormatConsole - human-readable, for local runs
+	FormatConsole Format = "console"
+)
+
+// Params - settings of New
+type Params struct {
+	// Level - "debug", "info", "warn", "error", "dpanic", "panic", "fatal", default "info"
+	Level string
+	// Format - default FormatJSON
+	Format Format
+	// SamplingInitial - entries with the same level and message logged every second before sampling starts, 0 = no sampling
+	SamplingInitial int
+	// SamplingThereafter - every Nth entry is logged after SamplingInitial, default 100
+	SamplingThereafter int
+}
+
+// Logger - zap logger with a level that can be changed at runtime
+//
+// the only logger of the service, entries get request ID and trace ID from ctx.
+// Children made with With share the level of their parent
+type Logger struct {
+	l     *zap.Logger
+	level zap.AtomicLevel
+}
+
+// New - create base logger that writes to stderr, create it once and derive children with With
+func New(params Params) (*Logger, error) {
+	return newLogger(params, os.Stderr)
+}
+
+// newLogger - New that writes to out
+func newLogger(params Params, out io.Writer) (*Logger, error) {
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	if len(params.Level) > 0 {
+		if err := level.UnmarshalText([]byte(params.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level '%s': %w", params.Level, err)
+		}
+	}
+
+	var encoder zapcore.Encoder
+	switch params.Format {
+	case FormatJSON, "":
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	case FormatConsole:
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	default:
+		return nil, fmt.Errorf("unknown log format: '%s' (Use one of these: 'json', 'console')", params.Format)
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.Lock(zapcore.AddSync(out)), level)
+	if params.SamplingInitial > 0 {
+		thereafter := params.SamplingThereafter
+		if thereafter <= 0 {
+			thereafter = 100
+		}
+		core = zapcore.NewSamplerWithOptions(core, time.Second, params.SamplingInitial, thereafter)
+	}
+
+	return &Logger{
+		l:     zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1), zap.AddStacktrace(zap.ErrorLevel)),
+		level: level,
+	}, nil
+}
+
+// With - child logger that adds fields to every entry
+func (l *Logger) With(fields ...zap.Field) *Logger {
+	return &Logger{l: l.l.With(fields...), level: l.level}
+}
+
+// Level - current level
+func (l *Logger) Level() string {
+	return l.level.String()
+}
+
+// SetLevel - change level of the logger, it's parent and all it's children
+func (l *Logger) SetLevel(level string) error {
+	if err := l.level.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level '%s': %w", level, err)
+	}
+	return nil
+}
+
+// Sync - flush buffered entries
+func (l *Logger) Sync() error {
+	return l.l.Sync()
+}
+
+// Debug - log at debug level, request ID and trace ID of ctx are added (if any)
+func (l *Logger) Debug(ctx context.Context, msg string, fields ...zap.Field) {
+	l.l.Debug(msg, contextFields(ctx, fields)...)
+}
+
+// Info - log at info level, request ID and trace ID of ctx are added (if any)
+func (l *Logger) Info(ctx context.Context, msg string, fields ...zap.Field) {
+	l.l.Info(msg, contextFields(ctx, fields)...)
+}
+
+// Warn - log at warn level, request ID and trace ID of ctx are added (if any)
+func (l *Logger) Warn(ctx context.Context, msg string, fields ...zap.Field) {
+	l.l.Warn(msg, contextFields(ctx, fields)...)
+}
+
+// Error - log at error level, request ID and trace ID of ctx are added (if any)
+func (l *Logger) Error(ctx context.Context, msg string, fields ...zap.Field) {
+	l.l.Error(msg, contextFields(ctx, fields)...)
+}
+
+// Fatal - log at fatal level and exit, request ID and trace ID of ctx are added (if any)
+func (l *Logger) Fatal(ctx context.Context, msg string, fields ...zap.Field) {
+	l.l.Fatal(msg, contextFields(ctx, fields)...)
+}
+
+// contextFields - fields with request ID (see WithRequestID) and trace ID of the span in ctx
+func contextFields(ctx context.Context, fields []zap.Field) []zap.Field {
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		fields = append(fields, zap.String(RequestIDField, requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", spanContext.TraceID().String()))
+	}
+	return fields
+}
+
+// RequestIDField - key of request ID in log entries
+const RequestIDField = "request_id"
+
+type ctxKey struct{}
+
+type requestIDCtxKey struct{}
+
+// WithRequestID - store request ID in ctx, entries logged with ctx get it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey{}, requestID)
+}
+
+// RequestIDFromContext - request ID stored with WithRequestID, false if none
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDCtxKey{}).(string)
+	return requestID, ok && len(requestID) > 0
+}
+
+// WithLogger - store l in ctx, see FromContext
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext - logger stored with WithLogger, default (info, JSON) logger if none
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*Logger); ok {
+		return l
+	}
+	return defaultLogger()
+}
+
+// defaultLogger - used when ctx has no logger, e.g. in tests
+var defaultLogger = sync.OnceValue(func() *Logger {
+	l, err := New(Params{})
+	if err != nil {
+		panic(fmt.Errorf("failed to create default logger: %w", err))
+	}
+	return l
+})
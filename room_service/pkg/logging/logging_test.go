@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+)
+
+// entries - JSON entries written into out
+func entries(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var result []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		entry := make(map[string]any)
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("entry %q isn't JSON: %v", line, err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestSetLevelChangesParentAndChildren(t *testing.T) {
+	out := &bytes.Buffer{}
+	base, err := newLogger(Params{Level: "info"}, out)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	child := base.With(zap.String("method", "Stream"))
+	ctx := context.Background()
+
+	child.Debug(ctx, "hidden")
+	if err = child.SetLevel("debug"); err != nil {
+		t.Fatalf("SetLevel: %v", err)
+	}
+	base.Debug(ctx, "parent debug")
+	child.Debug(ctx, "child debug")
+	if base.Level() != "debug" {
+		t.Fatalf("level = %s, want debug", base.Level())
+	}
+
+	if err = base.SetLevel("loud"); err == nil {
+		t.Fatal("expected error of invalid level")
+	}
+	if base.Level() != "debug" {
+		t.Fatalf("invalid level changed level to %s", base.Level())
+	}
+
+	logged := entries(t, out)
+	if len(logged) != 2 || logged[0]["msg"] != "parent debug" || logged[1]["msg"] != "child debug" {
+		t.Fatalf("entries = %v, want debug entries of parent and child after SetLevel", logged)
+	}
+	if logged[1]["method"] != "Stream" {
+		t.Fatalf("child entry = %v, want its fields", logged[1])
+	}
+}
+
+func TestNewRejectsInvalidParams(t *testing.T) {
+	if _, err := newLogger(Params{Level: "loud"}, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected error of invalid level")
+	}
+	if _, err := newLogger(Params{Format: "xml"}, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected error of unknown format")
+	}
+}
+
+func TestEntriesGetRequestIDOfContext(t *testing.T) {
+	out := &bytes.Buffer{}
+	base, err := newLogger(Params{}, out)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+
+	base.Info(context.Background(), "no request")
+	ctx := WithRequestID(context.Background(), "request-1")
+	ctx = WithLogger(ctx, base.With(zap.String("method", "Stream")))
+	// derived ctx (e.g. of a command) keeps request ID and logger
+	commandCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	FromContext(commandCtx).Warn(commandCtx, "in request")
+
+	if requestID, ok := RequestIDFromContext(commandCtx); !ok || requestID != "request-1" {
+		t.Fatalf("RequestIDFromContext = %q, %v, want request-1", requestID, ok)
+	}
+	if _, ok := RequestIDFromContext(WithRequestID(context.Background(), "")); ok {
+		t.Fatal("empty request ID is found")
+	}
+
+	logged := entries(t, out)
+	if len(logged) != 2 {
+		t.Fatalf("entries = %v, want 2", logged)
+	}
+	if _, ok := logged[0][RequestIDField]; ok {
+		t.Fatalf("entry without request = %v, want no request ID", logged[0])
+	}
+	if logged[1][RequestIDField] != "request-1" || logged[1]["method"] != "Stream" {
+		t.Fatalf("entry in request = %v, want request ID and method", logged[1])
+	}
+}
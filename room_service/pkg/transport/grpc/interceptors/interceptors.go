@@ -2,28 +2,68 @@ package interceptors
 
 import (
 	"context"
-	"github.com/chempik1234/super-danis-library-golang/v2/pkg/logger"
-	"github.com/google/uuid"
+	"github.com/chempik1234/room-service/internal/projectutils"
+	"github.com/chempik1234/room-service/pkg/logging"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"time"
 )
 
-func AddLogMiddleware(
-	ctx context.Context,
-	req interface{},
-	info *grpc.UnaryServerInfo,
-	handler grpc.UnaryHandler,
-) (interface{}, error) {
-	ctx, _ = logger.New(ctx)
-	ctx = context.WithValue(ctx, logger.KeyForRequestID, uuid.New().String())
-	logger.GetLoggerFromCtx(ctx).Info(ctx, "gRPC request",
-		zap.String("method", info.FullMethod),
-		zap.Time("request time", time.Now()),
-	)
-	reply, err := handler(ctx, req)
-	if err != nil {
-		logger.GetLoggerFromCtx(ctx).Warn(ctx, "gRPC hanler returned an error", zap.Error(err))
+// NewLogMiddleware - unary interceptor that stores base's child logger (with request_id and method) in ctx and logs the request
+//
+// request ID is stored in ctx with logging.WithRequestID
+func NewLogMiddleware(base *logging.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx = withRequestLogger(ctx, base, info.FullMethod)
+		logging.FromContext(ctx).Info(ctx, "gRPC request",
+			zap.Time("request time", time.Now()),
+		)
+		reply, err := handler(ctx, req)
+		if err != nil {
+			logging.FromContext(ctx).Warn(ctx, "gRPC hanler returned an error", zap.Error(err))
+		}
+		return reply, err
 	}
-	return reply, err
+}
+
+// NewStreamLogMiddleware - stream interceptor, same as NewLogMiddleware
+func NewStreamLogMiddleware(base *logging.Logger) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx := withRequestLogger(stream.Context(), base, info.FullMethod)
+		logging.FromContext(ctx).Info(ctx, "gRPC stream opened",
+			zap.Time("request time", time.Now()),
+		)
+		err := handler(srv, &streamWithContext{ServerStream: stream, ctx: ctx})
+		if err != nil {
+			logging.FromContext(ctx).Warn(ctx, "gRPC stream hanler returned an error", zap.Error(err))
+		}
+		return err
+	}
+}
+
+func withRequestLogger(ctx context.Context, base *logging.Logger, method string) context.Context {
+	requestID := projectutils.RequestIDFromIncomingContext(ctx)
+	ctx = logging.WithRequestID(ctx, requestID)
+	return logging.WithLogger(ctx, base.With(zap.String("method", method)))
+}
+
+// streamWithContext - grpc.ServerStream with replaced ctx
+type streamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context - implements grpc.ServerStream
+func (s *streamWithContext) Context() context.Context {
+	return s.ctx
 }